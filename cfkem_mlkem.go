@@ -0,0 +1,210 @@
+// Copyright 2024 The uTLS Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build go1.24
+
+package tls
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/mlkem"
+	"crypto/rand"
+
+	"github.com/cloudflare/circl/kem"
+)
+
+// x25519MLKEM768 implements the X25519MLKEM768 hybrid key exchange from
+// draft-kwiatkowski-tls-ecdhe-mlkem as a Circl kem.Scheme, so that it can be
+// used everywhere the Kyber drafts are. Unlike X25519Kyber768Draft00, the
+// ML-KEM part comes first in both the key share and the shared secret.
+type x25519MLKEM768 struct{}
+
+const (
+	x25519MLKEM768SeedSize      = mlkem.SeedSize + 32
+	x25519MLKEM768PublicKeySize = mlkem.EncapsulationKeySize768 + 32
+	x25519MLKEM768CiphertextLen = mlkem.CiphertextSize768 + 32
+)
+
+type x25519MLKEM768PublicKey struct {
+	mlkem  *mlkem.EncapsulationKey768
+	x25519 *ecdh.PublicKey
+}
+
+type x25519MLKEM768PrivateKey struct {
+	seed   []byte
+	mlkem  *mlkem.DecapsulationKey768
+	x25519 *ecdh.PrivateKey
+}
+
+func x25519MLKEM768Scheme() kem.Scheme {
+	return x25519MLKEM768{}
+}
+
+func (x25519MLKEM768) Name() string { return "X25519MLKEM768" }
+
+func (s x25519MLKEM768) GenerateKeyPair() (kem.PublicKey, kem.PrivateKey, error) {
+	seed := make([]byte, s.SeedSize())
+	if _, err := rand.Read(seed); err != nil {
+		return nil, nil, err
+	}
+	pk, sk := s.DeriveKeyPair(seed)
+	return pk, sk, nil
+}
+
+func (s x25519MLKEM768) Encapsulate(pk kem.PublicKey) (ct, ss []byte, err error) {
+	seed := make([]byte, s.EncapsulationSeedSize())
+	if _, err := rand.Read(seed); err != nil {
+		return nil, nil, err
+	}
+	return s.EncapsulateDeterministically(pk, seed)
+}
+
+func (s x25519MLKEM768) Decapsulate(sk kem.PrivateKey, ct []byte) ([]byte, error) {
+	priv, ok := sk.(*x25519MLKEM768PrivateKey)
+	if !ok {
+		return nil, kem.ErrTypeMismatch
+	}
+	if len(ct) != x25519MLKEM768CiphertextLen {
+		return nil, kem.ErrCiphertextSize
+	}
+	mlkemShared, err := priv.mlkem.Decapsulate(ct[:mlkem.CiphertextSize768])
+	if err != nil {
+		return nil, err
+	}
+	peer, err := ecdh.X25519().NewPublicKey(ct[mlkem.CiphertextSize768:])
+	if err != nil {
+		return nil, err
+	}
+	x25519Shared, err := priv.x25519.ECDH(peer)
+	if err != nil {
+		return nil, err
+	}
+	return append(mlkemShared, x25519Shared...), nil
+}
+
+func (s x25519MLKEM768) UnmarshalBinaryPublicKey(b []byte) (kem.PublicKey, error) {
+	if len(b) != x25519MLKEM768PublicKeySize {
+		return nil, kem.ErrPubKeySize
+	}
+	ek, err := mlkem.NewEncapsulationKey768(b[:mlkem.EncapsulationKeySize768])
+	if err != nil {
+		return nil, kem.ErrPubKey
+	}
+	x, err := ecdh.X25519().NewPublicKey(b[mlkem.EncapsulationKeySize768:])
+	if err != nil {
+		return nil, kem.ErrPubKey
+	}
+	return &x25519MLKEM768PublicKey{mlkem: ek, x25519: x}, nil
+}
+
+// UnmarshalBinaryPrivateKey expects the seed the key pair was derived from.
+func (s x25519MLKEM768) UnmarshalBinaryPrivateKey(b []byte) (kem.PrivateKey, error) {
+	if len(b) != x25519MLKEM768SeedSize {
+		return nil, kem.ErrPrivKeySize
+	}
+	sk, err := newX25519MLKEM768PrivateKey(b)
+	if err != nil {
+		return nil, err
+	}
+	return sk, nil
+}
+
+func (x25519MLKEM768) CiphertextSize() int { return x25519MLKEM768CiphertextLen }
+
+func (x25519MLKEM768) SharedKeySize() int { return mlkem.SharedKeySize + 32 }
+
+func (x25519MLKEM768) PrivateKeySize() int { return x25519MLKEM768SeedSize }
+
+func (x25519MLKEM768) PublicKeySize() int { return x25519MLKEM768PublicKeySize }
+
+func (s x25519MLKEM768) DeriveKeyPair(seed []byte) (kem.PublicKey, kem.PrivateKey) {
+	if len(seed) != x25519MLKEM768SeedSize {
+		panic(kem.ErrSeedSize)
+	}
+	sk, err := newX25519MLKEM768PrivateKey(seed)
+	if err != nil {
+		panic(err)
+	}
+	return sk.Public(), sk
+}
+
+func (x25519MLKEM768) SeedSize() int { return x25519MLKEM768SeedSize }
+
+// EncapsulateDeterministically uses seed for the X25519 part only, as
+// crypto/mlkem does not expose deterministic encapsulation.
+func (s x25519MLKEM768) EncapsulateDeterministically(pk kem.PublicKey, seed []byte) (ct, ss []byte, err error) {
+	pub, ok := pk.(*x25519MLKEM768PublicKey)
+	if !ok {
+		return nil, nil, kem.ErrTypeMismatch
+	}
+	if len(seed) != s.EncapsulationSeedSize() {
+		return nil, nil, kem.ErrSeedSize
+	}
+	eph, err := ecdh.X25519().NewPrivateKey(seed)
+	if err != nil {
+		return nil, nil, err
+	}
+	x25519Shared, err := eph.ECDH(pub.x25519)
+	if err != nil {
+		return nil, nil, err
+	}
+	mlkemShared, mlkemCt := pub.mlkem.Encapsulate()
+	ct = append(mlkemCt, eph.PublicKey().Bytes()...)
+	ss = append(mlkemShared, x25519Shared...)
+	return ct, ss, nil
+}
+
+func (x25519MLKEM768) EncapsulationSeedSize() int { return 32 }
+
+func newX25519MLKEM768PrivateKey(seed []byte) (*x25519MLKEM768PrivateKey, error) {
+	dk, err := mlkem.NewDecapsulationKey768(seed[:mlkem.SeedSize])
+	if err != nil {
+		return nil, err
+	}
+	x, err := ecdh.X25519().NewPrivateKey(seed[mlkem.SeedSize:])
+	if err != nil {
+		return nil, err
+	}
+	return &x25519MLKEM768PrivateKey{
+		seed:   bytes.Clone(seed),
+		mlkem:  dk,
+		x25519: x,
+	}, nil
+}
+
+func (pk *x25519MLKEM768PublicKey) Scheme() kem.Scheme { return x25519MLKEM768{} }
+
+func (pk *x25519MLKEM768PublicKey) MarshalBinary() ([]byte, error) {
+	return append(pk.mlkem.Bytes(), pk.x25519.Bytes()...), nil
+}
+
+func (pk *x25519MLKEM768PublicKey) Equal(other kem.PublicKey) bool {
+	o, ok := other.(*x25519MLKEM768PublicKey)
+	if !ok {
+		return false
+	}
+	return bytes.Equal(pk.mlkem.Bytes(), o.mlkem.Bytes()) && pk.x25519.Equal(o.x25519)
+}
+
+func (sk *x25519MLKEM768PrivateKey) Scheme() kem.Scheme { return x25519MLKEM768{} }
+
+func (sk *x25519MLKEM768PrivateKey) MarshalBinary() ([]byte, error) {
+	return bytes.Clone(sk.seed), nil
+}
+
+func (sk *x25519MLKEM768PrivateKey) Equal(other kem.PrivateKey) bool {
+	o, ok := other.(*x25519MLKEM768PrivateKey)
+	if !ok {
+		return false
+	}
+	return bytes.Equal(sk.seed, o.seed)
+}
+
+func (sk *x25519MLKEM768PrivateKey) Public() kem.PublicKey {
+	return &x25519MLKEM768PublicKey{
+		mlkem:  sk.mlkem.EncapsulationKey(),
+		x25519: sk.x25519.PublicKey(),
+	}
+}
@@ -0,0 +1,15 @@
+// Copyright 2024 The uTLS Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !go1.24
+
+package tls
+
+import "github.com/cloudflare/circl/kem"
+
+// x25519MLKEM768Scheme returns nil before Go 1.24, which introduced crypto/mlkem.
+// X25519MLKEM768 is then treated as an unsupported group.
+func x25519MLKEM768Scheme() kem.Scheme {
+	return nil
+}
@@ -0,0 +1,55 @@
+// Copyright 2024 The uTLS Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package clienthello generates the ClientHellos of uTLS parrots and their
+// fingerprints without a connection. It only relies on the parts of uTLS that
+// don't need a network, so that it can be used where there is none, such as
+// in a browser with GOOS=js GOARCH=wasm.
+package clienthello
+
+import (
+	tls "github.com/refraction-networking/utls"
+)
+
+// MarshalClientHello returns the ClientHello handshake message of id to
+// serverName, without a record header. An empty serverName omits the
+// server_name extension, as for a connection to an IP address.
+//
+// The ClientHello is new for every call, with different key shares and
+// GREASE values, unless id has a fixed Seed.
+func MarshalClientHello(id tls.ClientHelloID, serverName string) ([]byte, error) {
+	return tls.NewClientHelloBuilder(config(serverName), id).MarshalClientHello()
+}
+
+// Fingerprints returns the JA3 and JA4 fingerprints of the ClientHello of id
+// to serverName, see MarshalClientHello.
+func Fingerprints(id tls.ClientHelloID, serverName string) (ja3, ja4 string, err error) {
+	b := tls.NewClientHelloBuilder(config(serverName), id)
+	hello, err := b.MarshalClientHello()
+	if err != nil {
+		return "", "", err
+	}
+	if ja4, err = b.JA4(); err != nil {
+		return "", "", err
+	}
+
+	// The Fingerprinter expects a record.
+	record := append([]byte{22, 3, 1, byte(len(hello) >> 8), byte(len(hello))}, hello...)
+	spec, err := (&tls.Fingerprinter{AllowBluntMimicry: true}).RawClientHello(record)
+	if err != nil {
+		return "", "", err
+	}
+	return spec.JA3(), ja4, nil
+}
+
+func config(serverName string) *tls.Config {
+	return &tls.Config{
+		ServerName: serverName,
+		// There is no certificate to verify, but a Config without a
+		// ServerName must say so.
+		InsecureSkipVerify: serverName == "",
+		// Resumption would need a session cache and a previous connection.
+		OmitEmptyPsk: true,
+	}
+}
@@ -0,0 +1,58 @@
+// Copyright 2024 The uTLS Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package clienthello
+
+import (
+	"bytes"
+	"os/exec"
+	"strings"
+	"testing"
+
+	tls "github.com/refraction-networking/utls"
+	"github.com/refraction-networking/utls/testenv"
+)
+
+func TestMarshalClientHello(t *testing.T) {
+	hello, err := MarshalClientHello(tls.HelloChrome_120, "example.com")
+	if err != nil {
+		t.Fatalf("got error: %v; expected to succeed", err)
+	}
+	if len(hello) < 4 || hello[0] != 1 { // client_hello
+		t.Fatalf("got %x, expected a ClientHello message", hello)
+	}
+	if !bytes.Contains(hello, []byte("example.com")) {
+		t.Error("the ClientHello doesn't contain the server name")
+	}
+	if hello, err := MarshalClientHello(tls.HelloChrome_120, ""); err != nil || bytes.Contains(hello, []byte("example.com")) {
+		t.Errorf("got error %v, or the server name without one", err)
+	}
+}
+
+func TestFingerprints(t *testing.T) {
+	ja3, ja4, err := Fingerprints(tls.HelloSafari_18, "example.com")
+	if err != nil {
+		t.Fatalf("got error: %v; expected to succeed", err)
+	}
+	if ja4 != "t13d2014h2_a09f3c656075_14788d8d241b" {
+		t.Errorf("got JA4 %s, expected t13d2014h2_a09f3c656075_14788d8d241b", ja4)
+	}
+	if !strings.HasPrefix(ja3, "771,4865-4866-4867-49196-") {
+		t.Errorf("got JA3 %s, expected the cipher suites of Safari 18", ja3)
+	}
+}
+
+// TestBuildJS checks that the package builds for a browser.
+func TestBuildJS(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping in short mode")
+	}
+	testenv.MustHaveGoBuild(t)
+
+	cmd := exec.Command(testenv.GoToolPath(t), "build", "-o", t.TempDir()+"/clienthello.wasm", ".")
+	cmd.Env = append(cmd.Environ(), "GOOS=js", "GOARCH=wasm")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("GOOS=js GOARCH=wasm go build: %v\n%s", err, out)
+	}
+}
@@ -311,6 +311,10 @@ type ConnectionState struct {
 	// EncryptedExtensions message. It is only populated if the server sent the
 	// ech extension in EncryptedExtensions message.
 	ECHRetryConfigs []ECHConfig // [uTLS]
+
+	// ECHAccepted indicates the server accepted the encrypted ClientHello,
+	// so that the handshake was completed with the ClientHelloInner.
+	ECHAccepted bool // [uTLS]
 }
 
 // ExportKeyingMaterial returns length bytes of exported key material in a new
@@ -849,10 +853,13 @@ type Config struct {
 	// message or out-of-band.
 	//
 	// If ECHConfigs is nil and an ECH extension is present, GREASEd ECH
-	// extension will be sent.
-	//
-	// If GREASE ECH extension is present, this field will be ignored.
+	// extension will be sent. Otherwise the ECH extension encrypts the
+	// ClientHello to one of ECHConfigs, see (*UConn).SetECHConfigs.
 	ECHConfigs []ECHConfig // [uTLS]
+
+	// HandshakeTrace, if not nil, is called at the steps of client handshakes,
+	// see HandshakeTrace.
+	HandshakeTrace *HandshakeTrace // [uTLS]
 }
 
 const (
@@ -939,6 +946,7 @@ func (c *Config) Clone() *Config {
 
 		PreferSkipResumptionOnNilExtension: c.PreferSkipResumptionOnNilExtension, // [UTLS]
 		ECHConfigs:                         c.ECHConfigs,                         // [uTLS]
+		HandshakeTrace:                     c.HandshakeTrace,                     // [uTLS]
 	}
 }
 
@@ -1474,6 +1482,7 @@ const (
 	keyLogLabelServerHandshake = "SERVER_HANDSHAKE_TRAFFIC_SECRET"
 	keyLogLabelClientTraffic   = "CLIENT_TRAFFIC_SECRET_0"
 	keyLogLabelServerTraffic   = "SERVER_TRAFFIC_SECRET_0"
+	keyLogLabelClientEarly     = "CLIENT_EARLY_TRAFFIC_SECRET" // [uTLS]
 )
 
 func (c *Config) writeKeyLog(label string, clientRandom, secret []byte) error {
@@ -1515,6 +1524,10 @@ type Certificate struct {
 	// using x509.ParseCertificate to reduce per-handshake processing. If nil,
 	// the leaf certificate will be parsed as needed.
 	Leaf *x509.Certificate
+
+	// [uTLS] delegatedCredential is the delegated_credential extension of the
+	// leaf, as received by a client. See verifyDelegatedCredential.
+	delegatedCredential []byte
 }
 
 // leaf returns the parsed leaf certificate, either from c.Leaf or by parsing
@@ -686,6 +686,11 @@ func (c *Conn) readRecordOrCCS(expectChangeCipherSpec bool) error {
 	record := c.rawInput.Next(recordHeaderLen + n)
 	data, typ, err := c.in.decrypt(record)
 	if err != nil {
+		// [uTLS SECTION START]
+		if c.skipEarlyData(n) {
+			return c.readRecordOrCCS(expectChangeCipherSpec)
+		}
+		// [uTLS SECTION END]
 		return c.in.setErrorLocked(c.sendAlert(err.(alert)))
 	}
 	if len(data) > maxPlaintext {
@@ -694,8 +699,16 @@ func (c *Conn) readRecordOrCCS(expectChangeCipherSpec bool) error {
 
 	// Application Data messages are always protected.
 	if c.in.cipher == nil && typ == recordTypeApplicationData {
+		// [uTLS SECTION START]
+		if c.skipEarlyData(n) {
+			return c.readRecordOrCCS(expectChangeCipherSpec)
+		}
+		// [uTLS SECTION END]
 		return c.in.setErrorLocked(c.sendAlert(alertUnexpectedMessage))
 	}
+	if typ != recordTypeChangeCipherSpec {
+		c.utls.earlyDataToSkip = 0 // [uTLS] the client is done with 0-RTT data
+	}
 
 	if typ != recordTypeAlert && typ != recordTypeChangeCipherSpec && len(data) > 0 {
 		// This is a state-advancing message: reset the retry count.
@@ -758,6 +771,15 @@ func (c *Conn) readRecordOrCCS(expectChangeCipherSpec bool) error {
 		}
 
 	case recordTypeApplicationData:
+		// [uTLS SECTION START]
+		if !handshakeComplete && !c.isClient && c.in.level == QUICEncryptionLevelEarly {
+			if len(c.utls.receivedEarlyData)+len(data) > int(testingOnlyEarlyData.maxSize) {
+				return c.in.setErrorLocked(c.sendAlert(alertUnexpectedMessage))
+			}
+			c.utls.receivedEarlyData = append(c.utls.receivedEarlyData, data...)
+			return c.readRecordOrCCS(expectChangeCipherSpec)
+		}
+		// [uTLS SECTION END]
 		if !handshakeComplete || expectChangeCipherSpec {
 			return c.in.setErrorLocked(c.sendAlert(alertUnexpectedMessage))
 		}
@@ -891,6 +913,8 @@ const (
 // In the interests of simplicity and determinism, this code does not attempt
 // to reset the record size once the connection is idle, however.
 func (c *Conn) maxPayloadSizeForWrite(typ recordType) int {
+	maxPlaintext := c.utlsMaxPlaintext() // [uTLS] record_size_limit
+
 	if c.config.DynamicRecordSizingDisabled || typ != recordTypeApplicationData {
 		return maxPlaintext
 	}
@@ -995,12 +1019,19 @@ func (c *Conn) writeRecordLocked(typ recordType, data []byte) (int, error) {
 		outBufPool.Put(outBufPtr)
 	}()
 
+	// [uTLS] the ClientHello is split as set with UConn.SetRecordSplitPattern
+	var splitPattern []int
+	if c.isClient && typ == recordTypeHandshake && len(data) > 0 && data[0] == typeClientHello {
+		splitPattern = c.utls.recordSplitPattern
+	}
+
 	var n int
 	for len(data) > 0 {
 		m := len(data)
 		if maxPayload := c.maxPayloadSizeForWrite(typ); m > maxPayload {
 			m = maxPayload
 		}
+		m = splitRecord(splitPattern, n, m) // [uTLS]
 
 		_, outBuf = sliceForAppend(outBuf[:0], recordHeaderLen)
 		outBuf[0] = byte(typ)
@@ -1054,8 +1085,15 @@ func (c *Conn) writeHandshakeRecord(msg handshakeMessage, transcript transcriptH
 	if transcript != nil {
 		transcript.Write(data)
 	}
+	c.recordHandshakeMessage(data) // [uTLS]
 
-	return c.writeRecordLocked(recordTypeHandshake, data)
+	// [uTLS section begins]
+	n, err := c.writeRecordLocked(recordTypeHandshake, data)
+	if err == nil && data[0] == typeClientHello {
+		c.config.HandshakeTrace.clientHelloSent()
+	}
+	return n, err
+	// [uTLS section ends]
 }
 
 // writeChangeCipherRecord writes a ChangeCipherSpec message to the connection and
@@ -1097,6 +1135,10 @@ func (c *Conn) readHandshake(transcript transcriptHash) (any, error) {
 		return nil, err
 	}
 	data = c.hand.Next(4 + n)
+	c.recordHandshakeMessage(data) // [uTLS]
+	if c.isClient && data[0] == typeServerHello {
+		c.config.HandshakeTrace.serverHelloReceived() // [uTLS]
+	}
 	return c.unmarshalHandshakeMessage(data, transcript)
 }
 
@@ -1563,6 +1605,9 @@ func (c *Conn) handshakeContext(ctx context.Context) (ret error) {
 	c.handshakeErr = c.handshakeFn(handshakeCtx)
 	if c.handshakeErr == nil {
 		c.handshakes++
+		if c.isClient {
+			c.config.HandshakeTrace.handshakeComplete() // [uTLS]
+		}
 	} else {
 		// If an error occurred during the handshake try to flush the
 		// alert that might be left in the buffer.
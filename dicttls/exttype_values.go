@@ -62,6 +62,7 @@ const (
 	ExtType_quic_transport_parameters              uint16 = 57
 	ExtType_ticket_request                         uint16 = 58
 	ExtType_dnssec_chain                           uint16 = 59
+	ExtType_encrypted_client_hello                 uint16 = 65037 // https://datatracker.ietf.org/doc/html/draft-ietf-tls-esni
 	ExtType_renegotiation_info                     uint16 = 65281
 )
 
@@ -69,6 +70,7 @@ const (
 const (
 	ExtType_next_protocol_negotiation uint16 = 13172 // https://datatracker.ietf.org/doc/html/draft-agl-tls-nextprotoneg-04
 	ExtType_application_settings      uint16 = 17513 // https://www.ietf.org/archive/id/draft-vvv-tls-alps-01.html
+	ExtType_application_settings_new  uint16 = 17613 // not IANA assigned, used by Chrome since 131
 	ExtType_channel_id_old            uint16 = 30031 // https://datatracker.ietf.org/doc/html/draft-balfanz-tls-channelid-01
 	ExtType_channel_id                uint16 = 30032 // https://datatracker.ietf.org/doc/html/draft-balfanz-tls-channelid-01
 )
@@ -132,10 +134,12 @@ var DictExtTypeValueIndexed = map[uint16]string{
 	57:    "quic_transport_parameters",
 	58:    "ticket_request",
 	59:    "dnssec_chain",
+	65037: "encrypted_client_hello",
 	65281: "renegotiation_info",
 
 	13172: "next_protocol_negotiation",
 	17513: "application_settings",
+	17613: "application_settings_new",
 	30031: "channel_id_old",
 	30032: "channel_id",
 }
@@ -200,10 +204,12 @@ var DictExtTypeNameIndexed = map[string]uint16{
 	"quic_transport_parameters":              57,
 	"ticket_request":                         58,
 	"dnssec_chain":                           59,
+	"encrypted_client_hello":                 65037,
 	"renegotiation_info":                     65281,
 
 	"next_protocol_negotiation": 13172,
 	"application_settings":      17513,
+	"application_settings_new":  17613,
 	"channel_id_old":            30031,
 	"channel_id":                30032,
 }
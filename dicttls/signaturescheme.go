@@ -40,6 +40,18 @@ const (
 	SigScheme_ecdsa_brainpoolP512r1tls13_sha512 uint16 = 0x081C
 )
 
+// Not IANA assigned, the TLS 1.2 SignatureAndHashAlgorithm values still sent
+// by some clients, see RFC 5246, Section 7.4.1.4.1
+const (
+	SigScheme_dsa_sha1         uint16 = 0x0202
+	SigScheme_rsa_pkcs1_sha224 uint16 = 0x0301
+	SigScheme_dsa_sha224       uint16 = 0x0302
+	SigScheme_ecdsa_sha224     uint16 = 0x0303
+	SigScheme_dsa_sha256       uint16 = 0x0402
+	SigScheme_dsa_sha384       uint16 = 0x0502
+	SigScheme_dsa_sha512       uint16 = 0x0602
+)
+
 var DictSignatureSchemeValueIndexed = map[uint16]string{
 	0x0201: "rsa_pkcs1_sha1",
 	0x0203: "ecdsa_sha1",
@@ -75,6 +87,14 @@ var DictSignatureSchemeValueIndexed = map[uint16]string{
 	0x081A: "ecdsa_brainpoolP256r1tls13_sha256",
 	0x081B: "ecdsa_brainpoolP384r1tls13_sha384",
 	0x081C: "ecdsa_brainpoolP512r1tls13_sha512",
+
+	0x0202: "dsa_sha1",
+	0x0301: "rsa_pkcs1_sha224",
+	0x0302: "dsa_sha224",
+	0x0303: "ecdsa_sha224",
+	0x0402: "dsa_sha256",
+	0x0502: "dsa_sha384",
+	0x0602: "dsa_sha512",
 }
 
 var DictSignatureSchemeNameIndexed = map[string]uint16{
@@ -113,4 +133,12 @@ var DictSignatureSchemeNameIndexed = map[string]uint16{
 	"ecdsa_brainpoolP256r1tls13_sha256":   0x081A,
 	"ecdsa_brainpoolP384r1tls13_sha384":   0x081B,
 	"ecdsa_brainpoolP512r1tls13_sha512":   0x081C,
+
+	"dsa_sha1":         0x0202,
+	"rsa_pkcs1_sha224": 0x0301,
+	"dsa_sha224":       0x0302,
+	"ecdsa_sha224":     0x0303,
+	"dsa_sha256":       0x0402,
+	"dsa_sha384":       0x0502,
+	"dsa_sha512":       0x0602,
 }
@@ -50,10 +50,18 @@ const (
 	SupportedGroups_ffdhe4096                       uint16 = 258
 	SupportedGroups_ffdhe6144                       uint16 = 259
 	SupportedGroups_ffdhe8192                       uint16 = 260
+	SupportedGroups_SecP256r1MLKEM768               uint16 = 4587
+	SupportedGroups_X25519MLKEM768                  uint16 = 4588
+	SupportedGroups_SecP384r1MLKEM1024              uint16 = 4589
 	SupportedGroups_arbitrary_explicit_prime_curves uint16 = 65281
 	SupportedGroups_arbitrary_explicit_char2_curves uint16 = 65282
 )
 
+// Not IANA assigned
+const (
+	SupportedGroups_X25519Kyber768Draft00 uint16 = 25497 // https://datatracker.ietf.org/doc/html/draft-tls-westerbaan-xyber768d00-02
+)
+
 var DictSupportedGroupsValueIndexed = map[uint16]string{
 	1:     "sect163k1",
 	2:     "sect163r1",
@@ -101,8 +109,13 @@ var DictSupportedGroupsValueIndexed = map[uint16]string{
 	258:   "ffdhe4096",
 	259:   "ffdhe6144",
 	260:   "ffdhe8192",
+	4587:  "SecP256r1MLKEM768",
+	4588:  "X25519MLKEM768",
+	4589:  "SecP384r1MLKEM1024",
 	65281: "arbitrary_explicit_prime_curves",
 	65282: "arbitrary_explicit_char2_curves",
+
+	25497: "X25519Kyber768Draft00",
 }
 
 var DictSupportedGroupsNameIndexed = map[string]uint16{
@@ -152,6 +165,11 @@ var DictSupportedGroupsNameIndexed = map[string]uint16{
 	"ffdhe4096":                       258,
 	"ffdhe6144":                       259,
 	"ffdhe8192":                       260,
+	"SecP256r1MLKEM768":               4587,
+	"X25519MLKEM768":                  4588,
+	"SecP384r1MLKEM1024":              4589,
 	"arbitrary_explicit_prime_curves": 65281,
 	"arbitrary_explicit_char2_curves": 65282,
+
+	"X25519Kyber768Draft00": 25497,
 }
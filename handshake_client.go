@@ -436,7 +436,7 @@ func (c *Conn) loadSession(hello *clientHelloMsg) (
 		return nil, nil, nil, nil
 	}
 
-	if c.quic != nil && session.EarlyData {
+	if (c.quic != nil || c.utls.earlyData != nil && session.maxEarlyData != 0) && session.EarlyData { // [uTLS] 0-RTT over TCP
 		// For 0-RTT, the cipher suite has to match exactly, and we need to be
 		// offering the same ALPN.
 		if mutualCipherSuiteTLS13(hello.cipherSuites, session.cipherSuite) != nil {
@@ -671,6 +671,10 @@ func (hs *clientHandshakeState) doFullHandshake() error {
 			c.sendAlert(alertUnexpectedMessage)
 			return err
 		}
+		if _, ok := keyAgreement.(*ecdheKeyAgreement); ok {
+			// [uTLS] for UConn.NegotiatedGroup, the curve was checked by processServerKeyExchange
+			c.utls.serverKeyExchangeGroup = CurveID(skx.key[1])<<8 | CurveID(skx.key[2])
+		}
 
 		msg, err = c.readHandshake(&hs.finishedHash)
 		if err != nil {
@@ -848,6 +852,21 @@ func (hs *clientHandshakeState) processServerHello() (bool, error) {
 		}
 	}
 
+	// [uTLS] A parrot may not offer extended_master_secret, in which case the
+	// master secret must be derived the legacy way (RFC 7627, Section 5.2).
+	if hs.serverHello.extendedMasterSecret && !hs.hello.extendedMasterSecret {
+		c.sendAlert(alertUnsupportedExtension)
+		return false, errors.New("tls: server sent an unsolicited extended_master_secret extension")
+	}
+	if err := c.utlsSetMaxFragmentLength(hs.serverHello.maxFragmentLength); err != nil { // [uTLS]
+		c.sendAlert(alertIllegalParameter)
+		return false, err
+	}
+	if err := c.utlsSetRecordSizeLimit(hs.serverHello.recordSizeLimit); err != nil { // [uTLS]
+		c.sendAlert(alertIllegalParameter)
+		return false, err
+	}
+
 	if err := checkALPN(hs.hello.alpnProtocols, hs.serverHello.alpnProtocol, false); err != nil {
 		c.sendAlert(alertUnsupportedExtension)
 		return false, err
@@ -1075,6 +1094,11 @@ func (c *Conn) verifyServerCertificate(certificates [][]byte) error {
 		} else if c.config.InsecureServerNameToVerify != "*" {
 			opts.DNSName = c.config.InsecureServerNameToVerify
 		}
+		if c.utls.ech != nil && c.utls.ech.rejected {
+			// the server authenticates the ECH public name instead, see
+			// Section 6.1.7 of draft-ietf-tls-esni
+			opts.DNSName = c.utls.ech.publicName
+		}
 		// [UTLS SECTION END]
 
 		for _, cert := range certs[1:] {
@@ -1088,6 +1112,12 @@ func (c *Conn) verifyServerCertificate(certificates [][]byte) error {
 		}
 	}
 
+	// [uTLS] pins set with UConn.SetPinnedCerts are checked once the chain is verified
+	if err := c.checkPinnedCerts(certs); err != nil {
+		c.sendAlert(alertBadCertificate)
+		return err
+	}
+
 	switch certs[0].PublicKey.(type) {
 	case *rsa.PublicKey, *ecdsa.PublicKey, ed25519.PublicKey, circlSign.PublicKey: // [UTLS] ported from cloudflare/go
 		break
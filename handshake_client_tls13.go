@@ -35,9 +35,7 @@ func NewKeySharesParameters() *KeySharesParameters {
 	return &KeySharesParameters{
 		ecdhePrivKeymap: make(map[CurveID]*ecdh.PrivateKey),
 		ecdhePubKeymap:  make(map[CurveID]*ecdh.PublicKey),
-
-		kemPrivKeymap: make(map[CurveID]kem.PrivateKey),
-		kemPubKeymap:  make(map[CurveID]kem.PublicKey),
+		// the kem maps are only made by AddKemKeypair, as few parrots offer circl schemes
 	}
 }
 
@@ -58,6 +56,10 @@ func (ksp *KeySharesParameters) GetEcdhePubkey(curveID CurveID) (params *ecdh.Pu
 
 func (ksp *KeySharesParameters) AddKemKeypair(curveID CurveID, kemKey kem.PrivateKey, kemPubKey kem.PublicKey) {
 	if curveIdToCirclScheme(curveID) != nil { // only store for circl schemes
+		if ksp.kemPrivKeymap == nil {
+			ksp.kemPrivKeymap = make(map[CurveID]kem.PrivateKey)
+			ksp.kemPubKeymap = make(map[CurveID]kem.PublicKey)
+		}
 		ksp.kemPrivKeymap[curveID] = kemKey
 		ksp.kemPubKeymap[curveID] = kemPubKey
 	}
@@ -98,6 +100,10 @@ type clientHandshakeStateTLS13 struct {
 	trafficSecret []byte // client_application_traffic_secret_0
 
 	uconn *UConn // [uTLS]
+
+	// [uTLS] client_handshake_traffic_secret, installed once EndOfEarlyData
+	// is sent, while 0-RTT data is being sent over TCP
+	pendingClientSecret []byte
 }
 
 // handshake requires hs.c, hs.hello, hs.serverHello, hs.ecdheKey, and,
@@ -142,6 +148,12 @@ func (hs *clientHandshakeStateTLS13) handshake() error {
 		return err
 	}
 
+	// [uTLS SECTION START]
+	if err := hs.utlsCheckECHAcceptance(); err != nil {
+		return err
+	}
+	// [uTLS SECTION END]
+
 	hs.transcript = hs.suite.hash.New()
 
 	if err := transcriptMsg(hs.hello, hs.transcript); err != nil {
@@ -195,6 +207,13 @@ func (hs *clientHandshakeStateTLS13) handshake() error {
 		return err
 	}
 
+	// [uTLS SECTION START]
+	if c.utls.ech != nil && c.utls.ech.rejected {
+		c.sendAlert(alertECHRequired)
+		return &ECHRejectionError{RetryConfigs: c.utls.echRetryConfigs}
+	}
+	// [uTLS SECTION END]
+
 	c.isHandshakeComplete.Store(true)
 
 	return nil
@@ -274,6 +293,7 @@ func (hs *clientHandshakeStateTLS13) sendDummyChangeCipherSpec() error {
 // resends hs.hello, and reads the new ServerHello into hs.serverHello.
 func (hs *clientHandshakeStateTLS13) processHelloRetryRequest() error {
 	c := hs.c
+	c.utls.helloRetryRequest = hs.serverHello // [uTLS] for UConn.ServerHelloInfo
 
 	// The first ClientHello gets double-hashed into the transcript upon a
 	// HelloRetryRequest. (The idea is that the server might offload transcript
@@ -415,6 +435,9 @@ func (hs *clientHandshakeStateTLS13) processHelloRetryRequest() error {
 				return errors.New("uTLS does not support reprocessing of PSK key triggered by HelloRetryRequest")
 			}
 
+			// The extensions keep their order and GREASE values. Like
+			// BoringSSL, no GREASE key share is sent along with the one the
+			// server asked for, as RFC 8446, Section 4.1.2 requires.
 			keyShareExtFound := false
 			for _, ext := range hs.uconn.Extensions {
 				// new ks seems to be generated either way
@@ -429,32 +452,7 @@ func (hs *clientHandshakeStateTLS13) processHelloRetryRequest() error {
 			}
 
 			if len(hs.serverHello.cookie) > 0 {
-				// serverHello specified a cookie, let's echo it
-				cookieFound := false
-				for _, ext := range hs.uconn.Extensions {
-					if ks, ok := ext.(*CookieExtension); ok {
-						ks.Cookie = hs.serverHello.cookie
-						cookieFound = true
-					}
-				}
-
-				if !cookieFound {
-					// pick a random index where to add cookieExtension
-					// -2 instead of -1 is a lazy way to ensure that PSK is still a last extension
-					p, err := newPRNG()
-					if err != nil {
-						return err
-					}
-					cookieIndex := p.Intn(len(hs.uconn.Extensions) - 2)
-					if cookieIndex >= len(hs.uconn.Extensions) {
-						// this check is for empty hs.uconn.Extensions
-						return fmt.Errorf("cookieIndex >= len(hs.uconn.Extensions): %v >= %v",
-							cookieIndex, len(hs.uconn.Extensions))
-					}
-					hs.uconn.Extensions = append(hs.uconn.Extensions[:cookieIndex],
-						append([]TLSExtension{&CookieExtension{Cookie: hs.serverHello.cookie}},
-							hs.uconn.Extensions[cookieIndex:]...)...)
-				}
+				hs.uconn.setCookieExtension(hs.serverHello.cookie)
 			}
 			if err := hs.uconn.MarshalClientHello(); err != nil {
 				return err
@@ -465,9 +463,22 @@ func (hs *clientHandshakeStateTLS13) processHelloRetryRequest() error {
 	// [uTLS SECTION ENDS]
 	if hs.hello.earlyData {
 		hs.hello.earlyData = false
-		c.quicRejectedEarlyData()
+		// [uTLS SECTION START]
+		if c.quic == nil {
+			// The second ClientHello is not protected by the 0-RTT keys.
+			c.out.cipher, c.out.trafficSecret, c.out.level = nil, nil, QUICEncryptionLevelInitial
+			c.out.seq = [8]byte{}
+		} else {
+			c.quicRejectedEarlyData()
+		}
+		// [uTLS SECTION END]
 	}
 
+	// [uTLS SECTION START]
+	if err := c.runClientHelloCallback(hs.hello); err != nil {
+		return err
+	}
+	// [uTLS SECTION END]
 	if _, err := hs.c.writeHandshakeRecord(hs.hello, hs.transcript); err != nil {
 		return err
 	}
@@ -527,6 +538,11 @@ func (hs *clientHandshakeStateTLS13) processServerHello() error {
 	}
 	if !supportedGroupCompatible { // none matched
 		c.sendAlert(alertIllegalParameter)
+		for _, ks := range hs.hello.keyShares {
+			if ks.group == hs.serverHello.serverShare.group {
+				return errors.New("tls: server selected a group whose key share was provided without a private key")
+			}
+		}
 		return errors.New("tls: server selected unsupported group")
 	}
 	// [UTLS SECTION ENDS]
@@ -608,7 +624,13 @@ func (hs *clientHandshakeStateTLS13) establishHandshakeKeys() error {
 
 	clientSecret := hs.suite.deriveSecret(handshakeSecret,
 		clientHandshakeTrafficLabel, hs.transcript)
-	c.out.setTrafficSecret(hs.suite, QUICEncryptionLevelHandshake, clientSecret)
+	// [uTLS SECTION START]
+	if hs.hello.earlyData && c.quic == nil {
+		hs.pendingClientSecret = clientSecret
+	} else {
+		c.out.setTrafficSecret(hs.suite, QUICEncryptionLevelHandshake, clientSecret)
+	}
+	// [uTLS SECTION END]
 	serverSecret := hs.suite.deriveSecret(handshakeSecret,
 		serverHandshakeTrafficLabel, hs.transcript)
 	c.in.setTrafficSecret(hs.suite, QUICEncryptionLevelHandshake, serverSecret)
@@ -690,10 +712,11 @@ func (hs *clientHandshakeStateTLS13) readServerParameters() error {
 		c.sendAlert(alertUnsupportedExtension)
 		return errors.New("tls: server sent an unexpected early_data extension")
 	}
-	if hs.hello.earlyData && !encryptedExtensions.earlyData {
+	if hs.hello.earlyData && !encryptedExtensions.earlyData && c.quic != nil { // [uTLS] also sent over TCP
 		c.quicRejectedEarlyData()
 	}
 	if encryptedExtensions.earlyData {
+		c.utls.earlyDataAccepted = true // [uTLS]
 		if hs.session.cipherSuite != c.cipherSuite {
 			c.sendAlert(alertHandshakeFailure)
 			return errors.New("tls: server accepted 0-RTT with the wrong cipher suite")
@@ -783,6 +806,26 @@ func (hs *clientHandshakeStateTLS13) readServerCertificate() error {
 		return err
 	}
 
+	// [UTLS SECTION BEGINS]
+	// A delegated credential replaces the key of the certificate for the
+	// CertificateVerify, see RFC 9345, Section 4.1.3.
+	peerKey := c.peerCertificates[0].PublicKey
+	certVerifyAlgs := c.config.supportedSignatureAlgorithms()
+	if dc := certMsg.certificate.delegatedCredential; dc != nil {
+		if hs.uconn == nil || len(hs.uconn.delegatedCredentialAlgs) == 0 {
+			c.sendAlert(alertUnsupportedExtension)
+			return errors.New("tls: server sent an unrequested delegated credential")
+		}
+		cred, err := verifyDelegatedCredential(dc, c.peerCertificates[0], c.config.time(), hs.uconn.delegatedCredentialAlgs)
+		if err != nil {
+			c.sendAlert(alertIllegalParameter)
+			return err
+		}
+		peerKey = cred.publicKey
+		certVerifyAlgs = []SignatureScheme{cred.certVerifyAlgorithm}
+	}
+	// [UTLS SECTION ENDS]
+
 	// certificateVerifyMsg is included in the transcript, but not until
 	// after we verify the handshake signature, since the state before
 	// this message was sent is used.
@@ -798,7 +841,7 @@ func (hs *clientHandshakeStateTLS13) readServerCertificate() error {
 	}
 
 	// See RFC 8446, Section 4.4.3.
-	if !isSupportedSignatureAlgorithm(certVerify.signatureAlgorithm, c.config.supportedSignatureAlgorithms()) { // [UTLS] ported from cloudflare/go
+	if !isSupportedSignatureAlgorithm(certVerify.signatureAlgorithm, certVerifyAlgs) { // [UTLS] ported from cloudflare/go
 		c.sendAlert(alertIllegalParameter)
 		return errors.New("tls: certificate used with invalid signature algorithm")
 	}
@@ -811,7 +854,7 @@ func (hs *clientHandshakeStateTLS13) readServerCertificate() error {
 		return errors.New("tls: certificate used with invalid signature algorithm")
 	}
 	signed := signedMessage(sigHash, serverSignatureContext, hs.transcript)
-	if err := verifyHandshakeSignature(sigType, c.peerCertificates[0].PublicKey,
+	if err := verifyHandshakeSignature(sigType, peerKey, // [UTLS] the key of the delegated credential, if any
 		sigHash, signed, certVerify.signature); err != nil {
 		c.sendAlert(alertDecryptError)
 		return errors.New("tls: invalid signature by the server certificate: " + err.Error())
@@ -1013,6 +1056,12 @@ func (c *Conn) handleNewSessionTicket(msg *newSessionTicketMsgTLS13) error {
 	session.useBy = uint64(c.config.time().Add(lifetime).Unix())
 	session.ageAdd = msg.ageAdd
 	session.EarlyData = c.quic != nil && msg.maxEarlyData == 0xffffffff // RFC 9001, Section 4.6.1
+	// [uTLS SECTION START]
+	if c.quic == nil && msg.maxEarlyData != 0 {
+		session.EarlyData = true
+		session.maxEarlyData = msg.maxEarlyData
+	}
+	// [uTLS SECTION END]
 	cs := &ClientSessionState{ticket: msg.label, session: session}
 
 	if cacheKey := c.clientSessionCacheKey(); cacheKey != "" {
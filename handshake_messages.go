@@ -654,8 +654,10 @@ type serverHelloMsg struct {
 	selectedGroup CurveID
 
 	// [uTLS]
-	nextProtoNeg bool
-	nextProtos   []string
+	nextProtoNeg      bool
+	nextProtos        []string
+	maxFragmentLength uint8
+	recordSizeLimit   uint16
 }
 
 func (m *serverHelloMsg) marshal() ([]byte, error) {
@@ -750,6 +752,18 @@ func (m *serverHelloMsg) marshal() ([]byte, error) {
 			})
 		})
 	}
+	if m.maxFragmentLength != 0 { // [uTLS]
+		exts.AddUint16(fakeExtensionMaxFragmentLength)
+		exts.AddUint16LengthPrefixed(func(exts *cryptobyte.Builder) {
+			exts.AddUint8(m.maxFragmentLength)
+		})
+	}
+	if m.recordSizeLimit != 0 { // [uTLS]
+		exts.AddUint16(fakeRecordSizeLimit)
+		exts.AddUint16LengthPrefixed(func(exts *cryptobyte.Builder) {
+			exts.AddUint16(m.recordSizeLimit)
+		})
+	}
 
 	extBytes, err := exts.Bytes()
 	if err != nil {
@@ -890,6 +904,16 @@ func (m *serverHelloMsg) unmarshal(data []byte) bool {
 				len(m.supportedPoints) == 0 {
 				return false
 			}
+		case fakeExtensionMaxFragmentLength: // [uTLS]
+			// RFC 6066, Section 4
+			if !extData.ReadUint8(&m.maxFragmentLength) || m.maxFragmentLength == 0 {
+				return false
+			}
+		case fakeRecordSizeLimit: // [uTLS]
+			// RFC 8449, Section 4
+			if !extData.ReadUint16(&m.recordSizeLimit) || m.recordSizeLimit == 0 {
+				return false
+			}
 		default:
 			// Ignore unknown extensions.
 			continue
@@ -943,6 +967,18 @@ func (m *encryptedExtensionsMsg) marshal() ([]byte, error) {
 				b.AddUint16(extensionEarlyData)
 				b.AddUint16(0) // empty extension_data
 			}
+			if m.utls.maxFragmentLength != 0 { // [uTLS]
+				b.AddUint16(fakeExtensionMaxFragmentLength)
+				b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+					b.AddUint8(m.utls.maxFragmentLength)
+				})
+			}
+			if m.utls.recordSizeLimit != 0 { // [uTLS]
+				b.AddUint16(fakeRecordSizeLimit)
+				b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+					b.AddUint16(m.utls.recordSizeLimit)
+				})
+			}
 		})
 	})
 
@@ -1508,6 +1544,12 @@ func unmarshalCertificate(s *cryptobyte.String, certificate *Certificate) bool {
 					certificate.SignedCertificateTimestamps = append(
 						certificate.SignedCertificateTimestamps, sct)
 				}
+			case extensionDelegatedCredentials: // [uTLS]
+				if len(extData) == 0 {
+					return false
+				}
+				certificate.delegatedCredential = extData
+				extData = nil
 			default:
 				// Ignore unknown extensions.
 				continue
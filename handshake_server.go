@@ -225,6 +225,8 @@ func (hs *serverHandshakeState) processClientHello() error {
 	}
 
 	hs.hello.extendedMasterSecret = hs.clientHello.extendedMasterSecret
+	hs.hello.maxFragmentLength = testingOnlyMaxFragmentLength // [uTLS]
+	hs.hello.recordSizeLimit = testingOnlyRecordSizeLimit     // [uTLS]
 	hs.hello.secureRenegotiationSupported = hs.clientHello.secureRenegotiationSupported
 	hs.hello.compressionMethod = compressionNone
 	if len(hs.clientHello.serverName) > 0 {
@@ -369,7 +371,15 @@ func (hs *serverHandshakeState) pickCipherSuite() error {
 		}
 	}
 
-	hs.suite = selectCipherSuite(preferenceList, hs.clientHello.cipherSuites, hs.cipherSuiteOk)
+	// [uTLS] the ServerHelloSpec of a UServerConn may select the cipher suite
+	if id := c.utlsSelectCipherSuite(func() *ClientHelloInfo { return clientHelloInfo(hs.ctx, c, hs.clientHello) }); id != 0 {
+		if hs.suite = selectCipherSuite([]uint16{id}, hs.clientHello.cipherSuites, hs.cipherSuiteOk); hs.suite == nil {
+			c.sendAlert(alertHandshakeFailure)
+			return fmt.Errorf("tls: ServerHelloSpec selected cipher suite %s, which can't be negotiated", CipherSuiteName(id))
+		}
+	} else {
+		hs.suite = selectCipherSuite(preferenceList, hs.clientHello.cipherSuites, hs.cipherSuiteOk)
+	}
 	if hs.suite == nil {
 		c.sendAlert(alertHandshakeFailure)
 		return errors.New("tls: no cipher suite supported by both client and server")
@@ -534,6 +544,9 @@ func (hs *serverHandshakeState) doResumeHandshake() error {
 	if err := transcriptMsg(hs.clientHello, &hs.finishedHash); err != nil {
 		return err
 	}
+	if err := c.utlsMarshalServerHello(hs.hello); err != nil { // [uTLS]
+		return err
+	}
 	if _, err := hs.c.writeHandshakeRecord(hs.hello, &hs.finishedHash); err != nil {
 		return err
 	}
@@ -569,6 +582,9 @@ func (hs *serverHandshakeState) doFullHandshake() error {
 	if err := transcriptMsg(hs.clientHello, &hs.finishedHash); err != nil {
 		return err
 	}
+	if err := c.utlsMarshalServerHello(hs.hello); err != nil { // [uTLS]
+		return err
+	}
 	if _, err := hs.c.writeHandshakeRecord(hs.hello, &hs.finishedHash); err != nil {
 		return err
 	}
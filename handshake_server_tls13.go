@@ -42,6 +42,10 @@ type serverHandshakeStateTLS13 struct {
 	trafficSecret   []byte // client_application_traffic_secret_0
 	transcript      hash.Hash
 	clientFinished  []byte
+
+	// [uTLS] client_handshake_traffic_secret, installed once EndOfEarlyData
+	// is read, while 0-RTT data is being received over TCP
+	pendingClientSecret []byte
 }
 
 func (hs *serverHandshakeStateTLS13) handshake() error {
@@ -77,12 +81,18 @@ func (hs *serverHandshakeStateTLS13) handshake() error {
 	if _, err := c.flush(); err != nil {
 		return err
 	}
+	// [uTLS SECTION START]
+	if err := hs.readEndOfEarlyData(); err != nil {
+		return err
+	}
+	// [uTLS SECTION END]
 	if err := hs.readClientCertificate(); err != nil {
 		return err
 	}
 	if err := hs.readClientFinished(); err != nil {
 		return err
 	}
+	c.input.Reset(c.utls.receivedEarlyData) // [uTLS] read before anything else
 
 	c.isHandshakeComplete.Store(true)
 
@@ -147,6 +157,10 @@ func (hs *serverHandshakeStateTLS13) processClientHello() error {
 			c.sendAlert(alertIllegalParameter)
 			return errors.New("tls: early_data without pre_shared_key")
 		}
+	} else if hs.clientHello.earlyData && testingOnlyEarlyData.maxSize != 0 { // [uTLS]
+		// Skipped as described in RFC 8446, Section 4.2.10, unless accepted
+		// by checkForResumption.
+		c.utls.earlyDataToSkip = int(testingOnlyEarlyData.maxSize)
 	} else if hs.clientHello.earlyData {
 		// See RFC 8446, Section 4.2.10 for the complicated behavior required
 		// here. The scenario is that a different server at our address offered
@@ -168,6 +182,16 @@ func (hs *serverHandshakeStateTLS13) processClientHello() error {
 	if needFIPS() {
 		preferenceList = defaultCipherSuitesTLS13FIPS
 	}
+	// [uTLS SECTION BEGINS]
+	// the ServerHelloSpec of a UServerConn may select the cipher suite
+	if id := c.utlsSelectCipherSuite(func() *ClientHelloInfo { return clientHelloInfo(hs.ctx, c, hs.clientHello) }); id != 0 {
+		preferenceList = nil
+		if hs.suite = mutualCipherSuiteTLS13(hs.clientHello.cipherSuites, id); hs.suite == nil {
+			c.sendAlert(alertHandshakeFailure)
+			return fmt.Errorf("tls: ServerHelloSpec selected cipher suite %s, which can't be negotiated", CipherSuiteName(id))
+		}
+	}
+	// [uTLS SECTION ENDS]
 	for _, suiteID := range preferenceList {
 		hs.suite = mutualCipherSuiteTLS13(hs.clientHello.cipherSuites, suiteID)
 		if hs.suite != nil {
@@ -386,7 +410,8 @@ func (hs *serverHandshakeStateTLS13) checkForResumption() error {
 			return errors.New("tls: invalid PSK binder")
 		}
 
-		if c.quic != nil && hs.clientHello.earlyData && i == 0 &&
+		if (c.quic != nil || testingOnlyEarlyData.maxSize != 0 && !testingOnlyEarlyData.reject) && // [uTLS] 0-RTT over TCP
+			hs.clientHello.earlyData && i == 0 &&
 			sessionState.EarlyData && sessionState.cipherSuite == hs.suite.id &&
 			sessionState.alpnProtocol == c.clientProtocol {
 			hs.earlyData = true
@@ -396,7 +421,14 @@ func (hs *serverHandshakeStateTLS13) checkForResumption() error {
 				return err
 			}
 			earlyTrafficSecret := hs.suite.deriveSecret(hs.earlySecret, clientEarlyTrafficLabel, transcript)
-			c.quicSetReadSecret(QUICEncryptionLevelEarly, hs.suite.id, earlyTrafficSecret)
+			// [uTLS SECTION START]
+			if c.quic == nil {
+				c.utls.earlyDataToSkip = 0
+				c.in.setTrafficSecret(hs.suite, QUICEncryptionLevelEarly, earlyTrafficSecret)
+			} else {
+				c.quicSetReadSecret(QUICEncryptionLevelEarly, hs.suite.id, earlyTrafficSecret)
+			}
+			// [uTLS SECTION END]
 		}
 
 		c.didResume = true
@@ -513,6 +545,9 @@ func (hs *serverHandshakeStateTLS13) doHelloRetryRequest(selectedGroup CurveID)
 		selectedGroup:     selectedGroup,
 	}
 
+	if err := c.utlsMarshalServerHello(helloRetryRequest); err != nil { // [uTLS]
+		return err
+	}
 	if _, err := hs.c.writeHandshakeRecord(helloRetryRequest, hs.transcript); err != nil {
 		return err
 	}
@@ -616,6 +651,9 @@ func (hs *serverHandshakeStateTLS13) sendServerParameters() error {
 	if err := transcriptMsg(hs.clientHello, hs.transcript); err != nil {
 		return err
 	}
+	if err := c.utlsMarshalServerHello(hs.hello); err != nil { // [uTLS]
+		return err
+	}
 	if _, err := hs.c.writeHandshakeRecord(hs.hello, hs.transcript); err != nil {
 		return err
 	}
@@ -633,7 +671,13 @@ func (hs *serverHandshakeStateTLS13) sendServerParameters() error {
 
 	clientSecret := hs.suite.deriveSecret(hs.handshakeSecret,
 		clientHandshakeTrafficLabel, hs.transcript)
-	c.in.setTrafficSecret(hs.suite, QUICEncryptionLevelHandshake, clientSecret)
+	// [uTLS SECTION START]
+	if hs.earlyData && c.quic == nil {
+		hs.pendingClientSecret = clientSecret
+	} else {
+		c.in.setTrafficSecret(hs.suite, QUICEncryptionLevelHandshake, clientSecret)
+	}
+	// [uTLS SECTION END]
 	serverSecret := hs.suite.deriveSecret(hs.handshakeSecret,
 		serverHandshakeTrafficLabel, hs.transcript)
 	c.out.setTrafficSecret(hs.suite, QUICEncryptionLevelHandshake, serverSecret)
@@ -668,6 +712,10 @@ func (hs *serverHandshakeStateTLS13) sendServerParameters() error {
 		encryptedExtensions.quicTransportParameters = p
 		encryptedExtensions.earlyData = hs.earlyData
 	}
+	encryptedExtensions.earlyData = hs.earlyData // [uTLS] also over TCP
+
+	encryptedExtensions.utls.maxFragmentLength = testingOnlyMaxFragmentLength // [uTLS]
+	encryptedExtensions.utls.recordSizeLimit = testingOnlyRecordSizeLimit     // [uTLS]
 
 	if _, err := hs.c.writeHandshakeRecord(encryptedExtensions, hs.transcript); err != nil {
 		return err
@@ -709,9 +757,18 @@ func (hs *serverHandshakeStateTLS13) sendServerCertificate() error {
 	certMsg.scts = hs.clientHello.scts && len(hs.cert.SignedCertificateTimestamps) > 0
 	certMsg.ocspStapling = hs.clientHello.ocspStapling && len(hs.cert.OCSPStaple) > 0
 
-	if _, err := hs.c.writeHandshakeRecord(certMsg, hs.transcript); err != nil {
+	// [UTLS SECTION BEGINS]
+	var certHandshakeMsg handshakeMessage = certMsg
+	if testingOnlyCompressCertificate != nil {
+		var err error
+		if certHandshakeMsg, err = testingOnlyCompressCertificate(certMsg); err != nil {
+			return c.sendAlert(alertInternalError)
+		}
+	}
+	if _, err := hs.c.writeHandshakeRecord(certHandshakeMsg, hs.transcript); err != nil {
 		return err
 	}
+	// [UTLS SECTION ENDS]
 
 	certVerifyMsg := new(certificateVerifyMsg)
 	certVerifyMsg.hasSignatureAlgorithm = true
@@ -793,7 +850,7 @@ func (hs *serverHandshakeStateTLS13) sendServerFinished() error {
 	// If we did not request client certificates, at this point we can
 	// precompute the client finished and roll the transcript forward to send
 	// session tickets in our first flight.
-	if !hs.requestClientCert() {
+	if !hs.requestClientCert() && hs.pendingClientSecret == nil { // [uTLS] after EndOfEarlyData
 		if err := hs.sendSessionTickets(); err != nil {
 			return err
 		}
@@ -838,7 +895,7 @@ func (hs *serverHandshakeStateTLS13) sendSessionTickets() error {
 	if !hs.shouldSendSessionTickets() {
 		return nil
 	}
-	return c.sendSessionTicket(false)
+	return c.sendSessionTicket(testingOnlyEarlyData.maxSize != 0) // [uTLS]
 }
 
 func (c *Conn) sendSessionTicket(earlyData bool) error {
@@ -890,6 +947,9 @@ func (c *Conn) sendSessionTicket(earlyData bool) error {
 	if earlyData {
 		// RFC 9001, Section 4.6.1
 		m.maxEarlyData = 0xffffffff
+		if c.quic == nil {
+			m.maxEarlyData = testingOnlyEarlyData.maxSize // [uTLS]
+		}
 	}
 
 	if _, err := c.writeHandshakeRecord(m, nil); err != nil {
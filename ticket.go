@@ -96,6 +96,10 @@ type SessionState struct {
 	// Client-side TLS 1.3-only fields.
 	useBy  uint64 // seconds since UNIX epoch
 	ageAdd uint32
+
+	// maxEarlyData is the max_early_data_size of a ticket received over TCP.
+	// It is not serialized, so EarlyData alone doesn't allow 0-RTT over TCP.
+	maxEarlyData uint32 // [uTLS]
 }
 
 // Bytes encodes the session, including any private fields, so that it can be
@@ -876,6 +876,8 @@ func TestCloneNonFuncFields(t *testing.T) {
 			f.Set(reflect.ValueOf(map[string][]byte{"a": {1}}))
 		case "ECHConfigs": // [UTLS] ECH (Encrypted Client Hello) Configs
 			f.Set(reflect.ValueOf([]ECHConfig{{Version: 1}}))
+		case "HandshakeTrace": // [uTLS]
+			f.Set(reflect.ValueOf(&HandshakeTrace{}))
 		default:
 			t.Errorf("all fields must be accounted for, but saw unknown field %q", fn)
 		}
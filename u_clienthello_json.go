@@ -1,10 +1,12 @@
 package tls
 
 import (
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"os"
+	"strings"
 
 	"github.com/refraction-networking/utls/dicttls"
 )
@@ -17,6 +19,10 @@ type ClientHelloSpecJSONUnmarshaler struct {
 	Extensions         *TLSExtensionsJSONUnmarshaler      `json:"extensions"`
 	TLSVersMin         uint16                             `json:"min_vers,omitempty"` // optional
 	TLSVersMax         uint16                             `json:"max_vers,omitempty"` // optional
+
+	ForceExtensionOrder  bool `json:"force_extension_order,omitempty"`  // optional
+	GREASECipherCount    int  `json:"grease_cipher_count,omitempty"`    // optional
+	GREASEExtensionCount int  `json:"grease_extension_count,omitempty"` // optional
 }
 
 func (chsju *ClientHelloSpecJSONUnmarshaler) ClientHelloSpec() ClientHelloSpec {
@@ -26,6 +32,10 @@ func (chsju *ClientHelloSpecJSONUnmarshaler) ClientHelloSpec() ClientHelloSpec {
 		Extensions:         chsju.Extensions.Extensions(),
 		TLSVersMin:         chsju.TLSVersMin,
 		TLSVersMax:         chsju.TLSVersMax,
+
+		ForceExtensionOrder:  chsju.ForceExtensionOrder,
+		GREASECipherCount:    chsju.GREASECipherCount,
+		GREASEExtensionCount: chsju.GREASEExtensionCount,
 	}
 }
 
@@ -103,6 +113,12 @@ func (e *TLSExtensionsJSONUnmarshaler) UnmarshalJSON(jsonStr []byte) error {
 			continue
 		}
 
+		if accepter.extNameOnly.Id != nil {
+			// marshaled by GenericExtension.MarshalJSON, with its payload
+			exts = append(exts, &GenericExtension{})
+			continue
+		}
+
 		if extID, ok := dicttls.DictExtTypeNameIndexed[accepter.extNameOnly.Name]; !ok {
 			return fmt.Errorf("%w: %s", ErrUnknownExtension, accepter.extNameOnly.Name)
 		} else {
@@ -172,7 +188,8 @@ func genericExtension(id uint16, name string) TLSExtension {
 
 type tlsExtensionJSONAccepter struct {
 	extNameOnly struct {
-		Name string `json:"name"`
+		Name string  `json:"name"`
+		Id   *uint16 `json:"id"` // only set for generic extensions and GREASE
 	}
 	origJsonInput []byte
 }
@@ -182,3 +199,62 @@ func (t *tlsExtensionJSONAccepter) UnmarshalJSON(jsonStr []byte) error {
 	copy(t.origJsonInput, jsonStr)
 	return json.Unmarshal(jsonStr, &t.extNameOnly)
 }
+
+// hexBytes is opaque data in JSON, written as a hex string after a "hex:"
+// marker, which isn't in the base64 alphabet. Strings without it are read as
+// base64, the encoding/json default that these fields used before, and an
+// array of bytes is also accepted.
+type hexBytes []byte
+
+const hexBytesMarker = "hex:"
+
+func (b hexBytes) MarshalJSON() ([]byte, error) {
+	return json.Marshal(hexBytesMarker + hex.EncodeToString(b))
+}
+
+func (b *hexBytes) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil || !strings.HasPrefix(s, hexBytesMarker) {
+		return json.Unmarshal(data, (*[]byte)(b))
+	}
+	var err error
+	*b, err = hex.DecodeString(strings.TrimPrefix(s, hexBytesMarker))
+	return err
+}
+
+// jsonNames returns the names of values in dict, for JSON. GREASE values are
+// named "GREASE".
+func jsonNames[V, K ~uint8 | ~uint16](values []V, dict map[K]string, what string) ([]string, error) {
+	names := make([]string, 0, len(values))
+	for _, v := range values {
+		if isGREASEUint16(uint16(v)) {
+			names = append(names, "GREASE")
+			continue
+		}
+		name, ok := dict[K(v)]
+		if !ok {
+			return nil, fmt.Errorf("tls: %s %d has no JSON name", what, v)
+		}
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+// extensionJSONName returns the name of the extension type id in JSON.
+func extensionJSONName(id uint16) (string, error) {
+	if name, ok := dicttls.DictExtTypeValueIndexed[id]; ok {
+		return name, nil
+	}
+	return "", fmt.Errorf("tls: extension %d has no JSON name", id)
+}
+
+// marshalExtensionName marshals an extension without fields to JSON.
+func marshalExtensionName(id uint16) ([]byte, error) {
+	name, err := extensionJSONName(id)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(struct {
+		Name string `json:"name"`
+	}{name})
+}
@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"os"
 	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -121,3 +122,86 @@ func testClientHelloSpecUnmarshalJSON(
 func clientHelloSpecJSONTestIdentifier(id ClientHelloID) string {
 	return id.Client + id.Version
 }
+
+func TestClientHelloSpecMarshalJSON(t *testing.T) {
+	spec, err := utlsIdToSpec(HelloChrome_120)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// an extension without a name, which is kept with its payload
+	spec.Extensions = append(spec.Extensions, &GenericExtension{Id: 0x1234, Data: []byte{0xab, 0xcd}})
+
+	jsonCH, err := spec.MarshalJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(jsonCH), `{"id":4660,"data":"hex:abcd"}`) {
+		t.Errorf("the unknown extension is missing from %s", jsonCH)
+	}
+	again, err := json.Marshal(&spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(again) != string(jsonCH) {
+		t.Errorf("the same ClientHelloSpec marshaled to different JSON:\n%s\n%s", jsonCH, again)
+	}
+
+	jsonSpec, err := ClientHelloSpecFromJSON(jsonCH)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(*jsonSpec, spec) {
+		t.Errorf("ClientHelloSpecFromJSON(%s) = %#v, want %#v", jsonCH, *jsonSpec, spec)
+	}
+
+	spec.Extensions = append(spec.Extensions, &QUICTransportParametersExtension{})
+	if _, err := spec.MarshalJSON(); err == nil {
+		t.Error("marshaling an extension without JSON support succeeded, expected an error")
+	}
+}
+
+func TestClientHelloSpecUnmarshalJSONBase64(t *testing.T) {
+	// opaque data as written before the hex encoding: encoding/json's base64
+	jsonCH := []byte(`{
+		"cipher_suites": ["TLS_AES_128_GCM_SHA256"],
+		"compression_methods": ["NULL"],
+		"extensions": [
+			{"name": "key_share", "client_shares": [{"group": "x25519", "key_exchange": "AAEC"}]},
+			{"name": "cookie", "cookie": "AwQ="},
+			{"id": 4660, "data": "q80="},
+			{"name": "pre_shared_key", "identities": [{"identity": "BQY=", "obfuscated_ticket_age": 7}], "binders": ["CAk="]}
+		]
+	}`)
+	spec, err := ClientHelloSpecFromJSON(jsonCH)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []TLSExtension{
+		&KeyShareExtension{KeyShares: []KeyShare{{Group: X25519, Data: []byte{0, 1, 2}}}},
+		&CookieExtension{Cookie: []byte{3, 4}},
+		&GenericExtension{Id: 0x1234, Data: []byte{0xab, 0xcd}},
+		&FakePreSharedKeyExtension{
+			Identities: []PskIdentity{{Label: []byte{5, 6}, ObfuscatedTicketAge: 7}},
+			Binders:    [][]byte{{8, 9}},
+		},
+	}
+	if !reflect.DeepEqual(spec.Extensions, want) {
+		t.Errorf("ClientHelloSpecFromJSON(%s) = %#v, want %#v", jsonCH, spec.Extensions, want)
+	}
+
+	// the hex encoding reads back to the same bytes
+	again, err := spec.MarshalJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(again), `"key_exchange":"hex:000102"`) {
+		t.Errorf("key_exchange isn't written in hex: %s", again)
+	}
+	roundTrip, err := ClientHelloSpecFromJSON(again)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(roundTrip.Extensions, want) {
+		t.Errorf("ClientHelloSpecFromJSON(%s) = %#v, want %#v", again, roundTrip.Extensions, want)
+	}
+}
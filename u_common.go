@@ -5,6 +5,7 @@
 package tls
 
 import (
+	"bytes"
 	"crypto/hmac"
 	"crypto/sha512"
 	"encoding/json"
@@ -12,7 +13,11 @@ import (
 	"fmt"
 	"hash"
 	"log"
+	"slices"
+	"strconv"
+	"strings"
 
+	"github.com/refraction-networking/utls/dicttls"
 	"github.com/refraction-networking/utls/internal/helper"
 	"golang.org/x/crypto/cryptobyte"
 )
@@ -34,14 +39,17 @@ const (
 const (
 	extensionNextProtoNeg uint16 = 13172 // not IANA assigned. Removed by crypto/tls since Nov 2019
 
-	utlsExtensionPadding             uint16 = 21
-	utlsExtensionCompressCertificate uint16 = 27     // https://datatracker.ietf.org/doc/html/rfc8879#section-7.1
-	utlsExtensionApplicationSettings uint16 = 17513  // not IANA assigned
-	utlsFakeExtensionCustom          uint16 = 1234   // not IANA assigned, for ALPS
-	utlsExtensionECH                 uint16 = 0xfe0d // draft-ietf-tls-esni-17
-	utlsExtensionECHOuterExtensions  uint16 = 0xfd00 // draft-ietf-tls-esni-17
+	utlsExtensionPadding                uint16 = 21
+	utlsExtensionCompressCertificate    uint16 = 27     // https://datatracker.ietf.org/doc/html/rfc8879#section-7.1
+	utlsExtensionApplicationSettings    uint16 = 17513  // not IANA assigned
+	utlsExtensionApplicationSettingsNew uint16 = 17613  // not IANA assigned, used by Chrome since 131
+	utlsFakeExtensionCustom             uint16 = 1234   // not IANA assigned, for ALPS
+	utlsExtensionECH                    uint16 = 0xfe0d // draft-ietf-tls-esni-17
+	utlsExtensionECHOuterExtensions     uint16 = 0xfd00 // draft-ietf-tls-esni-17
 
 	// extensions with 'fake' prefix break connection, if server echoes them back
+	fakeExtensionMaxFragmentLength    uint16 = 1  // RFC 6066, honored if echoed
+	fakeExtensionHeartbeat            uint16 = 15 // RFC 6520
 	fakeExtensionEncryptThenMAC       uint16 = 22
 	fakeExtensionTokenBinding         uint16 = 24
 	fakeExtensionDelegatedCredentials uint16 = 34
@@ -119,6 +127,22 @@ const (
 	CertCompressionZstd   CertCompressionAlgo = 0x0003
 )
 
+// String returns the name of the algorithm, or "none" for 0, which is what
+// (*UConn).CertCompressionUsed returns for an uncompressed Certificate.
+func (alg CertCompressionAlgo) String() string {
+	switch alg {
+	case 0:
+		return "none"
+	case CertCompressionZlib:
+		return "zlib"
+	case CertCompressionBrotli:
+		return "brotli"
+	case CertCompressionZstd:
+		return "zstd"
+	}
+	return fmt.Sprintf("CertCompressionAlgo(%d)", uint16(alg))
+}
+
 const (
 	PskModePlain uint8 = pskModePlain
 	PskModeDHE   uint8 = pskModeDHE
@@ -150,19 +174,22 @@ func (p *ClientHelloID) IsSet() bool {
 
 const (
 	// clients
-	helloGolang           = "Golang"
-	helloRandomized       = "Randomized"
-	helloRandomizedALPN   = "Randomized-ALPN"
-	helloRandomizedNoALPN = "Randomized-NoALPN"
-	helloCustom           = "Custom"
-	helloFirefox          = "Firefox"
-	helloChrome           = "Chrome"
-	helloIOS              = "iOS"
-	helloAndroid          = "Android"
-	helloEdge             = "Edge"
-	helloSafari           = "Safari"
-	hello360              = "360Browser"
-	helloQQ               = "QQBrowser"
+	helloGolang              = "Golang"
+	helloRandomized          = "Randomized"
+	helloRandomizedALPN      = "Randomized-ALPN"
+	helloRandomizedNoALPN    = "Randomized-NoALPN"
+	helloRandomizedFixedALPN = "Randomized-FixedALPN"
+	helloCustom              = "Custom"
+	helloFirefox             = "Firefox"
+	helloChrome              = "Chrome"
+	helloIOS                 = "iOS"
+	helloAndroid             = "Android"
+	helloEdge                = "Edge"
+	helloSafari              = "Safari"
+	hello360                 = "360Browser"
+	helloQQ                  = "QQBrowser"
+	helloOpenSSL             = "OpenSSL"
+	helloCurl                = "curl"
 
 	// versions
 	helloAutoVers = "0"
@@ -180,9 +207,122 @@ type ClientHelloSpec struct {
 	// sessionID may or may not depend on ticket; nil => random
 	GetSessionID func(ticket []byte) [32]byte
 
+	// ForceExtensionOrder sends Extensions exactly in the order listed, for
+	// testing how servers handle non-standard ClientHellos. uTLS no longer
+	// checks that pre_shared_key is the last extension, allows more than two
+	// GREASE extensions, and appends the extensions it adds itself (e.g. for
+	// SetRecordSizeLimit, SetALPN or a HelloRetryRequest cookie) instead of
+	// inserting them before padding and pre_shared_key.
+	//
+	// This can produce ClientHellos that servers reject or that break the
+	// handshake: the binders of a pre_shared_key that is not last don't cover
+	// the extensions after it.
+	ForceExtensionOrder bool
+
+	// GREASECipherCount, if not zero, is the number of GREASE cipher suites
+	// sent, replacing those in CipherSuites. The first is sent first, as
+	// BoringSSL does, and the others are spread evenly among the suites.
+	GREASECipherCount int
+	// GREASEExtensionCount, if not zero, is the number of GREASE extensions
+	// sent, replacing those in Extensions. As in Chrome, the first is sent
+	// first and the last one, with a one byte body, before padding and
+	// pre_shared_key. The others are spread evenly between them. It can't be
+	// used with ForceExtensionOrder.
+	//
+	// All GREASE values of the cipher suites, and those of the extensions,
+	// are distinct, so at most 16 of each can be sent.
+	GREASEExtensionCount int
+
 	// TLSFingerprintLink string // ?? link to tlsfingerprint.io for informational purposes
 }
 
+// WithoutExtension returns a copy of chs without the extensions sent with
+// codepoint, e.g. to try a parrot without its padding extension, or without
+// extended_master_secret for a server that doesn't support it. Any GREASE
+// codepoint removes all GREASE extensions. The remaining extensions are shared
+// with chs.
+//
+// Without supported_versions, a spec that doesn't set TLSVersMin and TLSVersMax
+// offers TLS 1.0 to 1.2. ApplyPreset fails for a spec that still offers TLS 1.3
+// without supported_versions or key_share.
+func (chs *ClientHelloSpec) WithoutExtension(codepoint uint16) ClientHelloSpec {
+	spec := *chs
+	spec.Extensions = make([]TLSExtension, 0, len(chs.Extensions))
+	for _, ext := range chs.Extensions {
+		if !extensionHasCodepoint(ext, codepoint) {
+			spec.Extensions = append(spec.Extensions, ext)
+		}
+	}
+	return spec
+}
+
+// tls13OnlyExtensions are the extensions that browsers only send when they
+// offer TLS 1.3.
+var tls13OnlyExtensions = []uint16{
+	extensionSupportedVersions,
+	extensionKeyShare,
+	extensionPSKModes,
+	extensionPreSharedKey,
+	extensionEarlyData,
+	extensionCookie,
+	extensionQUICTransportParameters,
+	fakeExtensionDelegatedCredentials,
+	utlsExtensionCompressCertificate,
+	utlsExtensionApplicationSettings,
+	utlsExtensionApplicationSettingsNew,
+	utlsExtensionECH,
+}
+
+// ForceTLS12 returns a copy of chs that only offers TLS 1.2 and earlier, for
+// servers that don't support TLS 1.3, as the browser does when TLS 1.3 is
+// disabled: without the TLS 1.3 cipher suites, the extensions that only apply
+// to TLS 1.3 (supported_versions, key_share, psk_key_exchange_modes,
+// pre_shared_key, compress_certificate, ECH, etc.) and the post-quantum groups
+// of supported_groups. Everything else is kept in order, shared with chs.
+//
+// TLSVersMax is set to TLS 1.2, and TLSVersMin to the lowest version offered
+// by chs.
+func (chs *ClientHelloSpec) ForceTLS12() ClientHelloSpec {
+	spec := *chs
+	spec.TLSVersMin, spec.TLSVersMax = chs.TLSVersMin, VersionTLS12
+	spec.CipherSuites = make([]uint16, 0, len(chs.CipherSuites))
+	for _, suite := range chs.CipherSuites {
+		if cipherSuiteTLS13ByID(suite) == nil {
+			spec.CipherSuites = append(spec.CipherSuites, suite)
+		}
+	}
+
+	spec.Extensions = make([]TLSExtension, 0, len(chs.Extensions))
+	for _, ext := range chs.Extensions {
+		switch e := ext.(type) {
+		case *SupportedVersionsExtension:
+			if chs.TLSVersMin == 0 {
+				for _, vers := range e.Versions {
+					if !isGREASEUint16(vers) && (spec.TLSVersMin == 0 || vers < spec.TLSVersMin) {
+						spec.TLSVersMin = vers
+					}
+				}
+			}
+		case *SupportedCurvesExtension:
+			curves := make([]CurveID, 0, len(e.Curves))
+			for _, curve := range e.Curves {
+				if curveIdToCirclScheme(curve) == nil {
+					curves = append(curves, curve)
+				}
+			}
+			ext = &SupportedCurvesExtension{Curves: curves}
+		}
+		if !slices.ContainsFunc(tls13OnlyExtensions, func(codepoint uint16) bool { return extensionHasCodepoint(ext, codepoint) }) {
+			spec.Extensions = append(spec.Extensions, ext)
+		}
+	}
+	if spec.TLSVersMin == 0 {
+		spec.TLSVersMin = VersionTLS10
+	}
+	spec.TLSVersMin = min(spec.TLSVersMin, VersionTLS12)
+	return spec
+}
+
 // ReadCipherSuites is a helper function to construct a list of cipher suites from
 // a []byte into []uint16.
 //
@@ -211,6 +351,13 @@ func (chs *ClientHelloSpec) ReadCompressionMethods(compressionMethods []byte) er
 // ReadTLSExtensions is a helper function to construct a list of TLS extensions from
 // a byte slice into []TLSExtension.
 func (chs *ClientHelloSpec) ReadTLSExtensions(b []byte, allowBluntMimicry bool, realPSK bool) error {
+	return chs.readTLSExtensions(b, allowBluntMimicry, realPSK, false)
+}
+
+// readTLSExtensions is ReadTLSExtensions with an additional preserveUnknown flag,
+// which keeps unknown extensions as well as known extensions that fail to parse
+// as GenericExtension, with a copy of their original data.
+func (chs *ClientHelloSpec) readTLSExtensions(b []byte, allowBluntMimicry bool, realPSK bool, preserveUnknown bool) error {
 	extensions := cryptobyte.String(b)
 	for !extensions.Empty() {
 		var extension uint16
@@ -222,12 +369,20 @@ func (chs *ClientHelloSpec) ReadTLSExtensions(b []byte, allowBluntMimicry bool,
 			return fmt.Errorf("unable to read data for extension %x", extension)
 		}
 
+		if extension == extensionPreSharedKey && !extensions.Empty() {
+			// RFC 8446, Section 4.2.11: the binders are computed over every byte of
+			// the hello before them, so the extension can't be moved.
+			return fmt.Errorf("pre_shared_key extension must be the last extension")
+		}
+
 		ext := ExtensionFromID(extension)
 		extWriter, ok := ext.(TLSExtensionWriter)
 		if ext != nil && ok { // known extension and implements TLSExtensionWriter properly
 			switch extension {
 			case extensionPreSharedKey:
-				// PSK extension, need to see if we do real or fake PSK
+				// PSK extension, need to see if we do real or fake PSK. A real PSK
+				// extension is only a placeholder, its identities and binders are
+				// filled in from the session when the hello is built.
 				if realPSK {
 					extWriter = &UtlsPreSharedKeyExtension{}
 				} else {
@@ -239,13 +394,19 @@ func (chs *ClientHelloSpec) ReadTLSExtensions(b []byte, allowBluntMimicry bool,
 			}
 
 			if _, err := extWriter.Write(extData); err != nil {
+				if preserveUnknown {
+					chs.Extensions = append(chs.Extensions, &GenericExtension{extension, bytes.Clone(extData)})
+					continue
+				}
 				return err
 			}
 
 			chs.Extensions = append(chs.Extensions, extWriter)
 		} else {
-			if allowBluntMimicry {
-				chs.Extensions = append(chs.Extensions, &GenericExtension{extension, extData})
+			if preserveUnknown {
+				chs.Extensions = append(chs.Extensions, &GenericExtension{extension, bytes.Clone(extData)})
+			} else if allowBluntMimicry {
+				chs.Extensions = append(chs.Extensions, &GenericExtension{extension, bytes.Clone(extData)})
 			} else {
 				return fmt.Errorf("unsupported extension %d", extension)
 			}
@@ -432,7 +593,7 @@ func (chs *ClientHelloSpec) ImportTLSClientHello(data map[string][]byte) error {
 				if err != nil {
 					return err
 				}
-			case utlsExtensionApplicationSettings:
+			case utlsExtensionApplicationSettings, utlsExtensionApplicationSettingsNew:
 				// TODO: tlsfingerprint.io should record/provide application settings data
 				extWriter.(*ApplicationSettingsExtension).SupportedProtocols = []string{"h2"}
 			case extensionPreSharedKey:
@@ -464,7 +625,7 @@ func (chs *ClientHelloSpec) ImportTLSClientHelloFromJSON(jsonB []byte) error {
 
 // FromRaw converts a ClientHello message in the form of raw bytes into a ClientHelloSpec.
 //
-// ctrlFlags: []bool{bluntMimicry, realPSK}
+// ctrlFlags: []bool{bluntMimicry, realPSK, preserveUnknown}
 func (chs *ClientHelloSpec) FromRaw(raw []byte, ctrlFlags ...bool) error {
 	if chs == nil {
 		return errors.New("cannot unmarshal into nil ClientHelloSpec")
@@ -478,6 +639,10 @@ func (chs *ClientHelloSpec) FromRaw(raw []byte, ctrlFlags ...bool) error {
 	if len(ctrlFlags) > 1 {
 		realPSK = ctrlFlags[1]
 	}
+	var preserveUnknown = false
+	if len(ctrlFlags) > 2 {
+		preserveUnknown = ctrlFlags[2]
+	}
 
 	*chs = ClientHelloSpec{} // reset
 	s := cryptobyte.String(raw)
@@ -543,7 +708,7 @@ func (chs *ClientHelloSpec) FromRaw(raw []byte, ctrlFlags ...bool) error {
 		return errors.New("unable to read extensions data")
 	}
 
-	if err := chs.ReadTLSExtensions(extensions, bluntMimicry, realPSK); err != nil {
+	if err := chs.readTLSExtensions(extensions, bluntMimicry, realPSK, preserveUnknown); err != nil {
 		return err
 	}
 
@@ -570,11 +735,69 @@ func (chs *ClientHelloSpec) UnmarshalJSON(jsonB []byte) error {
 	return nil
 }
 
+// MarshalJSON marshals a ClientHelloSpec into the JSON form read by
+// UnmarshalJSON, with the names of cipher suites, extensions and their values.
+// Opaque data, like the payload of a GenericExtension, is a hex string, and
+// extensions without a name are marshaled with their codepoint. All
+// extensions must implement json.Marshaler, as the extensions of this package
+// do except QUICTransportParametersExtension. GetSessionID is not marshaled.
+func (chs *ClientHelloSpec) MarshalJSON() ([]byte, error) {
+	cipherSuites, err := jsonNames(chs.CipherSuites, dicttls.DictCipherSuiteValueIndexed, "cipher suite")
+	if err != nil {
+		return nil, err
+	}
+	compressionMethods, err := jsonNames(chs.CompressionMethods, dicttls.DictCompMethValueIndexed, "compression method")
+	if err != nil {
+		return nil, err
+	}
+	extensions := make([]json.RawMessage, 0, len(chs.Extensions))
+	for _, ext := range chs.Extensions {
+		m, ok := ext.(json.Marshaler)
+		if !ok {
+			return nil, fmt.Errorf("tls: extension %T cannot be marshaled to JSON", ext)
+		}
+		b, err := m.MarshalJSON()
+		if err != nil {
+			return nil, err
+		}
+		extensions = append(extensions, b)
+	}
+
+	return json.Marshal(struct {
+		CipherSuites         []string          `json:"cipher_suites"`
+		CompressionMethods   []string          `json:"compression_methods"`
+		Extensions           []json.RawMessage `json:"extensions"`
+		TLSVersMin           uint16            `json:"min_vers,omitempty"`
+		TLSVersMax           uint16            `json:"max_vers,omitempty"`
+		ForceExtensionOrder  bool              `json:"force_extension_order,omitempty"`
+		GREASECipherCount    int               `json:"grease_cipher_count,omitempty"`
+		GREASEExtensionCount int               `json:"grease_extension_count,omitempty"`
+	}{cipherSuites, compressionMethods, extensions, chs.TLSVersMin, chs.TLSVersMax, chs.ForceExtensionOrder,
+		chs.GREASECipherCount, chs.GREASEExtensionCount})
+}
+
+// ClientHelloSpecFromJSON returns the ClientHelloSpec marshaled to JSON by
+// ClientHelloSpec.MarshalJSON.
+func ClientHelloSpecFromJSON(data []byte) (*ClientHelloSpec, error) {
+	chs := &ClientHelloSpec{}
+	if err := chs.UnmarshalJSON(data); err != nil {
+		return nil, err
+	}
+	return chs, nil
+}
+
 var (
 	// HelloGolang will use default "crypto/tls" handshake marshaling codepath, which WILL
 	// overwrite your changes to Hello(Config, Session are fine).
 	// You might want to call BuildHandshakeState() before applying any changes.
 	// UConn.Extensions will be completely ignored.
+	//
+	// It sends the ClientHello that Client sends for the same Config, i.e. the
+	// defaults of the crypto/tls release this package is forked from, not those
+	// of the Go toolchain it is built with. The crypto/tls of current
+	// toolchains sends a different ClientHello (e.g. with post-quantum groups
+	// and a different cipher suite order), so HelloGolang doesn't look like a
+	// program built with them, see JA3Blocklist.
 	HelloGolang = ClientHelloID{helloGolang, helloAutoVers, nil, nil}
 
 	// HelloCustom will prepare ClientHello with empty uconn.Extensions so you can fill it with
@@ -586,16 +809,23 @@ var (
 	HelloRandomizedALPN   = ClientHelloID{helloRandomizedALPN, helloAutoVers, nil, nil}
 	HelloRandomizedNoALPN = ClientHelloID{helloRandomizedNoALPN, helloAutoVers, nil, nil}
 
+	// HelloRandomizedFixedALPN is like HelloRandomizedALPN, but always offers
+	// exactly ["h2", "http/1.1"] in ALPN like a browser does, regardless of
+	// Config.NextProtos.
+	HelloRandomizedFixedALPN = ClientHelloID{helloRandomizedFixedALPN, helloAutoVers, nil, nil}
+
 	// The rest will will parrot given browser.
-	HelloFirefox_Auto = HelloFirefox_120
-	HelloFirefox_55   = ClientHelloID{helloFirefox, "55", nil, nil}
-	HelloFirefox_56   = ClientHelloID{helloFirefox, "56", nil, nil}
-	HelloFirefox_63   = ClientHelloID{helloFirefox, "63", nil, nil}
-	HelloFirefox_65   = ClientHelloID{helloFirefox, "65", nil, nil}
-	HelloFirefox_99   = ClientHelloID{helloFirefox, "99", nil, nil}
-	HelloFirefox_102  = ClientHelloID{helloFirefox, "102", nil, nil}
-	HelloFirefox_105  = ClientHelloID{helloFirefox, "105", nil, nil}
-	HelloFirefox_120  = ClientHelloID{helloFirefox, "120", nil, nil}
+	// HelloFirefox_Auto is set in u_firefox_auto.go, as it depends on the Go
+	// version.
+	HelloFirefox_55  = ClientHelloID{helloFirefox, "55", nil, nil}
+	HelloFirefox_56  = ClientHelloID{helloFirefox, "56", nil, nil}
+	HelloFirefox_63  = ClientHelloID{helloFirefox, "63", nil, nil}
+	HelloFirefox_65  = ClientHelloID{helloFirefox, "65", nil, nil}
+	HelloFirefox_99  = ClientHelloID{helloFirefox, "99", nil, nil}
+	HelloFirefox_102 = ClientHelloID{helloFirefox, "102", nil, nil}
+	HelloFirefox_105 = ClientHelloID{helloFirefox, "105", nil, nil}
+	HelloFirefox_120 = ClientHelloID{helloFirefox, "120", nil, nil}
+	HelloFirefox_133 = ClientHelloID{helloFirefox, "133", nil, nil} // X25519MLKEM768 requires Go 1.24 or later
 
 	HelloChrome_Auto        = HelloChrome_120
 	HelloChrome_58          = ClientHelloID{helloChrome, "58", nil, nil}
@@ -626,11 +856,25 @@ var (
 	// Chrome w/ Post-Quantum Key Agreement and Encrypted ClientHello
 	HelloChrome_120_PQ = ClientHelloID{helloChrome, "120_PQ", nil, nil}
 
-	HelloIOS_Auto = HelloIOS_14
+	// Chrome w/ X25519MLKEM768 and the new ALPS codepoint. X25519MLKEM768
+	// requires Go 1.24 or later.
+	HelloChrome_131 = ClientHelloID{helloChrome, "131", nil, nil}
+
+	// See HelloChrome_PQ.
+	helloChrome_131_PQ_HybridOnly = ClientHelloID{helloChrome, "131_PQ_HybridOnly", nil, nil}
+	helloChrome_131_PQ_Classical  = ClientHelloID{helloChrome, "131_PQ_Classical", nil, nil}
+
+	// ClientHellos sent in QUIC Initial packets (HTTP/3), including the
+	// quic_transport_parameters extension. Only use them with UQUICClient.
+	HelloChrome_115_QUIC  = ClientHelloID{helloChrome, "115_QUIC", nil, nil}
+	HelloFirefox_116_QUIC = ClientHelloID{helloFirefox, "116_QUIC", nil, nil}
+
+	HelloIOS_Auto = HelloIOS_18
 	HelloIOS_11_1 = ClientHelloID{helloIOS, "111", nil, nil} // legacy "111" means 11.1
 	HelloIOS_12_1 = ClientHelloID{helloIOS, "12.1", nil, nil}
 	HelloIOS_13   = ClientHelloID{helloIOS, "13", nil, nil}
 	HelloIOS_14   = ClientHelloID{helloIOS, "14", nil, nil}
+	HelloIOS_18   = ClientHelloID{helloIOS, "18", nil, nil} // same ClientHello as HelloSafari_18
 
 	HelloAndroid_11_OkHttp = ClientHelloID{helloAndroid, "11", nil, nil}
 
@@ -638,8 +882,9 @@ var (
 	HelloEdge_85   = ClientHelloID{helloEdge, "85", nil, nil}
 	HelloEdge_106  = ClientHelloID{helloEdge, "106", nil, nil}
 
-	HelloSafari_Auto = HelloSafari_16_0
+	HelloSafari_Auto = HelloSafari_18
 	HelloSafari_16_0 = ClientHelloID{helloSafari, "16.0", nil, nil}
+	HelloSafari_18   = ClientHelloID{helloSafari, "18", nil, nil}
 
 	Hello360_Auto = Hello360_7_5 // Hello360_11_0 seems to be incompatible with this library
 	Hello360_7_5  = ClientHelloID{hello360, "7.5", nil, nil}
@@ -647,8 +892,126 @@ var (
 
 	HelloQQ_Auto = HelloQQ_11_1
 	HelloQQ_11_1 = ClientHelloID{helloQQ, "11.1", nil, nil}
+
+	// Command-line tools with their default settings, as baselines for
+	// servers that treat browsers differently. They offer cipher suites that
+	// uTLS doesn't implement: the DHE ones,
+	// TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA384,
+	// TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA384 and
+	// TLS_RSA_WITH_AES_256_CBC_SHA256. A server choosing one of those breaks
+	// the handshake, as does one accepting encrypt_then_mac with a CBC suite,
+	// which uTLS doesn't implement either.
+	HelloOpenSSL_3 = ClientHelloID{helloOpenSSL, "3.0", nil, nil} // openssl s_client
+	HelloCurl      = ClientHelloID{helloCurl, "7.88", nil, nil}   // curl 7.88 with OpenSSL 3.0
+)
+
+// PQMode selects the key shares of HelloChrome_PQ, as Chrome's post-quantum
+// key agreement rollout sent different ones across builds and field trials.
+type PQMode uint8
+
+const (
+	// PQModeHybridAndClassical sends X25519MLKEM768 and X25519 key shares, as
+	// HelloChrome_131 does.
+	PQModeHybridAndClassical PQMode = iota
+	// PQModeHybridOnly only sends an X25519MLKEM768 key share. The classical
+	// groups are still offered in supported_groups, for HelloRetryRequest.
+	PQModeHybridOnly
+	// PQModeClassicalOnly sends an X25519 key share, and doesn't offer
+	// X25519MLKEM768 at all, as Chrome with post-quantum key agreement
+	// disabled.
+	PQModeClassicalOnly
 )
 
+// HelloChrome_PQ returns the ClientHelloID of Chrome 131 with the key shares
+// and supported groups selected by mode. Like HelloChrome_131, modes sending
+// X25519MLKEM768 require Go 1.24 or later.
+func HelloChrome_PQ(mode PQMode) ClientHelloID {
+	switch mode {
+	case PQModeHybridOnly:
+		return helloChrome_131_PQ_HybridOnly
+	case PQModeClassicalOnly:
+		return helloChrome_131_PQ_Classical
+	default:
+		return HelloChrome_131
+	}
+}
+
+// autoClientHelloIDs are the parrots that the *_Auto ClientHelloIDs are.
+var autoClientHelloIDs = []ClientHelloID{
+	HelloFirefox_Auto, HelloChrome_Auto, HelloIOS_Auto, HelloEdge_Auto,
+	HelloSafari_Auto, Hello360_Auto, HelloQQ_Auto,
+}
+
+// ClientHelloIDEntry is an element of the list returned by AllClientHelloIDs.
+type ClientHelloIDEntry struct {
+	ID ClientHelloID
+	// Auto is set for the *_Auto ClientHelloIDs, e.g. HelloChrome_Auto, which
+	// are updated to newer parrots over time. ID is then the parrot that it
+	// currently is, which is also listed on its own with Auto unset.
+	Auto bool
+}
+
+// AllClientHelloIDs returns the built-in parrots, followed by each *_Auto
+// ClientHelloID, sorted by client name and then by version, from the oldest
+// to the most recent. A *_Auto ClientHelloID is listed right after the parrot
+// it currently is.
+//
+// HelloGolang, HelloCustom, the randomized ClientHelloIDs and the QUIC ones,
+// which are only for UQUICClient, are not included.
+func AllClientHelloIDs() []ClientHelloIDEntry {
+	entries := make([]ClientHelloIDEntry, 0, len(parrotHelloIDs)+len(autoClientHelloIDs))
+	for _, id := range parrotHelloIDs {
+		entries = append(entries, ClientHelloIDEntry{ID: id})
+	}
+	for _, id := range autoClientHelloIDs {
+		entries = append(entries, ClientHelloIDEntry{ID: id, Auto: true})
+	}
+	slices.SortStableFunc(entries, func(a, b ClientHelloIDEntry) int {
+		if c := strings.Compare(strings.ToLower(a.ID.Client), strings.ToLower(b.ID.Client)); c != 0 {
+			return c
+		}
+		if c := slices.Compare(parseHelloVersion(a.ID), parseHelloVersion(b.ID)); c != 0 {
+			return c
+		}
+		// e.g. "100" before "100_PSK"
+		if c := strings.Compare(a.ID.Version, b.ID.Version); c != 0 {
+			return c
+		}
+		if a.Auto == b.Auto {
+			return 0
+		} else if b.Auto {
+			return -1
+		}
+		return 1
+	})
+	return entries
+}
+
+// parseHelloVersion returns the numbers at the start of the version of id,
+// e.g. [16 0] for "16.0" and [114] for "114_Padding_PSK_Shuf".
+func parseHelloVersion(id ClientHelloID) []int {
+	version := id.Version
+	if id == HelloIOS_11_1 {
+		version = "11.1" // its version predates the dotted ones
+	}
+	var numbers []int
+	for _, field := range strings.FieldsFunc(version, func(r rune) bool { return r == '.' || r == '_' }) {
+		n, err := strconv.Atoi(field)
+		if err != nil {
+			break
+		}
+		numbers = append(numbers, n)
+	}
+	return numbers
+}
+
+// Weights are the probabilities, between 0 and 1, with which HelloRandomized
+// and the other randomized ClientHelloIDs make each choice. A weight of 1
+// always makes it, and 0 never does, e.g. TLSVersMax_Set_VersionTLS13: 1
+// always offers TLS 1.3 with supported_versions and key_share. ALPS is only
+// sent with ALPN offering h2, and padding is always sent with TLS 1.3 unless
+// RandomizedSpecOptions.TLS13PaddingByWeight is set. See
+// RandomizedSpecOptions to require extensions instead.
 type Weights struct {
 	Extensions_Append_ALPN                             float64
 	TLSVersMax_Set_VersionTLS13                        float64
@@ -666,6 +1029,15 @@ type Weights struct {
 	Extensions_Append_EMS                              float64
 	FirstKeyShare_Set_CurveP256                        float64
 	Extensions_Append_ALPS                             float64
+
+	// These extensions are only sent with TLS 1.3. Their weights are 0 in
+	// DefaultWeights, so that a seed keeps generating the same spec as
+	// before they were added.
+	Extensions_Append_GREASE_ECH   float64
+	Extensions_Append_CompressCert float64
+
+	// tls13PaddingByWeight is set by RandomizedSpecOptions.TLS13PaddingByWeight.
+	tls13PaddingByWeight bool
 }
 
 // Do not modify them directly as they may being used. If you
@@ -689,6 +1061,77 @@ var DefaultWeights = Weights{
 	Extensions_Append_ALPS:                             0.33,
 }
 
+// RandomizedSpecOptions configures the ClientHellos of HelloRandomized, as the
+// weights of its random choices and the extensions that it must always send.
+type RandomizedSpecOptions struct {
+	// Weights are the probabilities of the random choices, DefaultWeights if
+	// nil.
+	Weights *Weights
+
+	// AlwaysInclude lists extension types, e.g. 43 for supported_versions,
+	// that are sent whatever the weights draw, along with what they require:
+	// supported_versions, key_share, psk_key_exchange_modes,
+	// compress_certificate, GREASE ECH and ALPS make the ClientHello offer
+	// TLS 1.3, ALPS also requires ALPN, and is only sent if
+	// Config.NextProtos is empty or has "h2".
+	//
+	// server_name, session_ticket, signature_algorithms, ec_point_formats
+	// and supported_groups are always sent anyway, other extensions are
+	// never sent by HelloRandomized and make HelloID return an error.
+	AlwaysInclude []uint16
+
+	// TLS13PaddingByWeight makes Weights.Extensions_Append_Padding apply to
+	// the ClientHellos offering TLS 1.3 too, instead of always padding them.
+	// TLS 1.3 ClientHellos are often over 256 bytes, which is when padding is
+	// required to work around buggy middleboxes.
+	TLS13PaddingByWeight bool
+}
+
+// HelloID returns a HelloRandomized ClientHelloID generating specs with
+// these options. Like the one of any randomized ClientHelloID, the spec is
+// the same for the same seed, and random if seed is nil.
+func (o RandomizedSpecOptions) HelloID(seed *PRNGSeed) (ClientHelloID, error) {
+	weights := DefaultWeights
+	if o.Weights != nil {
+		weights = *o.Weights
+	}
+	weights.tls13PaddingByWeight = o.TLS13PaddingByWeight
+	for _, ext := range o.AlwaysInclude {
+		switch ext {
+		case extensionServerName, extensionSessionTicket, extensionSignatureAlgorithms,
+			extensionSupportedPoints, extensionSupportedCurves:
+			// always sent
+		case extensionALPN:
+			weights.Extensions_Append_ALPN = 1
+		case extensionSupportedVersions, extensionKeyShare, extensionPSKModes:
+			weights.TLSVersMax_Set_VersionTLS13 = 1
+		case utlsExtensionPadding:
+			weights.Extensions_Append_Padding = 1
+		case extensionStatusRequest:
+			weights.Extensions_Append_Status = 1
+		case extensionSCT:
+			weights.Extensions_Append_SCT = 1
+		case extensionRenegotiationInfo:
+			weights.Extensions_Append_Reneg = 1
+		case extensionExtendedMasterSecret:
+			weights.Extensions_Append_EMS = 1
+		case utlsExtensionApplicationSettings:
+			weights.Extensions_Append_ALPS = 1
+			weights.Extensions_Append_ALPN = 1
+			weights.TLSVersMax_Set_VersionTLS13 = 1
+		case utlsExtensionCompressCertificate:
+			weights.Extensions_Append_CompressCert = 1
+			weights.TLSVersMax_Set_VersionTLS13 = 1
+		case utlsExtensionECH:
+			weights.Extensions_Append_GREASE_ECH = 1
+			weights.TLSVersMax_Set_VersionTLS13 = 1
+		default:
+			return ClientHelloID{}, fmt.Errorf("tls: extension %d is never sent by HelloRandomized", ext)
+		}
+	}
+	return ClientHelloID{Client: helloRandomized, Version: helloAutoVers, Seed: seed, Weights: &weights}, nil
+}
+
 // based on spec's GreaseStyle, GREASE_PLACEHOLDER may be replaced by another GREASE value
 // https://tools.ietf.org/html/draft-ietf-tls-grease-01
 const GREASE_PLACEHOLDER = 0x0a0a
@@ -5,6 +5,8 @@
 package tls
 
 import (
+	"net"
+	"slices"
 	"testing"
 )
 
@@ -31,3 +33,82 @@ func TestUTLSIsGrease(t *testing.T) {
 		}
 	}
 }
+
+func TestUTLSAllClientHelloIDs(t *testing.T) {
+	entries := AllClientHelloIDs()
+	seen := make(map[ClientHelloIDEntry]bool)
+	clientsDone := make(map[string]bool)
+	for i, entry := range entries {
+		id := entry.ID
+		if _, err := UTLSIdToSpec(id); err != nil {
+			t.Errorf("%s: got error: %v; expected to succeed", id.Str(), err)
+		}
+		if seen[entry] {
+			t.Errorf("%s (auto %v) is listed twice", id.Str(), entry.Auto)
+		}
+		seen[entry] = true
+		// the parrots of a client are next to each other
+		if i > 0 && entries[i-1].ID.Client != id.Client {
+			clientsDone[entries[i-1].ID.Client] = true
+		}
+		if clientsDone[id.Client] {
+			t.Errorf("%s is not listed with the other %s parrots", id.Str(), id.Client)
+		}
+		// an auto ClientHelloID follows its parrot
+		if entry.Auto && (i == 0 || entries[i-1] != ClientHelloIDEntry{ID: id}) {
+			t.Errorf("the auto ClientHelloID %s doesn't follow its parrot", id.Str())
+		}
+	}
+
+	for _, id := range []ClientHelloID{HelloChrome_58, HelloChrome_131, HelloFirefox_120, HelloSafari_16_0, HelloCurl} {
+		if !seen[ClientHelloIDEntry{ID: id}] {
+			t.Errorf("%s is not listed", id.Str())
+		}
+	}
+	for _, id := range []ClientHelloID{HelloGolang, HelloCustom, HelloRandomized, HelloChrome_115_QUIC} {
+		if seen[ClientHelloIDEntry{ID: id}] || seen[ClientHelloIDEntry{ID: id, Auto: true}] {
+			t.Errorf("%s is listed", id.Str())
+		}
+	}
+	for _, id := range []ClientHelloID{HelloChrome_Auto, HelloFirefox_Auto, HelloIOS_Auto, HelloSafari_Auto, HelloEdge_Auto} {
+		if !seen[ClientHelloIDEntry{ID: id, Auto: true}] || !seen[ClientHelloIDEntry{ID: id}] {
+			t.Errorf("%s is not listed both as an auto ClientHelloID and as a parrot", id.Str())
+		}
+	}
+	if seen[ClientHelloIDEntry{ID: HelloChrome_58, Auto: true}] {
+		t.Errorf("%s is listed as an auto ClientHelloID", HelloChrome_58.Str())
+	}
+
+	// sorted by version, not as strings
+	for _, ids := range [][]ClientHelloID{
+		{HelloChrome_58, HelloChrome_100, HelloChrome_100_PSK, HelloChrome_131},
+		{HelloFirefox_99, HelloFirefox_102},
+		{HelloIOS_11_1, HelloIOS_12_1, HelloIOS_18},
+		{Hello360_7_5, Hello360_11_0},
+	} {
+		var indexes []int
+		for _, id := range ids {
+			indexes = append(indexes, slices.Index(entries, ClientHelloIDEntry{ID: id}))
+		}
+		if !slices.IsSorted(indexes) {
+			t.Errorf("got %v at %v, expected them in this order", ids, indexes)
+		}
+	}
+
+	// the list can't be modified through the returned slice
+	entries[0].ID = HelloGolang
+	if AllClientHelloIDs()[0].ID == HelloGolang {
+		t.Error("modifying the returned slice changed the list")
+	}
+}
+
+// The auto ClientHelloIDs must work with every supported Go version, see
+// u_firefox_auto_go123.go.
+func TestUTLSAutoClientHelloIDsBuild(t *testing.T) {
+	for _, id := range autoClientHelloIDs {
+		uconn := UClient(&net.TCPConn{}, &Config{ServerName: "example.com"}, id)
+		if err := uconn.BuildHandshakeState(); err != nil {
+			t.Errorf("%s: got error: %v; expected to succeed", id.Str(), err)
+		}
+	}
+}
@@ -10,12 +10,17 @@ import (
 	"context"
 	"crypto/cipher"
 	"crypto/ecdh"
-	"encoding/binary"
+	"crypto/sha256"
 	"errors"
 	"fmt"
 	"hash"
+	"io"
 	"net"
+	"reflect"
+	"slices"
 	"strconv"
+
+	"golang.org/x/crypto/cryptobyte"
 )
 
 type ClientHelloBuildStatus int
@@ -36,9 +41,27 @@ type UConn struct {
 	HandshakeState PubClientHandshakeState
 
 	greaseSeed [ssl_grease_last_index]uint16
+	// greaseValues is set by SetGREASEValues
+	greaseValues *GREASEValues
+
+	// sessionID is set by SetSessionID, nil means random.
+	sessionID []byte
 
 	omitSNIExtension bool
 
+	// recordSizeLimit is set by SetRecordSizeLimit, 0 means unset.
+	recordSizeLimit uint16
+
+	// compressionMethods is set by SetCompressionMethods, nil means unset.
+	compressionMethods []uint8
+
+	// dummyKeyShares is set by ClientHelloBuilder.SkipKeyGeneration.
+	dummyKeyShares bool
+
+	// forceExtensionOrder is copied from ClientHelloSpec.ForceExtensionOrder
+	// by ApplyPreset.
+	forceExtensionOrder bool
+
 	// skipResumptionOnNilExtension is copied from `Config.PreferSkipResumptionOnNilExtension`.
 	//
 	// By default, if ClientHelloSpec is predefined or utls-generated (as opposed to HelloCustom), this flag will be updated to true.
@@ -49,8 +72,15 @@ type UConn struct {
 	// server certificate. All other forms of certificate compression are unsupported.
 	certCompressionAlgs []CertCompressionAlgo
 
+	// delegatedCredentialAlgs are the signature algorithms of the
+	// delegated_credentials extension, if it was sent.
+	delegatedCredentialAlgs []SignatureScheme
+
 	// ech extension is a shortcut to the ECH extension in the Extensions slice if there is one.
 	ech ECHExtension
+
+	// http2Settings is set by SetHTTP2Fingerprint, nil means unset.
+	http2Settings *HTTP2Settings
 }
 
 // UClient returns a new uTLS client, with behavior depending on clientHelloID.
@@ -112,12 +142,15 @@ func (uconn *UConn) buildHandshakeState(loadSession bool) error {
 			return err
 		}
 
+		if uconn.sessionID != nil {
+			hello.sessionId = slices.Clone(uconn.sessionID)
+		}
 		uconn.HandshakeState.Hello = hello.getPublicPtr()
 		if ecdheKey, ok := keySharePrivate.(*ecdh.PrivateKey); ok {
 			uconn.HandshakeState.State13.EcdheKey = ecdheKey
 		} else if kemKey, ok := keySharePrivate.(*kemPrivateKey); ok {
 			uconn.HandshakeState.State13.KEMKey = kemKey.ToPublic()
-		} else {
+		} else if keySharePrivate != nil { // nil if TLS 1.3 is not offered
 			return fmt.Errorf("uTLS: unknown keySharePrivate type: %T", keySharePrivate)
 		}
 		uconn.HandshakeState.C = uconn.Conn
@@ -132,6 +165,7 @@ func (uconn *UConn) buildHandshakeState(loadSession bool) error {
 			if uconn.omitSNIExtension {
 				uconn.removeSNIExtension()
 			}
+			uconn.setRecordSizeLimitExtension()
 		}
 
 		err := uconn.ApplyConfig()
@@ -139,13 +173,19 @@ func (uconn *UConn) buildHandshakeState(loadSession bool) error {
 			return err
 		}
 
-		if loadSession {
+		// ECH is not combined with session resumption for now
+		if loadSession && !uconn.echEnabled() {
 			err = uconn.uLoadSession()
 			if err != nil {
 				return err
 			}
 		}
 
+		err = checkTLS13Extensions(uconn.config.MaxVersion, uconn.Extensions)
+		if err != nil {
+			return err
+		}
+
 		err = uconn.MarshalClientHello()
 		if err != nil {
 			return err
@@ -184,6 +224,9 @@ func (uconn *UConn) uLoadSession() error {
 			uconn.sessionController.setSessionTicketToUConn()
 		} else {
 			uconn.sessionController.initPskExt(session, earlySecret, binderKey, hello.pskIdentities)
+			if hello.earlyData && uconn.sessionController.state == PskExtInitialized {
+				uconn.addEarlyDataExtension()
+			}
 		}
 	}
 
@@ -263,15 +306,692 @@ func (uconn *UConn) SetClientRandom(r []byte) error {
 	}
 }
 
+// SetSessionID sets the legacy_session_id of the ClientHello, e.g. to
+// reproduce a capture exactly. By default it is 32 random bytes drawn from
+// Config.Rand, as browsers send for middlebox compatibility (RFC 8446,
+// Section 4.1.2), or empty for QUIC; any other size is a tell. Config.Rand is
+// read the same way, so the rest of the ClientHello is unchanged.
+//
+// id may be empty, and at most 32 bytes long. SetSessionID may be called
+// before or after ApplyPreset and BuildHandshakeState; a ClientHello that is
+// already built is marshaled again.
+func (uconn *UConn) SetSessionID(id []byte) error {
+	if len(id) > 32 {
+		return errors.New("tls: session ID is longer than 32 bytes")
+	}
+	uconn.sessionID = append([]byte{}, id...)
+	if hello := uconn.HandshakeState.Hello; hello != nil {
+		hello.SessionId = slices.Clone(uconn.sessionID)
+	}
+	if uconn.clientHelloBuildStatus == BuildByUtls {
+		return uconn.MarshalClientHello()
+	}
+	return nil
+}
+
+// SetClientHelloCallback sets f to be called with the ClientHello records,
+// byte for byte as they are about to be written to the connection, including
+// GREASE values and padding. f runs synchronously during the handshake, before
+// anything is sent, and is called again with the second ClientHello if the
+// server sends a HelloRetryRequest. The slice is not used after f returns.
+//
+// QUIC has no record layer, so for a UQUICConn f receives the ClientHello
+// message that is sent in CRYPTO frames instead.
+//
+// A nil f removes a previously set callback.
+func (uconn *UConn) SetClientHelloCallback(f func(record []byte)) {
+	uconn.utls.clientHelloCallback = f
+}
+
+// runClientHelloCallback calls the callback set with SetClientHelloCallback
+// with hello, framed the way writeRecordLocked sends it.
+func (c *Conn) runClientHelloCallback(hello *clientHelloMsg) error {
+	if c.utls.clientHelloCallback == nil {
+		return nil
+	}
+	data, err := hello.marshal()
+	if err != nil {
+		return err
+	}
+	if c.quic != nil {
+		c.utls.clientHelloCallback(bytes.Clone(data))
+		return nil
+	}
+
+	vers := c.vers
+	if vers == 0 {
+		vers = VersionTLS10
+	} else if vers == VersionTLS13 {
+		vers = VersionTLS12
+	}
+	maxPayload := c.maxPayloadSizeForWrite(recordTypeHandshake)
+	var records []byte
+	for n := 0; len(data) > 0; {
+		m := splitRecord(c.utls.recordSplitPattern, n, min(len(data), maxPayload))
+		records = append(records, byte(recordTypeHandshake), byte(vers>>8), byte(vers), byte(m>>8), byte(m))
+		records = append(records, data[:m]...)
+		data = data[m:]
+		n += m
+	}
+	c.utls.clientHelloCallback(records)
+	return nil
+}
+
+// SetRecordSplitPattern splits the ClientHello into several records, at the
+// given offsets in the ClientHello handshake message, which must be positive
+// and increasing, e.g. to split it in the middle of the server name. Offsets
+// past the end of the ClientHello are ignored, and records are still split at
+// the maximum record size. This also applies to the second ClientHello after
+// a HelloRetryRequest, but not to any other message, nor to what is read.
+//
+// Browsers send the ClientHello in a single record, so any pattern that splits
+// a ClientHello shorter than 16 KiB is distinguishable from them. QUIC has no
+// records, and ignores the pattern. A nil pattern removes it.
+func (uconn *UConn) SetRecordSplitPattern(offsets []int) error {
+	for i, offset := range offsets {
+		if offset <= 0 || i > 0 && offset <= offsets[i-1] {
+			return fmt.Errorf("tls: record split offsets must be positive and increasing, got %v", offsets)
+		}
+	}
+	uconn.utls.recordSplitPattern = slices.Clone(offsets)
+	return nil
+}
+
+// splitRecord returns the length of the record at offset n of a ClientHello
+// split at offsets, which is at most m.
+func splitRecord(offsets []int, n, m int) int {
+	for _, offset := range offsets {
+		if offset > n {
+			return min(m, offset-n)
+		}
+	}
+	return m
+}
+
+// SetECHConfigs sets the ECH configs, e.g. from the "ech" SvcParam of a DNS
+// HTTPS record, that the ClientHello is encrypted to. This requires the
+// ClientHelloSpec to contain an ECH extension such as BoringGREASEECH, which
+// sends GREASE ECH when no configs are set. It must be called before the
+// ClientHello is built.
+//
+// If the server does not accept ECH, the handshake fails with an
+// *ECHRejectionError holding the configs to retry with, if any.
+func (uconn *UConn) SetECHConfigs(configs []ECHConfig) error {
+	if uconn.clientHelloBuildStatus != NotBuilt {
+		return errors.New("tls: SetECHConfigs must be called before the ClientHello is built")
+	}
+	uconn.config.ECHConfigs = configs
+	return nil
+}
+
+// GREASEValues are the GREASE values of a ClientHello, see SetGREASEValues.
+// Each one must be of the form 0x?a?a, or 0 to keep the random value.
+type GREASEValues struct {
+	// Cipher is the GREASE cipher suite.
+	Cipher uint16
+	// Extension1 and Extension2 are the codepoints of the first and second
+	// GREASE extensions, which must differ.
+	Extension1 uint16
+	Extension2 uint16
+	// Group is the GREASE group of the supported_groups and key_share
+	// extensions.
+	Group uint16
+	// Version is the GREASE version of the supported_versions extension.
+	Version uint16
+	// SigAlg is the GREASE signature algorithm, which is the Cipher value by
+	// default.
+	SigAlg uint16
+}
+
+// SetGREASEValues pins the GREASE values of the ClientHello, e.g. to
+// reproduce a capture. The values that are not set are still drawn from
+// Config.Rand, which is read the same way, so the rest of the ClientHello is
+// unchanged by SetGREASEValues. It must be called before ApplyPreset and
+// BuildHandshakeState.
+func (uconn *UConn) SetGREASEValues(values GREASEValues) error {
+	if uconn.clientHelloBuildStatus != NotBuilt || uconn.Extensions != nil {
+		return errors.New("tls: SetGREASEValues must be called before the ClientHello is built")
+	}
+	for _, v := range []uint16{values.Cipher, values.Extension1, values.Extension2, values.Group, values.Version, values.SigAlg} {
+		if v != 0 && !isGREASEUint16(v) {
+			return fmt.Errorf("tls: %#04x is not a GREASE value", v)
+		}
+	}
+	if values.Extension1 != 0 && values.Extension1 == values.Extension2 {
+		return errors.New("tls: the two GREASE extensions must have different values")
+	}
+	uconn.greaseValues = &values
+	return nil
+}
+
+// CurrentSpec returns a copy of the ClientHelloSpec the UConn currently uses,
+// including any changes made to uconn.Extensions after ApplyPreset, so that it
+// can be applied to other connections. The extensions are deep copies without
+// connection-specific values, see cloneExtension.
+func (uconn *UConn) CurrentSpec() (ClientHelloSpec, error) {
+	hello := uconn.HandshakeState.Hello
+	spec := ClientHelloSpec{
+		CompressionMethods: bytes.Clone(hello.CompressionMethods),
+		TLSVersMin:         uconn.config.MinVersion,
+		TLSVersMax:         uconn.config.MaxVersion,
+	}
+	for _, suite := range hello.CipherSuites {
+		spec.CipherSuites = append(spec.CipherSuites, unGREASEUint16(suite))
+	}
+	for _, ext := range uconn.Extensions {
+		clone, err := cloneExtension(ext)
+		if err != nil {
+			return ClientHelloSpec{}, err
+		}
+		spec.Extensions = append(spec.Extensions, clone)
+	}
+	return spec, nil
+}
+
+// ReorderExtensions reorders uconn.Extensions to match order, a list of
+// extension codepoints that must account for every extension exactly once.
+// GREASE extensions are matched by position: the first GREASE value in order
+// refers to the first GREASE extension in uconn.Extensions, and so on.
+//
+// It is meant to be called after ApplyPreset or BuildHandshakeState, for
+// example to move a single extension of a parrot. If the ClientHello has
+// already been built, it is marshaled again.
+func (uconn *UConn) ReorderExtensions(order []uint16) error {
+	if len(order) != len(uconn.Extensions) {
+		return fmt.Errorf("tls: extension order has %d entries, expected %d", len(order), len(uconn.Extensions))
+	}
+
+	used := make([]bool, len(uconn.Extensions))
+	reordered := make([]TLSExtension, 0, len(uconn.Extensions))
+	for _, id := range order {
+		found := false
+		for i, ext := range uconn.Extensions {
+			if used[i] {
+				continue
+			}
+			extID, ok := extensionIDOf(ext)
+			if !ok {
+				return fmt.Errorf("tls: cannot reorder extension of type %T", ext)
+			}
+			if extID == id || isGREASEUint16(extID) && isGREASEUint16(id) {
+				used[i] = true
+				reordered = append(reordered, ext)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("tls: extension %d in order is not present or listed twice", id)
+		}
+	}
+	for i, ext := range reordered {
+		if _, ok := ext.(PreSharedKeyExtension); ok && i != len(reordered)-1 && !uconn.forceExtensionOrder {
+			return errors.New("tls: pre_shared_key extension must be the last extension")
+		}
+	}
+
+	uconn.Extensions = reordered
+	if uconn.clientHelloBuildStatus == BuildByUtls {
+		return uconn.MarshalClientHello()
+	}
+	return nil
+}
+
+// RemoveExtension removes the extensions sent with codepoint from
+// uconn.Extensions, and reports whether there were any. Any GREASE codepoint
+// removes all GREASE extensions. Like ReorderExtensions, it is meant to be
+// called after ApplyPreset or BuildHandshakeState. The ClientHello is rebuilt
+// without them by the handshake, or by BuildHandshakeState.
+//
+// Removing supported_versions limits the ClientHello to TLS 1.0 to 1.2, as
+// for a ClientHelloSpec without it. Removing key_share while TLS 1.3 is offered
+// makes building the ClientHello fail.
+func (uconn *UConn) RemoveExtension(codepoint uint16) bool {
+	kept := make([]TLSExtension, 0, len(uconn.Extensions))
+	removedVersions := false
+	for _, ext := range uconn.Extensions {
+		if !extensionHasCodepoint(ext, codepoint) {
+			kept = append(kept, ext)
+			continue
+		}
+		if _, ok := ext.(*SupportedVersionsExtension); ok {
+			removedVersions = true
+		}
+		uconn.replaceSessionExtension(ext, nil)
+	}
+	if len(kept) == len(uconn.Extensions) {
+		return false
+	}
+
+	uconn.Extensions = kept
+	if removedVersions {
+		// cannot fail without a supported_versions extension
+		uconn.SetTLSVers(0, 0, kept)
+	}
+	return true
+}
+
+// replaceSessionExtension updates the references of the session controller
+// to ext, which newExt replaces in uconn.Extensions, or which is removed if
+// newExt is nil. A PSK or session ticket extension that newExt is becomes the
+// one of the session controller if it has none.
+func (uconn *UConn) replaceSessionExtension(ext, newExt TLSExtension) {
+	s := uconn.sessionController
+	if psk, ok := ext.(PreSharedKeyExtension); ok && sameExtension(s.pskExtension, psk) {
+		s.pskExtension = nil
+	}
+	if ticket, ok := ext.(ISessionTicketExtension); ok && sameExtension(s.sessionTicketExt, ticket) {
+		s.sessionTicketExt = nil
+	}
+	if psk, ok := newExt.(PreSharedKeyExtension); ok && s.pskExtension == nil {
+		s.pskExtension = psk
+	}
+	if ticket, ok := newExt.(ISessionTicketExtension); ok && s.sessionTicketExt == nil {
+		s.sessionTicketExt = ticket
+	}
+}
+
+// sameExtension reports whether a and b are the same pointer. Extensions that
+// are not pointers are never the same, as comparing them panics if they hold
+// a slice or a map.
+func sameExtension(a, b TLSExtension) bool {
+	va, vb := reflect.ValueOf(a), reflect.ValueOf(b)
+	return va.Kind() == reflect.Pointer && vb.Kind() == reflect.Pointer &&
+		va.Type() == vb.Type() && va.Pointer() == vb.Pointer()
+}
+
+// VisitExtensions calls visit with each extension of uconn.Extensions, in
+// order, and replaces it with the returned one, or removes it if visit returns
+// nil. visit may also modify the extension in place. This is an escape hatch
+// for changes that no other method covers; it is meant to be called after
+// ApplyPreset or BuildHandshakeState.
+//
+// If the ClientHello was already built, it is rebuilt with the new extensions,
+// and an error is returned if that fails. As with RemoveExtension, the TLS
+// versions follow a supported_versions extension that is replaced, added or
+// removed, but not one modified in place. An extension that is not a pointer
+// is always considered replaced.
+func (uconn *UConn) VisitExtensions(visit func(ext TLSExtension) TLSExtension) error {
+	visited := make([]TLSExtension, 0, len(uconn.Extensions))
+	versionsChanged := false
+	for _, ext := range uconn.Extensions {
+		newExt := visit(ext)
+		if !sameExtension(newExt, ext) {
+			_, oldVersions := ext.(*SupportedVersionsExtension)
+			_, newVersions := newExt.(*SupportedVersionsExtension)
+			versionsChanged = versionsChanged || oldVersions || newVersions
+			uconn.replaceSessionExtension(ext, newExt)
+		}
+		if newExt != nil {
+			visited = append(visited, newExt)
+		}
+	}
+
+	uconn.Extensions = visited
+	if versionsChanged {
+		if err := uconn.SetTLSVers(0, 0, visited); err != nil {
+			return err
+		}
+	}
+	if uconn.clientHelloBuildStatus == BuildByUtls {
+		if err := checkTLS13Extensions(uconn.config.MaxVersion, visited); err != nil {
+			return err
+		}
+		if err := uconn.ApplyConfig(); err != nil {
+			return err
+		}
+		return uconn.MarshalClientHello()
+	}
+	return nil
+}
+
+// checkTLS13Extensions returns an error if exts offer TLS 1.3, up to maxVers,
+// without the extensions that servers require from TLS 1.3 clients (RFC 8446,
+// Section 9.2).
+func checkTLS13Extensions(maxVers uint16, exts []TLSExtension) error {
+	if maxVers < VersionTLS13 {
+		return nil
+	}
+	var haveVersions, haveKeyShare bool
+	for _, ext := range exts {
+		switch ext.(type) {
+		case *SupportedVersionsExtension:
+			haveVersions = true
+		case *KeyShareExtension:
+			haveKeyShare = true
+		}
+	}
+	if !haveVersions {
+		return errors.New("tls: TLS 1.3 is offered without the supported_versions extension")
+	}
+	if !haveKeyShare {
+		return errors.New("tls: TLS 1.3 is offered without the key_share extension")
+	}
+	return nil
+}
+
+// SetRecordSizeLimit sets the limit sent in the record_size_limit extension
+// (RFC 8449), which is added to the ClientHello if not present. The limit
+// must be between 64 and 16385.
+//
+// If the server sends its own record_size_limit, as RFC 8449 requires for
+// the limit to be negotiated, protected records written by the UConn are
+// limited to the size the server asked for.
+func (uconn *UConn) SetRecordSizeLimit(limit uint16) error {
+	if limit < 64 || limit > maxPlaintext+1 {
+		return fmt.Errorf("tls: record size limit %d is not between 64 and %d", limit, maxPlaintext+1)
+	}
+	if uconn.ClientHelloID == HelloGolang {
+		return errors.New("tls: record size limit cannot be set with HelloGolang")
+	}
+	if uconn.isHandshakeComplete.Load() {
+		return errors.New("tls: SetRecordSizeLimit must be called before the handshake")
+	}
+	uconn.recordSizeLimit = limit
+	if uconn.clientHelloBuildStatus == BuildByUtls {
+		uconn.setRecordSizeLimitExtension()
+		if err := uconn.ApplyConfig(); err != nil {
+			return err
+		}
+		return uconn.MarshalClientHello()
+	}
+	return nil
+}
+
+// SetCompressionMethods sets the legacy compression_methods of the
+// ClientHello, overriding ClientHelloSpec.CompressionMethods. It may be called
+// before or after BuildHandshakeState, but not with HelloGolang.
+//
+// Compression is not supported: the handshake fails if the server selects a
+// method other than no compression (0), and TLS 1.3 servers reject a
+// ClientHello that offers anything else.
+func (uconn *UConn) SetCompressionMethods(methods []uint8) error {
+	if len(methods) == 0 || len(methods) > 255 {
+		return fmt.Errorf("tls: %d compression methods is not between 1 and 255", len(methods))
+	}
+	if uconn.ClientHelloID == HelloGolang {
+		return errors.New("tls: compression methods cannot be set with HelloGolang")
+	}
+	if uconn.isHandshakeComplete.Load() {
+		return errors.New("tls: SetCompressionMethods must be called before the handshake")
+	}
+	uconn.compressionMethods = slices.Clone(methods)
+	if uconn.clientHelloBuildStatus == BuildByUtls {
+		uconn.HandshakeState.Hello.CompressionMethods = slices.Clone(methods)
+		return uconn.MarshalClientHello()
+	}
+	return nil
+}
+
+// setRecordSizeLimitExtension applies the limit set by SetRecordSizeLimit to
+// uconn.Extensions.
+func (uconn *UConn) setRecordSizeLimitExtension() {
+	if uconn.recordSizeLimit == 0 {
+		return
+	}
+	for _, ext := range uconn.Extensions {
+		if e, ok := ext.(*FakeRecordSizeLimitExtension); ok {
+			e.Limit = uconn.recordSizeLimit
+			return
+		}
+	}
+
+	uconn.insertExtension(&FakeRecordSizeLimitExtension{Limit: uconn.recordSizeLimit})
+}
+
+// insertExtension adds ext to uconn.Extensions before any trailing padding and
+// pre_shared_key extensions, which have to stay last, or at the end if the
+// ClientHelloSpec forces the extension order.
+func (uconn *UConn) insertExtension(ext TLSExtension) {
+	uconn.Extensions = slices.Insert(uconn.Extensions, uconn.insertExtensionIndex(), ext)
+}
+
+func (uconn *UConn) insertExtensionIndex() int {
+	i := len(uconn.Extensions)
+	if uconn.forceExtensionOrder {
+		return i
+	}
+	for i > 0 {
+		switch uconn.Extensions[i-1].(type) {
+		case PreSharedKeyExtension, *UtlsPaddingExtension:
+			i--
+			continue
+		}
+		break
+	}
+	return i
+}
+
+// utlsMaxPlaintext returns the maximum payload of a record, which is lowered
+// for protected records by the record_size_limit of the server, and for all
+// records by a negotiated max_fragment_length.
+func (c *Conn) utlsMaxPlaintext() int {
+	maxPayload := maxPlaintext
+	if c.utls.fragmentLength != 0 {
+		maxPayload = c.utls.fragmentLength
+	}
+	limit := int(c.utls.peerRecordSizeLimit)
+	if limit == 0 || c.out.cipher == nil {
+		return maxPayload
+	}
+	if c.vers == VersionTLS13 {
+		limit-- // the limit includes the encrypted ContentType
+	}
+	return min(limit, maxPayload)
+}
+
+// utlsSetMaxFragmentLength applies the max_fragment_length code echoed by the
+// server, 0 if it didn't send one. The server must echo the offered code. The
+// caller sends the alert.
+func (c *Conn) utlsSetMaxFragmentLength(code uint8) error {
+	if code == 0 {
+		return nil
+	}
+	if c.utls.maxFragmentLength == 0 {
+		return errors.New("tls: server sent an unsolicited max_fragment_length extension")
+	}
+	if code != c.utls.maxFragmentLength {
+		return fmt.Errorf("tls: server sent max_fragment_length code %d, expected %d", code, c.utls.maxFragmentLength)
+	}
+	c.utls.fragmentLength = 1 << (8 + code)
+	return nil
+}
+
+// utlsSetRecordSizeLimit applies the record_size_limit sent by the server, 0
+// if it didn't send one. It must be called after utlsSetMaxFragmentLength.
+// The caller sends the alert.
+func (c *Conn) utlsSetRecordSizeLimit(limit uint16) error {
+	if limit == 0 {
+		return nil
+	}
+	if c.utls.recordSizeLimit == 0 {
+		return errors.New("tls: server sent an unsolicited record_size_limit extension")
+	}
+	// RFC 8449, Section 4
+	if limit < 64 {
+		return fmt.Errorf("tls: server sent record size limit %d, which is less than 64", limit)
+	}
+	if c.utls.fragmentLength != 0 {
+		return errors.New("tls: server sent both max_fragment_length and record_size_limit")
+	}
+	c.utls.peerRecordSizeLimit = limit
+	return nil
+}
+
+// SetTargetHelloLength pads the ClientHello so that it is exactly n bytes
+// long, counting the 4-byte handshake message header but not the record
+// header, i.e. len(HandshakeState.Hello.Raw). This reproduces the length of
+// a captured ClientHello. It must be called after BuildHandshakeState.
+//
+// The padding extension of the spec is used, and one is added before
+// pre_shared_key if there is none (last with
+// ClientHelloSpec.ForceExtensionOrder). As the extension has a 4-byte header, n
+// must either be the length of the unpadded ClientHello, or exceed it by at
+// least 4. Otherwise an error is returned and the ClientHello is unchanged.
+// The target also applies to a ClientHello sent after a HelloRetryRequest,
+// as far as possible.
+func (uconn *UConn) SetTargetHelloLength(n int) error {
+	if uconn.clientHelloBuildStatus != BuildByUtls {
+		return errors.New("tls: SetTargetHelloLength must be called after BuildHandshakeState")
+	}
+
+	var padding *UtlsPaddingExtension
+	for _, ext := range uconn.Extensions {
+		if e, ok := ext.(*UtlsPaddingExtension); ok {
+			padding = e
+			break
+		}
+	}
+	savedExtensions := uconn.Extensions
+	var savedPadding *UtlsPaddingExtension
+	if padding != nil {
+		savedPadding = &UtlsPaddingExtension{}
+		*savedPadding = *padding
+	} else {
+		// early_data and pre_shared_key have to stay last
+		i := len(uconn.Extensions)
+		for ; i > 0 && !uconn.forceExtensionOrder; i-- {
+			if _, ok := uconn.Extensions[i-1].(*EarlyDataExtension); ok {
+				continue
+			}
+			if _, ok := uconn.Extensions[i-1].(PreSharedKeyExtension); ok {
+				continue
+			}
+			break
+		}
+		padding = &UtlsPaddingExtension{}
+		uconn.Extensions = slices.Insert(slices.Clip(uconn.Extensions), i, TLSExtension(padding))
+	}
+
+	unpaddedLen := 0
+	padding.GetPaddingLen = func(clientHelloUnpaddedLen int) (int, bool) {
+		unpaddedLen = clientHelloUnpaddedLen
+		if paddingLen := n - clientHelloUnpaddedLen - 4; paddingLen >= 0 {
+			return paddingLen, true
+		}
+		return 0, false
+	}
+	if err := uconn.BuildHandshakeState(); err != nil {
+		return err
+	}
+	if len(uconn.HandshakeState.Hello.Raw) == n {
+		return nil
+	}
+
+	// restore the ClientHello
+	if savedPadding != nil {
+		*padding = *savedPadding
+	}
+	uconn.Extensions = savedExtensions
+	if err := uconn.BuildHandshakeState(); err != nil {
+		return err
+	}
+	if unpaddedLen > n {
+		return fmt.Errorf("tls: ClientHello is already %d bytes, more than %d", unpaddedLen, n)
+	}
+	return fmt.Errorf("tls: ClientHello of %d bytes cannot be padded to %d, the padding extension takes at least 4 bytes", unpaddedLen, n)
+}
+
+// SetSNI sets the server name that is sent in the SNI extension and used to
+// verify the server certificate, i.e. Config.ServerName. Literal IP addresses
+// are never sent in SNI.
+//
+// Once a ClientHelloSpec has been applied, the SNI extension in Extensions is
+// updated in place. If the spec has none (or RemoveSNIExtension was called),
+// one is inserted first, after a leading GREASE extension, or last with
+// ClientHelloSpec.ForceExtensionOrder. The ClientHello is rebuilt with the new
+// name by the handshake, or by BuildHandshakeState.
 func (uconn *UConn) SetSNI(sni string) {
-	hname := hostnameInSNI(sni)
-	uconn.config.ServerName = hname
+	uconn.config.ServerName = sni
+	uconn.omitSNIExtension = false
+	if uconn.ClientHelloID == HelloGolang || (uconn.clientHelloBuildStatus == NotBuilt && len(uconn.Extensions) == 0) {
+		return // the ClientHello will be built from the config
+	}
+
 	for _, ext := range uconn.Extensions {
-		sniExt, ok := ext.(*SNIExtension)
-		if ok {
-			sniExt.ServerName = hname
+		if sniExt, ok := ext.(*SNIExtension); ok {
+			sniExt.ServerName = sni
+			return
 		}
 	}
+	if uconn.forceExtensionOrder {
+		uconn.Extensions = append(uconn.Extensions, &SNIExtension{ServerName: sni})
+		return
+	}
+	i := 0
+	if len(uconn.Extensions) > 0 {
+		if _, ok := uconn.Extensions[0].(*UtlsGREASEExtension); ok {
+			i = 1
+		}
+	}
+	uconn.Extensions = slices.Insert(uconn.Extensions, i, TLSExtension(&SNIExtension{ServerName: sni}))
+}
+
+// SNIExtensionBytes returns the server_name extension of the marshaled
+// ClientHello, including its type and length, and its offset within
+// HandshakeState.Hello.Raw, which is the ClientHello handshake message without
+// a record header. ok is false if the ClientHello has not been marshaled yet,
+// e.g. before BuildHandshakeState, or if it has no server_name extension.
+//
+// data is a copy: changes to it are not sent.
+func (uconn *UConn) SNIExtensionBytes() (offset int, data []byte, ok bool) {
+	hello := uconn.HandshakeState.Hello
+	if hello == nil || len(hello.Raw) == 0 {
+		return 0, nil, false
+	}
+	raw := hello.Raw
+	s := cryptobyte.String(raw)
+	var sessionID, cipherSuites, compressionMethods, exts cryptobyte.String
+	if !s.Skip(4+2+32) || // message header, version and random
+		!s.ReadUint8LengthPrefixed(&sessionID) ||
+		!s.ReadUint16LengthPrefixed(&cipherSuites) ||
+		!s.ReadUint8LengthPrefixed(&compressionMethods) ||
+		!s.ReadUint16LengthPrefixed(&exts) {
+		return 0, nil, false
+	}
+	offset = len(raw) - len(s) - len(exts)
+	for !exts.Empty() {
+		var extType uint16
+		var extData cryptobyte.String
+		if !exts.ReadUint16(&extType) || !exts.ReadUint16LengthPrefixed(&extData) {
+			return 0, nil, false
+		}
+		end := offset + 4 + len(extData)
+		if extType == extensionServerName {
+			return offset, slices.Clone(raw[offset:end]), true
+		}
+		offset = end
+	}
+	return 0, nil, false
+}
+
+// ExtensionsBytes returns the extensions block of the marshaled ClientHello,
+// everything after its compression_methods: the 2-byte length of the
+// extensions, followed by each of them with its type and length. It is nil if
+// the ClientHello has no extensions. With ECH, it is the block of the outer
+// ClientHello, as sent.
+//
+// It can be compared to the same bytes of a captured ClientHello, ignoring the
+// version, random and session ID they differ by. It fails if the ClientHello
+// has not been marshaled yet, e.g. before BuildHandshakeState. The returned
+// slice is a copy: changes to it are not sent.
+func (uconn *UConn) ExtensionsBytes() ([]byte, error) {
+	hello := uconn.HandshakeState.Hello
+	if hello == nil || len(hello.Raw) == 0 {
+		return nil, errors.New("tls: ClientHello is not built yet")
+	}
+	s := cryptobyte.String(hello.Raw)
+	var sessionID, cipherSuites, compressionMethods cryptobyte.String
+	if !s.Skip(4+2+32) || // message header, version and random
+		!s.ReadUint8LengthPrefixed(&sessionID) ||
+		!s.ReadUint16LengthPrefixed(&cipherSuites) ||
+		!s.ReadUint8LengthPrefixed(&compressionMethods) {
+		return nil, errors.New("tls: malformed ClientHello")
+	}
+	if s.Empty() {
+		return nil, nil
+	}
+	return slices.Clone(s), nil
 }
 
 // RemoveSNIExtension removes SNI from the list of extensions sent in ClientHello
@@ -294,6 +1014,142 @@ func (uconn *UConn) removeSNIExtension() {
 	uconn.Extensions = filteredExts
 }
 
+// SetALPN sets the protocols offered in the ALPN extension, i.e.
+// Config.NextProtos, in order of preference. It returns an error if protocols
+// is empty; use RemoveExtension to stop sending ALPN.
+//
+// Once a ClientHelloSpec has been applied, the ALPN extension in Extensions is
+// updated in place, or inserted before a trailing padding or pre_shared_key
+// extension if the spec has none. Protocols that are no longer offered are
+// dropped from the ApplicationSettingsExtension, and the extension is removed
+// if none remain, as browsers only send ALPS for the protocols they offer.
+func (uconn *UConn) SetALPN(protocols []string) error {
+	if len(protocols) == 0 {
+		return errors.New("tls: SetALPN requires at least one protocol")
+	}
+	for _, proto := range protocols {
+		if len(proto) == 0 || len(proto) > 255 {
+			return fmt.Errorf("tls: invalid ALPN protocol %q", proto)
+		}
+	}
+	protocols = slices.Clone(protocols)
+	uconn.config.NextProtos = protocols
+	if uconn.ClientHelloID == HelloGolang || (uconn.clientHelloBuildStatus == NotBuilt && len(uconn.Extensions) == 0) {
+		return nil // the ClientHello will be built from the config
+	}
+
+	found := false
+	exts := uconn.Extensions[:0]
+	for _, ext := range uconn.Extensions {
+		switch e := ext.(type) {
+		case *ALPNExtension:
+			e.AlpnProtocols = protocols
+			found = true
+		case *ApplicationSettingsExtension:
+			e.SupportedProtocols = slices.DeleteFunc(slices.Clone(e.SupportedProtocols), func(proto string) bool {
+				return !slices.Contains(protocols, proto)
+			})
+			if len(e.SupportedProtocols) == 0 {
+				continue
+			}
+		}
+		exts = append(exts, ext)
+	}
+	uconn.Extensions = exts
+	if !found {
+		uconn.insertExtension(&ALPNExtension{AlpnProtocols: protocols})
+	}
+	if uconn.HandshakeState.Hello != nil {
+		uconn.HandshakeState.Hello.AlpnProtocols = protocols
+	}
+	return nil
+}
+
+// SetSupportedCurves sets the groups of the supported_groups extension, in
+// order of preference, e.g. to experiment with the group preferences of a
+// parrot. GREASE_PLACEHOLDER (or any GREASE value) is replaced with the GREASE
+// value of the connection. With HelloGolang it sets Config.CurvePreferences;
+// otherwise it must be called after ApplyPreset or BuildHandshakeState, on a
+// ClientHello with a supported_groups extension.
+//
+// The key_share extension is kept consistent with it: the key shares of groups
+// that are no longer offered are dropped. An error is returned, and nothing is
+// changed, if that would leave no key share. New groups don't get a key share:
+// a server that only accepts one of them sends a HelloRetryRequest.
+func (uconn *UConn) SetSupportedCurves(curves []CurveID) error {
+	if len(curves) == 0 {
+		return errors.New("tls: SetSupportedCurves requires at least one group")
+	}
+	if uconn.isHandshakeComplete.Load() {
+		return errors.New("tls: SetSupportedCurves must be called before the handshake")
+	}
+	if uconn.ClientHelloID == HelloGolang {
+		uconn.config.CurvePreferences = slices.Clone(curves)
+		return nil
+	}
+
+	var curvesExt *SupportedCurvesExtension
+	var keyShareExt *KeyShareExtension
+	for _, ext := range uconn.Extensions {
+		switch e := ext.(type) {
+		case *SupportedCurvesExtension:
+			curvesExt = e
+		case *KeyShareExtension:
+			keyShareExt = e
+		}
+	}
+	if curvesExt == nil {
+		return errors.New("tls: SetSupportedCurves requires a ClientHello with a supported_groups extension")
+	}
+
+	grease := CurveID(GetBoringGREASEValue(uconn.greaseSeed, ssl_grease_group))
+	curves = slices.Clone(curves)
+	offersGREASE := false
+	for i, curve := range curves {
+		if isGREASEUint16(uint16(curve)) {
+			curves[i] = grease
+			offersGREASE = true
+		}
+	}
+
+	var keyShares []KeyShare
+	if keyShareExt != nil {
+		for _, ks := range keyShareExt.KeyShares {
+			if isGREASEUint16(uint16(ks.Group)) {
+				if offersGREASE {
+					ks.Group = grease
+					keyShares = append(keyShares, ks)
+				}
+			} else if slices.Contains(curves, ks.Group) {
+				keyShares = append(keyShares, ks)
+			}
+		}
+		if !slices.ContainsFunc(keyShares, func(ks KeyShare) bool { return !isGREASEUint16(uint16(ks.Group)) }) {
+			return errors.New("tls: SetSupportedCurves would remove the groups of all key shares")
+		}
+		keyShareExt.KeyShares = keyShares
+	}
+	curvesExt.Curves = curves
+
+	if uconn.clientHelloBuildStatus == BuildByUtls {
+		if err := uconn.ApplyConfig(); err != nil {
+			return err
+		}
+		return uconn.MarshalClientHello()
+	}
+	return nil
+}
+
+// clearKeyShareKeys drops the private keys generated for the key_share
+// extension from HandshakeState.
+func (uconn *UConn) clearKeyShareKeys() {
+	uconn.HandshakeState.State13.EcdheKey = nil
+	uconn.HandshakeState.State13.KEMKey = nil
+	if uconn.HandshakeState.State13.KeySharesParams != nil {
+		uconn.HandshakeState.State13.KeySharesParams = NewKeySharesParameters()
+	}
+}
+
 // Handshake runs the client handshake using given clientHandshakeState
 // Requires hs.hello, and, optionally, hs.session to be set.
 func (c *UConn) Handshake() error {
@@ -381,10 +1237,18 @@ func (c *UConn) handshakeContext(ctx context.Context) (ret error) {
 	c.handshakeErr = c.handshakeFn(handshakeCtx)
 	if c.handshakeErr == nil {
 		c.handshakes++
+		if c.isClient {
+			c.config.HandshakeTrace.handshakeComplete()
+		}
 	} else {
 		// If an error occurred during the hadshake try to flush the
 		// alert that might be left in the buffer.
 		c.flush()
+		// [uTLS section begins]
+		// The connection can't be used anymore, e.g. because ctx was
+		// canceled, so don't keep the private keys of its key shares around.
+		c.clearKeyShareKeys()
+		// [uTLS section ends]
 	}
 
 	if c.handshakeErr == nil && !c.isHandshakeComplete.Load() {
@@ -418,6 +1282,14 @@ func (c *UConn) handshakeContext(ctx context.Context) (ret error) {
 		close(c.quic.signalc)
 	}
 
+	// [uTLS section begins]
+	if c.handshakeErr == nil && c.quic == nil {
+		if err := c.writePendingEarlyData(); err != nil {
+			return err
+		}
+	}
+	// [uTLS section ends]
+
 	return c.handshakeErr
 }
 
@@ -486,6 +1358,8 @@ func (c *UConn) clientHandshake(ctx context.Context) (err error) {
 	hello := c.HandshakeState.Hello.getPrivatePtr()
 	defer func() { c.HandshakeState.Hello = hello.getPublicPtr() }()
 
+	c.utls.transcript = nil // this may be a renegotiation
+
 	sessionIsLocked := c.utls.sessionController.isSessionLocked()
 
 	// after this point exactly 1 out of 2 HandshakeState pointers is non-nil,
@@ -560,6 +1434,11 @@ func (c *UConn) clientHandshake(ctx context.Context) (err error) {
 		}()
 	}
 
+	// [uTLS section begins]
+	if err := c.runClientHelloCallback(hello); err != nil {
+		return err
+	}
+	// [uTLS section ends]
 	if _, err := c.writeHandshakeRecord(hello, nil); err != nil {
 		return err
 	}
@@ -571,7 +1450,15 @@ func (c *UConn) clientHandshake(ctx context.Context) (err error) {
 			return err
 		}
 		earlyTrafficSecret := suite.deriveSecret(earlySecret, clientEarlyTrafficLabel, transcript)
-		c.quicSetWriteSecret(QUICEncryptionLevelEarly, suite.id, earlyTrafficSecret)
+		// [uTLS section begins]
+		if c.quic == nil {
+			if err := c.sendEarlyData(suite, earlyTrafficSecret, session.maxEarlyData); err != nil {
+				return err
+			}
+		} else {
+			c.quicSetWriteSecret(QUICEncryptionLevelEarly, suite.id, earlyTrafficSecret)
+		}
+		// [uTLS section ends]
 	}
 
 	msg, err := c.readHandshake(nil)
@@ -589,6 +1476,21 @@ func (c *UConn) clientHandshake(ctx context.Context) (err error) {
 		return err
 	}
 
+	// [uTLS section begins]
+	// The supported_versions of a ClientHelloSpec may skip versions between its
+	// minimum and maximum, which the config doesn't. See RFC 8446, Section 4.2.1.
+	if serverHello.supportedVersion != 0 && len(hello.supportedVersions) > 0 &&
+		!slices.Contains(hello.supportedVersions, serverHello.supportedVersion) {
+		c.sendAlert(alertIllegalParameter)
+		return fmt.Errorf("tls: server selected protocol version %x, which was not offered", serverHello.supportedVersion)
+	}
+
+	// See RFC 8446, Section 4.2.10.
+	if hello.earlyData && c.quic == nil && c.vers != VersionTLS13 {
+		return errors.New("tls: server selected TLS 1.2 or lower after 0-RTT data was sent")
+	}
+	// [uTLS section ends]
+
 	// uTLS: do not create new handshakeState, use existing one
 	if c.vers == VersionTLS13 {
 		hs13 := c.HandshakeState.toPrivate13()
@@ -603,6 +1505,7 @@ func (c *UConn) clientHandshake(ctx context.Context) (err error) {
 			hs13.session = session
 		}
 		hs13.ctx = ctx
+		hs13.sentDummyCCS = hello.earlyData && c.quic == nil // [uTLS] sent along with the early data
 		// In TLS 1.3, session tickets are delivered after the handshake.
 		err = hs13.handshake()
 		if handshakeState := hs13.toPublic13(); handshakeState != nil {
@@ -611,6 +1514,12 @@ func (c *UConn) clientHandshake(ctx context.Context) (err error) {
 		return err
 	}
 
+	// ECH is only supported for TLS 1.3, a server negotiating an older
+	// version has rejected it.
+	if c.utls.ech != nil {
+		c.utls.ech.rejected = true
+	}
+
 	hs12 := c.HandshakeState.toPrivate12()
 	hs12.serverHello = serverHello
 	hs12.hello = hello
@@ -623,6 +1532,11 @@ func (c *UConn) clientHandshake(ctx context.Context) (err error) {
 	if err != nil {
 		return err
 	}
+	if c.utls.ech != nil {
+		c.utls.ech.rejected = true
+		c.sendAlert(alertECHRequired)
+		return &ECHRejectionError{}
+	}
 	return nil
 }
 
@@ -637,7 +1551,8 @@ func (uconn *UConn) ApplyConfig() error {
 }
 
 func (uconn *UConn) MarshalClientHello() error {
-	if len(uconn.config.ECHConfigs) > 0 && uconn.ech != nil {
+	uconn.utls.ech = nil
+	if uconn.echEnabled() {
 		if err := uconn.ech.Configure(uconn.config.ECHConfigs); err != nil {
 			return err
 		}
@@ -647,6 +1562,11 @@ func (uconn *UConn) MarshalClientHello() error {
 	return uconn.MarshalClientHelloNoECH() // if no ECH pointer, just marshal normally
 }
 
+// echEnabled reports whether the ClientHello is to be encrypted with ECH.
+func (uconn *UConn) echEnabled() bool {
+	return len(uconn.config.ECHConfigs) > 0 && uconn.ech != nil
+}
+
 // MarshalClientHelloNoECH marshals ClientHello as if there was no
 // ECH extension present.
 func (uconn *UConn) MarshalClientHelloNoECH() error {
@@ -682,31 +1602,36 @@ func (uconn *UConn) MarshalClientHelloNoECH() error {
 		helloLen += 2 + extensionsLen // 2 bytes for extensions' length
 	}
 
-	helloBuffer := bytes.Buffer{}
-	bufferedWriter := bufio.NewWriterSize(&helloBuffer, helloLen+4) // 1 byte for tls record type, 3 for length
+	helloBuffer := bytes.NewBuffer(make([]byte, 0, helloLen+4))
+	bufferedWriter := bufio.NewWriterSize(helloBuffer, helloLen+4) // 1 byte for tls record type, 3 for length
 	// We use buffered Writer to avoid checking write errors after every Write(): whenever first error happens
-	// Write() will become noop, and error will be accessible via Flush(), which is called once in the end
+	// Write() will become noop, and error will be accessible via Flush(), which is called once in the end.
+	// Integers are written byte by byte, as binary.Write allocates for each of them.
+	writeUint16 := func(v uint16) {
+		bufferedWriter.WriteByte(byte(v >> 8))
+		bufferedWriter.WriteByte(byte(v))
+	}
 
-	binary.Write(bufferedWriter, binary.BigEndian, typeClientHello)
-	helloLenBytes := []byte{byte(helloLen >> 16), byte(helloLen >> 8), byte(helloLen)} // poor man's uint24
-	binary.Write(bufferedWriter, binary.BigEndian, helloLenBytes)
-	binary.Write(bufferedWriter, binary.BigEndian, hello.Vers)
+	bufferedWriter.WriteByte(typeClientHello)
+	bufferedWriter.WriteByte(byte(helloLen >> 16)) // poor man's uint24
+	writeUint16(uint16(helloLen))
+	writeUint16(hello.Vers)
 
-	binary.Write(bufferedWriter, binary.BigEndian, hello.Random)
+	bufferedWriter.Write(hello.Random)
 
-	binary.Write(bufferedWriter, binary.BigEndian, uint8(len(hello.SessionId)))
-	binary.Write(bufferedWriter, binary.BigEndian, hello.SessionId)
+	bufferedWriter.WriteByte(uint8(len(hello.SessionId)))
+	bufferedWriter.Write(hello.SessionId)
 
-	binary.Write(bufferedWriter, binary.BigEndian, uint16(len(hello.CipherSuites)<<1))
+	writeUint16(uint16(len(hello.CipherSuites) << 1))
 	for _, suite := range hello.CipherSuites {
-		binary.Write(bufferedWriter, binary.BigEndian, suite)
+		writeUint16(suite)
 	}
 
-	binary.Write(bufferedWriter, binary.BigEndian, uint8(len(hello.CompressionMethods)))
-	binary.Write(bufferedWriter, binary.BigEndian, hello.CompressionMethods)
+	bufferedWriter.WriteByte(uint8(len(hello.CompressionMethods)))
+	bufferedWriter.Write(hello.CompressionMethods)
 
 	if len(uconn.Extensions) > 0 {
-		binary.Write(bufferedWriter, binary.BigEndian, uint16(extensionsLen))
+		writeUint16(uint16(extensionsLen))
 		for _, ext := range uconn.Extensions {
 			if _, err := bufferedWriter.ReadFrom(ext); err != nil {
 				return err
@@ -894,6 +1819,7 @@ func (c *Conn) utlsHandshakeMessageType(msgType byte) (handshakeMessage, error)
 func (c *Conn) utlsConnectionStateLocked(state *ConnectionState) {
 	state.PeerApplicationSettings = c.utls.peerApplicationSettings
 	state.ECHRetryConfigs = c.utls.echRetryConfigs
+	state.ECHAccepted = c.utls.ech != nil && c.utls.ech.accepted
 }
 
 type utlsConnExtraFields struct {
@@ -901,11 +1827,131 @@ type utlsConnExtraFields struct {
 	hasApplicationSettings   bool
 	peerApplicationSettings  []byte
 	localApplicationSettings []byte
+	// codepoint of the ALPS extension sent in ClientHello, also used for the
+	// client's EncryptedExtensions
+	applicationSettingsCodepoint uint16
+
+	// record_size_limit sent in the ClientHello, 0 if none, and the one sent
+	// by the server, 0 if it didn't send one
+	recordSizeLimit     uint16
+	peerRecordSizeLimit uint16
+
+	// ServerHelloSpec of a UServerConn, nil for other connections
+	serverHelloSpec *ServerHelloSpec
+
+	// max_fragment_length code sent in the ClientHello, 0 if none, and the
+	// fragment length the server agreed to, 0 if it didn't
+	maxFragmentLength uint8
+	fragmentLength    int
+
+	// algorithm the server compressed its Certificate message with, 0 if it
+	// wasn't compressed
+	certCompressionUsed CertCompressionAlgo
+
+	// group of the ServerKeyExchange in TLS 1.2 and earlier, 0 if none
+	serverKeyExchangeGroup CurveID
+
+	// SHA-256 hashes of the SubjectPublicKeyInfos set with
+	// UConn.SetPinnedCerts
+	pinnedCerts [][sha256.Size]byte
+
+	// offsets of the ClientHello records, set with UConn.SetRecordSplitPattern
+	recordSplitPattern []int
+
+	// set with UConn.SetClientHelloCallback
+	clientHelloCallback func(record []byte)
 
 	// Encrypted Client Hello (ECH)
-	echRetryConfigs []ECHConfig
+	echRetryConfigs    []ECHConfig
+	echRetryConfigList []byte            // echRetryConfigs as sent
+	ech                *echClientContext // set if the ClientHello was encrypted
+
+	// the HelloRetryRequest received by the client, if any
+	helloRetryRequest *serverHelloMsg
 
 	sessionController *sessionController
+
+	// 0-RTT data buffered by UConn.WriteEarlyData, how much of it was sent
+	// with the ClientHello, and whether the server accepted it
+	earlyData         []byte
+	earlyDataSent     int
+	earlyDataAccepted bool
+
+	// server side: 0-RTT data received during the handshake, and how many
+	// bytes of rejected 0-RTT data may still be skipped
+	receivedEarlyData []byte
+	earlyDataToSkip   int
+
+	// handshake messages of the last handshake, recorded for
+	// UConn.HandshakeTranscript if recordTranscript is set
+	recordTranscript bool
+	transcript       []byte
+}
+
+// recordHandshakeMessage appends msg to the transcript returned by
+// UConn.HandshakeTranscript, if it is part of a UConn handshake.
+func (c *Conn) recordHandshakeMessage(msg []byte) {
+	if c.utls.recordTranscript && !c.isHandshakeComplete.Load() {
+		c.utls.transcript = append(c.utls.transcript, msg...)
+	}
+}
+
+// EnableHandshakeTranscript makes the UConn keep the handshake messages that
+// HandshakeTranscript returns. They are not kept by default, as they include
+// the certificate chain of the server. It must be called before the handshake.
+func (uconn *UConn) EnableHandshakeTranscript() error {
+	if uconn.isHandshakeComplete.Load() {
+		return errors.New("tls: EnableHandshakeTranscript must be called before the handshake")
+	}
+	uconn.utls.recordTranscript = true
+	return nil
+}
+
+// HandshakeTranscript returns the handshake messages of the last handshake,
+// in order and each with its 4-byte header, from the ClientHello to the last
+// Finished message, as they were sent and received. Post-handshake messages,
+// like the NewSessionTicket messages of TLS 1.3, are not included. It returns
+// an error if EnableHandshakeTranscript wasn't called before the handshake, or
+// if the handshake is not complete.
+//
+// These are the messages that the transcript hash is computed over, except
+// that after a HelloRetryRequest the first ClientHello is replaced by its hash
+// (RFC 8446, Section 4.4.1), and that if the server accepted ECH, the
+// transcript hash covers the ClientHelloInner instead of the ClientHelloOuter
+// that was sent.
+func (uconn *UConn) HandshakeTranscript() ([]byte, error) {
+	if !uconn.utls.recordTranscript {
+		return nil, errors.New("tls: the handshake transcript was not enabled with EnableHandshakeTranscript")
+	}
+	if !uconn.isHandshakeComplete.Load() {
+		return nil, errors.New("tls: the handshake is not complete")
+	}
+	return slices.Clone(uconn.utls.transcript), nil
+}
+
+// ExportKeyingMaterial returns length bytes of keying material exported from
+// the connection, as defined in RFC 5705 for TLS 1.2 and in RFC 8446, Section
+// 7.5, for TLS 1.3. See ConnectionState.ExportKeyingMaterial.
+//
+// ConnectionState.ExportKeyingMaterial always fails if renegotiation is
+// enabled, which the RenegotiationInfoExtension of most parrots does, because
+// a renegotiation would change the keying material. This method works until
+// the connection is actually renegotiated. As in crypto/tls, TLS 1.2
+// connections can only export keying material if they negotiated the extended
+// master secret (RFC 7627).
+func (uconn *UConn) ExportKeyingMaterial(label string, context []byte, length int) ([]byte, error) {
+	uconn.handshakeMutex.Lock()
+	defer uconn.handshakeMutex.Unlock()
+	if !uconn.isHandshakeComplete.Load() {
+		return nil, errors.New("tls: ExportKeyingMaterial must be called after the handshake")
+	}
+	if uconn.handshakes > 1 {
+		return nil, errors.New("tls: ExportKeyingMaterial is unavailable after renegotiation")
+	}
+	if uconn.vers != VersionTLS13 && !uconn.extMasterSecret {
+		return noEKMBecauseNoEMS(label, context, length)
+	}
+	return uconn.ekm(label, context, length)
 }
 
 // Read reads data from the connection.
@@ -998,6 +2044,12 @@ func (c *UConn) handleRenegotiation() error {
 	c.isHandshakeComplete.Store(false)
 
 	// [uTLS section begins]
+	// The renegotiation ClientHello is the same, with a new random and the
+	// renegotiation_info set by RenegotiationInfoExtension.
+	if _, err := io.ReadFull(c.config.rand(), c.HandshakeState.Hello.Random); err != nil {
+		c.sendAlert(alertInternalError)
+		return errors.New("tls: short read from Rand: " + err.Error())
+	}
 	if err = c.BuildHandshakeState(); err != nil {
 		return err
 	}
@@ -6,16 +6,27 @@ package tls
 
 import (
 	"bytes"
+	"context"
+	"crypto/sha256"
 	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
 	"net"
 	"os"
 	"os/exec"
 	"runtime/debug"
+	"slices"
 	"strings"
 	"testing"
 	"time"
+
+	"github.com/cloudflare/circl/hpke"
+	"github.com/cloudflare/circl/kem"
+	"golang.org/x/crypto/cryptobyte"
+	"golang.org/x/crypto/hkdf"
 )
 
 // helloStrategy is a sum type interface which allows us to pass either a ClientHelloID or a ClientHelloSpec and then act accordingly
@@ -75,6 +86,60 @@ func TestUTLSHandshakeClientParrotGolang(t *testing.T) {
 	testUTLSHandshakeClientRSA_AES128_GCM_SHA256(t, hello)
 }
 
+func TestUTLSHelloGolangMatchesClient(t *testing.T) {
+	capture := func(t *testing.T, handshake func(net.Conn)) *ClientHelloSpec {
+		t.Helper()
+		c, s := net.Pipe()
+		defer s.Close()
+		go func() {
+			handshake(c)
+			c.Close()
+		}()
+		spec, _, err := (&Fingerprinter{AllowBluntMimicry: true}).FingerprintFromReader(s)
+		if err != nil {
+			t.Fatalf("got error: %v; expected to succeed", err)
+		}
+		return spec
+	}
+
+	for _, test := range []struct {
+		name   string
+		config *Config
+	}{
+		{"defaults", &Config{ServerName: "example.golang"}},
+		{"net/http", &Config{ServerName: "example.golang", NextProtos: []string{"h2", "http/1.1"}}},
+		{"TLS 1.2", &Config{ServerName: "example.golang", MaxVersion: VersionTLS12}},
+		{"no SNI", &Config{InsecureSkipVerify: true}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			expected := capture(t, func(c net.Conn) { Client(c, test.config.Clone()).Handshake() })
+			got := capture(t, func(c net.Conn) { UClient(c, test.config.Clone(), HelloGolang).Handshake() })
+			if diffs := DiffSpecs(*got, *expected); len(diffs) != 0 {
+				t.Errorf("HelloGolang differs from the ClientHello of Client: %v", diffs)
+			}
+		})
+	}
+
+	// without TLS 1.3, there are no key shares
+	c, s := localPipe(t)
+	done := make(chan error, 1)
+	go func() {
+		server := Server(s, testConfig.Clone())
+		defer server.Close()
+		done <- server.Handshake()
+	}()
+	uconn := UClient(c, &Config{ServerName: "example.golang", InsecureSkipVerify: true, MaxVersion: VersionTLS12}, HelloGolang)
+	defer uconn.Close()
+	if err := uconn.Handshake(); err != nil {
+		t.Fatalf("client handshake failed: %v", err)
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("server handshake failed: %v", err)
+	}
+	if vers := uconn.ConnectionState().Version; vers != VersionTLS12 {
+		t.Errorf("negotiated %s, expected TLS 1.2", VersionName(vers))
+	}
+}
 func TestUTLSHandshakeClientParrotChrome_70(t *testing.T) {
 	hello := &helloID{HelloChrome_70}
 
@@ -743,3 +808,1851 @@ func TestUTLSMakeConnWithCompleteHandshake(t *testing.T) {
 
 	serverTls.Write(serverMsg)
 }
+
+// testECHConfigs returns an ECHConfigList with a single config for a new
+// X25519 key, along with its private key.
+func testECHConfigs(t *testing.T, publicName string) ([]ECHConfig, kem.PrivateKey) {
+	pk, sk, err := hpke.KEM_X25519_HKDF_SHA256.Scheme().GenerateKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rawPk, err := pk.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var b cryptobyte.Builder
+	b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+		b.AddUint16(utlsExtensionECH)
+		b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+			b.AddUint8(42) // config_id
+			b.AddUint16(uint16(hpke.KEM_X25519_HKDF_SHA256))
+			b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) { b.AddBytes(rawPk) })
+			b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+				b.AddUint16(uint16(hpke.KDF_HKDF_SHA256))
+				b.AddUint16(uint16(hpke.AEAD_AES128GCM))
+			})
+			b.AddUint8(32) // maximum_name_length
+			b.AddUint8LengthPrefixed(func(b *cryptobyte.Builder) { b.AddBytes([]byte(publicName)) })
+			b.AddUint16(0) // extensions
+		})
+	})
+	configs, err := UnmarshalECHConfigs(b.BytesOrPanic())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return configs, sk
+}
+
+func TestUTLSECHEncryptsClientHello(t *testing.T) {
+	configs, sk := testECHConfigs(t, "public.example")
+	uconn := UClient(&net.TCPConn{}, &Config{ServerName: "secret.example"}, HelloChrome_120)
+	if err := uconn.SetECHConfigs(configs); err != nil {
+		t.Fatalf("got error: %v; expected to succeed", err)
+	}
+	if err := uconn.BuildHandshakeState(); err != nil {
+		t.Fatalf("got error: %v; expected to succeed", err)
+	}
+
+	outer := new(clientHelloMsg)
+	if !outer.unmarshal(uconn.HandshakeState.Hello.Raw) {
+		t.Fatal("failed to parse ClientHelloOuter")
+	}
+	if outer.serverName != "public.example" {
+		t.Errorf("ClientHelloOuter has SNI %q, expected the public name", outer.serverName)
+	}
+
+	// find the ECH extension of ClientHelloOuter
+	s := cryptobyte.String(uconn.HandshakeState.Hello.Raw[4:])
+	var exts, ech cryptobyte.String
+	if !s.Skip(2+32) || !s.Skip(1+len(outer.sessionId)) || !s.Skip(2+2*len(outer.cipherSuites)) ||
+		!s.Skip(1+len(outer.compressionMethods)) || !s.ReadUint16LengthPrefixed(&exts) {
+		t.Fatal("failed to parse ClientHelloOuter")
+	}
+	for !exts.Empty() {
+		var extType uint16
+		var extData cryptobyte.String
+		if !exts.ReadUint16(&extType) || !exts.ReadUint16LengthPrefixed(&extData) {
+			t.Fatal("failed to parse ClientHelloOuter extensions")
+		}
+		if extType == utlsExtensionECH {
+			ech = extData
+		}
+	}
+	var chType, configID uint8
+	var kdfID, aeadID uint16
+	var enc, payload cryptobyte.String
+	if !ech.ReadUint8(&chType) || !ech.ReadUint16(&kdfID) || !ech.ReadUint16(&aeadID) || !ech.ReadUint8(&configID) ||
+		!ech.ReadUint16LengthPrefixed(&enc) || !ech.ReadUint16LengthPrefixed(&payload) || !ech.Empty() {
+		t.Fatal("failed to parse ECH extension")
+	}
+	if chType != OuterClientHello || configID != 42 || kdfID != uint16(hpke.KDF_HKDF_SHA256) || aeadID != uint16(hpke.AEAD_AES128GCM) {
+		t.Fatalf("unexpected ECH extension: type %d, config_id %d, KDF %d, AEAD %d", chType, configID, kdfID, aeadID)
+	}
+
+	// decrypt it as the server would
+	aad := bytes.Clone(uconn.HandshakeState.Hello.Raw[4:])
+	i := bytes.Index(aad, payload)
+	clear(aad[i : i+len(payload)])
+	suite := hpke.NewSuite(hpke.KEM_X25519_HKDF_SHA256, hpke.KDF_HKDF_SHA256, hpke.AEAD_AES128GCM)
+	receiver, err := suite.NewReceiver(sk, append([]byte("tls ech\x00"), configs[0].raw...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	opener, err := receiver.Setup(enc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encoded, err := opener.Open(payload, aad)
+	if err != nil {
+		t.Fatalf("failed to decrypt ClientHelloInner: %v", err)
+	}
+	if len(encoded)%32 != 0 {
+		t.Errorf("EncodedClientHelloInner is %d bytes, expected a multiple of 32", len(encoded))
+	}
+
+	// reconstruct ClientHelloInner, which must match the one of the transcript
+	s = cryptobyte.String(encoded)
+	var vers uint16
+	var random, sessionID, cipherSuites, compressionMethods []byte
+	if !s.ReadUint16(&vers) || !s.ReadBytes(&random, 32) || !readUint8LengthPrefixed(&s, &sessionID) ||
+		!readUint16LengthPrefixed(&s, &cipherSuites) || !readUint8LengthPrefixed(&s, &compressionMethods) ||
+		!s.ReadUint16LengthPrefixed(&exts) {
+		t.Fatal("failed to parse EncodedClientHelloInner")
+	}
+	if len(sessionID) != 0 || !bytes.Equal(s, make([]byte, len(s))) {
+		t.Errorf("EncodedClientHelloInner has a session ID or non-zero padding")
+	}
+	var b cryptobyte.Builder
+	b.AddUint8(typeClientHello)
+	b.AddUint24LengthPrefixed(func(b *cryptobyte.Builder) {
+		b.AddUint16(vers)
+		b.AddBytes(random)
+		b.AddUint8LengthPrefixed(func(b *cryptobyte.Builder) { b.AddBytes(outer.sessionId) })
+		b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) { b.AddBytes(cipherSuites) })
+		b.AddUint8LengthPrefixed(func(b *cryptobyte.Builder) { b.AddBytes(compressionMethods) })
+		b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) { b.AddBytes(exts) })
+	})
+	innerRaw := b.BytesOrPanic()
+	if !bytes.Equal(innerRaw, uconn.utls.ech.innerHello) {
+		t.Errorf("reconstructed ClientHelloInner differs from the one sent")
+	}
+
+	inner := new(clientHelloMsg)
+	if !inner.unmarshal(innerRaw) {
+		t.Fatal("failed to parse ClientHelloInner")
+	}
+	if inner.serverName != "secret.example" {
+		t.Errorf("ClientHelloInner has SNI %q, expected secret.example", inner.serverName)
+	}
+	if bytes.Equal(inner.random, outer.random) {
+		t.Errorf("ClientHelloInner and ClientHelloOuter share the same random")
+	}
+}
+
+func TestUTLSECHAcceptConfirmation(t *testing.T) {
+	configs, _ := testECHConfigs(t, "public.example")
+	uconn := UClient(&net.TCPConn{}, &Config{ServerName: "secret.example"}, HelloChrome_120)
+	if err := uconn.SetECHConfigs(configs); err != nil {
+		t.Fatalf("got error: %v; expected to succeed", err)
+	}
+	if err := uconn.BuildHandshakeState(); err != nil {
+		t.Fatalf("got error: %v; expected to succeed", err)
+	}
+	ech := uconn.utls.ech
+
+	serverHello := &serverHelloMsg{
+		vers:             VersionTLS12,
+		random:           make([]byte, 32),
+		sessionId:        uconn.HandshakeState.Hello.SessionId,
+		cipherSuite:      TLS_AES_128_GCM_SHA256,
+		supportedVersion: VersionTLS13,
+		serverShare:      keyShare{group: X25519, data: make([]byte, 32)},
+	}
+	raw, err := serverHello.marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Section 7.2 of draft-ietf-tls-esni
+	transcript := sha256.New()
+	transcript.Write(ech.innerHello)
+	transcript.Write(raw)
+	var label cryptobyte.Builder
+	label.AddUint16(8)
+	label.AddUint8LengthPrefixed(func(b *cryptobyte.Builder) { b.AddBytes([]byte("tls13 ech accept confirmation")) })
+	label.AddUint8LengthPrefixed(func(b *cryptobyte.Builder) { b.AddBytes(transcript.Sum(nil)) })
+	confirmation := make([]byte, 8)
+	if _, err := io.ReadFull(hkdf.Expand(sha256.New, hkdf.Extract(sha256.New, ech.innerRandom, nil), label.BytesOrPanic()), confirmation); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, accept := range []bool{true, false} {
+		ech.accepted, ech.rejected = false, false
+		sh := new(serverHelloMsg)
+		withConfirmation := bytes.Clone(raw)
+		if accept {
+			copy(withConfirmation[30:38], confirmation)
+		}
+		if !sh.unmarshal(withConfirmation) {
+			t.Fatal("failed to parse ServerHello")
+		}
+		hs := &clientHandshakeStateTLS13{
+			c:           uconn.Conn,
+			serverHello: sh,
+			hello:       uconn.HandshakeState.Hello.getPrivatePtr(),
+			suite:       cipherSuiteTLS13ByID(TLS_AES_128_GCM_SHA256),
+		}
+		if err := hs.utlsCheckECHAcceptance(); err != nil {
+			t.Fatalf("got error: %v; expected to succeed", err)
+		}
+		if ech.accepted != accept || ech.rejected == accept {
+			t.Errorf("confirmation %v: got accepted %v, rejected %v", accept, ech.accepted, ech.rejected)
+		}
+		if usesInner := bytes.Equal(hs.hello.raw, ech.innerHello); usesInner != accept {
+			t.Errorf("confirmation %v: transcript uses ClientHelloInner: %v", accept, usesInner)
+		}
+		if status := uconn.ECHState().Status; status != map[bool]ECHStatus{true: ECHStatusAccepted, false: ECHStatusRejected}[accept] {
+			t.Errorf("confirmation %v: got ECH status %v", accept, status)
+		}
+	}
+}
+
+func TestUTLSECHRejected(t *testing.T) {
+	configs, _ := testECHConfigs(t, "example.golang")
+	issuer, err := x509.ParseCertificate(testRSACertificateIssuer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(issuer)
+	clientConfig := &Config{
+		ServerName: "secret.example",
+		RootCAs:    roots,
+		Time:       func() time.Time { return time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC) },
+	}
+
+	c, s := localPipe(t)
+	go func() {
+		server := Server(s, testConfig.Clone())
+		server.Handshake()
+		server.Close()
+	}()
+
+	uconn := UClient(c, clientConfig, HelloChrome_120)
+	defer uconn.Close()
+	if err := uconn.SetECHConfigs(configs); err != nil {
+		t.Fatalf("got error: %v; expected to succeed", err)
+	}
+
+	// the server doesn't know about ECH, so the handshake completes with
+	// ClientHelloOuter and a certificate for the public name
+	err = uconn.Handshake()
+	var echErr *ECHRejectionError
+	if !errors.As(err, &echErr) {
+		t.Fatalf("got error: %v; expected ECHRejectionError", err)
+	}
+	if len(echErr.RetryConfigs) != 0 {
+		t.Errorf("got %d retry configs, expected none", len(echErr.RetryConfigs))
+	}
+	if uconn.ConnectionState().ECHAccepted {
+		t.Errorf("ECH is reported as accepted")
+	}
+	if state := uconn.ECHState(); state.Status != ECHStatusRejected || state.ConfigID != 42 || state.RetryConfigs != nil {
+		t.Errorf("got ECH state %+v, expected rejected with config_id 42 and no retry configs", state)
+	}
+}
+
+func TestUTLSECHState(t *testing.T) {
+	t.Run("GREASE", func(t *testing.T) {
+		c, s := localPipe(t)
+		go func() {
+			server := Server(s, testConfig)
+			server.Handshake()
+			server.Close()
+		}()
+
+		uconn := UClient(c, &Config{ServerName: "example.golang", InsecureSkipVerify: true}, HelloChrome_120)
+		defer uconn.Close()
+		if state := uconn.ECHState(); state.Status != ECHStatusNone {
+			t.Errorf("got ECH status %v before building the ClientHello, expected none", state.Status)
+		}
+		if err := uconn.Handshake(); err != nil {
+			t.Fatalf("handshake failed: %v", err)
+		}
+		var sent uint8
+		for _, ext := range uconn.Extensions {
+			if g, ok := ext.(*GREASEEncryptedClientHelloExtension); ok {
+				sent = g.configId
+			}
+		}
+		if state := uconn.ECHState(); state.Status != ECHStatusGREASE || state.ConfigID != sent {
+			t.Errorf("got ECH state %+v, expected GREASE with config_id %d", state, sent)
+		}
+	})
+
+	t.Run("no extension", func(t *testing.T) {
+		uconn := UClient(&net.TCPConn{}, &Config{ServerName: "example.com"}, HelloChrome_102)
+		if err := uconn.BuildHandshakeState(); err != nil {
+			t.Fatalf("got error: %v; expected to succeed", err)
+		}
+		if state := uconn.ECHState(); state.Status != ECHStatusNone {
+			t.Errorf("got ECH status %v without an ECH extension, expected none", state.Status)
+		}
+	})
+
+	t.Run("retry configs", func(t *testing.T) {
+		configs, _ := testECHConfigs(t, "public.example")
+		retry, _ := testECHConfigs(t, "public.example")
+		uconn := UClient(&net.TCPConn{}, &Config{ServerName: "secret.example"}, HelloChrome_120)
+		if err := uconn.SetECHConfigs(configs); err != nil {
+			t.Fatalf("got error: %v; expected to succeed", err)
+		}
+		if err := uconn.BuildHandshakeState(); err != nil {
+			t.Fatalf("got error: %v; expected to succeed", err)
+		}
+		if state := uconn.ECHState(); state.Status != ECHStatusOffered || state.ConfigID != 42 {
+			t.Errorf("got ECH state %+v, expected offered with config_id 42", state)
+		}
+
+		var b cryptobyte.Builder
+		b.AddUint8(typeEncryptedExtensions)
+		b.AddUint24LengthPrefixed(func(b *cryptobyte.Builder) {
+			b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+				b.AddUint16(utlsExtensionECH)
+				b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+					b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) { b.AddBytes(retry[0].raw) })
+				})
+			})
+		})
+		ee := new(encryptedExtensionsMsg)
+		if !ee.unmarshal(b.BytesOrPanic()) {
+			t.Fatal("failed to parse EncryptedExtensions")
+		}
+		uconn.vers = VersionTLS13
+		hs := &clientHandshakeStateTLS13{c: uconn.Conn, uconn: uconn}
+		if err := hs.utlsReadServerParameters(ee); err != nil {
+			t.Fatalf("got error: %v; expected to succeed", err)
+		}
+		uconn.utls.ech.rejected = true
+
+		state := uconn.ECHState()
+		if state.Status != ECHStatusRejected {
+			t.Errorf("got ECH status %v, expected rejected", state.Status)
+		}
+		parsed, err := UnmarshalECHConfigs(state.RetryConfigs)
+		if err != nil || len(parsed) != 1 || !bytes.Equal(parsed[0].raw, retry[0].raw) {
+			t.Errorf("got retry configs %x (%v), expected the config sent by the server", state.RetryConfigs, err)
+		}
+	})
+}
+
+func TestUTLSCurrentSpec(t *testing.T) {
+	uconn := UClient(&net.TCPConn{}, &Config{ServerName: "foobar"}, HelloChrome_120)
+	if err := uconn.BuildHandshakeState(); err != nil {
+		t.Fatalf("got error: %v; expected to succeed", err)
+	}
+	generic := &GenericExtension{Id: 0xfeed, Data: []byte{1, 2, 3}}
+	uconn.Extensions = append(uconn.Extensions[:len(uconn.Extensions)-1], generic, uconn.Extensions[len(uconn.Extensions)-1])
+	var alpn *ALPNExtension
+	for _, ext := range uconn.Extensions {
+		if e, ok := ext.(*ALPNExtension); ok {
+			alpn = e
+			alpn.AlpnProtocols = []string{"http/1.1", "h2"}
+		}
+	}
+
+	spec, err := uconn.CurrentSpec()
+	if err != nil {
+		t.Fatalf("got error: %v; expected to succeed", err)
+	}
+	var order []uint16
+	for _, ext := range uconn.Extensions {
+		id, _ := extensionIDOf(ext)
+		order = append(order, unGREASEUint16(id))
+	}
+
+	// later changes to the UConn don't affect the spec
+	generic.Data[0] = 0xff
+	alpn.AlpnProtocols[0] = "h3"
+
+	other := UClient(&net.TCPConn{}, &Config{ServerName: "example.com"}, HelloCustom)
+	if err := other.ApplyPreset(&spec); err != nil {
+		t.Fatalf("got error: %v; expected to succeed", err)
+	}
+	if err := other.BuildHandshakeState(); err != nil {
+		t.Fatalf("got error: %v; expected to succeed", err)
+	}
+
+	var otherOrder []uint16
+	for _, ext := range other.Extensions {
+		id, _ := extensionIDOf(ext)
+		otherOrder = append(otherOrder, unGREASEUint16(id))
+		switch e := ext.(type) {
+		case *GenericExtension:
+			if !bytes.Equal(e.Data, []byte{1, 2, 3}) {
+				t.Errorf("GenericExtension data is %v, expected [1 2 3]", e.Data)
+			}
+		case *ALPNExtension:
+			if !sliceEq(e.AlpnProtocols, []string{"http/1.1", "h2"}) {
+				t.Errorf("ALPN is %v, expected [http/1.1 h2]", e.AlpnProtocols)
+			}
+		case *SNIExtension:
+			if e.ServerName != "example.com" {
+				t.Errorf("SNI is %q, expected example.com", e.ServerName)
+			}
+		case *KeyShareExtension:
+			if bytes.Equal(e.KeyShares[1].Data, uconn.HandshakeState.Hello.KeyShares[1].Data) {
+				t.Errorf("key share is copied from the original connection")
+			}
+		}
+	}
+	if !sliceEq(order, otherOrder) {
+		t.Errorf("got extension order %v, expected %v", otherOrder, order)
+	}
+	if expected := mustSpec(t, HelloChrome_120).CipherSuites; !sliceEq(spec.CipherSuites, expected) {
+		t.Errorf("got cipher suites %v, expected %v", spec.CipherSuites, expected)
+	}
+}
+
+func TestUTLSReorderExtensions(t *testing.T) {
+	uconn := UClient(&net.TCPConn{}, &Config{ServerName: "foobar"}, HelloChrome_120)
+	if err := uconn.BuildHandshakeState(); err != nil {
+		t.Fatalf("got error: %v; expected to succeed", err)
+	}
+
+	// move SNI to the front, GREASE extensions keep their relative order
+	var order []uint16
+	var greases []TLSExtension
+	for _, ext := range uconn.Extensions {
+		id, _ := extensionIDOf(ext)
+		if isGREASEUint16(id) {
+			greases = append(greases, ext)
+		}
+		if id != extensionServerName {
+			order = append(order, id)
+		}
+	}
+	order = append([]uint16{extensionServerName}, order...)
+	if err := uconn.ReorderExtensions(order); err != nil {
+		t.Fatalf("got error: %v; expected to succeed", err)
+	}
+
+	var got []uint16
+	var gotGreases []TLSExtension
+	for _, ext := range uconn.Extensions {
+		id, _ := extensionIDOf(ext)
+		got = append(got, id)
+		if isGREASEUint16(id) {
+			gotGreases = append(gotGreases, ext)
+		}
+	}
+	if !sliceEq(got, order) {
+		t.Errorf("got extension order %v, expected %v", got, order)
+	}
+	if len(gotGreases) != 2 || gotGreases[0] != greases[0] || gotGreases[1] != greases[1] {
+		t.Errorf("GREASE extensions were not matched by position")
+	}
+
+	// the ClientHello is marshaled again
+	exts := uconn.HandshakeState.Hello.Raw[39+len(uconn.HandshakeState.Hello.SessionId):]
+	exts = exts[2+int(exts[0])<<8|int(exts[1]):]
+	if exts = exts[1+int(exts[0])+2:]; exts[0] != 0 || exts[1] != 0 {
+		t.Errorf("ClientHello starts with extension %x, expected server_name", exts[:2])
+	}
+
+	for _, order := range [][]uint16{
+		order[1:],
+		append([]uint16{extensionServerName}, order[:len(order)-1]...),
+		append(order[1:], 0xfeed),
+	} {
+		if err := uconn.ReorderExtensions(order); err == nil {
+			t.Errorf("ReorderExtensions(%v): expected error", order)
+		}
+	}
+}
+
+// recordSizeConn records the length of the records written to it, assuming
+// each Write holds exactly one record.
+type recordSizeConn struct {
+	net.Conn
+	sizes []int
+}
+
+func (c *recordSizeConn) Write(b []byte) (int, error) {
+	if len(b) >= recordHeaderLen {
+		c.sizes = append(c.sizes, int(b[3])<<8|int(b[4]))
+	}
+	return c.Conn.Write(b)
+}
+
+func TestUTLSSetRecordSizeLimit(t *testing.T) {
+	for _, limit := range []uint16{0, 63, 16386} {
+		uconn := UClient(&net.TCPConn{}, &Config{ServerName: "foobar"}, HelloChrome_120)
+		if err := uconn.SetRecordSizeLimit(limit); err == nil {
+			t.Errorf("SetRecordSizeLimit(%d): expected error", limit)
+		}
+	}
+
+	// the extension is added before padding, or updated if present
+	for _, id := range []ClientHelloID{HelloChrome_120, HelloFirefox_120} {
+		uconn := UClient(&net.TCPConn{}, &Config{ServerName: "foobar"}, id)
+		if err := uconn.SetRecordSizeLimit(1024); err != nil {
+			t.Fatalf("got error: %v; expected to succeed", err)
+		}
+		if err := uconn.BuildHandshakeState(); err != nil {
+			t.Fatalf("got error: %v; expected to succeed", err)
+		}
+		var found []uint16
+		for _, ext := range uconn.Extensions {
+			switch e := ext.(type) {
+			case *FakeRecordSizeLimitExtension:
+				found = append(found, e.Limit)
+			case *UtlsPaddingExtension:
+				if len(found) == 0 {
+					t.Errorf("%s: record_size_limit is added after padding", id.Str())
+				}
+			}
+		}
+		if !sliceEq(found, []uint16{1024}) {
+			t.Errorf("%s: got record_size_limit extensions %v, expected [1024]", id.Str(), found)
+		}
+		if !bytes.Contains(uconn.HandshakeState.Hello.Raw, []byte{0x00, 0x1c, 0x00, 0x02, 0x04, 0x00}) {
+			t.Errorf("%s: record_size_limit of 1024 is not sent", id.Str())
+		}
+	}
+
+	defer func() { testingOnlyRecordSizeLimit = 0 }()
+	handshake := func(t *testing.T, version uint16, clientLimit uint16) (*UConn, *recordSizeConn, chan error, error) {
+		t.Helper()
+		c, s := localPipe(t)
+		serverConfig := testConfig.Clone()
+		serverConfig.MaxVersion = version
+		done := make(chan error, 1)
+		go func() {
+			server := Server(s, serverConfig)
+			defer server.Close()
+			_, err := io.Copy(io.Discard, server)
+			done <- err
+		}()
+		conn := &recordSizeConn{Conn: c}
+		uconn := UClient(conn, &Config{ServerName: "example.golang", InsecureSkipVerify: true}, HelloChrome_120)
+		if clientLimit != 0 {
+			if err := uconn.SetRecordSizeLimit(clientLimit); err != nil {
+				t.Fatalf("got error: %v; expected to succeed", err)
+			}
+		}
+		err := uconn.Handshake()
+		if err != nil {
+			uconn.Close()
+			<-done
+		}
+		return uconn, conn, done, err
+	}
+	// writeSizes returns the sizes of the records carrying 10000 bytes
+	writeSizes := func(t *testing.T, uconn *UConn, conn *recordSizeConn, done chan error) []int {
+		t.Helper()
+		conn.sizes = nil
+		if _, err := uconn.Write(make([]byte, 10000)); err != nil {
+			t.Fatal(err)
+		}
+		uconn.Close()
+		if err := <-done; err != nil {
+			t.Fatalf("server failed: %v", err)
+		}
+		return conn.sizes[:len(conn.sizes)-1] // without close_notify
+	}
+
+	for _, version := range []uint16{VersionTLS12, VersionTLS13} {
+		t.Run(VersionName(version), func(t *testing.T) {
+			// the limit isn't negotiated if the server doesn't send one
+			testingOnlyRecordSizeLimit = 0
+			uconn, conn, done, err := handshake(t, version, 1024)
+			if err != nil {
+				t.Fatalf("client handshake failed: %v", err)
+			}
+			if sizes := writeSizes(t, uconn, conn, done); slices.Max(sizes) <= 1024+24 {
+				t.Errorf("got records of %v bytes, expected the client's limit not to apply", sizes)
+			}
+
+			// the server's limit is used, not the one sent by the client
+			testingOnlyRecordSizeLimit = 1024
+			uconn, conn, done, err = handshake(t, version, 2048)
+			if err != nil {
+				t.Fatalf("client handshake failed: %v", err)
+			}
+			// 1024 bytes of data (with the ContentType in TLS 1.3) and at
+			// most 24 bytes of overhead
+			sizes := writeSizes(t, uconn, conn, done)
+			if len(sizes) < 10 {
+				t.Errorf("got %d records for 10000 bytes, expected at least 10", len(sizes))
+			}
+			for _, size := range sizes {
+				if size > 1024+24 {
+					t.Errorf("got record of %d bytes, expected at most %d", size, 1024+24)
+				}
+			}
+
+			testingOnlyRecordSizeLimit = 63
+			if _, _, _, err := handshake(t, version, 1024); err == nil || !strings.Contains(err.Error(), "less than 64") {
+				t.Errorf("got error %v, expected the limit to be rejected", err)
+			}
+			testingOnlyRecordSizeLimit = 1024
+			if _, _, _, err := handshake(t, version, 0); err == nil || !strings.Contains(err.Error(), "unsolicited record_size_limit") {
+				t.Errorf("got error %v, expected the extension to be unsolicited", err)
+			}
+		})
+	}
+}
+
+func TestUTLSSetSNI(t *testing.T) {
+	sniIndex := func(uconn *UConn) int {
+		for i, ext := range uconn.Extensions {
+			if _, ok := ext.(*SNIExtension); ok {
+				return i
+			}
+		}
+		return -1
+	}
+
+	// updated in place after the preset is applied
+	uconn := UClient(&net.TCPConn{}, &Config{ServerName: "example.com"}, HelloChrome_120)
+	if err := uconn.BuildHandshakeState(); err != nil {
+		t.Fatalf("got error: %v; expected to succeed", err)
+	}
+	before := sniIndex(uconn)
+	uconn.SetSNI("other.example")
+	if err := uconn.BuildHandshakeState(); err != nil {
+		t.Fatalf("got error: %v; expected to succeed", err)
+	}
+	if i := sniIndex(uconn); i != before || uconn.Extensions[i].(*SNIExtension).ServerName != "other.example" {
+		t.Errorf("got SNI extension %d, expected extension %d to be updated", i, before)
+	}
+	if uconn.config.ServerName != "other.example" || uconn.HandshakeState.Hello.ServerName != "other.example" {
+		t.Errorf("got server name %q in config and %q in hello", uconn.config.ServerName, uconn.HandshakeState.Hello.ServerName)
+	}
+	if !bytes.Contains(uconn.HandshakeState.Hello.Raw, []byte("other.example")) || bytes.Contains(uconn.HandshakeState.Hello.Raw, []byte("example.com")) {
+		t.Error("the ClientHello was not rebuilt with the new server name")
+	}
+
+	// literal IP addresses are used for verification, but not sent
+	uconn.SetSNI("192.0.2.1")
+	if err := uconn.BuildHandshakeState(); err != nil {
+		t.Fatalf("got error: %v; expected to succeed", err)
+	}
+	if uconn.config.ServerName != "192.0.2.1" || bytes.Contains(uconn.HandshakeState.Hello.Raw, []byte("other.example")) {
+		t.Errorf("got server name %q, and an SNI extension that is still sent", uconn.config.ServerName)
+	}
+
+	// inserted after the leading GREASE extension if the spec has none
+	uconn = UClient(&net.TCPConn{}, &Config{InsecureSkipVerify: true}, HelloCustom)
+	if err := uconn.ApplyPreset(&ClientHelloSpec{
+		CipherSuites: []uint16{GREASE_PLACEHOLDER, TLS_AES_128_GCM_SHA256},
+		Extensions: []TLSExtension{
+			&UtlsGREASEExtension{},
+			&SupportedVersionsExtension{Versions: []uint16{VersionTLS13}},
+			&KeyShareExtension{KeyShares: []KeyShare{{Group: X25519}}},
+		},
+	}); err != nil {
+		t.Fatalf("got error: %v; expected to succeed", err)
+	}
+	uconn.SetSNI("example.com")
+	if i := sniIndex(uconn); i != 1 || len(uconn.Extensions) != 4 {
+		t.Errorf("got SNI extension at %d of %d, expected 1 of 4", i, len(uconn.Extensions))
+	}
+	if err := uconn.BuildHandshakeState(); err != nil {
+		t.Fatalf("got error: %v; expected to succeed", err)
+	}
+	if !bytes.Contains(uconn.HandshakeState.Hello.Raw, []byte("example.com")) {
+		t.Error("the inserted SNI extension was not sent")
+	}
+
+	// SetSNI overrides an earlier RemoveSNIExtension
+	uconn = UClient(&net.TCPConn{}, &Config{ServerName: "example.com"}, HelloFirefox_120)
+	uconn.RemoveSNIExtension()
+	uconn.SetSNI("other.example")
+	if err := uconn.BuildHandshakeState(); err != nil {
+		t.Fatalf("got error: %v; expected to succeed", err)
+	}
+	if sniIndex(uconn) != 0 || !bytes.Contains(uconn.HandshakeState.Hello.Raw, []byte("other.example")) {
+		t.Error("got no SNI extension, expected SetSNI to add it back")
+	}
+}
+
+func TestUTLSHandshakeContextCancel(t *testing.T) {
+	c, s := localPipe(t)
+	defer s.Close()
+	uconn := UClient(c, &Config{ServerName: "example.com"}, HelloChrome_120)
+	if err := uconn.BuildHandshakeState(); err != nil {
+		t.Fatalf("got error: %v; expected to succeed", err)
+	}
+	sentHello := bytes.Clone(uconn.HandshakeState.Hello.Raw)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errc := make(chan error, 1)
+	go func() {
+		errc <- uconn.HandshakeContext(ctx)
+	}()
+
+	// the server reads the ClientHello and hangs
+	record := make([]byte, recordHeaderLen+len(sentHello))
+	if _, err := io.ReadFull(s, record); err != nil {
+		t.Fatalf("got error reading the ClientHello: %v", err)
+	}
+	if !bytes.Equal(record[recordHeaderLen:], sentHello) {
+		t.Error("the ClientHello sent is not the one built from the preset")
+	}
+	cancel()
+
+	select {
+	case err := <-errc:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("got error %v, expected context.Canceled", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("the handshake was not interrupted by the context")
+	}
+	if _, ok := uconn.HandshakeState.State13.KeySharesParams.GetEcdheKey(X25519); ok {
+		t.Error("the key share private keys were kept after the handshake failed")
+	}
+	if err := uconn.Handshake(); err == nil {
+		t.Error("got no error from a second handshake on the canceled connection")
+	}
+}
+
+func TestUTLSSetALPN(t *testing.T) {
+	uconn := UClient(&net.TCPConn{}, &Config{ServerName: "example.com"}, HelloChrome_131)
+	if err := uconn.SetALPN(nil); err == nil {
+		t.Fatal("got no error for an empty protocol list")
+	}
+	if err := uconn.BuildHandshakeState(); err != nil {
+		t.Fatalf("got error: %v; expected to succeed", err)
+	}
+	n := len(uconn.Extensions)
+
+	// h2 is still offered, so ALPS is kept
+	if err := uconn.SetALPN([]string{"h2"}); err != nil {
+		t.Fatalf("got error: %v; expected to succeed", err)
+	}
+	if err := uconn.BuildHandshakeState(); err != nil {
+		t.Fatalf("got error: %v; expected to succeed", err)
+	}
+	if len(uconn.Extensions) != n || !slices.Equal(uconn.config.NextProtos, []string{"h2"}) || !slices.Equal(uconn.HandshakeState.Hello.AlpnProtocols, []string{"h2"}) {
+		t.Errorf("got %d extensions and ALPN %q, expected %d and [h2]", len(uconn.Extensions), uconn.HandshakeState.Hello.AlpnProtocols, n)
+	}
+
+	// ALPS is dropped along with h2
+	if err := uconn.SetALPN([]string{"http/1.1"}); err != nil {
+		t.Fatalf("got error: %v; expected to succeed", err)
+	}
+	if err := uconn.BuildHandshakeState(); err != nil {
+		t.Fatalf("got error: %v; expected to succeed", err)
+	}
+	for _, ext := range uconn.Extensions {
+		switch e := ext.(type) {
+		case *ApplicationSettingsExtension:
+			t.Errorf("got ALPS for %q, expected it to be removed", e.SupportedProtocols)
+		case *ALPNExtension:
+			if !slices.Equal(e.AlpnProtocols, []string{"http/1.1"}) {
+				t.Errorf("got ALPN %q, expected [http/1.1]", e.AlpnProtocols)
+			}
+		}
+	}
+	if !bytes.Contains(uconn.HandshakeState.Hello.Raw, []byte("\x08http/1.1")) || !slices.Equal(uconn.HandshakeState.Hello.AlpnProtocols, []string{"http/1.1"}) {
+		t.Errorf("got ALPN %q in the ClientHello, expected [http/1.1]", uconn.HandshakeState.Hello.AlpnProtocols)
+	}
+
+	// inserted before the trailing padding if the spec has none
+	uconn = UClient(&net.TCPConn{}, &Config{ServerName: "example.com"}, HelloCustom)
+	if err := uconn.ApplyPreset(&ClientHelloSpec{
+		CipherSuites: []uint16{TLS_AES_128_GCM_SHA256},
+		Extensions: []TLSExtension{
+			&SNIExtension{},
+			&SupportedVersionsExtension{Versions: []uint16{VersionTLS13}},
+			&KeyShareExtension{KeyShares: []KeyShare{{Group: X25519}}},
+			&UtlsPaddingExtension{GetPaddingLen: BoringPaddingStyle},
+		},
+	}); err != nil {
+		t.Fatalf("got error: %v; expected to succeed", err)
+	}
+	if err := uconn.SetALPN([]string{"http/1.1"}); err != nil {
+		t.Fatalf("got error: %v; expected to succeed", err)
+	}
+	if _, ok := uconn.Extensions[3].(*ALPNExtension); !ok || len(uconn.Extensions) != 5 {
+		t.Errorf("got extension %T at position 3 of %d, expected ALPN before the padding", uconn.Extensions[3], len(uconn.Extensions))
+	}
+	if err := uconn.BuildHandshakeState(); err != nil {
+		t.Fatalf("got error: %v; expected to succeed", err)
+	}
+	if !bytes.Contains(uconn.HandshakeState.Hello.Raw, []byte("http/1.1")) {
+		t.Error("the inserted ALPN extension was not sent")
+	}
+}
+
+func TestUTLSSetSupportedCurves(t *testing.T) {
+	uconn := UClient(&net.TCPConn{}, &Config{ServerName: "example.com"}, HelloChrome_131)
+	if err := uconn.SetSupportedCurves([]CurveID{X25519}); err == nil {
+		t.Fatal("got no error before the ClientHello is built")
+	}
+	if err := uconn.BuildHandshakeState(); err != nil {
+		t.Fatalf("got error: %v; expected to succeed", err)
+	}
+	grease := CurveID(GetBoringGREASEValue(uconn.greaseSeed, ssl_grease_group))
+	check := func(curves, keyShares []CurveID) {
+		t.Helper()
+		var groups []CurveID
+		for _, ks := range uconn.HandshakeState.Hello.KeyShares {
+			groups = append(groups, ks.Group)
+		}
+		if !slices.Equal(uconn.HandshakeState.Hello.SupportedCurves, curves) || !slices.Equal(groups, keyShares) {
+			t.Errorf("got groups %v and key shares %v, expected %v and %v", uconn.HandshakeState.Hello.SupportedCurves, groups, curves, keyShares)
+		}
+		spec, err := (&Fingerprinter{}).FingerprintClientHello(prependRecordHeader(uconn.HandshakeState.Hello.Raw, VersionTLS10))
+		if err != nil {
+			t.Fatalf("got error: %v; expected to succeed", err)
+		}
+		for _, ext := range spec.Extensions {
+			if e, ok := ext.(*SupportedCurvesExtension); ok && len(e.Curves) != len(curves) {
+				t.Errorf("got %v in the ClientHello, expected %v", e.Curves, curves)
+			}
+		}
+	}
+
+	// the X25519MLKEM768 key share is dropped along with its group
+	if err := uconn.SetSupportedCurves([]CurveID{GREASE_PLACEHOLDER, X25519, CurveP256}); err != nil {
+		t.Fatalf("got error: %v; expected to succeed", err)
+	}
+	check([]CurveID{grease, X25519, CurveP256}, []CurveID{grease, X25519})
+
+	// no key share would be left
+	if err := uconn.SetSupportedCurves([]CurveID{CurveP256, CurveP384}); err == nil {
+		t.Error("got no error for groups without key shares")
+	}
+	check([]CurveID{grease, X25519, CurveP256}, []CurveID{grease, X25519})
+
+	// the GREASE key share goes without the GREASE group
+	if err := uconn.SetSupportedCurves([]CurveID{CurveP384, X25519}); err != nil {
+		t.Fatalf("got error: %v; expected to succeed", err)
+	}
+	check([]CurveID{CurveP384, X25519}, []CurveID{X25519})
+
+	// the remaining key share still works
+	c, s := localPipe(t)
+	done := make(chan error, 1)
+	go func() {
+		server := Server(s, testConfig.Clone())
+		defer server.Close()
+		done <- server.Handshake()
+	}()
+	uconn.SetUnderlyingConn(c)
+	defer uconn.Close()
+	uconn.config.InsecureSkipVerify = true
+	if err := uconn.Handshake(); err != nil {
+		t.Fatalf("client handshake failed: %v", err)
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("server handshake failed: %v", err)
+	}
+
+	// HelloGolang uses the config
+	uconn = UClient(&net.TCPConn{}, &Config{ServerName: "example.com"}, HelloGolang)
+	if err := uconn.SetSupportedCurves([]CurveID{CurveP256}); err != nil || !slices.Equal(uconn.config.CurvePreferences, []CurveID{CurveP256}) {
+		t.Errorf("got CurvePreferences %v (error: %v), expected [P-256]", uconn.config.CurvePreferences, err)
+	}
+}
+
+func TestUTLSForceExtensionOrder(t *testing.T) {
+	newSpec := func(force bool) *ClientHelloSpec {
+		return &ClientHelloSpec{
+			CipherSuites: []uint16{TLS_AES_128_GCM_SHA256, TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256},
+			Extensions: []TLSExtension{
+				&FakePreSharedKeyExtension{
+					Identities: []PskIdentity{{Label: []byte("ticket"), ObfuscatedTicketAge: 1}},
+					Binders:    [][]byte{bytes.Repeat([]byte{1}, 32)},
+				},
+				&UtlsGREASEExtension{},
+				&UtlsPaddingExtension{PaddingLen: 8, WillPad: true},
+				&SupportedVersionsExtension{Versions: []uint16{VersionTLS13, VersionTLS12}},
+				&UtlsGREASEExtension{},
+				&KeyShareExtension{KeyShares: []KeyShare{{Group: X25519}}},
+				&SNIExtension{},
+				&UtlsGREASEExtension{},
+			},
+			ForceExtensionOrder: force,
+		}
+	}
+
+	uconn := UClient(&net.TCPConn{}, &Config{ServerName: "example.com"}, HelloCustom)
+	if err := uconn.ApplyPreset(newSpec(false)); err == nil {
+		t.Error("got no error applying a spec with three GREASE extensions")
+	}
+
+	uconn = UClient(&net.TCPConn{}, &Config{ServerName: "example.com"}, HelloCustom)
+	if err := uconn.ApplyPreset(newSpec(true)); err != nil {
+		t.Fatalf("got error: %v; expected to succeed", err)
+	}
+	if err := uconn.SetRecordSizeLimit(0x4001); err != nil {
+		t.Fatalf("got error: %v; expected to succeed", err)
+	}
+	if err := uconn.SetALPN([]string{"h2"}); err != nil {
+		t.Fatalf("got error: %v; expected to succeed", err)
+	}
+	if err := uconn.BuildHandshakeState(); err != nil {
+		t.Fatalf("got error: %v; expected to succeed", err)
+	}
+
+	exts := clientHelloRawExtensions(t, uconn.HandshakeState.Hello.Raw)
+	expected := []uint16{extensionPreSharedKey, 0x0a0a, utlsExtensionPadding, extensionSupportedVersions, 0x0a0a,
+		extensionKeyShare, extensionServerName, 0x0a0a, extensionALPN, fakeRecordSizeLimit}
+	if len(exts) != len(expected) {
+		t.Fatalf("got %d extensions, expected %d", len(exts), len(expected))
+	}
+	for i, ext := range exts {
+		if isGREASEUint16(expected[i]) {
+			if !isGREASEUint16(ext.extType) {
+				t.Errorf("extension %d is %d, expected GREASE", i, ext.extType)
+			}
+			continue
+		}
+		if ext.extType != expected[i] {
+			t.Errorf("extension %d is %d, expected %d", i, ext.extType, expected[i])
+		}
+	}
+	if exts[1].extType == exts[4].extType || exts[1].extType != exts[7].extType {
+		t.Errorf("got GREASE extensions %#x, %#x and %#x, expected the first value to be repeated", exts[1].extType, exts[4].extType, exts[7].extType)
+	}
+
+	if err := uconn.ReorderExtensions([]uint16{extensionServerName, extensionPreSharedKey, 0x0a0a, utlsExtensionPadding,
+		extensionSupportedVersions, 0x0a0a, extensionKeyShare, 0x0a0a, extensionALPN, fakeRecordSizeLimit}); err != nil {
+		t.Errorf("got error reordering pre_shared_key: %v; expected to succeed", err)
+	}
+}
+
+func TestUTLSSetGREASEValues(t *testing.T) {
+	build := func(values *GREASEValues) *UConn {
+		uconn := UClient(&net.TCPConn{}, &Config{ServerName: "example.com", Rand: zeroSource{}}, HelloCustom)
+		if values != nil {
+			if err := uconn.SetGREASEValues(*values); err != nil {
+				t.Fatalf("got error: %v; expected to succeed", err)
+			}
+		}
+		spec := &ClientHelloSpec{
+			CipherSuites: []uint16{GREASE_PLACEHOLDER, TLS_AES_128_GCM_SHA256},
+			Extensions: []TLSExtension{
+				&UtlsGREASEExtension{},
+				&SNIExtension{},
+				&SupportedCurvesExtension{Curves: []CurveID{GREASE_PLACEHOLDER, X25519}},
+				&KeyShareExtension{KeyShares: []KeyShare{{Group: GREASE_PLACEHOLDER, Data: []byte{0}}, {Group: X25519}}},
+				&SupportedVersionsExtension{Versions: []uint16{GREASE_PLACEHOLDER, VersionTLS13}},
+				&SignatureAlgorithmsExtension{SupportedSignatureAlgorithms: []SignatureScheme{GREASE_PLACEHOLDER, ECDSAWithP256AndSHA256}},
+				&UtlsGREASEExtension{},
+			},
+		}
+		if err := uconn.ApplyPreset(spec); err != nil {
+			t.Fatalf("got error: %v; expected to succeed", err)
+		}
+		if err := uconn.BuildHandshakeState(); err != nil {
+			t.Fatalf("got error: %v; expected to succeed", err)
+		}
+		return uconn
+	}
+	greaseValues := func(uconn *UConn) GREASEValues {
+		values := GREASEValues{Cipher: uconn.HandshakeState.Hello.CipherSuites[0]}
+		for _, ext := range uconn.Extensions {
+			switch e := ext.(type) {
+			case *UtlsGREASEExtension:
+				if values.Extension1 == 0 {
+					values.Extension1 = e.Value
+				} else {
+					values.Extension2 = e.Value
+				}
+			case *SupportedCurvesExtension:
+				values.Group = uint16(e.Curves[0])
+			case *KeyShareExtension:
+				if uint16(e.KeyShares[0].Group) != values.Group {
+					t.Errorf("got GREASE key share %#x, expected the GREASE group %#x", e.KeyShares[0].Group, values.Group)
+				}
+			case *SupportedVersionsExtension:
+				values.Version = e.Versions[0]
+			case *SignatureAlgorithmsExtension:
+				values.SigAlg = uint16(e.SupportedSignatureAlgorithms[0])
+			}
+		}
+		return values
+	}
+
+	random := build(nil)
+	if got := greaseValues(random); got.SigAlg != got.Cipher {
+		t.Errorf("got GREASE signature algorithm %#x, expected the cipher suite %#x", got.SigAlg, got.Cipher)
+	}
+
+	want := GREASEValues{Cipher: 0x1a1a, Extension1: 0x2a2a, Extension2: 0x3a3a, Group: 0x4a4a, Version: 0x5a5a, SigAlg: 0x6a6a}
+	pinned := build(&want)
+	if got := greaseValues(pinned); got != want {
+		t.Errorf("got GREASE values %#v, expected %#v", got, want)
+	}
+	// The rest of the ClientHello is drawn from Rand as before.
+	if !bytes.Equal(pinned.HandshakeState.Hello.Random, random.HandshakeState.Hello.Random) ||
+		!bytes.Equal(pinned.HandshakeState.State13.EcdheKey.Bytes(), random.HandshakeState.State13.EcdheKey.Bytes()) {
+		t.Error("pinning the GREASE values changed the random and key share of the ClientHello")
+	}
+
+	// zeroSource draws 0x0a0a for both extensions, the random one is changed.
+	partial := build(&GREASEValues{Extension2: 0x0a0a})
+	if got := greaseValues(partial); got.Extension2 != 0x0a0a || got.Extension1 == got.Extension2 {
+		t.Errorf("got GREASE extensions %#x and %#x, expected a random one and 0x0a0a", got.Extension1, got.Extension2)
+	}
+
+	for _, values := range []GREASEValues{{Cipher: 0x1a2a}, {Group: 0x0b0b}, {Extension1: 0x1a1a, Extension2: 0x1a1a}} {
+		uconn := UClient(&net.TCPConn{}, &Config{ServerName: "example.com"}, HelloChrome_120)
+		if err := uconn.SetGREASEValues(values); err == nil {
+			t.Errorf("got no error setting GREASE values %#v", values)
+		}
+	}
+	if err := pinned.SetGREASEValues(want); err == nil {
+		t.Error("got no error setting GREASE values after BuildHandshakeState")
+	}
+}
+
+// writeRecorder records everything written to the underlying net.Conn.
+type writeRecorder struct {
+	net.Conn
+	written bytes.Buffer
+}
+
+func (w *writeRecorder) Write(b []byte) (int, error) {
+	w.written.Write(b)
+	return w.Conn.Write(b)
+}
+
+func TestUTLSClientHelloCallback(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		curves []CurveID
+		hellos int
+	}{
+		{"single", nil, 1},
+		{"HelloRetryRequest", []CurveID{CurveP256}, 2},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			serverConfig := testConfig.Clone()
+			serverConfig.CurvePreferences = test.curves
+
+			c, s := localPipe(t)
+			go func() {
+				server := Server(s, serverConfig)
+				server.Handshake()
+				server.Close()
+			}()
+
+			conn := &writeRecorder{Conn: c}
+			uconn := UClient(conn, &Config{ServerName: "example.golang", InsecureSkipVerify: true}, HelloChrome_120)
+			defer uconn.Close()
+			var records [][]byte
+			uconn.SetClientHelloCallback(func(record []byte) {
+				if conn.written.Len() != 0 && len(records) == 0 {
+					t.Error("the callback ran after data was written")
+				}
+				records = append(records, bytes.Clone(record))
+			})
+			if err := uconn.Handshake(); err != nil {
+				t.Fatalf("got error: %v; expected to succeed", err)
+			}
+
+			if len(records) != test.hellos {
+				t.Fatalf("the callback ran %d times, expected %d", len(records), test.hellos)
+			}
+			if !bytes.HasPrefix(conn.written.Bytes(), records[0]) {
+				t.Error("the first ClientHello record is not the first thing written")
+			}
+			if !bytes.Equal(records[0][recordHeaderLen:], uconn.HandshakeState.Hello.Raw) && test.hellos == 1 {
+				t.Error("the ClientHello record doesn't hold the marshaled ClientHello")
+			}
+			for _, record := range records {
+				if !bytes.Contains(conn.written.Bytes(), record) {
+					t.Errorf("ClientHello record %x was not sent", record[:recordHeaderLen])
+				}
+			}
+		})
+	}
+}
+
+func TestUTLSSetRecordSplitPattern(t *testing.T) {
+	uconn := UClient(&net.TCPConn{}, &Config{ServerName: "example.golang"}, HelloChrome_120)
+	for _, offsets := range [][]int{{0}, {-1}, {10, 10}, {20, 10}} {
+		if err := uconn.SetRecordSplitPattern(offsets); err == nil {
+			t.Errorf("SetRecordSplitPattern(%v) succeeded, expected an error", offsets)
+		}
+	}
+
+	c, s := localPipe(t)
+	go func() {
+		server := Server(s, testConfig)
+		server.Handshake()
+		server.Close()
+	}()
+
+	conn := &writeRecorder{Conn: c}
+	uconn = UClient(conn, &Config{ServerName: "example.golang", InsecureSkipVerify: true}, HelloChrome_120)
+	defer uconn.Close()
+	// the last offset is past the end of the ClientHello
+	offsets := []int{1, 6, 100, 1 << 20}
+	if err := uconn.SetRecordSplitPattern(offsets); err != nil {
+		t.Fatalf("got error: %v; expected to succeed", err)
+	}
+	var callbackRecords []byte
+	uconn.SetClientHelloCallback(func(records []byte) { callbackRecords = bytes.Clone(records) })
+	if err := uconn.Handshake(); err != nil {
+		t.Fatalf("got error: %v; expected to succeed", err)
+	}
+
+	hello := uconn.HandshakeState.Hello.Raw
+	want := []int{1, 5, 94, len(hello) - 100}
+	written := conn.written.Bytes()
+	var got []int
+	var payload []byte
+	for len(payload) < len(hello) {
+		if len(written) < recordHeaderLen || recordType(written[0]) != recordTypeHandshake {
+			t.Fatalf("got %d of the %d bytes of the ClientHello before another record", len(payload), len(hello))
+		}
+		n := int(written[3])<<8 | int(written[4])
+		got = append(got, n)
+		payload = append(payload, written[recordHeaderLen:recordHeaderLen+n]...)
+		written = written[recordHeaderLen+n:]
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("got ClientHello records of %v bytes, expected %v", got, want)
+	}
+	if !bytes.Equal(payload, hello) {
+		t.Error("the ClientHello records don't hold the marshaled ClientHello")
+	}
+	if !bytes.HasPrefix(conn.written.Bytes(), callbackRecords) || len(callbackRecords) != len(conn.written.Bytes())-len(written) {
+		t.Error("the ClientHello callback got different records than were sent")
+	}
+}
+
+func TestUTLSSetCompressionMethods(t *testing.T) {
+	for _, methods := range [][]uint8{nil, {}, make([]uint8, 256)} {
+		uconn := UClient(&net.TCPConn{}, &Config{ServerName: "foobar"}, HelloChrome_120)
+		if err := uconn.SetCompressionMethods(methods); err == nil {
+			t.Errorf("SetCompressionMethods(%d methods): expected error", len(methods))
+		}
+	}
+
+	compressionMethods := func(t *testing.T, uconn *UConn) []uint8 {
+		t.Helper()
+		var m clientHelloMsg
+		if !m.unmarshal(uconn.HandshakeState.Hello.Raw) {
+			t.Fatal("failed to unmarshal the ClientHello")
+		}
+		return m.compressionMethods
+	}
+
+	// from the ClientHelloSpec
+	spec := mustSpec(t, HelloChrome_120)
+	spec.CompressionMethods = []uint8{1, compressionNone}
+	uconn := UClient(&net.TCPConn{}, &Config{ServerName: "foobar"}, HelloCustom)
+	if err := uconn.ApplyPreset(spec); err != nil {
+		t.Fatalf("got error: %v; expected to succeed", err)
+	}
+	if err := uconn.BuildHandshakeState(); err != nil {
+		t.Fatalf("got error: %v; expected to succeed", err)
+	}
+	if got := compressionMethods(t, uconn); !bytes.Equal(got, []uint8{1, compressionNone}) {
+		t.Errorf("got compression methods %v from the ClientHelloSpec, expected [1 0]", got)
+	}
+
+	// set after the ClientHello is built
+	if err := uconn.SetCompressionMethods([]uint8{compressionNone, 64}); err != nil {
+		t.Fatalf("got error: %v; expected to succeed", err)
+	}
+	if got := compressionMethods(t, uconn); !bytes.Equal(got, []uint8{compressionNone, 64}) {
+		t.Errorf("got compression methods %v after SetCompressionMethods, expected [0 64]", got)
+	}
+
+	// set before, the TLS 1.2 server picks no compression
+	c, s := localPipe(t)
+	done := make(chan error, 1)
+	go func() {
+		config := testConfig.Clone()
+		config.MaxVersion = VersionTLS12
+		server := Server(s, config)
+		defer server.Close()
+		done <- server.Handshake()
+	}()
+	uconn = UClient(c, &Config{ServerName: "example.golang", InsecureSkipVerify: true}, HelloChrome_120)
+	if err := uconn.SetCompressionMethods([]uint8{1, compressionNone}); err != nil {
+		t.Fatalf("got error: %v; expected to succeed", err)
+	}
+	if err := uconn.Handshake(); err != nil {
+		t.Fatalf("client handshake failed: %v", err)
+	}
+	if got := compressionMethods(t, uconn); !bytes.Equal(got, []uint8{1, compressionNone}) {
+		t.Errorf("got compression methods %v, expected [1 0]", got)
+	}
+	if err := <-done; err != nil {
+		t.Errorf("server handshake failed: %v", err)
+	}
+	uconn.Close()
+	if err := uconn.SetCompressionMethods([]uint8{compressionNone}); err == nil {
+		t.Error("SetCompressionMethods after the handshake: expected error")
+	}
+}
+
+func TestUTLSSNIExtensionBytes(t *testing.T) {
+	uconn := UClient(&net.TCPConn{}, &Config{ServerName: "example.com"}, HelloChrome_120)
+	if _, _, ok := uconn.SNIExtensionBytes(); ok {
+		t.Error("got a server_name extension before the ClientHello is built")
+	}
+	if err := uconn.BuildHandshakeState(); err != nil {
+		t.Fatalf("got error: %v; expected to succeed", err)
+	}
+	offset, data, ok := uconn.SNIExtensionBytes()
+	if !ok {
+		t.Fatal("got no server_name extension")
+	}
+	raw := uconn.HandshakeState.Hello.Raw
+	if offset+len(data) > len(raw) || !bytes.Equal(raw[offset:offset+len(data)], data) {
+		t.Fatalf("got %x at offset %d, which is not in the ClientHello", data, offset)
+	}
+	want := []byte{0x00, 0x00, 0x00, 0x10, 0x00, 0x0e, 0x00, 0x00, 0x0b}
+	want = append(want, "example.com"...)
+	if !bytes.Equal(data, want) {
+		t.Errorf("got server_name extension %x, expected %x", data, want)
+	}
+
+	uconn = UClient(&net.TCPConn{}, &Config{ServerName: "192.0.2.1", InsecureSkipVerify: true}, HelloChrome_120)
+	if err := uconn.BuildHandshakeState(); err != nil {
+		t.Fatalf("got error: %v; expected to succeed", err)
+	}
+	if _, data, ok := uconn.SNIExtensionBytes(); ok {
+		t.Errorf("got server_name extension %x for an IP address", data)
+	}
+}
+
+func TestUTLSExtensionsBytes(t *testing.T) {
+	uconn := UClient(&net.TCPConn{}, &Config{ServerName: "example.com"}, HelloChrome_120)
+	if _, err := uconn.ExtensionsBytes(); err == nil {
+		t.Error("got no error before the ClientHello is built")
+	}
+	if err := uconn.BuildHandshakeState(); err != nil {
+		t.Fatalf("got error: %v; expected to succeed", err)
+	}
+	block, err := uconn.ExtensionsBytes()
+	if err != nil {
+		t.Fatalf("got error: %v; expected to succeed", err)
+	}
+	raw := uconn.HandshakeState.Hello.Raw
+	if !bytes.HasSuffix(raw, block) || len(block) < 2 || int(block[0])<<8|int(block[1]) != len(block)-2 {
+		t.Fatalf("got extensions block %x, which doesn't end the ClientHello", block)
+	}
+	want := []byte{0, 0}
+	for _, ext := range clientHelloRawExtensions(t, raw) {
+		want = append(want, byte(ext.extType>>8), byte(ext.extType), byte(len(ext.data)>>8), byte(len(ext.data)))
+		want = append(want, ext.data...)
+	}
+	want[0], want[1] = byte((len(want)-2)>>8), byte(len(want)-2)
+	if !bytes.Equal(block, want) {
+		t.Errorf("got extensions block %x, expected %x", block, want)
+	}
+	block[2] ^= 0xff
+	if bytes.HasSuffix(raw, block) {
+		t.Error("the extensions block is not a copy")
+	}
+
+	uconn = UClient(&net.TCPConn{}, &Config{ServerName: "example.com"}, HelloCustom)
+	if err := uconn.ApplyPreset(&ClientHelloSpec{
+		CipherSuites: []uint16{TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256},
+		TLSVersMin:   VersionTLS12,
+		TLSVersMax:   VersionTLS12,
+	}); err != nil {
+		t.Fatalf("got error: %v; expected to succeed", err)
+	}
+	if err := uconn.BuildHandshakeState(); err != nil {
+		t.Fatalf("got error: %v; expected to succeed", err)
+	}
+	if block, err := uconn.ExtensionsBytes(); err != nil || block != nil {
+		t.Errorf("got extensions block %x (error: %v) for a ClientHello without extensions", block, err)
+	}
+}
+
+func TestUTLSSetSessionID(t *testing.T) {
+	sessionID := func(hello []byte) []byte {
+		var ch clientHelloMsg
+		if !ch.unmarshal(hello) {
+			t.Fatal("malformed ClientHello")
+		}
+		return ch.sessionId
+	}
+
+	uconn := UClient(&net.TCPConn{}, &Config{ServerName: "example.com", Rand: zeroSource{}}, HelloChrome_120)
+	if err := uconn.BuildHandshakeState(); err != nil {
+		t.Fatalf("got error: %v; expected to succeed", err)
+	}
+	if got := sessionID(uconn.HandshakeState.Hello.Raw); !bytes.Equal(got, make([]byte, 32)) {
+		t.Errorf("got session ID %x, expected 32 bytes from Config.Rand", got)
+	}
+	random := uconn.HandshakeState.Hello.Random
+
+	custom := bytes.Repeat([]byte{0x42}, 32)
+	if err := uconn.SetSessionID(custom); err != nil {
+		t.Fatalf("got error: %v; expected to succeed", err)
+	}
+	if got := sessionID(uconn.HandshakeState.Hello.Raw); !bytes.Equal(got, custom) {
+		t.Errorf("got session ID %x after BuildHandshakeState, expected %x", got, custom)
+	}
+	if err := uconn.SetSessionID(nil); err != nil {
+		t.Fatalf("got error: %v; expected to succeed", err)
+	}
+	if got := sessionID(uconn.HandshakeState.Hello.Raw); len(got) != 0 {
+		t.Errorf("got session ID %x, expected an empty one", got)
+	}
+	if err := uconn.SetSessionID(make([]byte, 33)); err == nil {
+		t.Error("got no error setting a 33-byte session ID")
+	}
+
+	for _, id := range []ClientHelloID{HelloChrome_120, HelloFirefox_120, HelloGolang} {
+		t.Run(id.Str(), func(t *testing.T) {
+			c, s := localPipe(t)
+			done := make(chan error, 1)
+			go func() {
+				server := Server(s, testConfig.Clone())
+				defer server.Close()
+				done <- server.Handshake()
+			}()
+			uconn := UClient(c, &Config{ServerName: "example.golang", InsecureSkipVerify: true, Rand: zeroSource{}}, id)
+			defer uconn.Close()
+			// before the ClientHello is built
+			if err := uconn.SetSessionID(custom); err != nil {
+				t.Fatalf("got error: %v; expected to succeed", err)
+			}
+			var sent []byte
+			uconn.SetClientHelloCallback(func(record []byte) { sent = record[recordHeaderLen:] })
+			if err := uconn.Handshake(); err != nil {
+				t.Fatalf("client handshake failed: %v", err)
+			}
+			if err := <-done; err != nil {
+				t.Fatalf("server handshake failed: %v", err)
+			}
+			if got := sessionID(sent); !bytes.Equal(got, custom) {
+				t.Errorf("sent session ID %x, expected %x", got, custom)
+			}
+			if id == HelloChrome_120 && !bytes.Equal(uconn.HandshakeState.Hello.Random, random) {
+				t.Error("setting the session ID changed the random of the ClientHello")
+			}
+		})
+	}
+}
+
+func TestUTLSKeyLogWriter(t *testing.T) {
+	tls13Labels := []string{keyLogLabelClientHandshake, keyLogLabelServerHandshake, keyLogLabelClientTraffic, keyLogLabelServerTraffic}
+	for _, test := range []struct {
+		name   string
+		id     ClientHelloID
+		server func(*Config)
+		labels []string
+	}{
+		{"TLS 1.3", HelloChrome_120, nil, tls13Labels},
+		{"HelloRetryRequest", HelloChrome_120, func(c *Config) { c.CurvePreferences = []CurveID{CurveP384} }, tls13Labels},
+		{"TLS 1.2", HelloFirefox_120, func(c *Config) { c.MaxVersion = VersionTLS12 }, []string{keyLogLabelTLS12}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			c, s := localPipe(t)
+			var clientKeyLog, serverKeyLog bytes.Buffer
+			serverConfig := testConfig.Clone()
+			serverConfig.KeyLogWriter = &serverKeyLog
+			if test.server != nil {
+				test.server(serverConfig)
+			}
+			done := make(chan error, 1)
+			go func() {
+				server := Server(s, serverConfig)
+				defer server.Close()
+				done <- server.Handshake()
+			}()
+			uconn := UClient(c, &Config{ServerName: "example.golang", InsecureSkipVerify: true, KeyLogWriter: &clientKeyLog}, test.id)
+			defer uconn.Close()
+			if err := uconn.Handshake(); err != nil {
+				t.Fatalf("client handshake failed: %v", err)
+			}
+			if err := <-done; err != nil {
+				t.Fatalf("server handshake failed: %v", err)
+			}
+
+			// NSS key log lines are "<label> <client random> <secret>", in hex
+			var labels []string
+			random := hex.EncodeToString(uconn.HandshakeState.Hello.Random)
+			for _, line := range strings.Split(strings.TrimSuffix(clientKeyLog.String(), "\n"), "\n") {
+				fields := strings.Fields(line)
+				if len(fields) != 3 || fields[1] != random {
+					t.Errorf("got key log line %q, expected a secret for the client random %s", line, random)
+					continue
+				}
+				if secret, err := hex.DecodeString(fields[2]); err != nil || len(secret) < 32 {
+					t.Errorf("got key log line %q, expected a secret", line)
+				}
+				labels = append(labels, fields[0])
+			}
+			if !slices.Equal(labels, test.labels) {
+				t.Errorf("got key log labels %v, expected %v", labels, test.labels)
+			}
+			if !bytes.Equal(clientKeyLog.Bytes(), serverKeyLog.Bytes()) {
+				t.Errorf("got client key log %q, expected the server's %q", clientKeyLog.String(), serverKeyLog.String())
+			}
+		})
+	}
+}
+
+func TestUTLSHandshakeTranscript(t *testing.T) {
+	for _, test := range []struct {
+		version uint16
+		types   []uint8
+	}{
+		{VersionTLS13, []uint8{typeClientHello, typeServerHello, typeEncryptedExtensions,
+			typeCertificate, typeCertificateVerify, typeFinished, typeFinished}},
+		{VersionTLS12, []uint8{typeClientHello, typeServerHello, typeCertificate, typeServerKeyExchange,
+			typeServerHelloDone, typeClientKeyExchange, typeFinished, typeNewSessionTicket, typeFinished}},
+	} {
+		c, s := localPipe(t)
+		done := make(chan error, 1)
+		go func() {
+			config := testConfig.Clone()
+			config.MaxVersion = test.version
+			// other tests may have stapled an OCSP response to the shared
+			// certificate, which would add a CertificateStatus message
+			cert := config.Certificates[0]
+			cert.OCSPStaple = nil
+			config.Certificates = []Certificate{cert}
+			server := Server(s, config)
+			defer server.Close()
+			done <- server.Handshake()
+		}()
+		uconn := UClient(c, &Config{ServerName: "example.golang", InsecureSkipVerify: true}, HelloChrome_120)
+		if err := uconn.EnableHandshakeTranscript(); err != nil {
+			t.Fatalf("TLS %x: got error: %v; expected to succeed", test.version, err)
+		}
+		if _, err := uconn.HandshakeTranscript(); err == nil {
+			t.Errorf("TLS %x: got a transcript before the handshake", test.version)
+		}
+		if err := uconn.Handshake(); err != nil {
+			t.Fatalf("TLS %x: client handshake failed: %v", test.version, err)
+		}
+		if err := <-done; err != nil {
+			t.Fatalf("TLS %x: server handshake failed: %v", test.version, err)
+		}
+		uconn.Close()
+		if err := uconn.EnableHandshakeTranscript(); err == nil {
+			t.Errorf("TLS %x: got no error enabling the transcript after the handshake", test.version)
+		}
+
+		transcript, err := uconn.HandshakeTranscript()
+		if err != nil {
+			t.Fatalf("TLS %x: got error: %v; expected to succeed", test.version, err)
+		}
+		if !bytes.HasPrefix(transcript, uconn.HandshakeState.Hello.Raw) {
+			t.Errorf("TLS %x: the transcript doesn't start with the ClientHello", test.version)
+		}
+		var types []uint8
+		for s := cryptobyte.String(transcript); !s.Empty(); {
+			var typ uint8
+			var body cryptobyte.String
+			if !s.ReadUint8(&typ) || !s.ReadUint24LengthPrefixed(&body) {
+				t.Fatalf("TLS %x: malformed transcript", test.version)
+			}
+			types = append(types, typ)
+		}
+		if !bytes.Equal(types, test.types) {
+			t.Errorf("TLS %x: got handshake messages %v, expected %v", test.version, types, test.types)
+		}
+	}
+
+	// the messages are not kept by default
+	c, s := localPipe(t)
+	go func() {
+		server := Server(s, testConfig)
+		defer server.Close()
+		server.Handshake()
+	}()
+	uconn := UClient(c, &Config{ServerName: "example.golang", InsecureSkipVerify: true}, HelloChrome_120)
+	defer uconn.Close()
+	if err := uconn.Handshake(); err != nil {
+		t.Fatalf("client handshake failed: %v", err)
+	}
+	if _, err := uconn.HandshakeTranscript(); err == nil {
+		t.Error("got a transcript without EnableHandshakeTranscript")
+	}
+	if uconn.utls.transcript != nil {
+		t.Errorf("kept %d bytes of handshake messages without EnableHandshakeTranscript", len(uconn.utls.transcript))
+	}
+}
+
+func TestUTLSExportKeyingMaterial(t *testing.T) {
+	noEMS := mustSpec(t, HelloChrome_120)
+	noEMS.Extensions = slices.DeleteFunc(noEMS.Extensions, func(ext TLSExtension) bool {
+		_, ok := ext.(*ExtendedMasterSecretExtension)
+		return ok
+	})
+	for _, test := range []struct {
+		name    string
+		version uint16
+		spec    *ClientHelloSpec
+	}{
+		{"TLS 1.3", VersionTLS13, nil},
+		{"TLS 1.2", VersionTLS12, nil},
+		{"TLS 1.2 without EMS", VersionTLS12, noEMS},
+	} {
+		c, s := localPipe(t)
+		serverConfig := testConfig.Clone()
+		serverConfig.MaxVersion = test.version
+		server := Server(s, serverConfig)
+		done := make(chan error, 1)
+		go func() {
+			done <- server.Handshake()
+		}()
+		config := &Config{ServerName: "example.golang", InsecureSkipVerify: true}
+		uconn := UClient(c, config, HelloChrome_120)
+		if test.spec != nil {
+			uconn = UClient(c, config, HelloCustom)
+			if err := uconn.ApplyPreset(test.spec); err != nil {
+				t.Fatalf("%s: got error: %v; expected to succeed", test.name, err)
+			}
+		}
+		if _, err := uconn.ExportKeyingMaterial("EXPORTER-test", nil, 32); err == nil {
+			t.Errorf("%s: got keying material before the handshake", test.name)
+		}
+		if err := uconn.Handshake(); err != nil {
+			t.Fatalf("%s: client handshake failed: %v", test.name, err)
+		}
+		if err := <-done; err != nil {
+			t.Fatalf("%s: server handshake failed: %v", test.name, err)
+		}
+
+		got, err := uconn.ExportKeyingMaterial("EXPORTER-test", []byte("context"), 32)
+		if test.spec != nil {
+			if err == nil {
+				t.Errorf("%s: got keying material without the extended master secret", test.name)
+			}
+		} else {
+			state := server.ConnectionState()
+			want, _ := state.ExportKeyingMaterial("EXPORTER-test", []byte("context"), 32)
+			if err != nil || len(got) != 32 || !bytes.Equal(got, want) {
+				t.Errorf("%s: got keying material %x (error: %v), expected the server's %x", test.name, got, err, want)
+			}
+		}
+		uconn.Close()
+		server.Close()
+	}
+}
+
+func TestUTLSSetTargetHelloLength(t *testing.T) {
+	for _, id := range []ClientHelloID{HelloChrome_120, HelloFirefox_120} {
+		t.Run(id.Str(), func(t *testing.T) {
+			c, s := localPipe(t)
+			go func() {
+				server := Server(s, testConfig)
+				server.Handshake()
+				server.Close()
+			}()
+
+			uconn := UClient(c, &Config{ServerName: "example.golang", InsecureSkipVerify: true}, id)
+			defer uconn.Close()
+			if err := uconn.SetTargetHelloLength(1000); err == nil {
+				t.Error("SetTargetHelloLength before BuildHandshakeState succeeded, expected an error")
+			}
+			if err := uconn.BuildHandshakeState(); err != nil {
+				t.Fatalf("got error: %v; expected to succeed", err)
+			}
+			// neither hello is padded by its spec at this length
+			unpadded := bytes.Clone(uconn.HandshakeState.Hello.Raw)
+
+			for _, n := range []int{len(unpadded) - 1, len(unpadded) + 3} {
+				if err := uconn.SetTargetHelloLength(n); err == nil {
+					t.Errorf("padding a %d byte ClientHello to %d succeeded, expected an error", len(unpadded), n)
+				}
+				if !bytes.Equal(uconn.HandshakeState.Hello.Raw, unpadded) {
+					t.Errorf("the ClientHello changed after failing to pad it to %d", n)
+				}
+			}
+			for _, n := range []int{len(unpadded), len(unpadded) + 4, 1500} {
+				if err := uconn.SetTargetHelloLength(n); err != nil {
+					t.Fatalf("got error: %v; expected to succeed", err)
+				}
+				if l := len(uconn.HandshakeState.Hello.Raw); l != n {
+					t.Errorf("got a %d byte ClientHello, expected %d", l, n)
+				}
+			}
+
+			if err := uconn.Handshake(); err != nil {
+				t.Fatalf("handshake with the padded ClientHello failed: %v", err)
+			}
+		})
+	}
+}
+
+func TestUTLSRemoveExtension(t *testing.T) {
+	handshake := func(t *testing.T, remove uint16) (*UConn, error) {
+		c, s := localPipe(t)
+		go func() {
+			server := Server(s, testConfig)
+			server.Handshake()
+			server.Close()
+		}()
+
+		uconn := UClient(c, &Config{ServerName: "example.golang", InsecureSkipVerify: true}, HelloChrome_120)
+		t.Cleanup(func() { uconn.Close() })
+		if err := uconn.BuildHandshakeState(); err != nil {
+			t.Fatalf("got error: %v; expected to succeed", err)
+		}
+		if !uconn.RemoveExtension(remove) {
+			t.Fatalf("RemoveExtension(%d) removed nothing", remove)
+		}
+		if uconn.RemoveExtension(remove) {
+			t.Errorf("RemoveExtension(%d) removed extensions twice", remove)
+		}
+		return uconn, uconn.Handshake()
+	}
+
+	t.Run("GREASE", func(t *testing.T) {
+		uconn, err := handshake(t, GREASE_PLACEHOLDER)
+		if err != nil {
+			t.Fatalf("handshake failed: %v", err)
+		}
+		record := append([]byte{byte(recordTypeHandshake), 3, 1, byte(len(uconn.HandshakeState.Hello.Raw) >> 8), byte(len(uconn.HandshakeState.Hello.Raw))}, uconn.HandshakeState.Hello.Raw...)
+		spec, err := (&Fingerprinter{}).FingerprintClientHello(record)
+		if err != nil {
+			t.Fatalf("got error: %v; expected to succeed", err)
+		}
+		for _, ext := range spec.Extensions {
+			if id, _ := extensionIDOf(ext); isGREASEUint16(id) {
+				t.Errorf("the ClientHello that was sent has GREASE extension %x", id)
+			}
+		}
+	})
+
+	t.Run("supported_versions", func(t *testing.T) {
+		uconn, err := handshake(t, extensionSupportedVersions)
+		if err != nil {
+			t.Fatalf("handshake failed: %v", err)
+		}
+		if v := uconn.ConnectionState().Version; v != VersionTLS12 {
+			t.Errorf("negotiated %s, expected TLS 1.2", VersionName(v))
+		}
+	})
+
+	t.Run("key_share", func(t *testing.T) {
+		if _, err := handshake(t, extensionKeyShare); err == nil || !strings.Contains(err.Error(), "key_share") {
+			t.Errorf("got error %v, expected it to mention key_share", err)
+		}
+	})
+}
+
+func TestUTLSVisitExtensions(t *testing.T) {
+	c, s := localPipe(t)
+	done := make(chan error, 1)
+	go func() {
+		server := Server(s, testConfig.Clone())
+		defer server.Close()
+		done <- server.Handshake()
+	}()
+	uconn := UClient(c, &Config{ServerName: "example.golang", InsecureSkipVerify: true}, HelloChrome_120)
+	defer uconn.Close()
+	if err := uconn.BuildHandshakeState(); err != nil {
+		t.Fatalf("got error: %v; expected to succeed", err)
+	}
+	before := uconn.HandshakeState.Hello.Raw
+	n := len(uconn.Extensions)
+
+	// swap ALPN and drop signed_certificate_timestamp
+	if err := uconn.VisitExtensions(func(ext TLSExtension) TLSExtension {
+		switch ext.(type) {
+		case *ALPNExtension:
+			return &ALPNExtension{AlpnProtocols: []string{"http/1.1"}}
+		case *SCTExtension:
+			return nil
+		}
+		return ext
+	}); err != nil {
+		t.Fatalf("got error: %v; expected to succeed", err)
+	}
+	after := uconn.HandshakeState.Hello.Raw
+	if bytes.Equal(before, after) || len(uconn.Extensions) != n-1 {
+		t.Fatalf("got %d extensions and an unchanged ClientHello %v, expected %d and a new one", len(uconn.Extensions), bytes.Equal(before, after), n-1)
+	}
+	for _, ext := range clientHelloRawExtensions(t, after) {
+		switch ext.extType {
+		case extensionALPN:
+			if !bytes.Equal(ext.data, []byte("\x00\x09\x08http/1.1")) {
+				t.Errorf("got ALPN %x, expected http/1.1 only", ext.data)
+			}
+		case extensionSCT:
+			t.Error("got a signed_certificate_timestamp extension, expected it to be dropped")
+		}
+	}
+
+	if err := uconn.Handshake(); err != nil {
+		t.Fatalf("client handshake failed: %v", err)
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("server handshake failed: %v", err)
+	}
+	if proto := uconn.ConnectionState().NegotiatedProtocol; proto != "" {
+		t.Errorf("negotiated %q, expected no protocol", proto)
+	}
+
+	// the ClientHello can't be built without key_share
+	uconn = UClient(&net.TCPConn{}, &Config{ServerName: "example.golang"}, HelloChrome_120)
+	if err := uconn.BuildHandshakeState(); err != nil {
+		t.Fatalf("got error: %v; expected to succeed", err)
+	}
+	if err := uconn.VisitExtensions(func(ext TLSExtension) TLSExtension {
+		if _, ok := ext.(*KeyShareExtension); ok {
+			return nil
+		}
+		return ext
+	}); err == nil || !strings.Contains(err.Error(), "key_share") {
+		t.Errorf("got error %v, expected key_share to be missing", err)
+	}
+
+	// extensions that can't be compared can be returned as they are
+	uconn = UClient(&net.TCPConn{}, &Config{ServerName: "example.golang"}, HelloChrome_120)
+	if err := uconn.BuildHandshakeState(); err != nil {
+		t.Fatalf("got error: %v; expected to succeed", err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := uconn.VisitExtensions(func(ext TLSExtension) TLSExtension {
+			if _, ok := ext.(*SCTExtension); ok {
+				return valueExtension{&GenericExtension{Id: 0xfe7f, Data: []byte{1}}, []string{"a"}}
+			}
+			return ext
+		}); err != nil {
+			t.Fatalf("got error: %v; expected to succeed", err)
+		}
+	}
+	if !slices.ContainsFunc(clientHelloRawExtensions(t, uconn.HandshakeState.Hello.Raw), func(ext rawExtension) bool {
+		return ext.extType == 0xfe7f
+	}) {
+		t.Error("the ClientHello is missing the extension that replaced signed_certificate_timestamp")
+	}
+
+	// a replaced PSK extension is the one used for resumption
+	uconn = UClient(&net.TCPConn{}, &Config{ServerName: "example.golang", ClientSessionCache: NewLRUClientSessionCache(1)}, HelloCustom)
+	if err := uconn.ApplyPreset(mustSpec(t, HelloChrome_100_PSK)); err != nil {
+		t.Fatalf("got error: %v; expected to succeed", err)
+	}
+	psk := &UtlsPreSharedKeyExtension{}
+	if err := uconn.VisitExtensions(func(ext TLSExtension) TLSExtension {
+		if _, ok := ext.(PreSharedKeyExtension); ok {
+			return psk
+		}
+		return ext
+	}); err != nil {
+		t.Fatalf("got error: %v; expected to succeed", err)
+	}
+	if uconn.sessionController.pskExtension != psk {
+		t.Errorf("the session controller has the PSK extension %v, expected the new one", uconn.sessionController.pskExtension)
+	}
+}
+
+// valueExtension is a TLSExtension that is not a pointer, and can't be
+// compared because it holds a slice.
+type valueExtension struct {
+	*GenericExtension
+	tags []string
+}
+
+func TestUTLSWithoutExtension(t *testing.T) {
+	spec := mustSpec(t, HelloChrome_120)
+	padded := spec.WithoutExtension(utlsExtensionPadding)
+	if len(padded.Extensions) != len(spec.Extensions) {
+		t.Errorf("removing padding, which Chrome 120 doesn't send, changed the extensions")
+	}
+
+	without := spec.WithoutExtension(extensionALPN)
+	if len(without.Extensions) != len(spec.Extensions)-1 {
+		t.Errorf("got %d extensions, expected %d", len(without.Extensions), len(spec.Extensions)-1)
+	}
+	for _, ext := range without.Extensions {
+		if _, ok := ext.(*ALPNExtension); ok {
+			t.Error("ALPN extension was not removed")
+		}
+	}
+
+	without = spec.WithoutExtension(extensionSupportedVersions)
+	uconn := UClient(&net.TCPConn{}, &Config{ServerName: "example.com"}, HelloCustom)
+	if err := uconn.ApplyPreset(&without); err != nil {
+		t.Fatalf("got error: %v; expected to succeed", err)
+	}
+	if uconn.config.MaxVersion != VersionTLS12 {
+		t.Errorf("spec without supported_versions offers up to %s, expected TLS 1.2", VersionName(uconn.config.MaxVersion))
+	}
+
+	without = spec.WithoutExtension(extensionKeyShare)
+	uconn = UClient(&net.TCPConn{}, &Config{ServerName: "example.com"}, HelloCustom)
+	if err := uconn.ApplyPreset(&without); err == nil || !strings.Contains(err.Error(), "key_share") {
+		t.Errorf("got error %v applying a TLS 1.3 spec without key_share, expected it to mention key_share", err)
+	}
+}
+
+func TestUTLSForceTLS12(t *testing.T) {
+	for _, id := range []ClientHelloID{HelloChrome_131, HelloFirefox_133, HelloSafari_18} {
+		t.Run(id.Str(), func(t *testing.T) {
+			spec := mustSpec(t, id)
+			ja3 := spec.JA3()
+			forced := spec.ForceTLS12()
+			if forced.TLSVersMin < VersionTLS10 || forced.TLSVersMin > VersionTLS12 || forced.TLSVersMax != VersionTLS12 {
+				t.Errorf("got versions %s to %s, expected up to TLS 1.2", VersionName(forced.TLSVersMin), VersionName(forced.TLSVersMax))
+			}
+			for _, suite := range forced.CipherSuites {
+				if cipherSuiteTLS13ByID(suite) != nil {
+					t.Errorf("got TLS 1.3 cipher suite %s", CipherSuiteName(suite))
+				}
+			}
+			if errs := forced.Validate(); len(errs) != 0 {
+				t.Errorf("got invalid spec: %v", errs)
+			}
+			if spec.JA3() != ja3 {
+				t.Error("the original spec was modified")
+			}
+
+			c, s := localPipe(t)
+			serverConfig := testConfig.Clone()
+			serverConfig.MaxVersion = VersionTLS12
+			done := make(chan error, 1)
+			go func() {
+				server := Server(s, serverConfig)
+				defer server.Close()
+				done <- server.Handshake()
+			}()
+			uconn := UClient(c, &Config{ServerName: "example.golang", InsecureSkipVerify: true}, HelloCustom)
+			defer uconn.Close()
+			if err := uconn.ApplyPreset(&forced); err != nil {
+				t.Fatalf("got error: %v; expected to succeed", err)
+			}
+			if err := uconn.Handshake(); err != nil {
+				t.Fatalf("client handshake failed: %v", err)
+			}
+			if err := <-done; err != nil {
+				t.Fatalf("server handshake failed: %v", err)
+			}
+			if vers := uconn.ConnectionState().Version; vers != VersionTLS12 {
+				t.Errorf("negotiated %s, expected TLS 1.2", VersionName(vers))
+			}
+			for _, ext := range clientHelloRawExtensions(t, uconn.HandshakeState.Hello.Raw) {
+				if slices.Contains(tls13OnlyExtensions, ext.extType) {
+					t.Errorf("got TLS 1.3 extension %d in the ClientHello", ext.extType)
+				}
+				if ext.extType == extensionSupportedCurves {
+					var curves SupportedCurvesExtension
+					if _, err := curves.Write(ext.data); err != nil {
+						t.Fatal(err)
+					}
+					for _, curve := range curves.Curves {
+						if curveIdToCirclScheme(curve) != nil {
+							t.Errorf("got post-quantum group %v in the ClientHello", curve)
+						}
+					}
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,126 @@
+// Copyright 2024 The uTLS Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tls
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"golang.org/x/crypto/cryptobyte"
+)
+
+// oidDelegationUsage is the DelegationUsage certificate extension, which
+// allows the key of the certificate to sign delegated credentials.
+// See RFC 9345, Section 4.2.
+var oidDelegationUsage = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 44363, 44}
+
+// maxDelegatedCredentialValidity is the longest a delegated credential may
+// remain valid, see RFC 9345, Section 4.1.3.
+const maxDelegatedCredentialValidity = 7 * 24 * time.Hour
+
+const delegatedCredentialSignatureContext = "TLS, server delegated credentials\x00"
+
+// delegatedCredential is a DelegatedCredential, see RFC 9345, Section 4.
+type delegatedCredential struct {
+	// validTime is the validity of the credential in seconds after the
+	// notBefore time of the certificate.
+	validTime uint32
+	// certVerifyAlgorithm is the algorithm of the CertificateVerify signed
+	// with the credential.
+	certVerifyAlgorithm SignatureScheme
+	publicKey           crypto.PublicKey
+	// algorithm and signature are the signature of the credential by the key
+	// of the certificate.
+	algorithm SignatureScheme
+	signature []byte
+	// raw is the signed Credential.
+	raw []byte
+}
+
+// parseDelegatedCredential parses the delegated_credential extension of a
+// CertificateEntry.
+func parseDelegatedCredential(data []byte) (*delegatedCredential, error) {
+	dc := &delegatedCredential{}
+	s := cryptobyte.String(data)
+	var spki cryptobyte.String
+	var certVerifyAlgorithm, algorithm uint16
+	if !s.ReadUint32(&dc.validTime) ||
+		!s.ReadUint16(&certVerifyAlgorithm) ||
+		!s.ReadUint24LengthPrefixed(&spki) || spki.Empty() {
+		return nil, errors.New("tls: malformed delegated credential")
+	}
+	dc.raw = data[:len(data)-len(s)]
+	if !s.ReadUint16(&algorithm) ||
+		!readUint16LengthPrefixed(&s, &dc.signature) || len(dc.signature) == 0 ||
+		!s.Empty() {
+		return nil, errors.New("tls: malformed delegated credential")
+	}
+	dc.certVerifyAlgorithm = SignatureScheme(certVerifyAlgorithm)
+	dc.algorithm = SignatureScheme(algorithm)
+
+	var err error
+	dc.publicKey, err = x509.ParsePKIXPublicKey(spki)
+	if err != nil {
+		return nil, fmt.Errorf("tls: failed to parse delegated credential public key: %w", err)
+	}
+	return dc, nil
+}
+
+// verifyDelegatedCredential checks the delegated credential sent by the
+// server with its certificate leaf, which was verified, at time now. The
+// credential must be for one of the signature algorithms of the
+// delegated_credentials extension of the ClientHello.
+func verifyDelegatedCredential(data []byte, leaf *x509.Certificate, now time.Time, algs []SignatureScheme) (*delegatedCredential, error) {
+	dc, err := parseDelegatedCredential(data)
+	if err != nil {
+		return nil, err
+	}
+
+	if !slices.ContainsFunc(leaf.Extensions, func(ext pkix.Extension) bool { return ext.Id.Equal(oidDelegationUsage) }) ||
+		leaf.KeyUsage&x509.KeyUsageDigitalSignature == 0 {
+		return nil, errors.New("tls: server certificate is not allowed to sign delegated credentials")
+	}
+	expiry := leaf.NotBefore.Add(time.Duration(dc.validTime) * time.Second)
+	if !now.Before(expiry) {
+		return nil, errors.New("tls: delegated credential has expired")
+	}
+	if expiry.Sub(now) > maxDelegatedCredentialValidity {
+		return nil, errors.New("tls: delegated credential is valid for more than 7 days")
+	}
+	if !slices.Contains(algs, dc.certVerifyAlgorithm) {
+		return nil, fmt.Errorf("tls: delegated credential is for unrequested signature algorithm %v", dc.certVerifyAlgorithm)
+	}
+
+	sigType, sigHash, err := typeAndHashFromSignatureScheme(dc.algorithm)
+	if err != nil {
+		return nil, err
+	}
+	if sigType == signaturePKCS1v15 || sigHash == crypto.SHA1 {
+		return nil, errors.New("tls: delegated credential signed with invalid signature algorithm")
+	}
+	var signed bytes.Buffer
+	signed.Write(signaturePadding)
+	signed.WriteString(delegatedCredentialSignatureContext)
+	signed.Write(leaf.Raw)
+	signed.Write(dc.raw)
+	signed.Write([]byte{byte(dc.algorithm >> 8), byte(dc.algorithm)})
+	message := signed.Bytes()
+	if sigHash != directSigning {
+		h := sigHash.New()
+		h.Write(message)
+		message = h.Sum(nil)
+	}
+	if err := verifyHandshakeSignature(sigType, leaf.PublicKey, sigHash, message, dc.signature); err != nil {
+		return nil, errors.New("tls: invalid signature of the delegated credential: " + err.Error())
+	}
+	return dc, nil
+}
@@ -0,0 +1,181 @@
+// Copyright 2024 The uTLS Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tls
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/cryptobyte"
+)
+
+func TestUTLSDelegatedCredentialsExtension(t *testing.T) {
+	// out of the usual order, which must be kept
+	algs := []SignatureScheme{ECDSAWithP384AndSHA384, ECDSAWithP256AndSHA256, ECDSAWithSHA1, ECDSAWithP521AndSHA512}
+
+	uconn := UClient(&net.TCPConn{}, &Config{ServerName: "example.com"}, HelloCustom)
+	if err := uconn.ApplyPreset(&ClientHelloSpec{
+		CipherSuites: []uint16{TLS_AES_128_GCM_SHA256},
+		Extensions: []TLSExtension{
+			&SNIExtension{},
+			&SupportedVersionsExtension{Versions: []uint16{VersionTLS13}},
+			&KeyShareExtension{KeyShares: []KeyShare{{Group: X25519}}},
+			&DelegatedCredentialsExtension{SupportedSignatureAlgorithms: algs},
+		},
+	}); err != nil {
+		t.Fatalf("got error: %v; expected to succeed", err)
+	}
+	if err := uconn.BuildHandshakeState(); err != nil {
+		t.Fatalf("got error: %v; expected to succeed", err)
+	}
+	if !slices.Equal(uconn.delegatedCredentialAlgs, algs) {
+		t.Errorf("got requested delegated credential algorithms %v, expected %v", uconn.delegatedCredentialAlgs, algs)
+	}
+
+	spec, err := (&Fingerprinter{}).FingerprintClientHello(prependRecordHeader(uconn.HandshakeState.Hello.Raw, VersionTLS10))
+	if err != nil {
+		t.Fatalf("got error: %v; expected to succeed", err)
+	}
+	ext, ok := spec.Extensions[len(spec.Extensions)-1].(*DelegatedCredentialsExtension)
+	if !ok {
+		t.Fatalf("got %T, expected *DelegatedCredentialsExtension", spec.Extensions[len(spec.Extensions)-1])
+	}
+	if !slices.Equal(ext.SupportedSignatureAlgorithms, algs) {
+		t.Errorf("got signature algorithms %v, expected %v", ext.SupportedSignatureAlgorithms, algs)
+	}
+}
+
+// delegationCertificate returns a certificate that may sign delegated
+// credentials, unless delegation is false, and its key.
+func delegationCertificate(t *testing.T, delegation bool) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "example.com"},
+		NotBefore:    time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+		NotAfter:     time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}
+	if delegation {
+		template.ExtraExtensions = []pkix.Extension{{Id: oidDelegationUsage, Value: []byte{0x05, 0x00}}}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, key
+}
+
+// marshalDelegatedCredential returns a delegated credential for dcKey, signed
+// by certKey with ECDSAWithP256AndSHA256.
+func marshalDelegatedCredential(t *testing.T, cert *x509.Certificate, certKey, dcKey *ecdsa.PrivateKey, validTime uint32, certVerifyAlgorithm SignatureScheme) []byte {
+	spki, err := x509.MarshalPKIXPublicKey(dcKey.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var b cryptobyte.Builder
+	b.AddUint32(validTime)
+	b.AddUint16(uint16(certVerifyAlgorithm))
+	b.AddUint24LengthPrefixed(func(b *cryptobyte.Builder) { b.AddBytes(spki) })
+	cred := b.BytesOrPanic()
+
+	h := sha256.New()
+	h.Write(signaturePadding)
+	h.Write([]byte(delegatedCredentialSignatureContext))
+	h.Write(cert.Raw)
+	h.Write(cred)
+	h.Write([]byte{0x04, 0x03}) // ECDSAWithP256AndSHA256
+	sig, err := ecdsa.SignASN1(rand.Reader, certKey, h.Sum(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b = *cryptobyte.NewBuilder(cred)
+	b.AddUint16(uint16(ECDSAWithP256AndSHA256))
+	b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) { b.AddBytes(sig) })
+	return b.BytesOrPanic()
+}
+
+func TestUTLSVerifyDelegatedCredential(t *testing.T) {
+	cert, certKey := delegationCertificate(t, true)
+	dcKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := cert.NotBefore.Add(30 * 24 * time.Hour)
+	day := uint32(24 * time.Hour / time.Second)
+	validTime := uint32(now.Sub(cert.NotBefore)/time.Second) + day
+	algs := []SignatureScheme{ECDSAWithP256AndSHA256, ECDSAWithP384AndSHA384}
+
+	dc := marshalDelegatedCredential(t, cert, certKey, dcKey, validTime, ECDSAWithP256AndSHA256)
+	cred, err := verifyDelegatedCredential(dc, cert, now, algs)
+	if err != nil {
+		t.Fatalf("got error: %v; expected to succeed", err)
+	}
+	if !dcKey.PublicKey.Equal(cred.publicKey) || cred.certVerifyAlgorithm != ECDSAWithP256AndSHA256 {
+		t.Errorf("got key %v and algorithm %v, expected the delegated credential's", cred.publicKey, cred.certVerifyAlgorithm)
+	}
+
+	// the certificate message carries the credential of the leaf
+	var b cryptobyte.Builder
+	b.AddUint8(0) // certificate_request_context
+	b.AddUint24LengthPrefixed(func(b *cryptobyte.Builder) {
+		b.AddUint24LengthPrefixed(func(b *cryptobyte.Builder) { b.AddBytes(cert.Raw) })
+		b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+			b.AddUint16(extensionDelegatedCredentials)
+			b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) { b.AddBytes(dc) })
+		})
+	})
+	body := b.BytesOrPanic()
+	msg := append([]byte{typeCertificate, 0, byte(len(body) >> 8), byte(len(body))}, body...)
+	var certMsg certificateMsgTLS13
+	if !certMsg.unmarshal(msg) {
+		t.Fatal("failed to unmarshal a Certificate message with a delegated credential")
+	}
+	if string(certMsg.certificate.delegatedCredential) != string(dc) {
+		t.Error("got a different delegated credential from the Certificate message")
+	}
+
+	undelegated, undelegatedKey := delegationCertificate(t, false)
+	badSignature := slices.Clone(dc)
+	badSignature[len(badSignature)-1] ^= 1
+	for _, test := range []struct {
+		name string
+		dc   []byte
+		cert *x509.Certificate
+		err  string
+	}{
+		{"expired", marshalDelegatedCredential(t, cert, certKey, dcKey, validTime-2*day, ECDSAWithP256AndSHA256), cert, "expired"},
+		{"too long", marshalDelegatedCredential(t, cert, certKey, dcKey, validTime+7*day, ECDSAWithP256AndSHA256), cert, "more than 7 days"},
+		{"unrequested", marshalDelegatedCredential(t, cert, certKey, dcKey, validTime, ECDSAWithP521AndSHA512), cert, "unrequested"},
+		{"no DelegationUsage", marshalDelegatedCredential(t, undelegated, undelegatedKey, dcKey, validTime, ECDSAWithP256AndSHA256), undelegated, "not allowed"},
+		{"bad signature", badSignature, cert, "invalid signature"},
+		{"malformed", dc[:len(dc)-1], cert, "malformed"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := verifyDelegatedCredential(test.dc, test.cert, now, algs)
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("got error %v, expected it to contain %q", err, test.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,62 @@
+// Copyright 2024 The uTLS Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tls
+
+import (
+	"context"
+	"net"
+	"strings"
+
+	"golang.org/x/net/proxy"
+)
+
+// DialUTLS connects to addr through dialer, e.g. a SOCKS5 proxy from
+// proxy.SOCKS5, and completes a handshake with the ClientHello of id. A nil
+// dialer connects directly. A nil config is the same as an empty one.
+//
+// If config.ServerName is empty, it is inferred from the hostname in addr, as
+// for Dial. config is not modified.
+//
+// ctx applies to the connection if dialer implements proxy.ContextDialer, and
+// always to the handshake. Once the handshake is complete, ctx has no effect
+// on the returned connection.
+func DialUTLS(ctx context.Context, dialer proxy.Dialer, network, addr string, config *Config, id ClientHelloID) (*UConn, error) {
+	if dialer == nil {
+		dialer = proxy.Direct
+	}
+	if config == nil {
+		config = defaultConfig()
+	}
+	// If no ServerName is set, infer the ServerName
+	// from the hostname we're connecting to.
+	if config.ServerName == "" {
+		colonPos := strings.LastIndex(addr, ":")
+		if colonPos == -1 {
+			colonPos = len(addr)
+		}
+		// Make a copy to avoid polluting argument or default.
+		c := config.Clone()
+		c.ServerName = addr[:colonPos]
+		config = c
+	}
+
+	var rawConn net.Conn
+	var err error
+	if contextDialer, ok := dialer.(proxy.ContextDialer); ok {
+		rawConn, err = contextDialer.DialContext(ctx, network, addr)
+	} else {
+		rawConn, err = dialer.Dial(network, addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	uconn := UClient(rawConn, config, id)
+	if err := uconn.HandshakeContext(ctx); err != nil {
+		rawConn.Close()
+		return nil, err
+	}
+	return uconn, nil
+}
@@ -0,0 +1,98 @@
+// Copyright 2024 The uTLS Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tls
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+)
+
+// fakeDialer connects to a TLS server over a pipe, and records the address it
+// was asked to connect to.
+type fakeDialer struct {
+	t       *testing.T
+	network string
+	addr    string
+	server  chan *Conn
+}
+
+func (d *fakeDialer) Dial(network, addr string) (net.Conn, error) {
+	d.network, d.addr = network, addr
+	c, s := localPipe(d.t)
+	server := Server(s, testConfig.Clone())
+	go func() {
+		server.Handshake()
+		d.server <- server
+	}()
+	return c, nil
+}
+
+// fakeContextDialer is a fakeDialer that fails if its context is done.
+type fakeContextDialer struct {
+	fakeDialer
+}
+
+func (d *fakeContextDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return d.Dial(network, addr)
+}
+
+func TestUTLSDialUTLS(t *testing.T) {
+	config := &Config{InsecureSkipVerify: true}
+	dialer := &fakeDialer{t: t, server: make(chan *Conn, 1)}
+	uconn, err := DialUTLS(context.Background(), dialer, "tcp", "example.golang:443", config, HelloChrome_120)
+	if err != nil {
+		t.Fatalf("got error: %v; expected to succeed", err)
+	}
+	defer uconn.Close()
+	server := <-dialer.server
+	defer server.Close()
+	if dialer.network != "tcp" || dialer.addr != "example.golang:443" {
+		t.Errorf("dialed %s %s, expected tcp example.golang:443", dialer.network, dialer.addr)
+	}
+	if name := server.ConnectionState().ServerName; name != "example.golang" {
+		t.Errorf("got SNI %q, expected example.golang", name)
+	}
+	if config.ServerName != "" {
+		t.Errorf("config.ServerName was set to %q", config.ServerName)
+	}
+	if uconn.ClientHelloID != HelloChrome_120 || uconn.HandshakeState.Hello.Raw == nil {
+		t.Errorf("got ClientHelloID %v, expected the Chrome 120 ClientHello to be sent", uconn.ClientHelloID)
+	}
+
+	// an explicit ServerName is kept
+	config.ServerName = "other.golang"
+	uconn, err = DialUTLS(context.Background(), dialer, "tcp", "example.golang:443", config, HelloFirefox_120)
+	if err != nil {
+		t.Fatalf("got error: %v; expected to succeed", err)
+	}
+	defer uconn.Close()
+	server = <-dialer.server
+	defer server.Close()
+	if name := server.ConnectionState().ServerName; name != "other.golang" {
+		t.Errorf("got SNI %q, expected other.golang", name)
+	}
+
+	// a proxy.ContextDialer gets the context
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	contextDialer := &fakeContextDialer{fakeDialer{t: t, server: make(chan *Conn, 1)}}
+	if _, err := DialUTLS(ctx, contextDialer, "tcp", "example.golang:443", config, HelloChrome_120); !errors.Is(err, context.Canceled) {
+		t.Errorf("got error %v, expected the context to be canceled", err)
+	}
+	if contextDialer.addr != "" {
+		t.Error("dialed with a canceled context")
+	}
+
+	// other dialers only get it for the handshake
+	if _, err := DialUTLS(ctx, dialer, "tcp", "example.golang:443", config, HelloChrome_120); !errors.Is(err, context.Canceled) {
+		t.Errorf("got error %v, expected the context to be canceled", err)
+	}
+	(<-dialer.server).Close()
+}
@@ -0,0 +1,179 @@
+// Copyright 2024 The uTLS Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tls
+
+import (
+	"errors"
+	"slices"
+)
+
+// testingOnlyEarlyData is set in tests to make the server offer 0-RTT over TCP
+// in its session tickets, up to maxSize bytes. The early data is then accepted,
+// unless reject is set, in which case it is skipped as described in RFC 8446,
+// Section 4.2.10. Received early data is returned by the first Read calls.
+var testingOnlyEarlyData struct {
+	maxSize uint32
+	reject  bool
+}
+
+// WriteEarlyData buffers b to be sent as 0-RTT data (RFC 8446, Section 2.3),
+// right after the ClientHello. It must be called before the ClientHello is
+// built, and may be called several times.
+//
+// The data is only sent as 0-RTT if the resumed session allows it, in which
+// case the early_data extension is added to the ClientHello. Up to the
+// max_early_data_size of the session ticket is sent this way. Whatever is not
+// sent as 0-RTT, all of it if the server rejects the early data, is written
+// once the handshake completes, so that the server always receives b.
+//
+// 0-RTT data is not protected against replays, and must only be used for
+// requests that are safe to repeat.
+func (uconn *UConn) WriteEarlyData(b []byte) (int, error) {
+	if uconn.quic != nil {
+		return 0, errors.New("tls: WriteEarlyData is not supported for QUIC connections")
+	}
+	if uconn.clientHelloBuildStatus != NotBuilt {
+		return 0, errors.New("tls: WriteEarlyData must be called before the ClientHello is built")
+	}
+	if len(b) == 0 {
+		return 0, nil
+	}
+	uconn.utls.earlyData = append(uconn.utls.earlyData, b...)
+	return len(b), nil
+}
+
+// EarlyDataAccepted reports whether the server accepted the 0-RTT data sent
+// with the ClientHello. It is only meaningful once the handshake completed.
+func (uconn *UConn) EarlyDataAccepted() bool {
+	return uconn.utls.earlyDataAccepted
+}
+
+// addEarlyDataExtension marks the ClientHello as carrying 0-RTT data, and adds
+// the early_data extension right before the pre_shared_key one if the spec
+// doesn't have it already, or last with ClientHelloSpec.ForceExtensionOrder.
+func (uconn *UConn) addEarlyDataExtension() {
+	uconn.HandshakeState.Hello.EarlyData = true
+	for _, ext := range uconn.Extensions {
+		if _, ok := ext.(*EarlyDataExtension); ok {
+			return
+		}
+	}
+	if uconn.forceExtensionOrder {
+		uconn.Extensions = append(uconn.Extensions, &EarlyDataExtension{})
+		return
+	}
+	uconn.Extensions = slices.Insert(uconn.Extensions, len(uconn.Extensions)-1, TLSExtension(&EarlyDataExtension{}))
+}
+
+// sendEarlyData writes the dummy ChangeCipherSpec, which goes right after the
+// ClientHello when offering 0-RTT (RFC 8446, Appendix D.4), and up to
+// maxEarlyData bytes of the buffered early data with the client early traffic
+// secret. The record layer keeps using that secret until EndOfEarlyData.
+func (c *UConn) sendEarlyData(suite *cipherSuiteTLS13, secret []byte, maxEarlyData uint32) error {
+	c.out.Lock()
+	defer c.out.Unlock()
+
+	// The records are sent with the TLS 1.3 record version, which is only
+	// otherwise set along with the ServerHello.
+	vers := c.vers
+	c.vers, c.out.version = VersionTLS13, VersionTLS13
+	defer func() { c.vers = vers }()
+
+	if _, err := c.writeRecordLocked(recordTypeChangeCipherSpec, []byte{1}); err != nil {
+		return err
+	}
+
+	c.out.setTrafficSecret(suite, QUICEncryptionLevelEarly, secret)
+	if err := c.config.writeKeyLog(keyLogLabelClientEarly, c.HandshakeState.Hello.Random, secret); err != nil {
+		return err
+	}
+
+	data := c.utls.earlyData
+	if len(data) > int(maxEarlyData) {
+		data = data[:maxEarlyData]
+	}
+	n, err := c.writeRecordLocked(recordTypeApplicationData, data)
+	c.utls.earlyDataSent = n
+	return err
+}
+
+// sendEndOfEarlyData sends EndOfEarlyData if the server accepted the 0-RTT
+// data, and switches to the client handshake traffic secret, which wasn't
+// installed yet if any was sent.
+func (hs *clientHandshakeStateTLS13) sendEndOfEarlyData() error {
+	c := hs.c
+	if hs.pendingClientSecret == nil {
+		return nil
+	}
+
+	if c.utls.earlyDataAccepted {
+		if _, err := c.writeHandshakeRecord(&endOfEarlyDataMsg{}, hs.transcript); err != nil {
+			return err
+		}
+	}
+	c.out.setTrafficSecret(hs.suite, QUICEncryptionLevelHandshake, hs.pendingClientSecret)
+	hs.pendingClientSecret = nil
+	return nil
+}
+
+// writePendingEarlyData writes the early data that was not received by the
+// server as 0-RTT data, now that the handshake is complete.
+func (c *UConn) writePendingEarlyData() error {
+	data := c.utls.earlyData
+	if data == nil {
+		return nil
+	}
+	c.utls.earlyData = nil
+	if c.utls.earlyDataAccepted {
+		data = data[c.utls.earlyDataSent:]
+	}
+	if len(data) == 0 {
+		return nil
+	}
+
+	c.out.Lock()
+	defer c.out.Unlock()
+	_, err := c.writeRecordLocked(recordTypeApplicationData, data)
+	return c.out.setErrorLocked(err)
+}
+
+// readEndOfEarlyData reads the 0-RTT data and EndOfEarlyData that the client
+// sends after its ClientHello if the early data was accepted over TCP. It is
+// the server side of sendEndOfEarlyData.
+func (hs *serverHandshakeStateTLS13) readEndOfEarlyData() error {
+	c := hs.c
+	if hs.pendingClientSecret == nil {
+		return nil
+	}
+
+	// The 0-RTT data is stored by readRecordOrCCS along the way.
+	msg, err := c.readHandshake(hs.transcript)
+	if err != nil {
+		return err
+	}
+	if _, ok := msg.(*endOfEarlyDataMsg); !ok {
+		c.sendAlert(alertUnexpectedMessage)
+		return unexpectedMessageError(&endOfEarlyDataMsg{}, msg)
+	}
+	c.in.setTrafficSecret(hs.suite, QUICEncryptionLevelHandshake, hs.pendingClientSecret)
+	hs.pendingClientSecret = nil
+
+	// Session tickets are sent only now, as the transcript covers
+	// EndOfEarlyData.
+	if !hs.requestClientCert() {
+		return hs.sendSessionTickets()
+	}
+	return nil
+}
+
+// skipEarlyData reports whether a record of n bytes that could not be
+// processed is rejected 0-RTT data, which the server skips.
+func (c *Conn) skipEarlyData(n int) bool {
+	if c.isClient || c.utls.earlyDataToSkip < n {
+		return false
+	}
+	c.utls.earlyDataToSkip -= n
+	return true
+}
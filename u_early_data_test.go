@@ -0,0 +1,109 @@
+// Copyright 2024 The uTLS Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tls
+
+import (
+	"io"
+	"testing"
+	"time"
+)
+
+// earlyDataHandshake connects a UConn that sends request as early data to a
+// server that reads it and answers with "ok", and returns what the server read.
+func earlyDataHandshake(t *testing.T, config *Config, cache ClientSessionCache, id ClientHelloID, request string) (*UConn, string) {
+	c, s := localPipe(t)
+	received := make(chan string, 1)
+	go func() {
+		defer close(received)
+		server := Server(s, config)
+		defer server.Close()
+		b := make([]byte, len(request))
+		if _, err := io.ReadFull(server, b); err != nil {
+			t.Errorf("server read failed: %v", err)
+			return
+		}
+		received <- string(b)
+		server.Write([]byte("ok"))
+	}()
+
+	uconn := UClient(c, &Config{
+		ServerName:         "example.golang",
+		InsecureSkipVerify: true,
+		ClientSessionCache: cache,
+		// the test certificate is only valid until 2025
+		Time: func() time.Time { return time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC) },
+	}, id)
+	t.Cleanup(func() { uconn.Close() })
+	if _, err := uconn.WriteEarlyData([]byte(request)); err != nil {
+		t.Fatalf("got error: %v; expected to succeed", err)
+	}
+	if err := uconn.Handshake(); err != nil {
+		t.Fatalf("got error: %v; expected to succeed", err)
+	}
+	// the session ticket is read along with the answer
+	if _, err := io.ReadFull(uconn, make([]byte, 2)); err != nil {
+		t.Fatalf("got error: %v; expected to succeed", err)
+	}
+	return uconn, <-received
+}
+
+func TestUTLSEarlyData(t *testing.T) {
+	defer func() { testingOnlyEarlyData.maxSize, testingOnlyEarlyData.reject = 0, false }()
+	testingOnlyEarlyData.maxSize = 16384
+
+	serverConfig := testConfig.Clone()
+	serverConfig.NextProtos = []string{"h2"}
+	const request = "GET / HTTP/1.1\r\n\r\n"
+
+	for _, test := range []struct {
+		name     string
+		reject   bool
+		accepted bool
+	}{
+		{"accepted", false, true},
+		{"rejected", true, false},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			cache := NewLRUClientSessionCache(1)
+			testingOnlyEarlyData.reject = false
+
+			// Without a session, the data is sent after the handshake.
+			uconn, received := earlyDataHandshake(t, serverConfig, cache, HelloFirefox_120, request)
+			if uconn.EarlyDataAccepted() || received != request {
+				t.Fatalf("got accepted %v and %q without a session, expected false and %q", uconn.EarlyDataAccepted(), received, request)
+			}
+
+			testingOnlyEarlyData.reject = test.reject
+			uconn, received = earlyDataHandshake(t, serverConfig, cache, HelloFirefox_120, request)
+			if !uconn.ConnectionState().DidResume {
+				t.Fatal("second handshake didn't resume")
+			}
+			if uconn.EarlyDataAccepted() != test.accepted {
+				t.Errorf("got early data accepted %v, expected %v", uconn.EarlyDataAccepted(), test.accepted)
+			}
+			if received != request {
+				t.Errorf("server received %q, expected %q", received, request)
+			}
+
+			exts := uconn.Extensions
+			if _, ok := exts[len(exts)-2].(*EarlyDataExtension); !ok {
+				t.Errorf("got %T before the last extension, expected *EarlyDataExtension", exts[len(exts)-2])
+			}
+			if _, ok := exts[len(exts)-1].(PreSharedKeyExtension); !ok {
+				t.Errorf("got %T as the last extension, expected a PreSharedKeyExtension", exts[len(exts)-1])
+			}
+		})
+	}
+}
+
+func TestUTLSWriteEarlyDataAfterBuild(t *testing.T) {
+	uconn := UClient(nil, &Config{ServerName: "example.golang"}, HelloChrome_120)
+	if err := uconn.BuildHandshakeState(); err != nil {
+		t.Fatalf("got error: %v; expected to succeed", err)
+	}
+	if _, err := uconn.WriteEarlyData([]byte("data")); err == nil {
+		t.Error("WriteEarlyData after BuildHandshakeState succeeded, expected an error")
+	}
+}
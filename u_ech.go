@@ -1,11 +1,14 @@
 package tls
 
 import (
+	"bytes"
 	"crypto/rand"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"math/big"
+	"slices"
 	"sync"
 
 	"github.com/cloudflare/circl/hpke"
@@ -46,6 +49,11 @@ var (
 	_ EncryptedClientHelloExtension = (*UnimplementedECHExtension)(nil)
 )
 
+// GREASEEncryptedClientHelloExtension is the outer ECH extension browsers send
+// when they don't have an ECHConfig for the server, as described in Section 6.2
+// of draft-ietf-tls-esni. The cipher suite, config_id and payload length are
+// picked from the candidates, and the encapsulated key and payload are random.
+// BoringGREASEECH returns the candidates used by Chrome.
 type GREASEEncryptedClientHelloExtension struct {
 	CandidateCipherSuites []HPKESymmetricCipherSuite
 	cipherSuite           HPKESymmetricCipherSuite // randomly picked from CandidateCipherSuites or generated if empty
@@ -57,15 +65,26 @@ type GREASEEncryptedClientHelloExtension struct {
 
 	initOnce sync.Once
 
+	echConfig  *ECHConfig // set by Configure, the ClientHello is encrypted to it
+	innerHello bool       // while marshaling ClientHelloInner, only the type is written
+
 	UnimplementedECHExtension
 }
 
 type GREASEECHExtension = GREASEEncryptedClientHelloExtension // alias
 
-// init initializes the GREASEEncryptedClientHelloExtension with random values if they are not set.
+// init initializes the GREASEEncryptedClientHelloExtension with random values
+// from crypto/rand if they are not set.
+func (g *GREASEEncryptedClientHelloExtension) init() error {
+	return g.initWithRand(rand.Reader)
+}
+
+// initWithRand initializes the GREASEEncryptedClientHelloExtension with random
+// values read from rnd if they are not set. Only the first call has an effect,
+// which (*UConn).ApplyPreset makes with Config.Rand.
 //
 // Based on cloudflare/go's echGenerateGreaseExt()
-func (g *GREASEEncryptedClientHelloExtension) init() error {
+func (g *GREASEEncryptedClientHelloExtension) initWithRand(rnd io.Reader) error {
 	var initErr error
 	g.initOnce.Do(func() {
 		// Set the config_id field to a random byte.
@@ -75,7 +94,7 @@ func (g *GREASEEncryptedClientHelloExtension) init() error {
 		// but reuse the same config_id for HRR.
 		if len(g.CandidateConfigIds) == 0 {
 			var b []byte = make([]byte, 1)
-			_, err := rand.Read(b[:])
+			_, err := io.ReadFull(rnd, b[:])
 			if err != nil {
 				initErr = fmt.Errorf("error generating random byte for config_id: %w", err)
 				return
@@ -83,7 +102,7 @@ func (g *GREASEEncryptedClientHelloExtension) init() error {
 			g.configId = b[0]
 		} else {
 			// randomly pick one from the list
-			rndIndex, err := rand.Int(rand.Reader, big.NewInt(int64(len(g.CandidateConfigIds))))
+			rndIndex, err := rand.Int(rnd, big.NewInt(int64(len(g.CandidateConfigIds))))
 			if err != nil {
 				initErr = fmt.Errorf("error generating random index for config_id: %w", err)
 				return
@@ -100,7 +119,7 @@ func (g *GREASEEncryptedClientHelloExtension) init() error {
 			g.cipherSuite = HPKESymmetricCipherSuite{uint16(kdf), uint16(aead)}
 		} else {
 			// randomly pick one from the list
-			rndIndex, err := rand.Int(rand.Reader, big.NewInt(int64(len(g.CandidateCipherSuites))))
+			rndIndex, err := rand.Int(rnd, big.NewInt(int64(len(g.CandidateCipherSuites))))
 			if err != nil {
 				initErr = fmt.Errorf("error generating random index for cipher_suite: %w", err)
 				return
@@ -127,7 +146,7 @@ func (g *GREASEEncryptedClientHelloExtension) init() error {
 				return
 			}
 
-			g.EncapsulatedKey, _, err = sender.Setup(rand.Reader)
+			g.EncapsulatedKey, _, err = sender.Setup(rnd)
 			if err != nil {
 				initErr = fmt.Errorf("tls: grease ech: failed to setup encapsulated key: %w", err)
 				return
@@ -140,27 +159,27 @@ func (g *GREASEEncryptedClientHelloExtension) init() error {
 			}
 
 			// randomly pick one from the list
-			rndIndex, err := rand.Int(rand.Reader, big.NewInt(int64(len(g.CandidatePayloadLens))))
+			rndIndex, err := rand.Int(rnd, big.NewInt(int64(len(g.CandidatePayloadLens))))
 			if err != nil {
 				initErr = fmt.Errorf("error generating random index for payload length: %w", err)
 				return
 			}
 
-			initErr = g.randomizePayload(g.CandidatePayloadLens[rndIndex.Int64()])
+			initErr = g.randomizePayload(rnd, g.CandidatePayloadLens[rndIndex.Int64()])
 		}
 	})
 
 	return initErr
 }
 
-func (g *GREASEEncryptedClientHelloExtension) randomizePayload(encodedHelloInnerLen uint16) error {
+func (g *GREASEEncryptedClientHelloExtension) randomizePayload(rnd io.Reader, encodedHelloInnerLen uint16) error {
 	if len(g.payload) != 0 {
 		return errors.New("tls: grease ech: regenerating payload is forbidden")
 	}
 
 	aead := hpke.AEAD(g.cipherSuite.AeadId)
 	g.payload = make([]byte, int(aead.CipherLen(uint(encodedHelloInnerLen))))
-	_, err := rand.Read(g.payload)
+	_, err := io.ReadFull(rnd, g.payload)
 	if err != nil {
 		return fmt.Errorf("tls: generating grease ech payload: %w", err)
 	}
@@ -177,6 +196,9 @@ func (g *GREASEEncryptedClientHelloExtension) writeToUConn(uconn *UConn) error {
 
 // Len implements TLSExtension.
 func (g *GREASEEncryptedClientHelloExtension) Len() int {
+	if g.innerHello {
+		return 2 + 2 + 1 /* ClientHello Type */
+	}
 	g.init()
 	return 2 + 2 + 1 /* ClientHello Type */ + 4 /* CipherSuite */ + 1 /* Config ID */ + 2 + len(g.EncapsulatedKey) + 2 + len(g.payload)
 }
@@ -191,6 +213,10 @@ func (g *GREASEEncryptedClientHelloExtension) Read(b []byte) (int, error) {
 	b[1] = byte(utlsExtensionECH & 0xFF)
 	b[2] = byte((g.Len() - 4) >> 8)
 	b[3] = byte((g.Len() - 4) & 0xFF)
+	if g.innerHello {
+		b[4] = InnerClientHello
+		return g.Len(), io.EOF
+	}
 	b[4] = OuterClientHello
 	b[5] = byte(g.cipherSuite.KdfId >> 8)
 	b[6] = byte(g.cipherSuite.KdfId & 0xFF)
@@ -208,13 +234,134 @@ func (g *GREASEEncryptedClientHelloExtension) Read(b []byte) (int, error) {
 }
 
 // Configure implements EncryptedClientHelloExtension.
-func (*GREASEEncryptedClientHelloExtension) Configure([]ECHConfig) error {
-	return nil // no-op, it is not possible to configure a GREASE extension for now
+//
+// It selects the first of configs with a supported KEM and cipher suite, which
+// turns the extension into a real ECH extension. Among the cipher suites of the
+// config, the first one in CandidateCipherSuites is preferred.
+func (g *GREASEEncryptedClientHelloExtension) Configure(configs []ECHConfig) error {
+	for i := range configs {
+		config := &configs[i]
+		if config.Version != utlsExtensionECH || config.Contents.KeyConfig.PublicKey == nil {
+			continue // unknown version or unsupported KEM
+		}
+
+		var supported []HPKESymmetricCipherSuite
+		for _, suite := range config.Contents.KeyConfig.CipherSuites {
+			if hpke.KDF(suite.KdfId).IsValid() && hpke.AEAD(suite.AeadId).IsValid() {
+				supported = append(supported, suite)
+			}
+		}
+		if len(supported) == 0 {
+			continue
+		}
+
+		g.cipherSuite = supported[0]
+		for _, candidate := range g.CandidateCipherSuites {
+			if slices.Contains(supported, candidate) {
+				g.cipherSuite = candidate
+				break
+			}
+		}
+		g.echConfig = config
+		return nil
+	}
+	return errors.New("tls: ech: none of the ECH configs is supported")
 }
 
 // MarshalClientHello implements EncryptedClientHelloExtension.
-func (*GREASEEncryptedClientHelloExtension) MarshalClientHello(*UConn) error {
-	return errors.New("tls: grease ech: MarshalClientHello() is not implemented, use (*UConn).MarshalClientHello() instead")
+//
+// It marshals the ClientHelloInner from uconn.Extensions, encrypts it to the
+// configured ECHConfig and marshals the ClientHelloOuter carrying it, with the
+// same extensions but the public name of the ECHConfig as the SNI and a new
+// client random. See Section 6.1 of draft-ietf-tls-esni.
+func (g *GREASEEncryptedClientHelloExtension) MarshalClientHello(uconn *UConn) error {
+	if g.echConfig == nil {
+		return errors.New("tls: ech: MarshalClientHello called before Configure")
+	}
+	hello := uconn.HandshakeState.Hello
+	if !slices.Contains(hello.SupportedVersions, VersionTLS13) {
+		return errors.New("tls: ech: ECH requires TLS 1.3")
+	}
+
+	var sni *SNIExtension
+	for _, ext := range uconn.Extensions {
+		switch e := ext.(type) {
+		case *SNIExtension:
+			sni = e
+		case PreSharedKeyExtension:
+			if e.Len() > 0 {
+				return errors.New("tls: ech: session resumption is not supported with ECH")
+			}
+		}
+	}
+
+	contents := &g.echConfig.Contents
+	suite, err := hpkeAssembleSuite(contents.KeyConfig.KemId, g.cipherSuite.KdfId, g.cipherSuite.AeadId)
+	if err != nil {
+		return fmt.Errorf("tls: ech: %w", err)
+	}
+	info := append([]byte("tls ech\x00"), g.echConfig.raw...)
+	sender, err := suite.NewSender(contents.KeyConfig.PublicKey, info)
+	if err != nil {
+		return fmt.Errorf("tls: ech: failed to create sender: %w", err)
+	}
+	enc, sealer, err := sender.Setup(uconn.config.rand())
+	if err != nil {
+		return fmt.Errorf("tls: ech: failed to setup encapsulated key: %w", err)
+	}
+
+	// ClientHelloInner
+	g.innerHello = true
+	err = uconn.MarshalClientHelloNoECH()
+	g.innerHello = false
+	if err != nil {
+		return err
+	}
+	inner := &echClientContext{
+		innerHello:  hello.Raw,
+		innerRandom: hello.Random,
+		publicName:  string(contents.PublicName),
+	}
+
+	// EncodedClientHelloInner has an empty legacy_session_id, which the server
+	// copies from ClientHelloOuter, and is padded as in Section 6.1.3.
+	sessionIDEnd := 4 + 2 + 32 + 1 + len(hello.SessionId)
+	encoded := append(append(bytes.Clone(hello.Raw[4:4+2+32]), 0), hello.Raw[sessionIDEnd:]...)
+	var padding int
+	if sni != nil && sni.ServerName != "" {
+		padding = max(0, int(contents.MaximumNameLength)-len(sni.ServerName))
+	} else {
+		padding = 9 + int(contents.MaximumNameLength)
+	}
+	padding += 31 - (len(encoded)+padding-1)%32
+	encoded = append(encoded, make([]byte, padding)...)
+
+	// ClientHelloOuter, first with a zeroed payload to compute the AAD
+	outerRandom := make([]byte, 32)
+	if _, err := io.ReadFull(uconn.config.rand(), outerRandom); err != nil {
+		return fmt.Errorf("tls: ech: short read from Rand: %w", err)
+	}
+	hello.Random = outerRandom
+	g.configId = contents.KeyConfig.ConfigId
+	g.EncapsulatedKey = enc
+	g.payload = make([]byte, len(encoded)+int(hpke.AEAD(g.cipherSuite.AeadId).CipherLen(0)))
+	if sni != nil {
+		serverName := sni.ServerName
+		sni.ServerName = inner.publicName
+		defer func() { sni.ServerName = serverName }()
+	}
+	if err := uconn.MarshalClientHelloNoECH(); err != nil {
+		return err
+	}
+	if g.payload, err = sealer.Seal(encoded, hello.Raw[4:]); err != nil {
+		return fmt.Errorf("tls: ech: failed to encrypt ClientHelloInner: %w", err)
+	}
+	if err := uconn.MarshalClientHelloNoECH(); err != nil {
+		return err
+	}
+
+	uconn.utls.ech = inner
+	return nil
 }
 
 // Write implements TLSExtensionWriter.
@@ -270,11 +417,74 @@ func (g *GREASEEncryptedClientHelloExtension) Write(b []byte) (int, error) {
 		return fullLen, errors.New("bad payload")
 	}
 	aead := hpke.AEAD(g.cipherSuite.AeadId)
+	if len(ignored) < int(aead.CipherLen(0)) {
+		return fullLen, errors.New("bad payload")
+	}
 	g.CandidatePayloadLens = []uint16{uint16(len(ignored) - int(aead.CipherLen(0)))}
 
 	return fullLen, nil
 }
 
+// greaseECHJSON is a GREASEEncryptedClientHelloExtension in JSON.
+type greaseECHJSON struct {
+	Name            string                         `json:"name"`
+	CipherSuites    []hpkeSymmetricCipherSuiteJSON `json:"cipher_suites,omitempty"`
+	ConfigIds       []uint16                       `json:"config_ids,omitempty"` // not a []uint8, which is base64 in JSON
+	EncapsulatedKey hexBytes                       `json:"encapsulated_key,omitempty"`
+	PayloadLens     []uint16                       `json:"payload_lens,omitempty"`
+}
+
+type hpkeSymmetricCipherSuiteJSON struct {
+	KDF  string `json:"kdf"`
+	AEAD string `json:"aead"`
+}
+
+func (g *GREASEEncryptedClientHelloExtension) MarshalJSON() ([]byte, error) {
+	ech := greaseECHJSON{Name: "encrypted_client_hello", EncapsulatedKey: g.EncapsulatedKey, PayloadLens: g.CandidatePayloadLens}
+	for _, suite := range g.CandidateCipherSuites {
+		kdf, ok := dicttls.DictKDFIdentifierValueIndexed[suite.KdfId]
+		if !ok {
+			return nil, fmt.Errorf("tls: KDF %d has no JSON name", suite.KdfId)
+		}
+		aead, ok := dicttls.DictAEADIdentifierValueIndexed[suite.AeadId]
+		if !ok {
+			return nil, fmt.Errorf("tls: AEAD %d has no JSON name", suite.AeadId)
+		}
+		ech.CipherSuites = append(ech.CipherSuites, hpkeSymmetricCipherSuiteJSON{kdf, aead})
+	}
+	for _, id := range g.CandidateConfigIds {
+		ech.ConfigIds = append(ech.ConfigIds, uint16(id))
+	}
+	return json.Marshal(ech)
+}
+
+func (g *GREASEEncryptedClientHelloExtension) UnmarshalJSON(data []byte) error {
+	var ech greaseECHJSON
+	if err := json.Unmarshal(data, &ech); err != nil {
+		return err
+	}
+	for _, suite := range ech.CipherSuites {
+		kdf, ok := dicttls.DictKDFIdentifierNameIndexed[suite.KDF]
+		if !ok {
+			return fmt.Errorf("unknown KDF %s", suite.KDF)
+		}
+		aead, ok := dicttls.DictAEADIdentifierNameIndexed[suite.AEAD]
+		if !ok {
+			return fmt.Errorf("unknown AEAD %s", suite.AEAD)
+		}
+		g.CandidateCipherSuites = append(g.CandidateCipherSuites, HPKESymmetricCipherSuite{KdfId: kdf, AeadId: aead})
+	}
+	for _, id := range ech.ConfigIds {
+		if id > 0xff {
+			return fmt.Errorf("invalid config ID %d", id)
+		}
+		g.CandidateConfigIds = append(g.CandidateConfigIds, uint8(id))
+	}
+	g.EncapsulatedKey = ech.EncapsulatedKey
+	g.CandidatePayloadLens = ech.PayloadLens
+	return nil
+}
+
 // UnimplementedECHExtension is a placeholder for an ECH extension that is not implemented.
 // All implementations of EncryptedClientHelloExtension should embed this struct to ensure
 // forward compatibility.
@@ -327,3 +537,109 @@ func BoringGREASEECH() *GREASEEncryptedClientHelloExtension {
 		CandidatePayloadLens: []uint16{128, 160, 192, 224}, // +16: 144, 176, 208, 240
 	}
 }
+
+// echClientContext is the state of a connection that offered ECH, as kept by
+// the client until the server accepted or rejected it.
+type echClientContext struct {
+	innerHello  []byte // marshaled ClientHelloInner
+	innerRandom []byte
+	publicName  string
+
+	accepted bool
+	rejected bool
+}
+
+// ECHRejectionError is returned by the handshake when the server did not
+// accept ECH. The handshake was completed with the ClientHelloOuter, verifying
+// the certificate for the public name of the ECHConfig, and aborted afterwards.
+//
+// RetryConfigs are the ECH configs the server sent to be used instead, if any,
+// which may be passed to (*UConn).SetECHConfigs of a new connection.
+type ECHRejectionError struct {
+	RetryConfigs []ECHConfig
+}
+
+func (e *ECHRejectionError) Error() string {
+	if len(e.RetryConfigs) > 0 {
+		return "tls: server rejected ECH, retry with the provided configs"
+	}
+	return "tls: server rejected ECH"
+}
+
+// ECHStatus is the outcome of ECH for a connection, as reported by
+// (*UConn).ECHState.
+type ECHStatus uint8
+
+const (
+	// ECHStatusNone: the ClientHello has no ECH extension, or wasn't built.
+	ECHStatusNone ECHStatus = iota
+	// ECHStatusGREASE: the ECH extension was sent as GREASE, as no ECHConfig
+	// was set with SetECHConfigs.
+	ECHStatusGREASE
+	// ECHStatusOffered: the ClientHello was encrypted, and the server didn't
+	// answer yet.
+	ECHStatusOffered
+	// ECHStatusAccepted: the server accepted the encrypted ClientHelloInner.
+	ECHStatusAccepted
+	// ECHStatusRejected: the server used ClientHelloOuter, and the handshake
+	// failed with an *ECHRejectionError.
+	ECHStatusRejected
+)
+
+func (s ECHStatus) String() string {
+	switch s {
+	case ECHStatusNone:
+		return "none"
+	case ECHStatusGREASE:
+		return "GREASE"
+	case ECHStatusOffered:
+		return "offered"
+	case ECHStatusAccepted:
+		return "accepted"
+	case ECHStatusRejected:
+		return "rejected"
+	default:
+		return fmt.Sprintf("ECHStatus(%d)", uint8(s))
+	}
+}
+
+// ECHState describes the ECH negotiation of a connection.
+type ECHState struct {
+	Status ECHStatus
+
+	// ConfigID is the config_id of the ECH extension that was sent: the one of
+	// the ECHConfig the ClientHello was encrypted to, or a random one for
+	// GREASE. It is only known for GREASEEncryptedClientHelloExtension.
+	ConfigID uint8
+
+	// RetryConfigs is the ECHConfigList the server sent in its
+	// EncryptedExtensions, as on the wire, if any. Servers send it when they
+	// reject ECH or receive GREASE ECH, and it can be parsed with
+	// UnmarshalECHConfigs to dial again.
+	RetryConfigs []byte
+}
+
+// ECHState reports whether ECH was offered, GREASEd, accepted or rejected
+// for the ClientHello that was built. It is updated during the handshake,
+// and can be queried after it completed or failed.
+func (uconn *UConn) ECHState() ECHState {
+	state := ECHState{RetryConfigs: uconn.utls.echRetryConfigList}
+	if uconn.clientHelloBuildStatus != BuildByUtls || uconn.ech == nil || !slices.Contains(uconn.Extensions, TLSExtension(uconn.ech)) {
+		return state
+	}
+	if g, ok := uconn.ech.(*GREASEEncryptedClientHelloExtension); ok {
+		state.ConfigID = g.configId
+	}
+
+	switch ech := uconn.utls.ech; {
+	case ech == nil:
+		state.Status = ECHStatusGREASE
+	case ech.accepted:
+		state.Status = ECHStatusAccepted
+	case ech.rejected:
+		state.Status = ECHStatusRejected
+	default:
+		state.Status = ECHStatusOffered
+	}
+	return state
+}
@@ -1,12 +1,16 @@
 package tls_test
 
 import (
+	"bytes"
 	"errors"
 	"io"
+	"net"
+	"slices"
 	"testing"
 
 	tls "github.com/refraction-networking/utls"
 	"github.com/refraction-networking/utls/dicttls"
+	"golang.org/x/crypto/cryptobyte"
 )
 
 func TestGREASEECHWrite(t *testing.T) {
@@ -101,3 +105,76 @@ var (
 		},
 	}
 )
+
+// incrementingSource is an io.Reader that returns an unlimited number of
+// incrementing bytes.
+type incrementingSource struct{ next byte }
+
+func (s *incrementingSource) Read(b []byte) (int, error) {
+	for i := range b {
+		b[i] = s.next
+		s.next++
+	}
+	return len(b), nil
+}
+
+func TestGREASEECHParrots(t *testing.T) {
+	for _, test := range []struct {
+		id          tls.ClientHelloID
+		aeadIDs     []uint16
+		payloadLens []int
+	}{
+		{tls.HelloChrome_120, []uint16{dicttls.AEAD_AES_128_GCM, dicttls.AEAD_CHACHA20_POLY1305}, []int{144, 176, 208, 240}},
+		{tls.HelloFirefox_120, []uint16{dicttls.AEAD_AES_128_GCM, dicttls.AEAD_CHACHA20_POLY1305}, []int{239}},
+	} {
+		var raw []byte
+		for i := 0; i < 2; i++ {
+			uconn := tls.UClient(&net.TCPConn{}, &tls.Config{ServerName: "example.com", Rand: &incrementingSource{}}, test.id)
+			if err := uconn.BuildHandshakeState(); err != nil {
+				t.Fatalf("%s: got error: %v; expected to succeed", test.id.Str(), err)
+			}
+
+			var gech *tls.GREASEEncryptedClientHelloExtension
+			for _, ext := range uconn.Extensions {
+				if e, ok := ext.(*tls.GREASEEncryptedClientHelloExtension); ok {
+					gech = e
+				}
+			}
+			if gech == nil {
+				t.Fatalf("%s: no GREASE ECH extension", test.id.Str())
+			}
+			b := make([]byte, gech.Len())
+			if _, err := gech.Read(b); err != nil && !errors.Is(err, io.EOF) {
+				t.Fatalf("%s: failed to read GREASE ECH extension: %s", test.id.Str(), err)
+			}
+			if i == 1 {
+				if !bytes.Equal(b, raw) {
+					t.Errorf("%s: GREASE ECH extension differs with the same Config.Rand", test.id.Str())
+				}
+				break
+			}
+			raw = b
+		}
+
+		s := cryptobyte.String(raw[4:])
+		var chType, configID uint8 // config_id is random, but must be present
+		var kdfID, aeadID uint16
+		var enc, payload cryptobyte.String
+		if !s.ReadUint8(&chType) || !s.ReadUint16(&kdfID) || !s.ReadUint16(&aeadID) || !s.ReadUint8(&configID) ||
+			!s.ReadUint16LengthPrefixed(&enc) || !s.ReadUint16LengthPrefixed(&payload) || !s.Empty() {
+			t.Fatalf("%s: malformed GREASE ECH extension %x", test.id.Str(), raw)
+		}
+		if chType != tls.OuterClientHello {
+			t.Errorf("%s: got ClientHello type %d, expected outer", test.id.Str(), chType)
+		}
+		if kdfID != dicttls.HKDF_SHA256 || !slices.Contains(test.aeadIDs, aeadID) {
+			t.Errorf("%s: got KDF %d and AEAD %d, expected HKDF-SHA256 and one of %v", test.id.Str(), kdfID, aeadID, test.aeadIDs)
+		}
+		if len(enc) != 32 {
+			t.Errorf("%s: got %d byte enc, expected an X25519 key", test.id.Str(), len(enc))
+		}
+		if !slices.Contains(test.payloadLens, len(payload)) {
+			t.Errorf("%s: got %d byte payload, expected one of %v", test.id.Str(), len(payload), test.payloadLens)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+// Copyright 2024 The uTLS Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tls
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+var extensionRegistry struct {
+	sync.RWMutex
+	factories map[uint16]func() TLSExtension
+}
+
+// RegisterExtension makes ExtensionFromID return factory() for the extension
+// sent with codepoint, so that the Fingerprinter and ExtensionFromBytes parse
+// it with its Write method instead of keeping a GenericExtension, or failing.
+// This lets other packages support new or experimental extensions. factory
+// must return a new TLSExtension on each call, which should also implement
+// TLSExtensionWriter to be parsed. Types outside this package can implement
+// TLSExtension by embedding GenericExtension, and then add a Write method.
+//
+// It fails if codepoint is already registered or implemented by this
+// package, see ForceRegisterExtension. GREASE codepoints can't be registered.
+// It is safe to call concurrently with the parsing of ClientHellos.
+func RegisterExtension(codepoint uint16, factory func() TLSExtension) error {
+	if factory == nil {
+		return errors.New("tls: RegisterExtension called with a nil factory")
+	}
+	return registerExtension(codepoint, factory, false)
+}
+
+// ForceRegisterExtension is like RegisterExtension, but replaces the
+// registered factory or the implementation of this package of codepoint, if
+// any. A nil factory removes the registration, restoring the implementation
+// of this package.
+func ForceRegisterExtension(codepoint uint16, factory func() TLSExtension) error {
+	return registerExtension(codepoint, factory, true)
+}
+
+func registerExtension(codepoint uint16, factory func() TLSExtension, force bool) error {
+	if isGREASEUint16(codepoint) {
+		return fmt.Errorf("tls: GREASE extension %#04x can't be registered", codepoint)
+	}
+	extensionRegistry.Lock()
+	defer extensionRegistry.Unlock()
+	if !force {
+		if _, ok := extensionRegistry.factories[codepoint]; ok {
+			return fmt.Errorf("tls: extension %d is already registered", codepoint)
+		}
+		if builtinExtensionFromID(codepoint) != nil {
+			return fmt.Errorf("tls: extension %d is implemented by this package", codepoint)
+		}
+	}
+	if factory == nil {
+		delete(extensionRegistry.factories, codepoint)
+		return nil
+	}
+	if extensionRegistry.factories == nil {
+		extensionRegistry.factories = make(map[uint16]func() TLSExtension)
+	}
+	extensionRegistry.factories[codepoint] = factory
+	return nil
+}
+
+// registeredExtension returns the factory registered for codepoint, or nil.
+func registeredExtension(codepoint uint16) func() TLSExtension {
+	extensionRegistry.RLock()
+	defer extensionRegistry.RUnlock()
+	return extensionRegistry.factories[codepoint]
+}
@@ -0,0 +1,111 @@
+// Copyright 2024 The uTLS Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tls
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+)
+
+// experimentalExtension is implemented the way another package would, by
+// embedding GenericExtension.
+type experimentalExtension struct {
+	GenericExtension
+	Version uint8
+}
+
+func (e *experimentalExtension) Write(b []byte) (int, error) {
+	if len(b) != 1 {
+		return 0, errors.New("bad experimental extension")
+	}
+	e.Version = b[0]
+	e.Data = bytes.Clone(b)
+	return len(b), nil
+}
+
+func TestUTLSRegisterExtension(t *testing.T) {
+	const codepoint = 0xfe42
+	factory := func() TLSExtension {
+		return &experimentalExtension{GenericExtension: GenericExtension{Id: codepoint}}
+	}
+	t.Cleanup(func() { ForceRegisterExtension(codepoint, nil) })
+
+	spec := mustSpec(t, HelloFirefox_120)
+	spec.Extensions = append([]TLSExtension{&GenericExtension{Id: codepoint, Data: []byte{7}}}, spec.Extensions...)
+	uconn := UClient(&net.TCPConn{}, &Config{ServerName: "example.com"}, HelloCustom)
+	if err := uconn.ApplyPreset(spec); err != nil {
+		t.Fatalf("got error: %v; expected to succeed", err)
+	}
+	if err := uconn.BuildHandshakeState(); err != nil {
+		t.Fatalf("got error: %v; expected to succeed", err)
+	}
+	raw := uconn.HandshakeState.Hello.Raw
+	if _, err := (&Fingerprinter{}).FingerprintClientHello(prependRecordHeader(raw, VersionTLS10)); err == nil {
+		t.Error("got no error fingerprinting an unknown extension")
+	}
+
+	if err := RegisterExtension(codepoint, factory); err != nil {
+		t.Fatalf("got error: %v; expected to succeed", err)
+	}
+	fingerprinted, err := (&Fingerprinter{}).FingerprintClientHello(prependRecordHeader(raw, VersionTLS10))
+	if err != nil {
+		t.Fatalf("got error: %v; expected to succeed", err)
+	}
+	if e, ok := fingerprinted.Extensions[0].(*experimentalExtension); !ok || e.Version != 7 {
+		t.Fatalf("got %#v, expected the registered extension", fingerprinted.Extensions[0])
+	}
+	uconn = UClient(&net.TCPConn{}, &Config{ServerName: "example.com"}, HelloCustom)
+	if err := uconn.ApplyPreset(fingerprinted); err != nil {
+		t.Fatalf("got error: %v; expected to succeed", err)
+	}
+	if err := uconn.BuildHandshakeState(); err != nil {
+		t.Fatalf("got error: %v; expected to succeed", err)
+	}
+	if got, want := clientHelloRawExtensions(t, uconn.HandshakeState.Hello.Raw)[0], clientHelloRawExtensions(t, raw)[0]; got.extType != want.extType || !bytes.Equal(got.data, want.data) {
+		t.Errorf("got extension %d with %x, expected %d with %x", got.extType, got.data, want.extType, want.data)
+	}
+	if ext, ok := ExtensionFromBytes(codepoint, []byte{1, 2}).(*GenericExtension); !ok || ext.Id != codepoint {
+		t.Errorf("got %#v for data that doesn't parse, expected a GenericExtension", ext)
+	}
+
+	if err := RegisterExtension(codepoint, factory); err == nil {
+		t.Error("got no error registering an extension twice")
+	}
+	if err := RegisterExtension(extensionALPN, factory); err == nil {
+		t.Error("got no error registering the ALPN extension")
+	}
+	if err := ForceRegisterExtension(0x0a0a, factory); err == nil {
+		t.Error("got no error registering a GREASE extension")
+	}
+	if err := ForceRegisterExtension(extensionALPN, factory); err != nil {
+		t.Fatalf("got error: %v; expected to succeed", err)
+	}
+	_, replaced := ExtensionFromID(extensionALPN).(*experimentalExtension)
+	if err := ForceRegisterExtension(extensionALPN, nil); err != nil {
+		t.Fatalf("got error: %v; expected to succeed", err)
+	}
+	if _, restored := ExtensionFromID(extensionALPN).(*ALPNExtension); !replaced || !restored {
+		t.Errorf("ForceRegisterExtension replaced ALPN: %v, and restored it: %v", replaced, restored)
+	}
+
+	var wg sync.WaitGroup
+	for i := uint16(0); i < 8; i++ {
+		wg.Add(1)
+		go func(i uint16) {
+			defer wg.Done()
+			if err := RegisterExtension(codepoint+1+i, factory); err != nil {
+				t.Errorf("got error: %v; expected to succeed", err)
+			}
+			for j := uint16(0); j < 8; j++ {
+				ExtensionFromID(codepoint + 1 + j)
+			}
+			ForceRegisterExtension(codepoint+1+i, nil)
+		}(i)
+	}
+	wg.Wait()
+}
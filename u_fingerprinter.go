@@ -4,6 +4,12 @@
 
 package tls
 
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
 // Fingerprinter is a struct largely for holding options for the FingerprintClientHello func
 type Fingerprinter struct {
 	// AllowBluntMimicry will ensure that unknown extensions are
@@ -18,7 +24,27 @@ type Fingerprinter struct {
 	// (including things like different SNI lengths) would cause padding to be necessary
 	AlwaysAddPadding bool
 
-	RealPSKResumption bool // if set, PSK extension (if any) will be real PSK extension, otherwise it will be fake PSK extension
+	// RealPSKResumption makes the pre_shared_key extension (if any) a
+	// UtlsPreSharedKeyExtension, which is filled with the identity and live
+	// binders of the resumed session during the handshake. Otherwise it will be
+	// a FakePreSharedKeyExtension replaying the captured identities and binders.
+	// Either way it is kept as the last extension, as RFC 8446 requires.
+	RealPSKResumption bool
+
+	// PreserveUnknownExtensions will keep every extension that is not recognized,
+	// or that is recognized but cannot be parsed (e.g. because a newer browser uses
+	// a different format), as a GenericExtension holding a copy of its exact bytes
+	// at its original position. Unlike AllowBluntMimicry, parsing never fails
+	// because of extension contents, which is useful for replaying captures from
+	// clients that are newer than this library.
+	PreserveUnknownExtensions bool
+
+	// NormalizeGREASE replaces every GREASE value that ApplyPreset regenerates
+	// with GREASE_PLACEHOLDER, so that the resulting ClientHelloSpec doesn't
+	// carry the GREASE of the fingerprinted connection. Cipher suites,
+	// supported_groups, key_share, supported_versions and GREASE extensions
+	// are always normalized; this also covers signature_algorithms.
+	NormalizeGREASE bool
 }
 
 // FingerprintClientHello returns a ClientHelloSpec which is based on the
@@ -45,11 +71,15 @@ func (f *Fingerprinter) FingerprintClientHello(data []byte) (clientHelloSpec *Cl
 func (f *Fingerprinter) RawClientHello(raw []byte) (clientHelloSpec *ClientHelloSpec, err error) {
 	clientHelloSpec = &ClientHelloSpec{}
 
-	err = clientHelloSpec.FromRaw(raw, f.AllowBluntMimicry, f.RealPSKResumption)
+	err = clientHelloSpec.FromRaw(raw, f.AllowBluntMimicry, f.RealPSKResumption, f.PreserveUnknownExtensions)
 	if err != nil {
 		return nil, err
 	}
 
+	if f.NormalizeGREASE {
+		clientHelloSpec.normalizeGREASE()
+	}
+
 	if f.AlwaysAddPadding {
 		clientHelloSpec.AlwaysAddPadding()
 	}
@@ -57,6 +87,195 @@ func (f *Fingerprinter) RawClientHello(raw []byte) (clientHelloSpec *ClientHello
 	return clientHelloSpec, nil
 }
 
+// FingerprintAndRegenerateKeys is like RawClientHello, but makes sure that
+// the key shares of raw, e.g. captured on another machine, don't end up in the
+// ClientHelloSpec. Their groups and order are kept, and ApplyPreset generates
+// fresh keys for them, so that the ClientHello can be replayed with a working
+// handshake. The rest of the ClientHello is kept as captured: a padding
+// extension, if any, pads to the captured length again once the new keys and
+// server name are in, with AlwaysPadToLen, whatever that length is. The
+// BoringPaddingStyle of the parrots would leave a ClientHello of 512 bytes
+// or more unpadded.
+//
+// A key_share extension that PreserveUnknownExtensions would keep as a
+// GenericExtension is parsed anyway, and an error is returned if that fails.
+func (f *Fingerprinter) FingerprintAndRegenerateKeys(raw []byte) (*ClientHelloSpec, error) {
+	spec, err := f.RawClientHello(raw)
+	if err != nil {
+		return nil, err
+	}
+	for i, ext := range spec.Extensions {
+		if e, ok := ext.(*GenericExtension); ok && e.Id == extensionKeyShare {
+			keyShare := &KeyShareExtension{}
+			if _, err := keyShare.Write(e.Data); err != nil {
+				return nil, fmt.Errorf("tls: cannot regenerate the keys of the key_share extension: %w", err)
+			}
+			spec.Extensions[i] = keyShare
+			ext = keyShare
+		}
+		if e, ok := ext.(*UtlsPaddingExtension); ok {
+			e.GetPaddingLen = AlwaysPadToLen(len(raw) - recordHeaderLen)
+		}
+		if e, ok := ext.(*KeyShareExtension); ok {
+			for j := range e.KeyShares {
+				if !isGREASEUint16(uint16(e.KeyShares[j].Group)) {
+					e.KeyShares[j].Data = nil
+				}
+			}
+		}
+	}
+	return spec, nil
+}
+
+// FingerprintFromReader reads one ClientHello from r, which may be fragmented
+// across several handshake records, and returns its ClientHelloSpec and the
+// bytes read from r, record headers included. It reads exactly up to the end
+// of the last record of the ClientHello, so that r can then be passed on,
+// e.g. to a server after the returned bytes.
+//
+// If reading or parsing fails, the bytes read so far are returned with the
+// error.
+func (f *Fingerprinter) FingerprintFromReader(r io.Reader) (*ClientHelloSpec, []byte, error) {
+	var raw, msg []byte
+	var recordVersion uint16
+	msgLen := -1 // unknown until the handshake message header is read
+	for msgLen < 0 || len(msg) < msgLen {
+		start := len(raw)
+		raw = append(raw, make([]byte, recordHeaderLen)...)
+		if n, err := io.ReadFull(r, raw[start:]); err != nil {
+			return nil, raw[:start+n], err
+		}
+		header := raw[start:]
+		if recordType(header[0]) != recordTypeHandshake {
+			return nil, raw, errors.New("tls: record is not a handshake")
+		}
+		if start == 0 {
+			recordVersion = uint16(header[1])<<8 | uint16(header[2])
+		}
+		n := int(header[3])<<8 | int(header[4])
+		if n == 0 || n > maxPlaintext {
+			return nil, raw, errors.New("tls: invalid handshake record length")
+		}
+
+		start = len(raw)
+		raw = append(raw, make([]byte, n)...)
+		if n, err := io.ReadFull(r, raw[start:]); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, raw[:start+n], err
+		}
+		msg = append(msg, raw[start:]...)
+
+		if msgLen < 0 && len(msg) >= 4 {
+			if msg[0] != typeClientHello {
+				return nil, raw, errors.New("tls: handshake message is not a ClientHello")
+			}
+			msgLen = 4 + (int(msg[1])<<16 | int(msg[2])<<8 | int(msg[3]))
+			if msgLen > 0xffff {
+				return nil, raw, errors.New("tls: ClientHello is too large")
+			}
+		}
+	}
+	if len(msg) > msgLen {
+		return nil, raw, errors.New("tls: unexpected data after the ClientHello")
+	}
+
+	// RawClientHello takes the ClientHello as a single record.
+	record := append([]byte{byte(recordTypeHandshake), byte(recordVersion >> 8), byte(recordVersion),
+		byte(len(msg) >> 8), byte(len(msg))}, msg...)
+	spec, err := f.RawClientHello(record)
+	if err != nil {
+		return nil, raw, err
+	}
+	return spec, raw, nil
+}
+
+// normalizeGREASE replaces the GREASE values in chs with GREASE_PLACEHOLDER.
+func (chs *ClientHelloSpec) normalizeGREASE() {
+	for i, suite := range chs.CipherSuites {
+		chs.CipherSuites[i] = unGREASEUint16(suite)
+	}
+	for _, ext := range chs.Extensions {
+		switch e := ext.(type) {
+		case *UtlsGREASEExtension:
+			e.Value = GREASE_PLACEHOLDER
+		case *SupportedCurvesExtension:
+			for i, curve := range e.Curves {
+				e.Curves[i] = CurveID(unGREASEUint16(uint16(curve)))
+			}
+		case *KeyShareExtension:
+			for i := range e.KeyShares {
+				e.KeyShares[i].Group = CurveID(unGREASEUint16(uint16(e.KeyShares[i].Group)))
+			}
+		case *SupportedVersionsExtension:
+			for i, vers := range e.Versions {
+				e.Versions[i] = unGREASEUint16(vers)
+			}
+		case *SignatureAlgorithmsExtension:
+			for i, scheme := range e.SupportedSignatureAlgorithms {
+				e.SupportedSignatureAlgorithms[i] = SignatureScheme(unGREASEUint16(uint16(scheme)))
+			}
+		}
+	}
+}
+
+// GREASEPositions are the indexes of GREASE values in the lists of a
+// ClientHelloSpec, see ClientHelloSpec.GREASEPositions.
+type GREASEPositions struct {
+	CipherSuites        []int
+	Extensions          []int // in ClientHelloSpec.Extensions
+	SupportedGroups     []int
+	KeyShares           []int
+	SupportedVersions   []int
+	SignatureAlgorithms []int
+}
+
+// GREASEPositions returns where GREASE values appear in chs, e.g. in a spec
+// returned by a Fingerprinter, to find the GREASE slots of a new browser
+// version. GREASE_PLACEHOLDER counts as GREASE, so it works the same with
+// Fingerprinter.NormalizeGREASE.
+func (chs *ClientHelloSpec) GREASEPositions() GREASEPositions {
+	var pos GREASEPositions
+	for i, suite := range chs.CipherSuites {
+		if isGREASEUint16(suite) {
+			pos.CipherSuites = append(pos.CipherSuites, i)
+		}
+	}
+	for i, ext := range chs.Extensions {
+		if id, ok := extensionIDOf(ext); ok && isGREASEUint16(id) {
+			pos.Extensions = append(pos.Extensions, i)
+		}
+		switch e := ext.(type) {
+		case *SupportedCurvesExtension:
+			for j, curve := range e.Curves {
+				if isGREASEUint16(uint16(curve)) {
+					pos.SupportedGroups = append(pos.SupportedGroups, j)
+				}
+			}
+		case *KeyShareExtension:
+			for j, ks := range e.KeyShares {
+				if isGREASEUint16(uint16(ks.Group)) {
+					pos.KeyShares = append(pos.KeyShares, j)
+				}
+			}
+		case *SupportedVersionsExtension:
+			for j, vers := range e.Versions {
+				if isGREASEUint16(vers) {
+					pos.SupportedVersions = append(pos.SupportedVersions, j)
+				}
+			}
+		case *SignatureAlgorithmsExtension:
+			for j, scheme := range e.SupportedSignatureAlgorithms {
+				if isGREASEUint16(uint16(scheme)) {
+					pos.SignatureAlgorithms = append(pos.SignatureAlgorithms, j)
+				}
+			}
+		}
+	}
+	return pos
+}
+
 // UnmarshalJSONClientHello returns a ClientHelloSpec which is based on the
 // ClientHello JSON bytes that is passed in as the json argument.
 func (f *Fingerprinter) UnmarshalJSONClientHello(json []byte) (clientHelloSpec *ClientHelloSpec, err error) {
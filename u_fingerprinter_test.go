@@ -8,9 +8,12 @@ import (
 	"bytes"
 	"encoding/hex"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net"
 	"reflect"
+	"slices"
+	"strings"
 	"testing"
 )
 
@@ -210,7 +213,7 @@ func checkUTLSFingerPrintClientHello(t *testing.T, clientHelloID ClientHelloID,
 	}
 
 	// We can't effectively check the extensions on randomized client hello ids
-	if !(clientHelloID == HelloRandomized || clientHelloID == HelloRandomizedALPN || clientHelloID == HelloRandomizedNoALPN) {
+	if !(clientHelloID == HelloRandomized || clientHelloID == HelloRandomizedALPN || clientHelloID == HelloRandomizedNoALPN || clientHelloID == HelloRandomizedFixedALPN) {
 		for i, originalExtension := range uconn.Extensions {
 			if _, ok := originalExtension.(*UtlsPaddingExtension); ok {
 				// We can't really compare padding extensions in this way
@@ -235,7 +238,7 @@ func checkUTLSFingerPrintClientHello(t *testing.T, clientHelloID ClientHelloID,
 
 func TestUTLSFingerprintClientHello(t *testing.T) {
 	clientHellosToTest := []ClientHelloID{
-		HelloChrome_58, HelloChrome_70, HelloChrome_83, HelloFirefox_55, HelloFirefox_63, HelloIOS_11_1, HelloIOS_12_1, HelloRandomized, HelloRandomizedALPN, HelloRandomizedNoALPN}
+		HelloChrome_58, HelloChrome_70, HelloChrome_83, HelloFirefox_55, HelloFirefox_63, HelloIOS_11_1, HelloIOS_12_1, HelloRandomized, HelloRandomizedALPN, HelloRandomizedNoALPN, HelloRandomizedFixedALPN}
 
 	serverNames := []string{"foobar"}
 
@@ -290,6 +293,142 @@ func TestUTLSFingerprintClientHelloBluntMimicry(t *testing.T) {
 	t.Errorf("generated ClientHelloSpec with BluntMimicry did not correctly carry over generic extension")
 }
 
+func TestUTLSFingerprintClientHelloPreserveUnknownExtensions(t *testing.T) {
+	spec, err := utlsIdToSpec(HelloFirefox_105)
+	if err != nil {
+		t.Fatalf("got error: %v; expected to succeed", err)
+	}
+	// an unknown extension in the middle, and a known one in a format we can't parse
+	spec.Extensions = append(spec.Extensions[:3], append([]TLSExtension{
+		&GenericExtension{0xfeed, []byte("random data")},
+		&GenericExtension{extensionSupportedVersions, []byte{0x01, 0x03}},
+	}, spec.Extensions[3:]...)...)
+	for i, ext := range spec.Extensions {
+		if _, ok := ext.(*SupportedVersionsExtension); ok {
+			spec.Extensions = append(spec.Extensions[:i], spec.Extensions[i+1:]...)
+			break
+		}
+	}
+	spec.TLSVersMin, spec.TLSVersMax = VersionTLS10, VersionTLS12
+
+	clientRandom := make([]byte, 32)
+	uconn := UClient(&net.TCPConn{}, &Config{ServerName: "foobar"}, HelloCustom)
+	if err := uconn.ApplyPreset(&spec); err != nil {
+		t.Fatalf("got error: %v; expected to succeed", err)
+	}
+	if err := uconn.SetClientRandom(clientRandom); err != nil {
+		t.Fatalf("got error: %v; expected to succeed", err)
+	}
+	if err := uconn.BuildHandshakeState(); err != nil {
+		t.Fatalf("got error: %v; expected to succeed", err)
+	}
+	captured := uconn.HandshakeState.Hello.Raw
+
+	f := &Fingerprinter{}
+	if _, err := f.RawClientHello(prependRecordHeader(captured, VersionTLS10)); err == nil {
+		t.Fatalf("expected error generating spec from client hello with unknown extensions")
+	}
+
+	f = &Fingerprinter{PreserveUnknownExtensions: true}
+	generatedSpec, err := f.RawClientHello(prependRecordHeader(captured, VersionTLS10))
+	if err != nil {
+		t.Fatalf("got error: %v; expected to succeed", err)
+	}
+	for i, ext := range generatedSpec.Extensions {
+		// reuse the captured key material
+		if ks, ok := ext.(*KeyShareExtension); ok {
+			ks.KeyShares = uconn.Extensions[i].(*KeyShareExtension).KeyShares
+		}
+	}
+
+	generatedUConn := UClient(&net.TCPConn{}, &Config{ServerName: "foobar"}, HelloCustom)
+	if err := generatedUConn.ApplyPreset(generatedSpec); err != nil {
+		t.Fatalf("got error: %v; expected to succeed", err)
+	}
+	if err := generatedUConn.SetClientRandom(clientRandom); err != nil {
+		t.Fatalf("got error: %v; expected to succeed", err)
+	}
+	if err := generatedUConn.BuildHandshakeState(); err != nil {
+		t.Fatalf("got error: %v; expected to succeed", err)
+	}
+	generatedUConn.HandshakeState.Hello.SessionId = uconn.HandshakeState.Hello.SessionId
+	if err := generatedUConn.MarshalClientHello(); err != nil {
+		t.Fatalf("got error: %v; expected to succeed", err)
+	}
+
+	if !bytes.Equal(captured, generatedUConn.HandshakeState.Hello.Raw) {
+		t.Errorf("rebuilt ClientHello differs from the captured one:\n%x\n%x", captured, generatedUConn.HandshakeState.Hello.Raw)
+	}
+}
+
+func TestUTLSFingerprintClientHelloNormalizeGREASE(t *testing.T) {
+	spec, err := utlsIdToSpec(HelloChrome_131)
+	if err != nil {
+		t.Fatalf("got error: %v; expected to succeed", err)
+	}
+	for _, ext := range spec.Extensions {
+		if e, ok := ext.(*SignatureAlgorithmsExtension); ok {
+			e.SupportedSignatureAlgorithms = append([]SignatureScheme{GREASE_PLACEHOLDER}, e.SupportedSignatureAlgorithms...)
+		}
+	}
+	uconn := UClient(&net.TCPConn{}, &Config{ServerName: "foobar"}, HelloCustom)
+	if err := uconn.ApplyPreset(&spec); err != nil {
+		t.Fatalf("got error: %v; expected to succeed", err)
+	}
+	if err := uconn.BuildHandshakeState(); err != nil {
+		t.Fatalf("got error: %v; expected to succeed", err)
+	}
+	raw := prependRecordHeader(uconn.HandshakeState.Hello.Raw, VersionTLS10)
+
+	f := &Fingerprinter{NormalizeGREASE: true}
+	generatedSpec, err := f.FingerprintClientHello(raw)
+	if err != nil {
+		t.Fatalf("got error: %v; expected to succeed", err)
+	}
+
+	var grease []uint16
+	if isGREASEUint16(generatedSpec.CipherSuites[0]) {
+		grease = append(grease, generatedSpec.CipherSuites[0])
+	}
+	for _, ext := range generatedSpec.Extensions {
+		switch e := ext.(type) {
+		case *UtlsGREASEExtension:
+			grease = append(grease, e.Value)
+		case *SupportedCurvesExtension:
+			grease = append(grease, uint16(e.Curves[0]))
+		case *KeyShareExtension:
+			grease = append(grease, uint16(e.KeyShares[0].Group))
+		case *SupportedVersionsExtension:
+			grease = append(grease, e.Versions[0])
+		case *SignatureAlgorithmsExtension:
+			grease = append(grease, uint16(e.SupportedSignatureAlgorithms[0]))
+		}
+	}
+	// cipher suite, 2 GREASE extensions, curve, key share, version and signature algorithm
+	if len(grease) != 7 {
+		t.Fatalf("got GREASE values %x, expected 7", grease)
+	}
+	for _, v := range grease {
+		if v != GREASE_PLACEHOLDER {
+			t.Errorf("got GREASE values %x, expected all to be GREASE_PLACEHOLDER", grease)
+			break
+		}
+	}
+
+	// the spec gets fresh GREASE when it is applied
+	other := UClient(&net.TCPConn{}, &Config{ServerName: "foobar"}, HelloCustom)
+	if err := other.ApplyPreset(generatedSpec); err != nil {
+		t.Fatalf("got error: %v; expected to succeed", err)
+	}
+	if err := other.BuildHandshakeState(); err != nil {
+		t.Fatalf("got error: %v; expected to succeed", err)
+	}
+	sigAlgs := other.HandshakeState.Hello.SupportedSignatureAlgorithms
+	if len(sigAlgs) == 0 || !isGREASEUint16(uint16(sigAlgs[0])) {
+		t.Errorf("got signature algorithms %v, expected a leading GREASE value", sigAlgs)
+	}
+}
+
 func TestUTLSFingerprintClientHelloAlwaysAddPadding(t *testing.T) {
 	serverName := "foobar"
 
@@ -372,6 +511,44 @@ func TestUTLSFingerprintClientHelloAlwaysAddPadding(t *testing.T) {
 	}
 }
 
+// chromeResumptionHelloHex is a ClientHello sent by Chrome to resume a session
+// with edgeapi.slack.com, see TestUTLSFingerprintClientHelloKeepPSK.
+const chromeResumptionHelloHex = "16030102400100023c03035cef5aa9122008e37f0f74d717cd4ae0f745daba4292e6fbca3cd5bf9123498f208c4aa23444084eeb70097efe0b8f6e3a56c717abd67505c950aab314de59bd8f00204a4a130113021303c02bc02fc02cc030cca9cca8c013c014009c009d002f0035010001d33a3a0000000000160014000011656467656170692e736c61636b2e636f6d00170000ff01000100000a000a0008dada001d00170018000b00020100002300000010000e000c02683208687474702f312e31000500050100000000000d0012001004030804040105030805050108060601001200000033002b0029dada000100001d0020e35e636d4e2dcd5f39309170285dab92dbe81fefe4926826cec1ef881321687e002d00020101002b000b0a2a2a0304030303020301001b00030200024a4a0001000029010b00e600e017fab59672c1966ae78fc4dacd7efb42e735de956e3f96d342bb8e63a5233ce21c92d6d75036601d74ccbc3ca0085f3ac2ebbd83da13501ac3c6d612bcb453fb206a39a8112d768bea1976d7c14e6de9aa0ee70ea732554d3c57d1a993f1044a46c1fb371811039ef30582cacf41bd497121d67793b8ee4df7a60d525f7df052fd66cda7f141bb553d9253816752d923ac7c71426179db4f26a7d42f0d65a2dd2dbaafb86fa17b2da23fd57c5064c76551cfda86304051231e4da9e697fedbcb5ae8cb2f6cb92f71164acf2edff5bccc1266cd648a53cc46262eabf40727bcb6958a3d1300212083e99d791672d39919dcb387f2fa7aeee938ec32ecf4b861306f7df4f9a8a746"
+
+func TestUTLSGREASEPositions(t *testing.T) {
+	helloBytes, err := hex.DecodeString(chromeResumptionHelloHex)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, normalize := range []bool{false, true} {
+		f := &Fingerprinter{NormalizeGREASE: normalize}
+		spec, err := f.FingerprintClientHello(helloBytes)
+		if err != nil {
+			t.Fatalf("got error: %v; expected to succeed", err)
+		}
+		// Chrome GREASEs the first cipher suite, group, key share and
+		// version, and the first and last extensions before pre_shared_key.
+		expected := GREASEPositions{
+			CipherSuites:      []int{0},
+			Extensions:        []int{0, len(spec.Extensions) - 2},
+			SupportedGroups:   []int{0},
+			KeyShares:         []int{0},
+			SupportedVersions: []int{0},
+		}
+		if _, ok := spec.Extensions[len(spec.Extensions)-1].(*FakePreSharedKeyExtension); !ok {
+			t.Fatalf("got last extension %T, expected pre_shared_key", spec.Extensions[len(spec.Extensions)-1])
+		}
+		if pos := spec.GREASEPositions(); !reflect.DeepEqual(pos, expected) {
+			t.Errorf("NormalizeGREASE %v: got GREASE positions %+v, expected %+v", normalize, pos, expected)
+		}
+	}
+
+	spec := mustSpec(t, HelloFirefox_120)
+	if pos := spec.GREASEPositions(); !reflect.DeepEqual(pos, GREASEPositions{}) {
+		t.Errorf("got GREASE positions %+v for Firefox, expected none", pos)
+	}
+}
+
 func TestUTLSFingerprintClientHelloKeepPSK(t *testing.T) {
 	// TLSv1.3 Record Layer: Handshake Protocol: Client Hello
 	//     Content Type: Handshake (22)
@@ -491,7 +668,7 @@ func TestUTLSFingerprintClientHelloKeepPSK(t *testing.T) {
 	// 				Length: 267
 	// 				Pre-Shared Key extension
 
-	byteString := []byte("16030102400100023c03035cef5aa9122008e37f0f74d717cd4ae0f745daba4292e6fbca3cd5bf9123498f208c4aa23444084eeb70097efe0b8f6e3a56c717abd67505c950aab314de59bd8f00204a4a130113021303c02bc02fc02cc030cca9cca8c013c014009c009d002f0035010001d33a3a0000000000160014000011656467656170692e736c61636b2e636f6d00170000ff01000100000a000a0008dada001d00170018000b00020100002300000010000e000c02683208687474702f312e31000500050100000000000d0012001004030804040105030805050108060601001200000033002b0029dada000100001d0020e35e636d4e2dcd5f39309170285dab92dbe81fefe4926826cec1ef881321687e002d00020101002b000b0a2a2a0304030303020301001b00030200024a4a0001000029010b00e600e017fab59672c1966ae78fc4dacd7efb42e735de956e3f96d342bb8e63a5233ce21c92d6d75036601d74ccbc3ca0085f3ac2ebbd83da13501ac3c6d612bcb453fb206a39a8112d768bea1976d7c14e6de9aa0ee70ea732554d3c57d1a993f1044a46c1fb371811039ef30582cacf41bd497121d67793b8ee4df7a60d525f7df052fd66cda7f141bb553d9253816752d923ac7c71426179db4f26a7d42f0d65a2dd2dbaafb86fa17b2da23fd57c5064c76551cfda86304051231e4da9e697fedbcb5ae8cb2f6cb92f71164acf2edff5bccc1266cd648a53cc46262eabf40727bcb6958a3d1300212083e99d791672d39919dcb387f2fa7aeee938ec32ecf4b861306f7df4f9a8a746")
+	byteString := []byte(chromeResumptionHelloHex)
 
 	helloBytes := make([]byte, hex.DecodedLen(len(byteString)))
 	_, err := hex.Decode(helloBytes, byteString)
@@ -515,6 +692,258 @@ func TestUTLSFingerprintClientHelloKeepPSK(t *testing.T) {
 	t.Errorf("generated ClientHelloSpec with KeepPSK does not include preshared key extension")
 }
 
+func TestUTLSFingerprintClientHelloPSKLast(t *testing.T) {
+	helloBytes, err := hex.DecodeString(chromeResumptionHelloHex)
+	if err != nil {
+		t.Fatalf("got error: %v; expected to succeed", err)
+	}
+
+	for _, realPSK := range []bool{false, true} {
+		f := &Fingerprinter{RealPSKResumption: realPSK, AlwaysAddPadding: true}
+		spec, err := f.FingerprintClientHello(helloBytes)
+		if err != nil {
+			t.Fatalf("RealPSKResumption %v: got error: %v; expected to succeed", realPSK, err)
+		}
+
+		last := spec.Extensions[len(spec.Extensions)-1]
+		switch psk := last.(type) {
+		case *UtlsPreSharedKeyExtension:
+			if !realPSK {
+				t.Errorf("got UtlsPreSharedKeyExtension, expected FakePreSharedKeyExtension")
+			}
+		case *FakePreSharedKeyExtension:
+			if realPSK {
+				t.Errorf("got FakePreSharedKeyExtension, expected UtlsPreSharedKeyExtension")
+			}
+			if len(psk.Identities) != 1 || len(psk.Identities[0].Label) != 224 || len(psk.Binders) != 1 || len(psk.Binders[0]) != 32 {
+				t.Errorf("got %d identities and %d binders, expected one 224 byte identity and one 32 byte binder", len(psk.Identities), len(psk.Binders))
+			}
+		default:
+			t.Fatalf("RealPSKResumption %v: last extension is %T, expected pre_shared_key", realPSK, last)
+		}
+
+		// the spec must not alias the captured bytes, which may be reused
+		if psk, ok := last.(*FakePreSharedKeyExtension); ok {
+			scratch := bytes.Clone(helloBytes)
+			spec, _ := f.FingerprintClientHello(scratch)
+			clear(scratch)
+			if binder := spec.Extensions[len(spec.Extensions)-1].(*FakePreSharedKeyExtension).Binders[0]; !bytes.Equal(binder, psk.Binders[0]) {
+				t.Errorf("FakePreSharedKeyExtension binder aliases the fingerprinted hello")
+			}
+		}
+
+		uconn := UClient(&net.TCPConn{}, &Config{ServerName: "edgeapi.slack.com"}, HelloCustom)
+		if err := uconn.ApplyPreset(spec); err != nil {
+			t.Fatalf("RealPSKResumption %v: got error: %v; expected to succeed", realPSK, err)
+		}
+		if _, ok := uconn.Extensions[len(uconn.Extensions)-1].(PreSharedKeyExtension); !ok {
+			t.Errorf("RealPSKResumption %v: pre_shared_key is not the last extension after ApplyPreset", realPSK)
+		}
+	}
+}
+
+func TestUTLSFingerprintClientHelloPSKNotLast(t *testing.T) {
+	helloBytes, err := hex.DecodeString(chromeResumptionHelloHex)
+	if err != nil {
+		t.Fatalf("got error: %v; expected to succeed", err)
+	}
+
+	// append an empty extended_master_secret after pre_shared_key, adjusting
+	// the record, handshake and extensions lengths
+	helloBytes = append(helloBytes, 0x00, 0x17, 0x00, 0x00)
+	for _, off := range []int{3, 7, 112} {
+		l := int(helloBytes[off])<<8 | int(helloBytes[off+1])
+		l += 4
+		helloBytes[off], helloBytes[off+1] = byte(l>>8), byte(l)
+	}
+
+	for _, f := range []*Fingerprinter{{}, {RealPSKResumption: true}, {PreserveUnknownExtensions: true}} {
+		if _, err := f.FingerprintClientHello(helloBytes); err == nil {
+			t.Errorf("%+v: fingerprinted a hello with extensions after pre_shared_key; expected error", *f)
+		}
+	}
+}
+
+func TestUTLSFingerprintFromReader(t *testing.T) {
+	uconn := UClient(&net.TCPConn{}, &Config{ServerName: "example.com"}, HelloChrome_120)
+	if err := uconn.BuildHandshakeState(); err != nil {
+		t.Fatalf("got error: %v; expected to succeed", err)
+	}
+	hello := uconn.HandshakeState.Hello.Raw
+	expected, err := (&Fingerprinter{}).FingerprintClientHello(prependRecordHeader(hello, VersionTLS10))
+	if err != nil {
+		t.Fatalf("got error: %v; expected to succeed", err)
+	}
+
+	// fragment the ClientHello, with the handshake message header split
+	// across the first two records
+	var records []byte
+	for _, fragment := range [][]byte{hello[:2], hello[2:100], hello[100:]} {
+		records = append(records, prependRecordHeader(fragment, VersionTLS10)...)
+	}
+	next := []byte{byte(recordTypeChangeCipherSpec), 3, 3, 0, 1, 1}
+	r := bytes.NewReader(append(slices.Clone(records), next...))
+	spec, raw, err := (&Fingerprinter{}).FingerprintFromReader(r)
+	if err != nil {
+		t.Fatalf("got error: %v; expected to succeed", err)
+	}
+	if !bytes.Equal(raw, records) {
+		t.Errorf("got raw bytes %x, expected %x", raw, records)
+	}
+	if rest, _ := io.ReadAll(r); !bytes.Equal(rest, next) {
+		t.Errorf("%x was left in the reader, expected %x", rest, next)
+	}
+	if spec.JA3() != expected.JA3() || len(spec.Extensions) != len(expected.Extensions) {
+		t.Fatalf("got JA3 %s, expected %s", spec.JA3(), expected.JA3())
+	}
+	for i, ext := range spec.Extensions {
+		if _, ok := ext.(*GREASEEncryptedClientHelloExtension); ok {
+			continue // its payload is drawn when it is serialized
+		}
+		got, _ := io.ReadAll(ext)
+		want, _ := io.ReadAll(expected.Extensions[i])
+		if !bytes.Equal(got, want) {
+			t.Errorf("got extension %x, expected %x", got, want)
+		}
+	}
+
+	for _, test := range []struct {
+		name string
+		data []byte
+		raw  int // number of bytes read before the error
+	}{
+		{"truncated", records[:len(records)-1], len(records) - 1},
+		{"not handshake", next, recordHeaderLen},
+		{"not ClientHello", prependRecordHeader([]byte{typeServerHello, 0, 0, 0}, VersionTLS12), recordHeaderLen + 4},
+		{"trailing data", prependRecordHeader(append(slices.Clone(hello), typeFinished, 0, 0, 0), VersionTLS10), recordHeaderLen + len(hello) + 4},
+	} {
+		if _, raw, err := (&Fingerprinter{}).FingerprintFromReader(bytes.NewReader(test.data)); err == nil {
+			t.Errorf("%s: got no error", test.name)
+		} else if !bytes.Equal(raw, test.data[:test.raw]) {
+			t.Errorf("%s: got raw bytes %x, expected %x", test.name, raw, test.data[:test.raw])
+		}
+	}
+}
+
+// maskGREASE returns a copy of data with the GREASE values in lists of
+// uint16, like supported_groups, replaced by GREASE_PLACEHOLDER.
+func maskGREASE(data []byte) []byte {
+	masked := bytes.Clone(data)
+	for i := 0; i+1 < len(masked); i++ {
+		if isGREASEUint16(uint16(masked[i])<<8 | uint16(masked[i+1])) {
+			masked[i], masked[i+1] = GREASE_PLACEHOLDER>>8, GREASE_PLACEHOLDER&0xff
+			i++
+		}
+	}
+	return masked
+}
+
+func TestUTLSFingerprintAndRegenerateKeys(t *testing.T) {
+	capture := UClient(&net.TCPConn{}, &Config{ServerName: "capture.example.golang"}, HelloChrome_100)
+	if err := capture.BuildHandshakeState(); err != nil {
+		t.Fatalf("got error: %v; expected to succeed", err)
+	}
+	captured := capture.HandshakeState.Hello.Raw
+
+	spec, err := (&Fingerprinter{}).FingerprintAndRegenerateKeys(prependRecordHeader(captured, VersionTLS10))
+	if err != nil {
+		t.Fatalf("got error: %v; expected to succeed", err)
+	}
+
+	c, s := localPipe(t)
+	done := make(chan error, 1)
+	go func() {
+		server := Server(s, testConfig.Clone())
+		defer server.Close()
+		done <- server.Handshake()
+	}()
+	uconn := UClient(c, &Config{ServerName: "example.golang", InsecureSkipVerify: true}, HelloCustom)
+	defer uconn.Close()
+	if err := uconn.ApplyPreset(spec); err != nil {
+		t.Fatalf("got error: %v; expected to succeed", err)
+	}
+	if err := uconn.Handshake(); err != nil {
+		t.Fatalf("client handshake with the replayed ClientHello failed: %v", err)
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("server handshake failed: %v", err)
+	}
+	replayed := uconn.HandshakeState.Hello.Raw
+
+	// the server name is shorter, and the padding makes up for it
+	if len(replayed) != len(captured) {
+		t.Errorf("got a %d byte ClientHello, expected the captured %d bytes", len(replayed), len(captured))
+	}
+	capturedExts := clientHelloRawExtensions(t, captured)
+	replayedExts := clientHelloRawExtensions(t, replayed)
+	if len(replayedExts) != len(capturedExts) {
+		t.Fatalf("got %d extensions, expected the captured %d", len(replayedExts), len(capturedExts))
+	}
+	for i, ext := range replayedExts {
+		want := capturedExts[i]
+		if unGREASEUint16(ext.extType) != unGREASEUint16(want.extType) {
+			t.Errorf("extension %d: got type %d, expected %d", i, ext.extType, want.extType)
+			continue
+		}
+		switch {
+		case isGREASEUint16(ext.extType), ext.extType == extensionServerName, ext.extType == utlsExtensionPadding:
+		case ext.extType == extensionKeyShare:
+			var captureShares, replayShares KeyShareExtension
+			if _, err := captureShares.Write(want.data); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := replayShares.Write(ext.data); err != nil {
+				t.Fatal(err)
+			}
+			if len(replayShares.KeyShares) != len(captureShares.KeyShares) || len(ext.data) != len(want.data) {
+				t.Errorf("got key_share %x, expected the groups and sizes of %x", ext.data, want.data)
+			} else if bytes.Equal(ext.data, want.data) {
+				t.Error("got the captured key shares, expected fresh ones")
+			}
+		default:
+			if !bytes.Equal(maskGREASE(ext.data), maskGREASE(want.data)) {
+				t.Errorf("extension %d: got %x, expected the captured %x", ext.extType, ext.data, want.data)
+			}
+		}
+	}
+}
+
+func TestUTLSFingerprintAndRegenerateKeysLongHello(t *testing.T) {
+	// a 700 byte ClientHello with padding, which BoringPaddingStyle never sends
+	captureSpec := mustSpec(t, HelloChrome_100)
+	for _, ext := range captureSpec.Extensions {
+		if e, ok := ext.(*UtlsPaddingExtension); ok {
+			e.GetPaddingLen = AlwaysPadToLen(700)
+		}
+	}
+	capture := UClient(&net.TCPConn{}, &Config{ServerName: strings.Repeat("a", 300) + ".example.golang"}, HelloCustom)
+	if err := capture.ApplyPreset(captureSpec); err != nil {
+		t.Fatalf("got error: %v; expected to succeed", err)
+	}
+	if err := capture.BuildHandshakeState(); err != nil {
+		t.Fatalf("got error: %v; expected to succeed", err)
+	}
+	captured := capture.HandshakeState.Hello.Raw
+	if len(captured) != 700 {
+		t.Fatalf("got a %d byte capture, expected 700 bytes", len(captured))
+	}
+
+	spec, err := (&Fingerprinter{}).FingerprintAndRegenerateKeys(prependRecordHeader(captured, VersionTLS10))
+	if err != nil {
+		t.Fatalf("got error: %v; expected to succeed", err)
+	}
+	uconn := UClient(&net.TCPConn{}, &Config{ServerName: "example.golang"}, HelloCustom)
+	if err := uconn.ApplyPreset(spec); err != nil {
+		t.Fatalf("got error: %v; expected to succeed", err)
+	}
+	if err := uconn.BuildHandshakeState(); err != nil {
+		t.Fatalf("got error: %v; expected to succeed", err)
+	}
+	if n := len(uconn.HandshakeState.Hello.Raw); n != len(captured) {
+		t.Errorf("got a %d byte ClientHello, expected the captured %d bytes", n, len(captured))
+	}
+}
+
 func TestUTLSHandshakeClientFingerprintedSpecFromChrome_58(t *testing.T) {
 	helloID := HelloChrome_58
 	serverName := "foobar"
@@ -729,3 +1158,70 @@ func TestFingerprintDumpLargerThanExtensions(t *testing.T) {
 		t.Error("clientHelloSpec cannot be nil")
 	}
 }
+
+func FuzzFingerprintClientHello(f *testing.F) {
+	for _, id := range parrotHelloIDs {
+		hello, err := NewClientHelloBuilder(&Config{ServerName: "example.com", OmitEmptyPsk: true}, id).MarshalClientHello()
+		if err != nil {
+			continue // e.g. the parrot needs a newer Go version
+		}
+		f.Add(prependRecordHeader(hello, VersionTLS10))
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		for _, fp := range []*Fingerprinter{
+			{},
+			{AllowBluntMimicry: true, RealPSKResumption: true, NormalizeGREASE: true},
+			{PreserveUnknownExtensions: true, AlwaysAddPadding: true},
+		} {
+			spec, err := fp.FingerprintClientHello(data)
+			if err != nil {
+				continue
+			}
+			// a parsed ClientHello can be serialized again
+			for _, ext := range spec.Extensions {
+				if _, ok := ext.(*UtlsPaddingExtension); ok {
+					continue // its length depends on the rest of the ClientHello
+				}
+				b := make([]byte, ext.Len())
+				if _, err := ext.Read(b); err != nil && err != io.EOF {
+					t.Errorf("failed to serialize parsed %T: %v", ext, err)
+				}
+			}
+		}
+	})
+}
+
+func TestUTLSFingerprintMalformedExtensions(t *testing.T) {
+	binder := bytes.Repeat([]byte{0xbb}, 32)
+	psk := func(identities, binders []byte) []byte {
+		b := append([]byte{byte(len(identities) >> 8), byte(len(identities))}, identities...)
+		b = append(b, byte(len(binders)>>8), byte(len(binders)))
+		return append(b, binders...)
+	}
+	identity := []byte{0, 2, 0xaa, 0xaa, 0, 0, 0, 1}
+	for _, test := range []struct {
+		name string
+		ext  TLSExtensionWriter
+		data []byte
+	}{
+		{"short PSK binder", &FakePreSharedKeyExtension{}, psk(identity, []byte{2, 0xbb, 0xbb})},
+		{"PSK without identities", &FakePreSharedKeyExtension{}, psk(nil, append([]byte{32}, binder...))},
+		{"PSK with more binders", &FakePreSharedKeyExtension{}, psk(identity, append(append([]byte{32}, binder...), append([]byte{32}, binder...)...))},
+		{"PSK identity overflowing", &FakePreSharedKeyExtension{}, psk([]byte{0}, append([]byte{32}, binder...))},
+		{"short ECH payload", &GREASEEncryptedClientHelloExtension{}, []byte{0, 0, 1, 0, 1, 0x42, 0, 1, 0xee, 0, 4, 1, 2, 3, 4}},
+	} {
+		if _, err := test.ext.Write(test.data); err == nil {
+			t.Errorf("%s: got no error, expected the extension to be rejected", test.name)
+		}
+	}
+
+	ext := &FakePreSharedKeyExtension{}
+	if _, err := ext.Write(psk(identity, append([]byte{32}, binder...))); err != nil {
+		t.Fatalf("got error: %v; expected to succeed", err)
+	}
+	if len(ext.Identities) != 1 || !bytes.Equal(ext.Identities[0].Label, []byte{0xaa, 0xaa}) || ext.Identities[0].ObfuscatedTicketAge != 1 ||
+		len(ext.Binders) != 1 || !bytes.Equal(ext.Binders[0], binder) {
+		t.Errorf("got identities %v and binders %x, expected one of each", ext.Identities, ext.Binders)
+	}
+}
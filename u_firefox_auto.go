@@ -0,0 +1,11 @@
+// Copyright 2024 The uTLS Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build go1.24
+
+package tls
+
+// HelloFirefox_Auto is HelloFirefox_133, whose X25519MLKEM768 key share
+// requires crypto/mlkem, see u_firefox_auto_go123.go for older Go versions.
+var HelloFirefox_Auto = HelloFirefox_133
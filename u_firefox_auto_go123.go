@@ -0,0 +1,11 @@
+// Copyright 2024 The uTLS Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !go1.24
+
+package tls
+
+// HelloFirefox_Auto is HelloFirefox_120 before Go 1.24, as the X25519MLKEM768
+// key share of HelloFirefox_133 requires crypto/mlkem.
+var HelloFirefox_Auto = HelloFirefox_120
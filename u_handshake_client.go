@@ -7,9 +7,11 @@ package tls
 import (
 	"bytes"
 	"compress/zlib"
+	"crypto/subtle"
 	"errors"
 	"fmt"
 	"io"
+	"slices"
 
 	"github.com/andybalholm/brotli"
 	"github.com/klauspost/compress/zstd"
@@ -32,6 +34,7 @@ func (hs *clientHandshakeStateTLS13) utlsReadServerCertificate(msg any) (process
 					if err != nil {
 						return nil, fmt.Errorf("tls: failed to decompress certificate message: %w", err)
 					} else {
+						hs.c.utls.certCompressionUsed = CertCompressionAlgo(compressedCertMsg.algorithm)
 						return msg, nil
 					}
 				}
@@ -90,23 +93,31 @@ func (hs *clientHandshakeStateTLS13) decompressCert(m utlsCompressedCertificateM
 		return nil, fmt.Errorf("unsupported algorithm (%d)", m.algorithm)
 	}
 
+	// The uncompressed message is subject to the same limit as an
+	// uncompressed Certificate message would be.
+	if m.uncompressedLength > maxHandshake {
+		c.sendAlert(alertBadCertificate)
+		return nil, fmt.Errorf("uncompressed len (%d) exceeds maximum of %d bytes", m.uncompressedLength, maxHandshake)
+	}
+
 	rawMsg := make([]byte, m.uncompressedLength+4) // +4 for message type and uint24 length field
 	rawMsg[0] = typeCertificate
 	rawMsg[1] = uint8(m.uncompressedLength >> 16)
 	rawMsg[2] = uint8(m.uncompressedLength >> 8)
 	rawMsg[3] = uint8(m.uncompressedLength)
 
-	n, err := decompressed.Read(rawMsg[4:])
-	if err != nil && !errors.Is(err, io.EOF) {
+	// Decompressors may return fewer bytes than requested from a single Read.
+	n, err := io.ReadFull(decompressed, rawMsg[4:])
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
 		c.sendAlert(alertBadCertificate)
 		return nil, err
 	}
-	if n < len(rawMsg)-4 {
+	if n < len(rawMsg)-4 || err == nil && !decompressorDrained(decompressed) {
 		// If, after decompression, the specified length does not match the actual length, the party
 		// receiving the invalid message MUST abort the connection with the "bad_certificate" alert.
 		// https://datatracker.ietf.org/doc/html/rfc8879#section-4
 		c.sendAlert(alertBadCertificate)
-		return nil, fmt.Errorf("decompressed len (%d) does not match specified len (%d)", n, m.uncompressedLength)
+		return nil, fmt.Errorf("decompressed len does not match specified len (%d)", m.uncompressedLength)
 	}
 	certMsg := new(certificateMsgTLS13)
 	if !certMsg.unmarshal(rawMsg) {
@@ -115,9 +126,19 @@ func (hs *clientHandshakeStateTLS13) decompressCert(m utlsCompressedCertificateM
 	return certMsg, nil
 }
 
+// decompressorDrained reports whether r has no data left.
+func decompressorDrained(r io.Reader) bool {
+	var b [1]byte
+	n, _ := io.ReadFull(r, b[:])
+	return n == 0
+}
+
 // to be called in (*clientHandshakeStateTLS13).handshake(),
 // after hs.readServerFinished() and before hs.sendClientCertificate()
 func (hs *clientHandshakeStateTLS13) serverFinishedReceived() error {
+	if err := hs.sendEndOfEarlyData(); err != nil {
+		return err
+	}
 	if err := hs.sendClientEncryptedExtensions(); err != nil {
 		return err
 	}
@@ -130,6 +151,7 @@ func (hs *clientHandshakeStateTLS13) sendClientEncryptedExtensions() error {
 	if c.utls.hasApplicationSettings {
 		clientEncryptedExtensions.hasApplicationSettings = true
 		clientEncryptedExtensions.applicationSettings = c.utls.localApplicationSettings
+		clientEncryptedExtensions.applicationSettingsCodepoint = c.utls.applicationSettingsCodepoint
 		if _, err := c.writeHandshakeRecord(clientEncryptedExtensions, hs.transcript); err != nil {
 			return err
 		}
@@ -138,10 +160,53 @@ func (hs *clientHandshakeStateTLS13) sendClientEncryptedExtensions() error {
 	return nil
 }
 
+// utlsCheckECHAcceptance checks the ECH acceptance confirmation in the server
+// random, see Section 7.2 of draft-ietf-tls-esni. If the server accepted ECH,
+// the handshake continues with the ClientHelloInner.
+func (hs *clientHandshakeStateTLS13) utlsCheckECHAcceptance() error {
+	ech := hs.c.utls.ech
+	if ech == nil {
+		return nil
+	}
+	if bytes.Equal(hs.serverHello.random, helloRetryRequestRandom) {
+		hs.c.sendAlert(alertInternalError)
+		return errors.New("tls: HelloRetryRequest is not supported with ECH")
+	}
+
+	serverHello, err := hs.serverHello.marshal()
+	if err != nil {
+		return err
+	}
+	transcript := hs.suite.hash.New()
+	transcript.Write(ech.innerHello)
+	transcript.Write(serverHello[:30])
+	transcript.Write(make([]byte, 8))
+	transcript.Write(serverHello[38:])
+	confirmation := hs.suite.expandLabel(hs.suite.extract(ech.innerRandom, nil),
+		"ech accept confirmation", transcript.Sum(nil), 8)
+
+	if subtle.ConstantTimeCompare(confirmation, hs.serverHello.random[24:]) != 1 {
+		ech.rejected = true
+		return nil
+	}
+	ech.accepted = true
+	hs.hello.raw = ech.innerHello
+	hs.hello.random = ech.innerRandom
+	return nil
+}
+
 func (hs *clientHandshakeStateTLS13) utlsReadServerParameters(encryptedExtensions *encryptedExtensionsMsg) error {
 	hs.c.utls.hasApplicationSettings = encryptedExtensions.utls.hasApplicationSettings
 	hs.c.utls.peerApplicationSettings = encryptedExtensions.utls.applicationSettings
 	hs.c.utls.echRetryConfigs = encryptedExtensions.utls.echRetryConfigs
+	hs.c.utls.echRetryConfigList = encryptedExtensions.utls.echRetryConfigList
+
+	if err := hs.c.utlsSetMaxFragmentLength(encryptedExtensions.utls.maxFragmentLength); err != nil {
+		return err
+	}
+	if err := hs.c.utlsSetRecordSizeLimit(encryptedExtensions.utls.recordSizeLimit); err != nil {
+		return err
+	}
 
 	if hs.c.utls.hasApplicationSettings {
 		if hs.uconn.vers < VersionTLS13 {
@@ -181,6 +246,32 @@ func (hs *clientHandshakeStateTLS13) utlsReadServerParameters(encryptedExtension
 	return nil
 }
 
+// setCookieExtension echoes the cookie of a HelloRetryRequest. If the
+// ClientHello has no cookie extension, one is inserted after
+// supported_versions, where BoringSSL sends it when it doesn't permute its
+// extensions, or before the trailing padding and pre_shared_key extensions if
+// there is no supported_versions. With ClientHelloSpec.ForceExtensionOrder,
+// it is appended.
+func (uconn *UConn) setCookieExtension(cookie []byte) {
+	for _, ext := range uconn.Extensions {
+		if e, ok := ext.(*CookieExtension); ok {
+			e.Cookie = cookie
+			return
+		}
+	}
+
+	i := uconn.insertExtensionIndex()
+	if !uconn.forceExtensionOrder {
+		for j, ext := range uconn.Extensions {
+			if _, ok := ext.(*SupportedVersionsExtension); ok {
+				i = j + 1
+				break
+			}
+		}
+	}
+	uconn.Extensions = slices.Insert(uconn.Extensions, i, TLSExtension(&CookieExtension{Cookie: cookie}))
+}
+
 func (c *Conn) makeClientHelloForApplyPreset() (*clientHelloMsg, clientKeySharePrivate, error) {
 	config := c.config
 
@@ -219,7 +310,7 @@ func (c *Conn) makeClientHelloForApplyPreset() (*clientHelloMsg, clientKeyShareP
 		vers:                         clientHelloVersion,
 		compressionMethods:           []uint8{compressionNone},
 		random:                       make([]byte, 32),
-		extendedMasterSecret:         true,
+		extendedMasterSecret:         false, // set by ExtendedMasterSecretExtension
 		ocspStapling:                 true,
 		scts:                         true,
 		serverName:                   hostnameInSNI(config.ServerName),
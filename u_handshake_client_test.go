@@ -0,0 +1,436 @@
+// Copyright 2024 The uTLS Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tls
+
+import (
+	"bytes"
+	"compress/zlib"
+	"crypto/x509"
+	"io"
+	"net"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+	"golang.org/x/crypto/cryptobyte"
+)
+
+// compressCertificate returns a function for testingOnlyCompressCertificate
+// that compresses with alg, and declares the uncompressed length plus delta.
+func compressCertificate(alg CertCompressionAlgo, delta int) func(*certificateMsgTLS13) (*utlsCompressedCertificateMsg, error) {
+	return func(certMsg *certificateMsgTLS13) (*utlsCompressedCertificateMsg, error) {
+		raw, err := certMsg.marshal()
+		if err != nil {
+			return nil, err
+		}
+		body := raw[4:] // without message type and length
+
+		var b bytes.Buffer
+		switch alg {
+		case CertCompressionBrotli:
+			w := brotli.NewWriter(&b)
+			w.Write(body)
+			w.Close()
+		case CertCompressionZlib:
+			w := zlib.NewWriter(&b)
+			w.Write(body)
+			w.Close()
+		case CertCompressionZstd:
+			w, err := zstd.NewWriter(&b)
+			if err != nil {
+				return nil, err
+			}
+			w.Write(body)
+			w.Close()
+		}
+		return &utlsCompressedCertificateMsg{
+			algorithm:                    uint16(alg),
+			uncompressedLength:           uint32(len(body) + delta),
+			compressedCertificateMessage: b.Bytes(),
+		}, nil
+	}
+}
+
+func testCompressedCertificateHandshake(t *testing.T, id ClientHelloID) (*UConn, error) {
+	issuer, err := x509.ParseCertificate(testRSACertificateIssuer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(issuer)
+	clientConfig := &Config{
+		ServerName: "example.golang",
+		RootCAs:    roots,
+		Time:       func() time.Time { return time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC) },
+	}
+
+	// a long chain, so that the certificate doesn't decompress in one go
+	serverConfig := testConfig.Clone()
+	cert := serverConfig.Certificates[0]
+	for i := 0; i < 20; i++ {
+		cert.Certificate = append(cert.Certificate, testRSACertificateIssuer)
+	}
+	serverConfig.Certificates = []Certificate{cert}
+
+	c, s := localPipe(t)
+	go func() {
+		server := Server(s, serverConfig)
+		server.Handshake()
+		server.Close()
+	}()
+
+	uconn := UClient(c, clientConfig, id)
+	t.Cleanup(func() { uconn.Close() })
+	return uconn, uconn.Handshake()
+}
+
+func TestUTLSCompressedCertificate(t *testing.T) {
+	defer func() { testingOnlyCompressCertificate = nil }()
+
+	for _, test := range []struct {
+		name string
+		id   ClientHelloID
+		alg  CertCompressionAlgo
+	}{
+		{"brotli", HelloChrome_120, CertCompressionBrotli},
+		{"zlib", HelloSafari_18, CertCompressionZlib},
+		{"zstd", HelloFirefox_133, CertCompressionZstd},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			testingOnlyCompressCertificate = compressCertificate(test.alg, 0)
+			uconn, err := testCompressedCertificateHandshake(t, test.id)
+			if err != nil {
+				t.Fatalf("got error: %v; expected to succeed", err)
+			}
+			if n := len(uconn.ConnectionState().PeerCertificates); n != 21 {
+				t.Errorf("got %d peer certificates, expected 21", n)
+			}
+			if alg := uconn.CertCompressionUsed(); alg != test.alg {
+				t.Errorf("got CertCompressionUsed %v, expected %v", alg, test.alg)
+			}
+			if alg := uconn.ServerHelloInfo().CertCompression; alg != test.alg {
+				t.Errorf("got ServerHelloInfo.CertCompression %v, expected %v", alg, test.alg)
+			}
+		})
+	}
+
+	t.Run("none", func(t *testing.T) {
+		testingOnlyCompressCertificate = nil
+		uconn, err := testCompressedCertificateHandshake(t, HelloChrome_120)
+		if err != nil {
+			t.Fatalf("got error: %v; expected to succeed", err)
+		}
+		if alg := uconn.CertCompressionUsed(); alg != 0 {
+			t.Errorf("got CertCompressionUsed %v for an uncompressed certificate", alg)
+		}
+	})
+}
+
+func TestUTLSCompressedCertificateInvalid(t *testing.T) {
+	defer func() { testingOnlyCompressCertificate = nil }()
+
+	for _, test := range []struct {
+		name  string
+		alg   CertCompressionAlgo
+		delta int
+		err   string
+	}{
+		{"unadvertised", CertCompressionZlib, 0, "unadvertised algorithm"},
+		{"too short", CertCompressionBrotli, 1, "does not match"},
+		{"too long", CertCompressionBrotli, -1, "does not match"},
+		{"too large", CertCompressionBrotli, maxHandshake, "exceeds maximum"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			testingOnlyCompressCertificate = compressCertificate(test.alg, test.delta)
+			_, err := testCompressedCertificateHandshake(t, HelloChrome_120)
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("got error %v, expected it to contain %q", err, test.err)
+			}
+		})
+	}
+}
+
+func TestUTLSResumption(t *testing.T) {
+	for _, id := range []ClientHelloID{HelloChrome_120, HelloFirefox_120, HelloSafari_18} {
+		t.Run(id.Str(), func(t *testing.T) {
+			clientConfig := &Config{
+				ServerName:         "example.golang",
+				InsecureSkipVerify: true,
+				ClientSessionCache: NewLRUClientSessionCache(1),
+				// the test certificate is only valid until 2025
+				Time: func() time.Time { return time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC) },
+			}
+
+			handshake := func() *UConn {
+				c, s := localPipe(t)
+				go func() {
+					server := Server(s, testConfig)
+					if err := server.Handshake(); err == nil {
+						// the session ticket is read along with this
+						server.Write([]byte("ok"))
+					}
+					server.Close()
+				}()
+
+				uconn := UClient(c, clientConfig, id)
+				t.Cleanup(func() { uconn.Close() })
+				if err := uconn.Handshake(); err != nil {
+					t.Fatalf("got error: %v; expected to succeed", err)
+				}
+				if _, err := uconn.Read(make([]byte, 2)); err != nil {
+					t.Fatalf("got error: %v; expected to succeed", err)
+				}
+				return uconn
+			}
+
+			if uconn := handshake(); uconn.ConnectionState().DidResume {
+				t.Fatal("first handshake resumed")
+			}
+
+			uconn := handshake()
+			if !uconn.ConnectionState().DidResume {
+				t.Fatal("second handshake didn't resume")
+			}
+			if _, ok := uconn.Extensions[len(uconn.Extensions)-1].(PreSharedKeyExtension); !ok {
+				t.Errorf("got %T as the last extension, expected a PreSharedKeyExtension", uconn.Extensions[len(uconn.Extensions)-1])
+			}
+			hello := uconn.HandshakeState.Hello
+			if len(hello.PskIdentities) != 1 || len(hello.PskBinders) != 1 {
+				t.Errorf("got %d PSK identities and %d binders, expected one each", len(hello.PskIdentities), len(hello.PskBinders))
+			}
+
+			record := append([]byte{byte(recordTypeHandshake), 3, 1, byte(len(hello.Raw) >> 8), byte(len(hello.Raw))}, hello.Raw...)
+			fp, err := (&Fingerprinter{}).RawClientHello(record)
+			if err != nil {
+				t.Fatalf("fingerprinting the ClientHello failed: %v", err)
+			}
+			if _, ok := fp.Extensions[len(fp.Extensions)-1].(PreSharedKeyExtension); !ok {
+				t.Error("the sent ClientHello doesn't end with the pre_shared_key extension")
+			}
+		})
+	}
+}
+
+// readHandshakeRecord reads records from conn until it gets a handshake
+// record, and returns its message.
+func readHandshakeRecord(t *testing.T, conn net.Conn) []byte {
+	for {
+		header := make([]byte, recordHeaderLen)
+		if _, err := io.ReadFull(conn, header); err != nil {
+			t.Fatalf("got error reading a record: %v", err)
+		}
+		record := make([]byte, int(header[3])<<8|int(header[4]))
+		if _, err := io.ReadFull(conn, record); err != nil {
+			t.Fatalf("got error reading a record: %v", err)
+		}
+		if recordType(header[0]) == recordTypeHandshake {
+			return record
+		}
+	}
+}
+
+// clientHelloRawExtensions returns the extensions of a ClientHello message.
+func clientHelloRawExtensions(t *testing.T, hello []byte) []rawExtension {
+	s := cryptobyte.String(hello)
+	var sessionID, cipherSuites, compressionMethods, extensions cryptobyte.String
+	if !s.Skip(4+2+32) || !s.ReadUint8LengthPrefixed(&sessionID) ||
+		!s.ReadUint16LengthPrefixed(&cipherSuites) || !s.ReadUint8LengthPrefixed(&compressionMethods) ||
+		!s.ReadUint16LengthPrefixed(&extensions) {
+		t.Fatal("malformed ClientHello")
+	}
+	var exts []rawExtension
+	for !extensions.Empty() {
+		var ext rawExtension
+		var data cryptobyte.String
+		if !extensions.ReadUint16(&ext.extType) || !extensions.ReadUint16LengthPrefixed(&data) {
+			t.Fatal("malformed ClientHello extensions")
+		}
+		ext.data = data
+		exts = append(exts, ext)
+	}
+	return exts
+}
+
+func TestUTLSHelloRetryRequestSecondHello(t *testing.T) {
+	c, s := localPipe(t)
+	defer s.Close()
+	uconn := UClient(c, &Config{ServerName: "example.com", InsecureSkipVerify: true}, HelloChrome_120)
+	go func() {
+		uconn.Handshake()
+		uconn.Close()
+	}()
+
+	firstHello := readHandshakeRecord(t, s)
+	var ch clientHelloMsg
+	if !ch.unmarshal(firstHello) {
+		t.Fatal("malformed ClientHello")
+	}
+	cookie := []byte("a HelloRetryRequest cookie")
+	hrr, err := (&serverHelloMsg{
+		vers:             VersionTLS12,
+		random:           helloRetryRequestRandom,
+		sessionId:        ch.sessionId,
+		cipherSuite:      TLS_AES_128_GCM_SHA256,
+		supportedVersion: VersionTLS13,
+		selectedGroup:    CurveP256,
+		cookie:           cookie,
+	}).marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Write(append([]byte{byte(recordTypeHandshake), 3, 3, byte(len(hrr) >> 8), byte(len(hrr))}, hrr...)); err != nil {
+		t.Fatal(err)
+	}
+	secondHello := readHandshakeRecord(t, s)
+
+	first, second := clientHelloRawExtensions(t, firstHello), clientHelloRawExtensions(t, secondHello)
+	if len(second) != len(first)+1 {
+		t.Fatalf("got %d extensions in the second ClientHello, expected %d", len(second), len(first)+1)
+	}
+	i := 0
+	for _, ext := range second {
+		switch ext.extType {
+		case extensionCookie:
+			if i == 0 || first[i-1].extType != extensionSupportedVersions {
+				t.Errorf("got the cookie extension at position %d, expected it after supported_versions", i)
+			}
+			want := append([]byte{0, byte(len(cookie))}, cookie...)
+			if !bytes.Equal(ext.data, want) {
+				t.Errorf("got cookie extension %x, expected %x", ext.data, want)
+			}
+			continue
+		case extensionKeyShare:
+			// only the requested key share, without GREASE
+			want := []byte{0, 0x45, byte(CurveP256 >> 8), byte(CurveP256), 0, 0x41}
+			if len(ext.data) != len(want)+0x41 || !bytes.HasPrefix(ext.data, want) {
+				t.Errorf("got key_share %x, expected a single CurveP256 key share", ext.data)
+			}
+		case utlsExtensionPadding:
+		default:
+			if !bytes.Equal(ext.data, first[i].data) {
+				t.Errorf("extension %d changed from %x to %x", ext.extType, first[i].data, ext.data)
+			}
+		}
+		if ext.extType != first[i].extType {
+			t.Errorf("got extension %d at position %d, expected %d like in the first ClientHello", ext.extType, i, first[i].extType)
+		}
+		i++
+	}
+
+	var ch2 clientHelloMsg
+	if !ch2.unmarshal(secondHello) || !slices.Equal(ch2.cipherSuites, ch.cipherSuites) || !bytes.Equal(ch2.random, ch.random) {
+		t.Error("got different cipher suites or random in the second ClientHello")
+	}
+}
+
+func TestUTLSCookieExtension(t *testing.T) {
+	// specWithCookie returns the spec of Firefox 120, which doesn't shuffle its
+	// extensions, with the cookie extension first.
+	specWithCookie := func(t *testing.T, cookie []byte) *ClientHelloSpec {
+		spec := mustSpec(t, HelloFirefox_120)
+		spec.Extensions = append([]TLSExtension{&CookieExtension{Cookie: cookie}}, spec.Extensions...)
+		return spec
+	}
+	cookieData := func(cookie []byte) []byte {
+		return append([]byte{byte(len(cookie) >> 8), byte(len(cookie))}, cookie...)
+	}
+
+	t.Run("initial ClientHello", func(t *testing.T) {
+		cookie := []byte("an initial cookie")
+		c, s := localPipe(t)
+		done := make(chan error, 1)
+		go func() {
+			server := Server(s, testConfig.Clone())
+			defer server.Close()
+			done <- server.Handshake()
+		}()
+		uconn := UClient(c, &Config{ServerName: "example.golang", InsecureSkipVerify: true}, HelloCustom)
+		defer uconn.Close()
+		if err := uconn.ApplyPreset(specWithCookie(t, cookie)); err != nil {
+			t.Fatalf("got error: %v; expected to succeed", err)
+		}
+		if err := uconn.Handshake(); err != nil {
+			t.Fatalf("client handshake failed: %v", err)
+		}
+		if err := <-done; err != nil {
+			t.Fatalf("server handshake failed: %v", err)
+		}
+
+		hello := uconn.HandshakeState.Hello.Raw
+		if ext := clientHelloRawExtensions(t, hello)[0]; ext.extType != extensionCookie || !bytes.Equal(ext.data, cookieData(cookie)) {
+			t.Errorf("got extension %d with %x first, expected cookie %x", ext.extType, ext.data, cookieData(cookie))
+		}
+		spec, err := (&Fingerprinter{}).FingerprintClientHello(prependRecordHeader(hello, VersionTLS10))
+		if err != nil {
+			t.Fatalf("got error: %v; expected to succeed", err)
+		}
+		if e, ok := spec.Extensions[0].(*CookieExtension); !ok || !bytes.Equal(e.Cookie, cookie) {
+			t.Errorf("got %#v from the fingerprinter, expected the cookie", spec.Extensions[0])
+		}
+	})
+
+	for _, test := range []struct {
+		name    string
+		initial []byte
+	}{
+		{"echoed in place", nil},
+		{"replacing the initial cookie", []byte("an initial cookie")},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			c, s := localPipe(t)
+			defer s.Close()
+			uconn := UClient(c, &Config{ServerName: "example.com", InsecureSkipVerify: true}, HelloCustom)
+			if err := uconn.ApplyPreset(specWithCookie(t, test.initial)); err != nil {
+				t.Fatalf("got error: %v; expected to succeed", err)
+			}
+			go func() {
+				uconn.Handshake()
+				uconn.Close()
+			}()
+
+			firstHello := readHandshakeRecord(t, s)
+			first := clientHelloRawExtensions(t, firstHello)
+			if (first[0].extType == extensionCookie) != (test.initial != nil) {
+				t.Errorf("got extension %d first, expected a cookie only if one is set", first[0].extType)
+			}
+			var ch clientHelloMsg
+			if !ch.unmarshal(firstHello) {
+				t.Fatal("malformed ClientHello")
+			}
+			cookie := []byte("a HelloRetryRequest cookie")
+			hrr, err := (&serverHelloMsg{
+				vers:             VersionTLS12,
+				random:           helloRetryRequestRandom,
+				sessionId:        ch.sessionId,
+				cipherSuite:      TLS_AES_128_GCM_SHA256,
+				supportedVersion: VersionTLS13,
+				selectedGroup:    CurveP256,
+				cookie:           cookie,
+			}).marshal()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := s.Write(append([]byte{byte(recordTypeHandshake), 3, 3, byte(len(hrr) >> 8), byte(len(hrr))}, hrr...)); err != nil {
+				t.Fatal(err)
+			}
+			second := clientHelloRawExtensions(t, readHandshakeRecord(t, s))
+			if second[0].extType != extensionCookie || !bytes.Equal(second[0].data, cookieData(cookie)) {
+				t.Errorf("got extension %d with %x first, expected the cookie %x of the HelloRetryRequest", second[0].extType, second[0].data, cookieData(cookie))
+			}
+			for _, ext := range second[1:] {
+				if ext.extType == extensionCookie {
+					t.Error("got a second cookie extension")
+				}
+			}
+		})
+	}
+
+	if _, err := (&CookieExtension{}).Write([]byte{0, 0}); err == nil {
+		t.Error("got no error parsing an empty cookie")
+	}
+}
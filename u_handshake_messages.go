@@ -53,16 +53,33 @@ func (m *utlsCompressedCertificateMsg) unmarshal(data []byte) bool {
 	return true
 }
 
+// testingOnlyCompressCertificate is set in tests to make the server send its
+// Certificate message compressed, as the server side doesn't implement RFC 8879.
+var testingOnlyCompressCertificate func(*certificateMsgTLS13) (*utlsCompressedCertificateMsg, error)
+
+// testingOnlyMaxFragmentLength is set in tests to make the server echo a
+// max_fragment_length extension with this code, as the server side doesn't
+// implement RFC 6066.
+var testingOnlyMaxFragmentLength uint8
+
+// testingOnlyRecordSizeLimit is set in tests to make the server send a
+// record_size_limit extension with this limit, as the server side doesn't
+// implement RFC 8449.
+var testingOnlyRecordSizeLimit uint16
+
 type utlsEncryptedExtensionsMsgExtraFields struct {
 	hasApplicationSettings bool
 	applicationSettings    []byte
 	echRetryConfigs        []ECHConfig
+	echRetryConfigList     []byte // echRetryConfigs as sent
 	customExtension        []byte
+	maxFragmentLength      uint8
+	recordSizeLimit        uint16
 }
 
 func (m *encryptedExtensionsMsg) utlsUnmarshal(extension uint16, extData cryptobyte.String) bool {
 	switch extension {
-	case utlsExtensionApplicationSettings:
+	case utlsExtensionApplicationSettings, utlsExtensionApplicationSettingsNew:
 		m.utls.hasApplicationSettings = true
 		m.utls.applicationSettings = []byte(extData)
 	case utlsExtensionECH:
@@ -71,15 +88,25 @@ func (m *encryptedExtensionsMsg) utlsUnmarshal(extension uint16, extData cryptob
 		if err != nil {
 			return false
 		}
+		m.utls.echRetryConfigList = []byte(extData)
+	case fakeExtensionMaxFragmentLength:
+		if !extData.ReadUint8(&m.utls.maxFragmentLength) || m.utls.maxFragmentLength == 0 || !extData.Empty() {
+			return false
+		}
+	case fakeRecordSizeLimit:
+		if !extData.ReadUint16(&m.utls.recordSizeLimit) || m.utls.recordSizeLimit == 0 || !extData.Empty() {
+			return false
+		}
 	}
 	return true // success/unknown extension
 }
 
 type utlsClientEncryptedExtensionsMsg struct {
-	raw                    []byte
-	applicationSettings    []byte
-	hasApplicationSettings bool
-	customExtension        []byte
+	raw                          []byte
+	applicationSettings          []byte
+	hasApplicationSettings       bool
+	applicationSettingsCodepoint uint16 // utlsExtensionApplicationSettings if zero
+	customExtension              []byte
 }
 
 func (m *utlsClientEncryptedExtensionsMsg) marshal() (x []byte, err error) {
@@ -92,7 +119,11 @@ func (m *utlsClientEncryptedExtensionsMsg) marshal() (x []byte, err error) {
 	builder.AddUint24LengthPrefixed(func(body *cryptobyte.Builder) {
 		body.AddUint16LengthPrefixed(func(extensions *cryptobyte.Builder) {
 			if m.hasApplicationSettings {
-				extensions.AddUint16(utlsExtensionApplicationSettings)
+				codepoint := m.applicationSettingsCodepoint
+				if codepoint == 0 {
+					codepoint = utlsExtensionApplicationSettings
+				}
+				extensions.AddUint16(codepoint)
 				extensions.AddUint16LengthPrefixed(func(msg *cryptobyte.Builder) {
 					msg.AddBytes(m.applicationSettings)
 				})
@@ -129,9 +160,10 @@ func (m *utlsClientEncryptedExtensionsMsg) unmarshal(data []byte) bool {
 		}
 
 		switch extension {
-		case utlsExtensionApplicationSettings:
+		case utlsExtensionApplicationSettings, utlsExtensionApplicationSettingsNew:
 			m.hasApplicationSettings = true
 			m.applicationSettings = []byte(extData)
+			m.applicationSettingsCodepoint = extension
 		default:
 			// Unknown extensions are illegal in EncryptedExtensions.
 			return false
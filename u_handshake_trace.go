@@ -0,0 +1,43 @@
+// Copyright 2024 The uTLS Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tls
+
+import "time"
+
+// HandshakeTrace is a set of hooks called at the steps of a client handshake,
+// e.g. to measure its latency, like httptrace.ClientTrace for HTTP requests.
+// Any of them may be nil. They are called synchronously by the goroutine
+// running the handshake, and must not block.
+//
+// After a HelloRetryRequest, or during a renegotiation, ClientHelloSent and
+// ServerHelloReceived are called again. HandshakeComplete is only called once
+// the handshake succeeds.
+type HandshakeTrace struct {
+	// ClientHelloSent is called with the time the ClientHello was written.
+	ClientHelloSent func(time.Time)
+	// ServerHelloReceived is called with the time the ServerHello, or the
+	// HelloRetryRequest, was read.
+	ServerHelloReceived func(time.Time)
+	// HandshakeComplete is called with the time the handshake completed.
+	HandshakeComplete func(time.Time)
+}
+
+func (t *HandshakeTrace) clientHelloSent() {
+	if t != nil && t.ClientHelloSent != nil {
+		t.ClientHelloSent(time.Now())
+	}
+}
+
+func (t *HandshakeTrace) serverHelloReceived() {
+	if t != nil && t.ServerHelloReceived != nil {
+		t.ServerHelloReceived(time.Now())
+	}
+}
+
+func (t *HandshakeTrace) handshakeComplete() {
+	if t != nil && t.HandshakeComplete != nil {
+		t.HandshakeComplete(time.Now())
+	}
+}
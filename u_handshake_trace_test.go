@@ -0,0 +1,93 @@
+// Copyright 2024 The uTLS Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tls
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestUTLSHandshakeTrace(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		id     ClientHelloID
+		server func(*Config)
+		events []string
+	}{
+		{"TLS 1.3", HelloChrome_120, nil, []string{"ClientHello", "ServerHello", "complete"}},
+		{"TLS 1.2", HelloChrome_120, func(c *Config) { c.MaxVersion = VersionTLS12 }, []string{"ClientHello", "ServerHello", "complete"}},
+		{"HelloRetryRequest", HelloChrome_120, func(c *Config) { c.CurvePreferences = []CurveID{CurveP384} },
+			[]string{"ClientHello", "ServerHello", "ClientHello", "ServerHello", "complete"}},
+		{"HelloGolang", HelloGolang, nil, []string{"ClientHello", "ServerHello", "complete"}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			var events []string
+			var times []time.Time
+			event := func(name string) func(time.Time) {
+				return func(at time.Time) {
+					events = append(events, name)
+					times = append(times, at)
+				}
+			}
+
+			c, s := localPipe(t)
+			serverConfig := testConfig.Clone()
+			if test.server != nil {
+				test.server(serverConfig)
+			}
+			serverConfig.HandshakeTrace = &HandshakeTrace{HandshakeComplete: event("server")} // clients only
+			done := make(chan error, 1)
+			go func() {
+				server := Server(s, serverConfig)
+				defer server.Close()
+				done <- server.Handshake()
+			}()
+			config := &Config{ServerName: "example.golang", InsecureSkipVerify: true, HandshakeTrace: &HandshakeTrace{
+				ClientHelloSent:     event("ClientHello"),
+				ServerHelloReceived: event("ServerHello"),
+				HandshakeComplete:   event("complete"),
+			}}
+			uconn := UClient(c, config, test.id)
+			defer uconn.Close()
+			start := time.Now()
+			if err := uconn.Handshake(); err != nil {
+				t.Fatalf("client handshake failed: %v", err)
+			}
+			if err := <-done; err != nil {
+				t.Fatalf("server handshake failed: %v", err)
+			}
+
+			if !slices.Equal(events, test.events) {
+				t.Errorf("got events %v, expected %v", events, test.events)
+			}
+			for i, at := range times {
+				if (i == 0 && at.Before(start)) || (i > 0 && at.Before(times[i-1])) || at.After(time.Now()) {
+					t.Errorf("%s at %v is out of order, after %v", events[i], at, times[:i])
+				}
+			}
+
+			// a complete handshake doesn't call the hooks again
+			if err := uconn.Handshake(); err != nil || len(events) != len(test.events) {
+				t.Errorf("got events %v (error: %v) after a second call to Handshake", events, err)
+			}
+		})
+	}
+}
+
+func TestUTLSHandshakeTraceNil(t *testing.T) {
+	var trace *HandshakeTrace
+	empty := &HandshakeTrace{}
+	if n := testing.AllocsPerRun(100, func() {
+		trace.clientHelloSent()
+		trace.serverHelloReceived()
+		trace.handshakeComplete()
+		empty.clientHelloSent()
+		empty.serverHelloReceived()
+		empty.handshakeComplete()
+	}); n != 0 {
+		t.Errorf("got %v allocations without hooks, expected none", n)
+	}
+}
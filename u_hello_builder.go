@@ -0,0 +1,105 @@
+// Copyright 2024 The uTLS Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tls
+
+import (
+	"fmt"
+	"slices"
+)
+
+// ClientHelloBuilder builds the ClientHello of a ClientHelloID without a
+// connection, e.g. to compute fingerprints or dump the ClientHello of a
+// parrot. The ClientHello is the same that a UConn with the same Config and
+// ClientHelloID would send, including the GREASE values, key shares and
+// session ID, which are drawn once per builder.
+type ClientHelloBuilder struct {
+	uconn *UConn
+}
+
+// NewClientHelloBuilder returns a ClientHelloBuilder for the ClientHello of id
+// with config, which may be nil. Like for UClient, config.ServerName sets the
+// server_name extension.
+func NewClientHelloBuilder(config *Config, id ClientHelloID) *ClientHelloBuilder {
+	return &ClientHelloBuilder{uconn: UClient(nil, config, id)}
+}
+
+// ApplyPreset applies a ClientHelloSpec to the builder, see UConn.ApplyPreset.
+// It should only be used with HelloCustom.
+func (b *ClientHelloBuilder) ApplyPreset(p *ClientHelloSpec) error {
+	return b.uconn.ApplyPreset(p)
+}
+
+// HandshakeState returns the state of the ClientHello, once it has been built
+// by MarshalClientHello. Like with a UConn, the extensions and the hello may
+// be modified before calling MarshalClientHello again.
+func (b *ClientHelloBuilder) HandshakeState() *PubClientHandshakeState {
+	return &b.uconn.HandshakeState
+}
+
+// Extensions returns the extensions of the ClientHello, see UConn.Extensions.
+func (b *ClientHelloBuilder) Extensions() []TLSExtension {
+	return b.uconn.Extensions
+}
+
+// SkipKeyGeneration makes the builder send key shares filled with fixed dummy
+// bytes, of the length of a public key of their group, instead of generating
+// key pairs. This is faster when only the ClientHello bytes are needed, e.g.
+// to compute fingerprints in bulk, but the key shares are not valid public
+// keys and have no private keys: the ClientHello must not be used for a
+// handshake. It must be called before ApplyPreset and MarshalClientHello,
+// and has no effect with HelloGolang.
+func (b *ClientHelloBuilder) SkipKeyGeneration() {
+	b.uconn.dummyKeyShares = true
+}
+
+// dummyKeyShareData returns the key_share data sent for group by
+// ClientHelloBuilder.SkipKeyGeneration: an uncompressed point marker
+// followed by zeroes for NIST curves, and zeroes otherwise.
+func dummyKeyShareData(group CurveID) ([]byte, error) {
+	if scheme := curveIdToCirclScheme(group); scheme != nil {
+		return make([]byte, scheme.PublicKeySize()), nil
+	}
+	var data []byte
+	switch group {
+	case X25519:
+		return make([]byte, 32), nil
+	case CurveP256:
+		data = make([]byte, 1+2*32)
+	case CurveP384:
+		data = make([]byte, 1+2*48)
+	case CurveP521:
+		data = make([]byte, 1+2*66)
+	default:
+		return nil, fmt.Errorf("tls: unsupported group %v in KeyShareExtension, fill its Data to mimic it", group)
+	}
+	data[0] = 4 // uncompressed
+	return data, nil
+}
+
+// MarshalClientHello builds the ClientHello, if it's the first call, and
+// returns the ClientHello handshake message, without a record header. The key
+// shares are generated like for a handshake, unless SkipKeyGeneration was
+// called, and their private keys are in HandshakeState().State13.EcdheKey and
+// KeySharesParams.
+//
+// Later calls marshal the ClientHello again, with the same random values, so
+// that changes made through HandshakeState or Extensions are included.
+func (b *ClientHelloBuilder) MarshalClientHello() ([]byte, error) {
+	if err := b.uconn.BuildHandshakeState(); err != nil {
+		return nil, err
+	}
+	hello := b.uconn.HandshakeState.Hello
+	if hello.Raw == nil {
+		// HelloGolang is only marshaled when it is sent.
+		return hello.getPrivatePtr().marshal()
+	}
+	return slices.Clone(hello.Raw), nil
+}
+
+// JA4 returns the JA4 fingerprint of the ClientHello, see UConn.JA4. It must
+// be called after MarshalClientHello.
+func (b *ClientHelloBuilder) JA4() (string, error) {
+	return b.uconn.JA4()
+}
@@ -0,0 +1,152 @@
+// Copyright 2024 The uTLS Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tls
+
+import (
+	"bytes"
+	"testing"
+
+	"golang.org/x/crypto/cryptobyte"
+)
+
+func TestUTLSClientHelloBuilder(t *testing.T) {
+	for _, id := range append(parrotHelloIDs, HelloGolang) {
+		t.Run(id.Str(), func(t *testing.T) {
+			b := NewClientHelloBuilder(&Config{ServerName: "example.com", OmitEmptyPsk: true}, id)
+			hello, err := b.MarshalClientHello()
+			if err != nil {
+				t.Fatalf("got error: %v; expected to succeed", err)
+			}
+			if len(hello) < 4 || hello[0] != typeClientHello {
+				t.Fatalf("got %x, expected a ClientHello message", hello)
+			}
+			for _, ext := range clientHelloRawExtensions(t, hello) {
+				if ext.extType != extensionKeyShare {
+					continue
+				}
+				s := cryptobyte.String(ext.data)
+				var shares cryptobyte.String
+				if !s.ReadUint16LengthPrefixed(&shares) {
+					t.Fatal("malformed key_share extension")
+				}
+				for !shares.Empty() {
+					var group uint16
+					var key cryptobyte.String
+					if !shares.ReadUint16(&group) || !shares.ReadUint16LengthPrefixed(&key) {
+						t.Fatal("malformed key_share extension")
+					}
+					if len(key) == 0 {
+						t.Errorf("key share %v has no public key", CurveID(group))
+					}
+				}
+				if state := b.HandshakeState().State13; state.EcdheKey == nil && state.KeySharesParams == nil {
+					t.Error("got no key share private keys")
+				}
+			}
+
+			again, err := b.MarshalClientHello()
+			if err != nil {
+				t.Fatalf("got error: %v; expected to succeed", err)
+			}
+			if !bytes.Equal(hello, again) {
+				t.Error("got a different ClientHello from the second call")
+			}
+		})
+	}
+}
+
+func TestUTLSClientHelloBuilderSkipKeyGeneration(t *testing.T) {
+	lengths := map[CurveID]int{
+		X25519:                32,
+		CurveP256:             65,
+		CurveP384:             97,
+		CurveP521:             133,
+		X25519MLKEM768:        32 + 1184,
+		X25519Kyber768Draft00: 32 + 1184,
+	}
+	groups := []CurveID{X25519, CurveP256, CurveP384, CurveP521, X25519MLKEM768, X25519Kyber768Draft00}
+	build := func(skip bool) (hello []byte, shares []KeyShare, b *ClientHelloBuilder) {
+		var keyShares []KeyShare
+		for _, group := range groups {
+			keyShares = append(keyShares, KeyShare{Group: group})
+		}
+		b = NewClientHelloBuilder(&Config{ServerName: "example.com"}, HelloCustom)
+		if skip {
+			b.SkipKeyGeneration()
+		}
+		if err := b.ApplyPreset(&ClientHelloSpec{
+			CipherSuites: []uint16{TLS_AES_128_GCM_SHA256},
+			Extensions: []TLSExtension{
+				&SupportedCurvesExtension{Curves: groups},
+				&KeyShareExtension{KeyShares: keyShares},
+				&SupportedVersionsExtension{Versions: []uint16{VersionTLS13}},
+			},
+		}); err != nil {
+			t.Fatalf("got error: %v; expected to succeed", err)
+		}
+		hello, err := b.MarshalClientHello()
+		if err != nil {
+			t.Fatalf("got error: %v; expected to succeed", err)
+		}
+		return hello, b.Extensions()[1].(*KeyShareExtension).KeyShares, b
+	}
+
+	keygenHello, keygenShares, _ := build(false)
+	hello, shares, b := build(true)
+	if len(hello) != len(keygenHello) {
+		t.Errorf("got a ClientHello of %d bytes, expected %d like with key generation", len(hello), len(keygenHello))
+	}
+	for i, ks := range shares {
+		if ks.Group != groups[i] || len(ks.Data) != lengths[ks.Group] || len(keygenShares[i].Data) != lengths[ks.Group] {
+			t.Errorf("got %d bytes for %v (%d with key generation), expected %d", len(ks.Data), ks.Group, len(keygenShares[i].Data), lengths[groups[i]])
+		}
+	}
+	if state := b.HandshakeState().State13; state.EcdheKey != nil || state.KEMKey != nil {
+		t.Error("got private keys without key generation")
+	}
+	if again, _, _ := build(true); !bytes.Equal(keyShareBytes(t, again), keyShareBytes(t, hello)) {
+		t.Error("got different dummy key shares")
+	}
+
+	b = NewClientHelloBuilder(&Config{ServerName: "example.com"}, HelloCustom)
+	b.SkipKeyGeneration()
+	if err := b.ApplyPreset(&ClientHelloSpec{
+		Extensions: []TLSExtension{&KeyShareExtension{KeyShares: []KeyShare{{Group: CurveID(0x1234)}}}},
+	}); err == nil {
+		t.Error("got no error for an unknown group")
+	}
+}
+
+// keyShareBytes returns the data of the key_share extension of hello.
+func keyShareBytes(t *testing.T, hello []byte) []byte {
+	for _, ext := range clientHelloRawExtensions(t, hello) {
+		if ext.extType == extensionKeyShare {
+			return ext.data
+		}
+	}
+	t.Fatal("no key_share extension")
+	return nil
+}
+
+func BenchmarkUTLSClientHelloBuilder(b *testing.B) {
+	for _, skip := range []bool{false, true} {
+		name := "KeyGeneration"
+		if skip {
+			name = "SkipKeyGeneration"
+		}
+		b.Run(name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				builder := NewClientHelloBuilder(&Config{ServerName: "example.com"}, HelloChrome_131)
+				if skip {
+					builder.SkipKeyGeneration()
+				}
+				if _, err := builder.MarshalClientHello(); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
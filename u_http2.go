@@ -0,0 +1,210 @@
+// Copyright 2024 The uTLS Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tls
+
+import (
+	"fmt"
+	"strings"
+)
+
+// HTTP2SettingID is the identifier of an HTTP/2 SETTINGS parameter
+// (RFC 9113, Section 6.5.2).
+type HTTP2SettingID uint16
+
+const (
+	HTTP2SettingHeaderTableSize       HTTP2SettingID = 0x1
+	HTTP2SettingEnablePush            HTTP2SettingID = 0x2
+	HTTP2SettingMaxConcurrentStreams  HTTP2SettingID = 0x3
+	HTTP2SettingInitialWindowSize     HTTP2SettingID = 0x4
+	HTTP2SettingMaxFrameSize          HTTP2SettingID = 0x5
+	HTTP2SettingMaxHeaderListSize     HTTP2SettingID = 0x6
+	HTTP2SettingEnableConnectProtocol HTTP2SettingID = 0x8 // RFC 8441
+	HTTP2SettingNoRFC7540Priorities   HTTP2SettingID = 0x9 // RFC 9218
+)
+
+// HTTP2Setting is a single parameter of an HTTP/2 SETTINGS frame.
+type HTTP2Setting struct {
+	ID  HTTP2SettingID
+	Val uint32
+}
+
+// HTTP2Priority is the stream dependency and weight of an HTTP/2 PRIORITY
+// frame, or of the priority fields of a HEADERS frame.
+type HTTP2Priority struct {
+	// StreamID is the stream being prioritized. It is ignored for the
+	// priority of a HEADERS frame.
+	StreamID  uint32
+	Exclusive bool
+	StreamDep uint32
+	// Weight is the value sent on the wire, one less than the actual weight.
+	Weight uint8
+}
+
+// HTTP2Settings describes how the HTTP/2 connection prefix and requests of a
+// browser look on the wire, which is fingerprinted together with its
+// ClientHello. uTLS does not speak HTTP/2 itself: the values are recorded on
+// a UConn with SetHTTP2Fingerprint for a cooperating HTTP/2 transport to
+// emit.
+type HTTP2Settings struct {
+	// Settings are the parameters of the initial SETTINGS frame, in order.
+	Settings []HTTP2Setting
+
+	// WindowUpdateIncrement is the increment of the WINDOW_UPDATE frame sent
+	// for the connection after SETTINGS, or 0 if none is sent.
+	WindowUpdateIncrement uint32
+
+	// Priorities are the PRIORITY frames sent after WINDOW_UPDATE, in order.
+	Priorities []HTTP2Priority
+
+	// HeaderPriority, if not nil, is sent with the HEADERS frame of every
+	// request.
+	HeaderPriority *HTTP2Priority
+
+	// PseudoHeaderOrder is the order of the request pseudo-headers, such as
+	// ":method" and ":authority".
+	PseudoHeaderOrder []string
+}
+
+// Canonical HTTP/2 settings of the browsers that uTLS parrots. They match
+// HelloChrome_120 and later, HelloFirefox_120 and HelloSafari_18 respectively.
+//
+// Treat them as read-only, and use Clone to make modified copies.
+var (
+	HTTP2SettingsChrome = &HTTP2Settings{
+		Settings: []HTTP2Setting{
+			{HTTP2SettingHeaderTableSize, 65536},
+			{HTTP2SettingEnablePush, 0},
+			{HTTP2SettingInitialWindowSize, 6291456},
+			{HTTP2SettingMaxHeaderListSize, 262144},
+		},
+		WindowUpdateIncrement: 15663105,
+		HeaderPriority:        &HTTP2Priority{Exclusive: true, StreamDep: 0, Weight: 255},
+		PseudoHeaderOrder:     []string{":method", ":authority", ":scheme", ":path"},
+	}
+
+	HTTP2SettingsFirefox = &HTTP2Settings{
+		Settings: []HTTP2Setting{
+			{HTTP2SettingHeaderTableSize, 65536},
+			{HTTP2SettingInitialWindowSize, 131072},
+			{HTTP2SettingMaxFrameSize, 16384},
+		},
+		WindowUpdateIncrement: 12517377,
+		Priorities: []HTTP2Priority{
+			{StreamID: 3, StreamDep: 0, Weight: 200},
+			{StreamID: 5, StreamDep: 0, Weight: 100},
+			{StreamID: 7, StreamDep: 0, Weight: 0},
+			{StreamID: 9, StreamDep: 7, Weight: 0},
+			{StreamID: 11, StreamDep: 3, Weight: 0},
+			{StreamID: 13, StreamDep: 0, Weight: 240},
+		},
+		HeaderPriority:    &HTTP2Priority{StreamDep: 13, Weight: 41},
+		PseudoHeaderOrder: []string{":method", ":path", ":authority", ":scheme"},
+	}
+
+	HTTP2SettingsSafari = &HTTP2Settings{
+		Settings: []HTTP2Setting{
+			{HTTP2SettingEnablePush, 0},
+			{HTTP2SettingMaxConcurrentStreams, 100},
+			{HTTP2SettingInitialWindowSize, 2097152},
+			{HTTP2SettingNoRFC7540Priorities, 1},
+		},
+		WindowUpdateIncrement: 10420225,
+		PseudoHeaderOrder:     []string{":method", ":scheme", ":authority", ":path"},
+	}
+)
+
+// HTTP2SettingsFor returns a copy of the canonical HTTP/2 settings for the
+// browser of id, or nil if there are none.
+func HTTP2SettingsFor(id ClientHelloID) *HTTP2Settings {
+	switch id.Client {
+	case helloChrome, helloEdge, helloAndroid:
+		return HTTP2SettingsChrome.Clone()
+	case helloFirefox:
+		return HTTP2SettingsFirefox.Clone()
+	case helloSafari, helloIOS:
+		return HTTP2SettingsSafari.Clone()
+	}
+	return nil
+}
+
+// Clone returns a deep copy of s.
+func (s *HTTP2Settings) Clone() *HTTP2Settings {
+	if s == nil {
+		return nil
+	}
+	clone := &HTTP2Settings{
+		Settings:              append([]HTTP2Setting(nil), s.Settings...),
+		WindowUpdateIncrement: s.WindowUpdateIncrement,
+		Priorities:            append([]HTTP2Priority(nil), s.Priorities...),
+		PseudoHeaderOrder:     append([]string(nil), s.PseudoHeaderOrder...),
+	}
+	if s.HeaderPriority != nil {
+		p := *s.HeaderPriority
+		clone.HeaderPriority = &p
+	}
+	return clone
+}
+
+// AkamaiFingerprint returns the Akamai HTTP/2 fingerprint of s, as described
+// in "Passive Fingerprinting of HTTP/2 Clients" (Black Hat EU 2017), e.g.
+// "1:65536;2:0;4:6291456;6:262144|15663105|0|m,a,s,p" for Chrome.
+func (s *HTTP2Settings) AkamaiFingerprint() string {
+	var b strings.Builder
+
+	for i, setting := range s.Settings {
+		if i > 0 {
+			b.WriteByte(';')
+		}
+		fmt.Fprintf(&b, "%d:%d", setting.ID, setting.Val)
+	}
+
+	if s.WindowUpdateIncrement > 0 {
+		fmt.Fprintf(&b, "|%d|", s.WindowUpdateIncrement)
+	} else {
+		b.WriteString("|00|")
+	}
+
+	if len(s.Priorities) == 0 {
+		b.WriteByte('0')
+	}
+	for i, p := range s.Priorities {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		exclusive := 0
+		if p.Exclusive {
+			exclusive = 1
+		}
+		fmt.Fprintf(&b, "%d:%d:%d:%d", p.StreamID, exclusive, p.StreamDep, int(p.Weight)+1)
+	}
+
+	b.WriteByte('|')
+	for i, name := range s.PseudoHeaderOrder {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		if name = strings.TrimPrefix(name, ":"); name != "" {
+			b.WriteByte(name[0])
+		}
+	}
+
+	return b.String()
+}
+
+// SetHTTP2Fingerprint records the HTTP/2 settings that should be used on top
+// of uconn, for an HTTP/2 transport to retrieve with HTTP2Fingerprint. It
+// does not change the ClientHello, so the ALPN extension of uconn must offer
+// "h2" for them to be used at all.
+//
+// A nil settings removes a previously set value.
+func (uconn *UConn) SetHTTP2Fingerprint(settings *HTTP2Settings) {
+	uconn.http2Settings = settings.Clone()
+}
+
+// HTTP2Fingerprint returns a copy of the HTTP/2 settings set with
+// SetHTTP2Fingerprint, or nil if none were set.
+func (uconn *UConn) HTTP2Fingerprint() *HTTP2Settings {
+	return uconn.http2Settings.Clone()
+}
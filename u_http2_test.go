@@ -0,0 +1,62 @@
+// Copyright 2024 The uTLS Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tls
+
+import (
+	"net"
+	"testing"
+)
+
+func TestUTLSAkamaiFingerprint(t *testing.T) {
+	tests := []struct {
+		settings *HTTP2Settings
+		expected string
+	}{
+		{HTTP2SettingsChrome, "1:65536;2:0;4:6291456;6:262144|15663105|0|m,a,s,p"},
+		{HTTP2SettingsFirefox, "1:65536;4:131072;5:16384|12517377|3:0:0:201,5:0:0:101,7:0:0:1,9:0:7:1,11:0:3:1,13:0:0:241|m,p,a,s"},
+		{HTTP2SettingsSafari, "2:0;3:100;4:2097152;9:1|10420225|0|m,s,a,p"},
+		{&HTTP2Settings{Settings: []HTTP2Setting{{HTTP2SettingMaxConcurrentStreams, 1000}}}, "3:1000|00|0|"},
+	}
+	for _, test := range tests {
+		if fp := test.settings.AkamaiFingerprint(); fp != test.expected {
+			t.Errorf("got Akamai fingerprint %s, expected %s", fp, test.expected)
+		}
+	}
+}
+
+func TestUTLSSetHTTP2Fingerprint(t *testing.T) {
+	uconn := UClient(&net.TCPConn{}, nil, HelloChrome_120)
+	if uconn.HTTP2Fingerprint() != nil {
+		t.Fatal("got HTTP/2 settings before any were set")
+	}
+
+	settings := HTTP2SettingsFor(uconn.ClientHelloID)
+	uconn.SetHTTP2Fingerprint(settings)
+	settings.Settings[0].Val = 4096
+	settings.HeaderPriority.Weight = 0
+
+	got := uconn.HTTP2Fingerprint()
+	if fp, expected := got.AkamaiFingerprint(), HTTP2SettingsChrome.AkamaiFingerprint(); fp != expected {
+		t.Errorf("got Akamai fingerprint %s, expected %s", fp, expected)
+	}
+	if got.HeaderPriority == nil || got.HeaderPriority.Weight != 255 {
+		t.Errorf("got header priority %+v, expected weight 255", got.HeaderPriority)
+	}
+
+	if HTTP2SettingsFor(HelloFirefox_120).AkamaiFingerprint() != HTTP2SettingsFirefox.AkamaiFingerprint() {
+		t.Error("got wrong HTTP/2 settings for Firefox")
+	}
+	if HTTP2SettingsFor(HelloIOS_18).AkamaiFingerprint() != HTTP2SettingsSafari.AkamaiFingerprint() {
+		t.Error("got wrong HTTP/2 settings for iOS")
+	}
+	if HTTP2SettingsFor(HelloGolang) != nil {
+		t.Error("got HTTP/2 settings for HelloGolang, expected none")
+	}
+
+	uconn.SetHTTP2Fingerprint(nil)
+	if uconn.HTTP2Fingerprint() != nil {
+		t.Error("got HTTP/2 settings after they were removed")
+	}
+}
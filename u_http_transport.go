@@ -0,0 +1,183 @@
+// Copyright 2024 The uTLS Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tls
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+// AcceptEncodingFor returns the Accept-Encoding header that the browser or
+// client of id sends with its requests, or "" if it doesn't send one or is
+// unknown. Chrome and Firefox offer zstd since versions 123 and 126.
+func AcceptEncodingFor(id ClientHelloID) string {
+	const (
+		withBrotli = "gzip, deflate, br"
+		withZstd   = "gzip, deflate, br, zstd"
+	)
+	major := helloMajorVersion(id)
+	switch id.Client {
+	case helloChrome, helloEdge:
+		if major >= 123 {
+			return withZstd
+		}
+		return withBrotli
+	case helloFirefox:
+		if major >= 126 {
+			return withZstd
+		}
+		return withBrotli
+	case helloSafari, helloIOS, hello360, helloQQ:
+		return withBrotli
+	case helloAndroid:
+		return "gzip"
+	}
+	return ""
+}
+
+// helloMajorVersion returns the leading number of the version of id, e.g. 131
+// for "131_PQ", or 0 if there is none.
+func helloMajorVersion(id ClientHelloID) int {
+	end := strings.IndexFunc(id.Version, func(r rune) bool { return r < '0' || r > '9' })
+	if end < 0 {
+		end = len(id.Version)
+	}
+	major, _ := strconv.Atoi(id.Version[:end])
+	return major
+}
+
+// fingerprintAwareTransport is the http.RoundTripper returned by
+// NewFingerprintAwareTransport.
+type fingerprintAwareTransport struct {
+	acceptEncoding string
+	base           http.RoundTripper
+}
+
+// NewFingerprintAwareTransport returns an http.RoundTripper that sends the
+// requests with base, which should connect with the ClientHello of id, and
+// sets the Accept-Encoding header of the browser of id, see AcceptEncodingFor.
+// Servers may respond differently to clients whose HTTP headers don't match
+// their TLS fingerprint. A nil base is http.DefaultTransport.
+//
+// Like http.Transport does for gzip, responses are transparently decoded,
+// with gzip, deflate, br or zstd, when the header was set by the returned
+// RoundTripper: their Content-Encoding and Content-Length headers are removed
+// and Response.Uncompressed is set. Requests that already have an
+// Accept-Encoding or a Range header are sent unmodified, and their responses
+// returned as is. So are all requests if the browser of id is unknown.
+func NewFingerprintAwareTransport(id ClientHelloID, base http.RoundTripper) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &fingerprintAwareTransport{acceptEncoding: AcceptEncodingFor(id), base: base}
+}
+
+func (t *fingerprintAwareTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if t.acceptEncoding == "" || req.Header.Get("Accept-Encoding") != "" || req.Header.Get("Range") != "" {
+		return t.base.RoundTrip(req)
+	}
+	req = req.Clone(req.Context())
+	req.Header.Set("Accept-Encoding", t.acceptEncoding)
+	resp, err := t.base.RoundTrip(req)
+	if err != nil || resp.Body == nil || req.Method == http.MethodHead {
+		return resp, err
+	}
+
+	codings := strings.Split(resp.Header.Get("Content-Encoding"), ",")
+	body := resp.Body
+	// the last coding was applied last, so it is decoded first
+	for i := len(codings) - 1; i >= 0; i-- {
+		coding := strings.ToLower(strings.TrimSpace(codings[i]))
+		if coding == "" || coding == "identity" {
+			continue
+		}
+		decoded, err := newContentDecoder(coding, body)
+		if err != nil {
+			body.Close()
+			return nil, err
+		}
+		body = decoded
+	}
+	if body != resp.Body {
+		resp.Body = body
+		resp.Header.Del("Content-Encoding")
+		resp.Header.Del("Content-Length")
+		resp.ContentLength = -1
+		resp.Uncompressed = true
+	}
+	return resp, nil
+}
+
+// contentDecoder reads the body of a response decoded with coding, a
+// lowercase Content-Encoding. Like the gzip reader of http.Transport, the
+// decoder is only created on the first Read, so that RoundTrip doesn't wait
+// for the body.
+type contentDecoder struct {
+	coding string
+	body   io.ReadCloser
+	r      io.Reader
+	close  func() // closes r, if needed
+	err    error
+}
+
+func newContentDecoder(coding string, body io.ReadCloser) (io.ReadCloser, error) {
+	switch coding {
+	case "gzip", "x-gzip", "deflate", "br", "zstd":
+		return &contentDecoder{coding: coding, body: body}, nil
+	}
+	return nil, fmt.Errorf("tls: unsupported Content-Encoding %q", coding)
+}
+
+func (d *contentDecoder) Read(p []byte) (int, error) {
+	if d.r == nil && d.err == nil {
+		d.r, d.err = d.open()
+	}
+	if d.err != nil {
+		return 0, d.err
+	}
+	return d.r.Read(p)
+}
+
+func (d *contentDecoder) open() (io.Reader, error) {
+	switch d.coding {
+	case "gzip", "x-gzip":
+		return gzip.NewReader(d.body)
+	case "deflate":
+		// "deflate" is meant to be zlib, but some servers send raw DEFLATE,
+		// which browsers accept as well.
+		br := bufio.NewReader(d.body)
+		header, err := br.Peek(2)
+		if err == nil && header[0]&0x0f == 8 && (uint16(header[0])<<8|uint16(header[1]))%31 == 0 {
+			return zlib.NewReader(br)
+		}
+		return flate.NewReader(br), nil
+	case "br":
+		return brotli.NewReader(d.body), nil
+	default: // zstd
+		decoder, err := zstd.NewReader(d.body)
+		if err != nil {
+			return nil, err
+		}
+		d.close = decoder.Close
+		return decoder, nil
+	}
+}
+
+func (d *contentDecoder) Close() error {
+	if d.close != nil {
+		d.close()
+	}
+	return d.body.Close()
+}
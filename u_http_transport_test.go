@@ -0,0 +1,136 @@
+// Copyright 2024 The uTLS Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tls
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestUTLSAcceptEncodingFor(t *testing.T) {
+	for _, test := range []struct {
+		id       ClientHelloID
+		expected string
+	}{
+		{HelloChrome_Auto, "gzip, deflate, br"},
+		{HelloChrome_131, "gzip, deflate, br, zstd"},
+		{HelloChrome_100_PSK, "gzip, deflate, br"},
+		{HelloFirefox_120, "gzip, deflate, br"},
+		{HelloFirefox_133, "gzip, deflate, br, zstd"},
+		{HelloSafari_18, "gzip, deflate, br"},
+		{HelloIOS_12_1, "gzip, deflate, br"},
+		{HelloGolang, ""},
+		{HelloRandomized, ""},
+	} {
+		if got := AcceptEncodingFor(test.id); got != test.expected {
+			t.Errorf("%s: got Accept-Encoding %q, expected %q", test.id.Str(), got, test.expected)
+		}
+	}
+}
+
+func TestUTLSFingerprintAwareTransport(t *testing.T) {
+	const content = "hello, hello, hello, hello"
+	encode := func(coding string) []byte {
+		var buf bytes.Buffer
+		var w io.WriteCloser
+		switch coding {
+		case "gzip":
+			w = gzip.NewWriter(&buf)
+		case "deflate":
+			w = zlib.NewWriter(&buf)
+		case "raw deflate":
+			w, _ = flate.NewWriter(&buf, flate.DefaultCompression)
+		case "br":
+			w = brotli.NewWriter(&buf)
+		case "zstd":
+			w, _ = zstd.NewWriter(&buf)
+		}
+		w.Write([]byte(content))
+		w.Close()
+		return buf.Bytes()
+	}
+
+	var sent http.Header
+	respond := func(coding string, body []byte) http.RoundTripper {
+		return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			sent = req.Header
+			header := http.Header{}
+			if coding != "" {
+				header.Set("Content-Encoding", coding)
+			}
+			return &http.Response{
+				StatusCode:    http.StatusOK,
+				Header:        header,
+				Body:          io.NopCloser(bytes.NewReader(body)),
+				ContentLength: int64(len(body)),
+			}, nil
+		})
+	}
+
+	for _, test := range []struct {
+		coding string
+		body   []byte
+	}{
+		{"", []byte(content)},
+		{"gzip", encode("gzip")},
+		{"deflate", encode("deflate")},
+		{"deflate", encode("raw deflate")},
+		{"br", encode("br")},
+		{"zstd", encode("zstd")},
+	} {
+		req, _ := http.NewRequest("GET", "https://example.golang/", nil)
+		resp, err := NewFingerprintAwareTransport(HelloChrome_131, respond(test.coding, test.body)).RoundTrip(req)
+		if err != nil {
+			t.Fatalf("%s: got error: %v; expected to succeed", test.coding, err)
+		}
+		if got := sent.Get("Accept-Encoding"); got != "gzip, deflate, br, zstd" {
+			t.Errorf("%s: sent Accept-Encoding %q, expected Chrome's", test.coding, got)
+		}
+		if req.Header.Get("Accept-Encoding") != "" {
+			t.Errorf("%s: the request was modified", test.coding)
+		}
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil || string(body) != content {
+			t.Errorf("%s: got body %q (error: %v), expected %q", test.coding, body, err, content)
+		}
+		if test.coding != "" && (resp.Header.Get("Content-Encoding") != "" || !resp.Uncompressed || resp.ContentLength != -1) {
+			t.Errorf("%s: got Content-Encoding %q, Uncompressed %v and ContentLength %d for a decoded response", test.coding,
+				resp.Header.Get("Content-Encoding"), resp.Uncompressed, resp.ContentLength)
+		}
+	}
+
+	// the caller's Accept-Encoding is kept, and the response isn't decoded
+	req, _ := http.NewRequest("GET", "https://example.golang/", nil)
+	req.Header.Set("Accept-Encoding", "br")
+	resp, err := NewFingerprintAwareTransport(HelloChrome_131, respond("br", encode("br"))).RoundTrip(req)
+	if err != nil {
+		t.Fatalf("got error: %v; expected to succeed", err)
+	}
+	if sent.Get("Accept-Encoding") != "br" || resp.Header.Get("Content-Encoding") != "br" || resp.Uncompressed {
+		t.Errorf("sent Accept-Encoding %q and got Content-Encoding %q, expected br to be left alone", sent.Get("Accept-Encoding"), resp.Header.Get("Content-Encoding"))
+	}
+
+	// an encoding that wasn't offered
+	req, _ = http.NewRequest("GET", "https://example.golang/", nil)
+	if _, err := NewFingerprintAwareTransport(HelloChrome_131, respond("compress", []byte(content))).RoundTrip(req); err == nil || !strings.Contains(err.Error(), "compress") {
+		t.Errorf("got error %v, expected an unsupported Content-Encoding", err)
+	}
+}
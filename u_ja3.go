@@ -0,0 +1,290 @@
+// Copyright 2024 The uTLS Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tls
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// JA3 returns the JA3 string (https://github.com/salesforce/ja3) of the
+// ClientHello described by chs, in the form
+//
+//	SSLVersion,Ciphers,Extensions,EllipticCurves,EllipticCurvePointFormats
+//
+// GREASE values are omitted from every field. The padding extension is
+// reported whenever it is part of the spec, even though whether it is
+// actually sent depends on the final length of the ClientHello.
+//
+// Note that some parrots shuffle their extensions each time the spec is
+// generated (see ShuffleChromeTLSExtensions), so their JA3 is not stable.
+func (chs *ClientHelloSpec) JA3() string {
+	var curves []uint16
+	var points []uint16
+	var exts []uint16
+	for _, ext := range chs.Extensions {
+		if _, ok := ext.(*UtlsGREASEExtension); ok {
+			continue
+		}
+		id, ok := extensionIDOf(ext)
+		if !ok {
+			raw, sent, err := marshalExtension(ext)
+			if err != nil || !sent {
+				continue
+			}
+			id = raw.extType
+		}
+		if isGREASEUint16(id) {
+			continue
+		}
+		exts = append(exts, id)
+
+		switch e := ext.(type) {
+		case *SupportedCurvesExtension:
+			for _, curve := range e.Curves {
+				if !isGREASEUint16(uint16(curve)) {
+					curves = append(curves, uint16(curve))
+				}
+			}
+		case *SupportedPointsExtension:
+			for _, point := range e.SupportedPoints {
+				points = append(points, uint16(point))
+			}
+		}
+	}
+
+	var ciphers []uint16
+	for _, suite := range chs.CipherSuites {
+		if !isGREASEUint16(suite) {
+			ciphers = append(ciphers, suite)
+		}
+	}
+
+	return strconv.Itoa(int(chs.legacyVersion())) + "," +
+		joinDecimal(ciphers) + "," +
+		joinDecimal(exts) + "," +
+		joinDecimal(curves) + "," +
+		joinDecimal(points)
+}
+
+// JA3Digest returns the hex encoded MD5 hash of chs.JA3().
+func (chs *ClientHelloSpec) JA3Digest() string {
+	sum := md5.Sum([]byte(chs.JA3()))
+	return hex.EncodeToString(sum[:])
+}
+
+// JA3Blocklist maps the JA3 digests of ClientHellos that are widely blocked to
+// the name of the client that sends them, see (*UConn).IsLikelyFlagged. It
+// holds the default ClientHellos of Go's crypto/tls, which uTLS sends with
+// HelloGolang, with and without HTTP/2 ALPN as net/http sends them. Entries
+// may be added before connections are made, but the map must not be modified
+// concurrently with IsLikelyFlagged.
+//
+// The crypto/tls entries were captured from Go 1.27 with the GODEBUG defaults
+// of each go version of the go.mod of the main module, which select the
+// post-quantum groups that are offered. The ClientHellos of older Go
+// releases, which also offer the cipher suites that Go 1.27 removed, are not
+// listed.
+var JA3Blocklist = map[string]string{
+	"88476a293aeb6f5dc7a3630d391c2980": "Go crypto/tls (HelloGolang)",
+	"fd75aaca18604d62f2bc8b02b345140f": "Go net/http (HelloGolang)",
+	"4743044c2dced5918d0e89957116ae91": "Go crypto/tls without AES-GCM hardware (HelloGolang)",
+	"a5ffe99b4afe2bbee3bb55e9ad67fc7a": "Go net/http without AES-GCM hardware (HelloGolang)",
+
+	// go 1.21 to 1.23: X25519, P-256, P-384 and P-521
+	"6aa3e70ad597aeef07e78d50366922c1": "Go crypto/tls (go 1.21 to 1.23)",
+	"95b6f6d62c2c0f5258859e829e0055f5": "Go net/http (go 1.21 to 1.23)",
+	// go 1.24 and 1.25: X25519MLKEM768 first
+	"20b279993ae2e137e62b9647c6d768fb": "Go crypto/tls (go 1.24 and 1.25)",
+	"e69402f870ecf542b4f017b0ed32936a": "Go net/http (go 1.24 and 1.25)",
+	// go 1.26 and later: X25519MLKEM768, SecP256r1MLKEM768 and
+	// SecP384r1MLKEM1024 first
+	"9b7dcdf3f997f1fb7b4409c94cb7ef36": "Go crypto/tls (go 1.26 and later)",
+	"03117a8ed39ef02427ebbc39f121275c": "Go net/http (go 1.26 and later)",
+}
+
+// IsLikelyFlagged reports whether the JA3 digest of the ClientHello that
+// uconn sends is in JA3Blocklist, and if so, the name of the client it
+// belongs to. It can be called once the ClientHello is built, e.g. by
+// BuildHandshakeState, and reports false before that.
+func (uconn *UConn) IsLikelyFlagged() (bool, string) {
+	hello := uconn.HandshakeState.Hello
+	if hello == nil {
+		return false, ""
+	}
+	raw := hello.Raw
+	if raw == nil {
+		// HelloGolang is only marshaled when it is sent.
+		var err error
+		if raw, err = hello.getPrivatePtr().marshal(); err != nil {
+			return false, ""
+		}
+	}
+	record := append([]byte{byte(recordTypeHandshake), 3, 1, byte(len(raw) >> 8), byte(len(raw))}, raw...)
+	spec, err := (&Fingerprinter{AllowBluntMimicry: true}).RawClientHello(record)
+	if err != nil {
+		return false, ""
+	}
+	name, ok := JA3Blocklist[spec.JA3Digest()]
+	return ok, name
+}
+
+// ClientHelloSpecFromJA3 builds the closest possible ClientHelloSpec from
+// a JA3 string, as produced by (*ClientHelloSpec).JA3.
+//
+// JA3 carries only the extension types, so most extensions are created with
+// empty bodies. The exceptions are supported_groups and ec_point_formats,
+// which are taken from the JA3 itself, and extensions that are required for
+// a working handshake (e.g. signature_algorithms, supported_versions and
+// key_share), which are filled with Chrome-like defaults. Unknown extension
+// types are added as GenericExtension without data.
+func ClientHelloSpecFromJA3(ja3 string) (ClientHelloSpec, error) {
+	fields := strings.Split(ja3, ",")
+	if len(fields) != 5 {
+		return ClientHelloSpec{}, fmt.Errorf("tls: JA3 must have 5 comma separated fields, got %d", len(fields))
+	}
+
+	vers, err := strconv.ParseUint(fields[0], 10, 16)
+	if err != nil {
+		return ClientHelloSpec{}, fmt.Errorf("tls: invalid JA3 version %q: %w", fields[0], err)
+	}
+	var values [4][]uint16
+	for i, field := range fields[1:] {
+		values[i], err = splitDecimal(field)
+		if err != nil {
+			return ClientHelloSpec{}, fmt.Errorf("tls: invalid JA3 field %d: %w", i+2, err)
+		}
+	}
+	ciphers, extIDs, curveIDs, pointIDs := values[0], values[1], values[2], values[3]
+
+	curves := make([]CurveID, len(curveIDs))
+	for i, curve := range curveIDs {
+		curves[i] = CurveID(curve)
+	}
+	points := make([]uint8, len(pointIDs))
+	for i, point := range pointIDs {
+		if point > 0xff {
+			return ClientHelloSpec{}, fmt.Errorf("tls: invalid JA3 point format %d", point)
+		}
+		points[i] = uint8(point)
+	}
+
+	spec := ClientHelloSpec{
+		CipherSuites:       ciphers,
+		CompressionMethods: []uint8{compressionNone},
+		TLSVersMin:         VersionTLS10,
+		TLSVersMax:         uint16(vers),
+	}
+	for _, id := range extIDs {
+		var ext TLSExtension
+		switch id {
+		case extensionSupportedCurves:
+			ext = &SupportedCurvesExtension{Curves: curves}
+		case extensionSupportedPoints:
+			ext = &SupportedPointsExtension{SupportedPoints: points}
+		case extensionALPN:
+			ext = &ALPNExtension{AlpnProtocols: []string{"h2", "http/1.1"}}
+		case extensionSignatureAlgorithms:
+			ext = &SignatureAlgorithmsExtension{SupportedSignatureAlgorithms: []SignatureScheme{
+				ECDSAWithP256AndSHA256,
+				PSSWithSHA256,
+				PKCS1WithSHA256,
+				ECDSAWithP384AndSHA384,
+				PSSWithSHA384,
+				PKCS1WithSHA384,
+				PSSWithSHA512,
+				PKCS1WithSHA512,
+			}}
+		case extensionSupportedVersions:
+			spec.TLSVersMin = 0
+			spec.TLSVersMax = 0
+			ext = &SupportedVersionsExtension{Versions: []uint16{VersionTLS13, VersionTLS12}}
+		case extensionKeyShare:
+			group := X25519
+			if len(curves) > 0 {
+				group = curves[0]
+				for _, curve := range curves {
+					if curve == X25519 {
+						group = X25519
+						break
+					}
+				}
+			}
+			ext = &KeyShareExtension{KeyShares: []KeyShare{{Group: group}}}
+		case extensionPSKModes:
+			ext = &PSKKeyExchangeModesExtension{Modes: []uint8{PskModeDHE}}
+		case extensionRenegotiationInfo:
+			ext = &RenegotiationInfoExtension{Renegotiation: RenegotiateOnceAsClient}
+		case utlsExtensionCompressCertificate:
+			ext = &UtlsCompressCertExtension{Algorithms: []CertCompressionAlgo{CertCompressionBrotli}}
+		case utlsExtensionApplicationSettings, utlsExtensionApplicationSettingsNew:
+			ext = &ApplicationSettingsExtension{CodePoint: id, SupportedProtocols: []string{"h2"}}
+		case utlsExtensionPadding:
+			ext = &UtlsPaddingExtension{GetPaddingLen: BoringPaddingStyle}
+		case utlsExtensionECH:
+			ext = BoringGREASEECH()
+		default:
+			ext = ExtensionFromID(id)
+			if ext == nil {
+				ext = &GenericExtension{Id: id}
+			}
+		}
+		spec.Extensions = append(spec.Extensions, ext)
+	}
+
+	return spec, nil
+}
+
+// splitDecimal parses dash separated decimal numbers.
+func splitDecimal(s string) ([]uint16, error) {
+	if len(s) == 0 {
+		return nil, nil
+	}
+	strs := strings.Split(s, "-")
+	values := make([]uint16, len(strs))
+	for i, str := range strs {
+		v, err := strconv.ParseUint(str, 10, 16)
+		if err != nil {
+			return nil, err
+		}
+		values[i] = uint16(v)
+	}
+	return values, nil
+}
+
+// legacyVersion returns the legacy_version field of a ClientHello built
+// from chs, which is capped at TLS 1.2 as required by RFC 8446, Section 4.1.2.
+func (chs *ClientHelloSpec) legacyVersion() uint16 {
+	vers := chs.TLSVersMax
+	if vers == 0 {
+		vers = VersionTLS12
+		for _, ext := range chs.Extensions {
+			if sv, ok := ext.(*SupportedVersionsExtension); ok {
+				vers = 0
+				for _, v := range sv.Versions {
+					if !isGREASEUint16(v) && v > vers {
+						vers = v
+					}
+				}
+			}
+		}
+	}
+	if vers > VersionTLS12 {
+		vers = VersionTLS12
+	}
+	return vers
+}
+
+// joinDecimal formats values as dash separated decimal numbers.
+func joinDecimal(values []uint16) string {
+	strs := make([]string, len(values))
+	for i, v := range values {
+		strs[i] = strconv.Itoa(int(v))
+	}
+	return strings.Join(strs, "-")
+}
@@ -0,0 +1,190 @@
+// Copyright 2024 The uTLS Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tls
+
+import (
+	"crypto/tls"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestUTLSJA3(t *testing.T) {
+	tests := []struct {
+		id     ClientHelloID
+		ja3    string
+		digest string
+	}{
+		{
+			HelloChrome_100,
+			"771,4865-4866-4867-49195-49199-49196-49200-52393-52392-49171-49172-156-157-47-53,0-23-65281-10-11-35-16-5-13-18-51-45-43-27-17513-21,29-23-24,0",
+			"cd08e31494f9531f560d64c695473da9",
+		},
+		{
+			HelloFirefox_105,
+			"771,4865-4867-4866-49195-49199-52393-52392-49196-49200-49162-49161-49171-49172-156-157-47-53,0-23-65281-10-11-35-16-5-34-51-43-13-45-28-21,29-23-24-25-256-257,0",
+			"579ccef312d18482fc42e2b822ca2430",
+		},
+	}
+
+	for _, test := range tests {
+		spec := mustSpec(t, test.id)
+		if ja3 := spec.JA3(); ja3 != test.ja3 {
+			t.Errorf("%s: got JA3 %s, expected %s", test.id.Str(), ja3, test.ja3)
+		}
+		if digest := spec.JA3Digest(); digest != test.digest {
+			t.Errorf("%s: got JA3 digest %s, expected %s", test.id.Str(), digest, test.digest)
+		}
+	}
+}
+
+func TestUTLSJA3EdgeCases(t *testing.T) {
+	spec := &ClientHelloSpec{
+		CipherSuites: []uint16{GREASE_PLACEHOLDER, TLS_AES_128_GCM_SHA256, 0x1a1a, TLS_CHACHA20_POLY1305_SHA256},
+		Extensions: []TLSExtension{
+			&UtlsGREASEExtension{},
+			&SNIExtension{},
+			&SupportedCurvesExtension{},
+			&GenericExtension{Id: 0xfafa},
+			&SupportedPointsExtension{SupportedPoints: []byte{0, 1}},
+			&SupportedVersionsExtension{Versions: []uint16{GREASE_PLACEHOLDER, VersionTLS13, VersionTLS12}},
+		},
+	}
+	if ja3, expected := spec.JA3(), "771,4865-4867,0-10-11-43,,0-1"; ja3 != expected {
+		t.Errorf("got JA3 %s, expected %s", ja3, expected)
+	}
+
+	spec = &ClientHelloSpec{TLSVersMax: VersionTLS11}
+	if ja3, expected := spec.JA3(), "770,,,,"; ja3 != expected {
+		t.Errorf("got JA3 %s, expected %s", ja3, expected)
+	}
+}
+
+func TestUTLSClientHelloSpecFromJA3(t *testing.T) {
+	for _, id := range []ClientHelloID{HelloChrome_100, HelloFirefox_105, HelloIOS_14} {
+		ja3 := mustSpec(t, id).JA3()
+		spec, err := ClientHelloSpecFromJA3(ja3)
+		if err != nil {
+			t.Fatalf("%s: got error: %v; expected to succeed", id.Str(), err)
+		}
+		if got := spec.JA3(); got != ja3 {
+			t.Errorf("%s: got JA3 %s after round trip, expected %s", id.Str(), got, ja3)
+		}
+
+		uconn := UClient(&net.TCPConn{}, &Config{ServerName: "example.com"}, HelloCustom)
+		if err := uconn.ApplyPreset(&spec); err != nil {
+			t.Fatalf("%s: got error: %v; expected to succeed", id.Str(), err)
+		}
+		if err := uconn.BuildHandshakeState(); err != nil {
+			t.Fatalf("%s: got error: %v; expected to succeed", id.Str(), err)
+		}
+	}
+}
+
+func TestUTLSClientHelloSpecFromJA3Unknown(t *testing.T) {
+	spec, err := ClientHelloSpecFromJA3("771,4865,0-1234-10,29,")
+	if err != nil {
+		t.Fatalf("got error: %v; expected to succeed", err)
+	}
+	if len(spec.Extensions) != 3 {
+		t.Fatalf("got %d extensions, expected 3", len(spec.Extensions))
+	}
+	generic, ok := spec.Extensions[1].(*GenericExtension)
+	if !ok || generic.Id != 1234 || len(generic.Data) != 0 {
+		t.Errorf("got %#v, expected empty GenericExtension with Id 1234", spec.Extensions[1])
+	}
+	curves, ok := spec.Extensions[2].(*SupportedCurvesExtension)
+	if !ok || !sliceEq(curves.Curves, []CurveID{X25519}) {
+		t.Errorf("got %#v, expected SupportedCurvesExtension with X25519", spec.Extensions[2])
+	}
+}
+
+func TestUTLSClientHelloSpecFromJA3Invalid(t *testing.T) {
+	for _, ja3 := range []string{
+		"",
+		"771,4865,0-10,29",
+		"771,4865,0-10,29,0,0",
+		"771,4865,0-x,29,0",
+		"771,4865,0-10,29,256",
+	} {
+		_, err := ClientHelloSpecFromJA3(ja3)
+		if err == nil || !strings.HasPrefix(err.Error(), "tls: ") {
+			t.Errorf("ClientHelloSpecFromJA3(%q): got error %v, expected descriptive error", ja3, err)
+		}
+	}
+}
+
+func TestUTLSIsLikelyFlagged(t *testing.T) {
+	defer func(aesGCM bool) { hasAESGCMHardwareSupport = aesGCM }(hasAESGCMHardwareSupport)
+	for _, test := range []struct {
+		config  *Config
+		aesGCM  bool
+		flagged string
+	}{
+		{&Config{ServerName: "example.com"}, true, "Go crypto/tls (HelloGolang)"},
+		{&Config{ServerName: "example.com", NextProtos: []string{"h2", "http/1.1"}}, true, "Go net/http (HelloGolang)"},
+		{&Config{ServerName: "example.com"}, false, "Go crypto/tls without AES-GCM hardware (HelloGolang)"},
+		{&Config{ServerName: "example.com", NextProtos: []string{"h2", "http/1.1"}}, false, "Go net/http without AES-GCM hardware (HelloGolang)"},
+	} {
+		hasAESGCMHardwareSupport = test.aesGCM
+		uconn := UClient(&net.TCPConn{}, test.config, HelloGolang)
+		if flagged, name := uconn.IsLikelyFlagged(); flagged {
+			t.Errorf("got %q flagged before the ClientHello is built", name)
+		}
+		if err := uconn.BuildHandshakeState(); err != nil {
+			t.Fatalf("got error: %v; expected to succeed", err)
+		}
+		if flagged, name := uconn.IsLikelyFlagged(); !flagged || name != test.flagged {
+			t.Errorf("got flagged %v as %q, expected %q", flagged, name, test.flagged)
+		}
+	}
+
+	// the ClientHellos sent by crypto/tls itself, with the GODEBUG defaults of
+	// the go version of go.mod
+	for _, protos := range [][]string{nil, {"h2", "http/1.1"}} {
+		c, s := net.Pipe()
+		go func() {
+			tls.Client(c, &tls.Config{ServerName: "example.com", NextProtos: protos}).Handshake()
+			c.Close()
+		}()
+		spec, _, err := (&Fingerprinter{AllowBluntMimicry: true}).FingerprintFromReader(s)
+		s.Close()
+		if err != nil {
+			t.Fatalf("got error: %v; expected to succeed", err)
+		}
+		uconn := UClient(&net.TCPConn{}, &Config{ServerName: "example.com", NextProtos: protos}, HelloCustom)
+		if err := uconn.ApplyPreset(spec); err != nil {
+			t.Fatalf("got error: %v; expected to succeed", err)
+		}
+		if err := uconn.BuildHandshakeState(); err != nil {
+			t.Fatalf("got error: %v; expected to succeed", err)
+		}
+		if flagged, _ := uconn.IsLikelyFlagged(); !flagged {
+			t.Errorf("the crypto/tls ClientHello with ALPN %v is not flagged, JA3 %s", protos, spec.JA3())
+		}
+	}
+
+	for _, id := range []ClientHelloID{HelloChrome_120, HelloFirefox_120, HelloSafari_18, HelloChrome_Auto} {
+		uconn := UClient(&net.TCPConn{}, &Config{ServerName: "example.com"}, id)
+		if err := uconn.BuildHandshakeState(); err != nil {
+			t.Fatalf("got error: %v; expected to succeed", err)
+		}
+		if flagged, name := uconn.IsLikelyFlagged(); flagged {
+			t.Errorf("%s: got flagged as %q", id.Str(), name)
+		}
+	}
+
+	// users can extend the blocklist
+	spec := mustSpec(t, HelloFirefox_120)
+	JA3Blocklist[spec.JA3Digest()] = "test"
+	defer delete(JA3Blocklist, spec.JA3Digest())
+	uconn := UClient(&net.TCPConn{}, &Config{ServerName: "example.com"}, HelloFirefox_120)
+	if err := uconn.BuildHandshakeState(); err != nil {
+		t.Fatalf("got error: %v; expected to succeed", err)
+	}
+	if flagged, name := uconn.IsLikelyFlagged(); !flagged || name != "test" {
+		t.Errorf("got flagged %v as %q, expected the added entry", flagged, name)
+	}
+}
@@ -0,0 +1,342 @@
+// Copyright 2024 The uTLS Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tls
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/cryptobyte"
+)
+
+// rawExtension is a single extension as it appears on the wire:
+// the 2-byte type followed by the extension_data (without its length prefix).
+type rawExtension struct {
+	extType uint16
+	data    []byte
+}
+
+// marshalExtension serializes ext and splits the result into extension
+// type and data. ok is false if the extension would not be sent at all
+// (e.g. SNI with an empty server name or padding that is not needed).
+func marshalExtension(ext TLSExtension) (raw rawExtension, ok bool, err error) {
+	extLen := ext.Len()
+	if extLen == 0 {
+		return rawExtension{}, false, nil
+	}
+	if extLen < 4 {
+		return rawExtension{}, false, fmt.Errorf("tls: extension %T has invalid length %d", ext, extLen)
+	}
+	b := make([]byte, extLen)
+	if _, err := ext.Read(b); err != nil && err != io.EOF {
+		return rawExtension{}, false, fmt.Errorf("tls: failed to marshal extension %T: %w", ext, err)
+	}
+	return rawExtension{
+		extType: uint16(b[0])<<8 | uint16(b[1]),
+		data:    b[4:],
+	}, true, nil
+}
+
+// JA4 returns the JA4 fingerprint (https://github.com/FoxIO-LLC/ja4) of
+// the ClientHello that uconn is configured to send.
+//
+// It may be called once a ClientHelloSpec has been applied, either via
+// ApplyPreset or BuildHandshakeState, and before the handshake. Presence of
+// the padding extension is determined the same way MarshalClientHello does.
+//
+// HelloGolang is not supported, since its ClientHello is not built from
+// uconn.Extensions.
+func (uconn *UConn) JA4() (string, error) {
+	if uconn.ClientHelloID.Client == helloGolang {
+		return "", errors.New("tls: JA4 is not supported for HelloGolang")
+	}
+	hello := uconn.HandshakeState.Hello
+	if hello == nil {
+		return "", errors.New("tls: ClientHello is not built yet")
+	}
+
+	exts, err := uconn.wireExtensions()
+	if err != nil {
+		return "", err
+	}
+	return ja4(uconn.quic != nil, hello.Vers, hello.CipherSuites, exts)
+}
+
+// wireExtensions returns the extensions of the ClientHello that uconn is
+// configured to send, as they would be marshaled. Presence of the padding
+// extension is determined the same way MarshalClientHello does, but its
+// data is left empty.
+func (uconn *UConn) wireExtensions() ([]rawExtension, error) {
+	hello := uconn.HandshakeState.Hello
+
+	// padding presence depends on the length of the rest of the ClientHello
+	unpaddedLen := 2 + 32 + 1 + len(hello.SessionId) +
+		2 + len(hello.CipherSuites)*2 +
+		1 + len(hello.CompressionMethods) + 2
+	for _, ext := range uconn.Extensions {
+		if _, ok := ext.(*UtlsPaddingExtension); !ok {
+			unpaddedLen += ext.Len()
+		}
+	}
+
+	var exts []rawExtension
+	for _, ext := range uconn.Extensions {
+		if pe, ok := ext.(*UtlsPaddingExtension); ok && pe.GetPaddingLen != nil {
+			if _, willPad := pe.GetPaddingLen(unpaddedLen + 4); willPad {
+				exts = append(exts, rawExtension{extType: utlsExtensionPadding})
+			}
+			continue
+		}
+		raw, ok, err := marshalExtension(ext)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			exts = append(exts, raw)
+		}
+	}
+
+	return exts, nil
+}
+
+// ja4 computes the JA4 fingerprint from the ClientHello fields.
+func ja4(quic bool, legacyVers uint16, cipherSuites []uint16, exts []rawExtension) (string, error) {
+	var b strings.Builder
+
+	// JA4_a
+	if quic {
+		b.WriteByte('q')
+	} else {
+		b.WriteByte('t')
+	}
+
+	vers := legacyVers
+	hasSNI := false
+	alpn := ""
+	var sigAlgs []uint16
+	var extTypes []uint16
+	extCount := 0
+	for _, ext := range exts {
+		if isGREASEUint16(ext.extType) {
+			continue
+		}
+		extCount++
+
+		extData := cryptobyte.String(ext.data)
+		switch ext.extType {
+		case extensionServerName:
+			hasSNI = true
+			continue // not included in JA4_c
+		case extensionALPN:
+			var protoList, proto cryptobyte.String
+			if !extData.ReadUint16LengthPrefixed(&protoList) ||
+				!protoList.ReadUint8LengthPrefixed(&proto) {
+				return "", errors.New("tls: malformed ALPN extension")
+			}
+			alpn = string(proto)
+			continue // not included in JA4_c
+		case extensionSupportedVersions:
+			var versList cryptobyte.String
+			if !extData.ReadUint8LengthPrefixed(&versList) {
+				return "", errors.New("tls: malformed supported_versions extension")
+			}
+			var highest uint16
+			for !versList.Empty() {
+				var v uint16
+				if !versList.ReadUint16(&v) {
+					return "", errors.New("tls: malformed supported_versions extension")
+				}
+				if !isGREASEUint16(v) && v > highest {
+					highest = v
+				}
+			}
+			if highest != 0 {
+				vers = highest
+			}
+		case extensionSignatureAlgorithms:
+			var sigAlgList cryptobyte.String
+			if !extData.ReadUint16LengthPrefixed(&sigAlgList) {
+				return "", errors.New("tls: malformed signature_algorithms extension")
+			}
+			for !sigAlgList.Empty() {
+				var sigAlg uint16
+				if !sigAlgList.ReadUint16(&sigAlg) {
+					return "", errors.New("tls: malformed signature_algorithms extension")
+				}
+				sigAlgs = append(sigAlgs, sigAlg)
+			}
+		}
+		extTypes = append(extTypes, ext.extType)
+	}
+
+	b.WriteString(ja4Version(vers))
+	if hasSNI {
+		b.WriteByte('d')
+	} else {
+		b.WriteByte('i')
+	}
+
+	var ciphers []uint16
+	for _, suite := range cipherSuites {
+		if !isGREASEUint16(suite) {
+			ciphers = append(ciphers, suite)
+		}
+	}
+	fmt.Fprintf(&b, "%02d%02d", min(len(ciphers), 99), min(extCount, 99))
+	b.WriteString(ja4ALPN(alpn))
+
+	// JA4_b
+	b.WriteByte('_')
+	sort.Slice(ciphers, func(i, j int) bool { return ciphers[i] < ciphers[j] })
+	b.WriteString(ja4Hash(joinHex(ciphers)))
+
+	// JA4_c
+	b.WriteByte('_')
+	sort.Slice(extTypes, func(i, j int) bool { return extTypes[i] < extTypes[j] })
+	if len(extTypes) == 0 {
+		b.WriteString(ja4Hash(""))
+	} else if len(sigAlgs) == 0 {
+		b.WriteString(ja4Hash(joinHex(extTypes)))
+	} else {
+		b.WriteString(ja4Hash(joinHex(extTypes) + "_" + joinHex(sigAlgs)))
+	}
+
+	return b.String(), nil
+}
+
+func ja4Version(vers uint16) string {
+	switch vers {
+	case VersionTLS13:
+		return "13"
+	case VersionTLS12:
+		return "12"
+	case VersionTLS11:
+		return "11"
+	case VersionTLS10:
+		return "10"
+	case VersionSSL30:
+		return "s3"
+	default:
+		return "00"
+	}
+}
+
+// ja4ALPN returns the first and last characters of the first ALPN value,
+// or of its hex representation if either of them is not alphanumeric.
+func ja4ALPN(alpn string) string {
+	if len(alpn) == 0 {
+		return "00"
+	}
+	first, last := alpn[0], alpn[len(alpn)-1]
+	if !isAlphanumeric(first) || !isAlphanumeric(last) {
+		h := hex.EncodeToString([]byte(alpn))
+		return string([]byte{h[0], h[len(h)-1]})
+	}
+	return string([]byte{first, last})
+}
+
+func isAlphanumeric(c byte) bool {
+	return ('0' <= c && c <= '9') || ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z')
+}
+
+// ja4Hash returns the first 12 hex characters of the sha256 of s,
+// or all zeroes if s is empty.
+func ja4Hash(s string) string {
+	if len(s) == 0 {
+		return "000000000000"
+	}
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])[:12]
+}
+
+// joinHex formats values as comma separated 4-digit lowercase hex.
+func joinHex(values []uint16) string {
+	strs := make([]string, len(values))
+	for i, v := range values {
+		strs[i] = fmt.Sprintf("%04x", v)
+	}
+	return strings.Join(strs, ",")
+}
+
+// parrotHelloIDs lists the built-in parrots with a fixed ClientHello
+// structure, oldest first within each browser. Where several of them share a
+// JA4, HelloIDByJA4 returns the one listed last.
+var parrotHelloIDs = []ClientHelloID{
+	HelloFirefox_55, HelloFirefox_56, HelloFirefox_63, HelloFirefox_65,
+	HelloFirefox_99, HelloFirefox_102, HelloFirefox_105, HelloFirefox_120,
+	HelloFirefox_133,
+
+	HelloChrome_58, HelloChrome_62, HelloChrome_70, HelloChrome_72,
+	HelloChrome_83, HelloChrome_87, HelloChrome_96, HelloChrome_100,
+	HelloChrome_100_PSK, HelloChrome_102, HelloChrome_106_Shuffle,
+	HelloChrome_112_PSK_Shuf, HelloChrome_114_Padding_PSK_Shuf,
+	HelloChrome_115_PQ, HelloChrome_115_PQ_PSK, HelloChrome_120,
+	HelloChrome_120_PQ, HelloChrome_131,
+
+	HelloEdge_85, HelloEdge_106,
+
+	HelloIOS_11_1, HelloIOS_12_1, HelloIOS_13, HelloIOS_14, HelloIOS_18,
+
+	HelloSafari_16_0, HelloSafari_18,
+
+	HelloAndroid_11_OkHttp,
+
+	Hello360_7_5, Hello360_11_0,
+
+	HelloQQ_11_1,
+
+	HelloOpenSSL_3, HelloCurl,
+}
+
+var (
+	ja4IndexOnce sync.Once
+	ja4Index     map[string]ClientHelloID
+)
+
+// HelloIDByJA4 returns the built-in parrot whose ClientHello has the given
+// JA4 fingerprint, for picking a preset that matches observed traffic. If
+// several parrots share the fingerprint, the most recent one is returned.
+//
+// Fingerprints are indexed both with SNI (a "d" in JA4_a) and without it
+// ("i"), as sent to a literal IP address. Randomized parrots never match.
+//
+// The index is computed on first use, which generates a ClientHello for
+// every parrot.
+func HelloIDByJA4(ja4 string) (ClientHelloID, bool) {
+	ja4IndexOnce.Do(func() {
+		ja4Index = make(map[string]ClientHelloID)
+		for _, id := range parrotHelloIDs {
+			for _, serverName := range []string{"example.com", "192.0.2.1"} {
+				fp, err := parrotJA4(id, serverName)
+				if err != nil {
+					continue // e.g. the parrot needs a newer Go version
+				}
+				ja4Index[fp] = id
+			}
+		}
+	})
+	id, ok := ja4Index[ja4]
+	return id, ok
+}
+
+// parrotJA4 returns the JA4 of the ClientHello of id sent to serverName.
+func parrotJA4(id ClientHelloID, serverName string) (string, error) {
+	spec, err := UTLSIdToSpec(id)
+	if err != nil {
+		return "", err
+	}
+	uconn := UClient(&net.TCPConn{}, &Config{ServerName: serverName}, HelloCustom)
+	if err := uconn.ApplyPreset(&spec); err != nil {
+		return "", err
+	}
+	return uconn.JA4()
+}
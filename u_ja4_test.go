@@ -0,0 +1,107 @@
+// Copyright 2024 The uTLS Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tls
+
+import (
+	"net"
+	"testing"
+)
+
+func TestUTLSJA4(t *testing.T) {
+	tests := []struct {
+		id         ClientHelloID
+		serverName string
+		expected   string
+	}{
+		// Known fingerprints of the corresponding browsers
+		{HelloChrome_100, "example.com", "t13d1516h2_8daaf6152771_e5627efa2ab1"},
+		{HelloChrome_120, "example.com", "t13d1516h2_8daaf6152771_02713d6af862"},
+		{HelloFirefox_120, "example.com", "t13d1715h2_5b57614c22b0_5c2c66f702b0"},
+		// IP addresses are not sent in SNI
+		{HelloChrome_120, "127.0.0.1", "t13i1515h2_8daaf6152771_02713d6af862"},
+	}
+
+	for _, test := range tests {
+		uconn := UClient(&net.TCPConn{}, &Config{ServerName: test.serverName}, test.id)
+		if err := uconn.BuildHandshakeState(); err != nil {
+			t.Fatalf("got error: %v; expected to succeed", err)
+		}
+		fp, err := uconn.JA4()
+		if err != nil {
+			t.Fatalf("got error: %v; expected to succeed", err)
+		}
+		if fp != test.expected {
+			t.Errorf("%s with server name %q: got JA4 %s, expected %s", test.id.Str(), test.serverName, fp, test.expected)
+		}
+	}
+}
+
+func TestUTLSJA4BeforeBuild(t *testing.T) {
+	uconn := UClient(&net.TCPConn{}, &Config{ServerName: "example.com"}, HelloCustom)
+	if err := uconn.ApplyPreset(mustSpec(t, HelloChrome_100)); err != nil {
+		t.Fatalf("got error: %v; expected to succeed", err)
+	}
+	fp, err := uconn.JA4()
+	if err != nil {
+		t.Fatalf("got error: %v; expected to succeed", err)
+	}
+	// padding is predicted before the ClientHello is marshaled
+	if expected := "t13d1516h2_8daaf6152771_e5627efa2ab1"; fp != expected {
+		t.Errorf("got JA4 %s, expected %s", fp, expected)
+	}
+}
+
+func TestUTLSJA4ALPN(t *testing.T) {
+	tests := map[string]string{
+		"":         "00",
+		"h2":       "h2",
+		"http/1.1": "h1",
+		"h":        "hh",
+		"\xab\xcd": "ad",
+	}
+	for alpn, expected := range tests {
+		if got := ja4ALPN(alpn); got != expected {
+			t.Errorf("ja4ALPN(%q) = %s, expected %s", alpn, got, expected)
+		}
+	}
+}
+
+func mustSpec(t *testing.T, id ClientHelloID) *ClientHelloSpec {
+	spec, err := UTLSIdToSpec(id)
+	if err != nil {
+		t.Fatalf("got error: %v; expected to succeed", err)
+	}
+	return &spec
+}
+
+func TestUTLSHelloIDByJA4(t *testing.T) {
+	for _, id := range parrotHelloIDs {
+		for _, serverName := range []string{"example.com", "192.0.2.1"} {
+			fp, err := parrotJA4(id, serverName)
+			if err != nil {
+				t.Errorf("%s: got error: %v; expected to succeed", id.Str(), err)
+				continue
+			}
+			match, ok := HelloIDByJA4(fp)
+			if !ok {
+				t.Errorf("%s: JA4 %s not found", id.Str(), fp)
+				continue
+			}
+			if matchFP, _ := parrotJA4(match, serverName); matchFP != fp {
+				t.Errorf("%s: JA4 %s matched %s with JA4 %s", id.Str(), fp, match.Str(), matchFP)
+			}
+		}
+	}
+
+	// the most recent of identical parrots is returned
+	fp, _ := parrotJA4(HelloSafari_16_0, "example.com")
+	if match, _ := HelloIDByJA4(fp); match != HelloSafari_18 {
+		t.Errorf("got %s for the JA4 of %s, expected %s", match.Str(), HelloSafari_16_0.Str(), HelloSafari_18.Str())
+	}
+
+	if _, ok := HelloIDByJA4("t13d1516h2_000000000000_000000000000"); ok {
+		t.Error("got a match for an unknown JA4")
+	}
+}
@@ -0,0 +1,130 @@
+// Copyright 2024 The uTLS Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tls
+
+import (
+	"fmt"
+	"net/http"
+	"net/textproto"
+	"sort"
+	"strings"
+)
+
+// HeaderOrderKey is a magic key for http.Header that lists header names in
+// the order they are sent on the wire, as used by popular net/http forks.
+// It is never counted as a header itself.
+const HeaderOrderKey = "Header-Order:"
+
+// JA4H returns the JA4H fingerprint (https://github.com/FoxIO-LLC/ja4) of an
+// HTTP request, which complements the JA4 of the UConn it is sent over.
+//
+// Headers are taken in the order listed under HeaderOrderKey, if any, and
+// otherwise sorted by name, which is how net/http writes them for HTTP/1.x.
+// Headers added by the transport (e.g. Host or User-Agent) are only counted
+// when present in req.Header. HTTP/2 pseudo-headers such as ":authority"
+// are never part of the fingerprint.
+func JA4H(req *http.Request) string {
+	var b strings.Builder
+
+	// JA4H_a
+	method := strings.ToLower(req.Method)
+	if method == "" {
+		method = "get"
+	}
+	if len(method) < 2 {
+		method += "0"
+	}
+	b.WriteString(method[:2])
+
+	switch {
+	case req.ProtoMajor == 1 && req.ProtoMinor == 0:
+		b.WriteString("10")
+	case req.ProtoMajor == 2:
+		b.WriteString("20")
+	case req.ProtoMajor == 3:
+		b.WriteString("30")
+	default:
+		b.WriteString("11")
+	}
+
+	cookies := req.Cookies()
+	if len(cookies) > 0 {
+		b.WriteByte('c')
+	} else {
+		b.WriteByte('n')
+	}
+	if req.Header.Get("Referer") != "" {
+		b.WriteByte('r')
+	} else {
+		b.WriteByte('n')
+	}
+
+	headers := ja4hHeaderNames(req)
+	fmt.Fprintf(&b, "%02d", min(len(headers), 99))
+	b.WriteString(ja4hLanguage(req.Header.Get("Accept-Language")))
+
+	// JA4H_b
+	b.WriteByte('_')
+	b.WriteString(ja4Hash(strings.Join(headers, ",")))
+
+	// JA4H_c and JA4H_d
+	sort.SliceStable(cookies, func(i, j int) bool { return cookies[i].Name < cookies[j].Name })
+	names := make([]string, len(cookies))
+	fields := make([]string, len(cookies))
+	for i, cookie := range cookies {
+		names[i] = cookie.Name
+		fields[i] = cookie.Name + "=" + cookie.Value
+	}
+	b.WriteByte('_')
+	b.WriteString(ja4Hash(strings.Join(names, ",")))
+	b.WriteByte('_')
+	b.WriteString(ja4Hash(strings.Join(fields, ",")))
+
+	return b.String()
+}
+
+// ja4hHeaderNames returns the names of the headers of req that are part of
+// JA4H, in wire order.
+func ja4hHeaderNames(req *http.Request) []string {
+	skip := func(name string) bool {
+		canonical := textproto.CanonicalMIMEHeaderKey(name)
+		return strings.HasPrefix(name, ":") || canonical == HeaderOrderKey ||
+			canonical == "Cookie" || canonical == "Referer"
+	}
+
+	var names []string
+	if order, ok := req.Header[HeaderOrderKey]; ok {
+		for _, name := range order {
+			if !skip(name) && len(req.Header.Values(name)) > 0 {
+				names = append(names, name)
+			}
+		}
+		return names
+	}
+
+	for name := range req.Header {
+		if !skip(name) {
+			if req.ProtoMajor >= 2 {
+				name = strings.ToLower(name)
+			}
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ja4hLanguage returns the first 4 characters of the primary language in an
+// Accept-Language header, without hyphens and padded with zeroes.
+func ja4hLanguage(acceptLanguage string) string {
+	lang := strings.ToLower(strings.ReplaceAll(acceptLanguage, "-", ""))
+	if i := strings.IndexAny(lang, ",;"); i >= 0 {
+		lang = lang[:i]
+	}
+	if len(lang) > 4 {
+		lang = lang[:4]
+	}
+	return lang + strings.Repeat("0", 4-len(lang))
+}
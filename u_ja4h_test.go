@@ -0,0 +1,53 @@
+// Copyright 2024 The uTLS Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tls
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestUTLSJA4H(t *testing.T) {
+	req, err := http.NewRequest("GET", "https://example.com/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header[HeaderOrderKey] = []string{"Host", "User-Agent", "Accept", "Accept-Language", "Referer", "Cookie", "Accept-Encoding"}
+	req.Header.Set("Host", "example.com")
+	req.Header.Set("User-Agent", "Mozilla/5.0")
+	req.Header.Set("Accept", "*/*")
+	req.Header.Set("Accept-Language", "en-US,en;q=0.9")
+	req.Header.Set("Accept-Encoding", "gzip")
+	req.Header.Set("Referer", "https://example.com/")
+	req.Header.Set("Cookie", "b=1; a=2")
+
+	if fp, expected := JA4H(req), "ge11cr05enus_f3bb7aa45ec4_1eb7c54d5283_69d0e313a5d8"; fp != expected {
+		t.Errorf("got JA4H %s, expected %s", fp, expected)
+	}
+}
+
+func TestUTLSJA4HHTTP2(t *testing.T) {
+	req, err := http.NewRequest("POST", "https://example.com/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.ProtoMajor, req.ProtoMinor = 2, 0
+	req.Header.Set("User-Agent", "Mozilla/5.0")
+	req.Header.Set("Accept", "*/*")
+	req.Header.Set("Accept-Language", "de")
+
+	// pseudo-headers are ignored, others are sorted and lowercased without an explicit order
+	req.Header[":authority"] = []string{"example.com"}
+	req.Header[":method"] = []string{"POST"}
+	if fp, expected := JA4H(req), "po20nn03de00_98d9c6caaddf_000000000000_000000000000"; fp != expected {
+		t.Errorf("got JA4H %s, expected %s", fp, expected)
+	}
+
+	// explicit order may list pseudo-headers and names that are not present
+	req.Header[HeaderOrderKey] = []string{":method", ":authority", "accept", "accept-language", "x-missing", "user-agent"}
+	if fp, expected := JA4H(req), "po20nn03de00_98d9c6caaddf_000000000000_000000000000"; fp != expected {
+		t.Errorf("got JA4H %s, expected %s", fp, expected)
+	}
+}
@@ -0,0 +1,399 @@
+// Copyright 2024 The uTLS Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tls
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/cryptobyte"
+)
+
+// jarmProbeTimeout bounds each JARM probe, from dialing to reading the
+// server's first record. It is the timeout of the reference implementation.
+const jarmProbeTimeout = 20 * time.Second
+
+// jarmOrder is how a list of ciphers, ALPN protocols or versions is
+// reordered for a JARM probe.
+type jarmOrder int
+
+const (
+	jarmForward jarmOrder = iota
+	jarmReverse
+	jarmTopHalf
+	jarmBottomHalf
+	jarmMiddleOut
+)
+
+// jarmVersionSupport is what a JARM probe offers in supported_versions.
+type jarmVersionSupport int
+
+const (
+	jarmNoSupport    jarmVersionSupport = iota // no supported_versions extension
+	jarmTLS12Support                           // TLS 1.0 to 1.2
+	jarmTLS13Support                           // TLS 1.0 to 1.3
+)
+
+type jarmProbe struct {
+	name           string
+	version        uint16
+	noTLS13Ciphers bool
+	cipherOrder    jarmOrder
+	grease         bool
+	rareALPN       bool
+	versionSupport jarmVersionSupport
+	extensionOrder jarmOrder
+}
+
+// jarmProbes are the 10 probes of https://github.com/salesforce/jarm, in the
+// order their results are hashed.
+var jarmProbes = [...]jarmProbe{
+	{"tls1_2_forward", VersionTLS12, false, jarmForward, false, false, jarmTLS12Support, jarmReverse},
+	{"tls1_2_reverse", VersionTLS12, false, jarmReverse, false, false, jarmTLS12Support, jarmForward},
+	{"tls1_2_top_half", VersionTLS12, false, jarmTopHalf, false, false, jarmNoSupport, jarmForward},
+	{"tls1_2_bottom_half", VersionTLS12, false, jarmBottomHalf, false, true, jarmNoSupport, jarmForward},
+	{"tls1_2_middle_out", VersionTLS12, false, jarmMiddleOut, true, true, jarmNoSupport, jarmReverse},
+	{"tls1_1_middle_out", VersionTLS11, false, jarmForward, false, false, jarmNoSupport, jarmForward},
+	{"tls1_3_forward", VersionTLS13, false, jarmForward, false, false, jarmTLS13Support, jarmReverse},
+	{"tls1_3_reverse", VersionTLS13, false, jarmReverse, false, false, jarmTLS13Support, jarmForward},
+	{"tls1_3_invalid", VersionTLS13, true, jarmForward, false, false, jarmTLS13Support, jarmForward},
+	{"tls1_3_middle_out", VersionTLS13, false, jarmMiddleOut, true, false, jarmTLS13Support, jarmReverse},
+}
+
+// jarmCiphers are the cipher suites offered by JARM probes, before they are
+// reordered. It also determines the cipher suite bytes of the JARM hash.
+var jarmCiphers = []uint16{
+	0x0016, 0x0033, 0x0067, 0xc09e, 0xc0a2, 0x009e, 0x0039, 0x006b,
+	0xc09f, 0xc0a3, 0x009f, 0x0045, 0x00be, 0x0088, 0x00c4, 0x009a,
+	0xc008, 0xc009, 0xc023, 0xc0ac, 0xc0ae, 0xc02b, 0xc00a, 0xc024,
+	0xc0ad, 0xc0af, 0xc02c, 0xc072, 0xc073, 0xcca9, 0x1302, 0x1301,
+	0xcc14, 0xc007, 0xc012, 0xc013, 0xc027, 0xc02f, 0xc014, 0xc028,
+	0xc030, 0xc060, 0xc061, 0xc076, 0xc077, 0xcca8, 0x1305, 0x1304,
+	0x1303, 0xcc13, 0xc011, 0x000a, 0x002f, 0x003c, 0xc09c, 0xc0a0,
+	0x009c, 0x0035, 0x003d, 0xc09d, 0xc0a1, 0x009d, 0x0041, 0x00ba,
+	0x0084, 0x00c0, 0x0007, 0x0004, 0x0005,
+}
+
+// jarmHashCiphers is the sorted list the selected cipher suite is looked up
+// in for the JARM hash.
+var jarmHashCiphers = []uint16{
+	0x0004, 0x0005, 0x0007, 0x000a, 0x0016, 0x002f, 0x0033, 0x0035,
+	0x0039, 0x003c, 0x003d, 0x0041, 0x0045, 0x0067, 0x006b, 0x0084,
+	0x0088, 0x009a, 0x009c, 0x009d, 0x009e, 0x009f, 0x00ba, 0x00be,
+	0x00c0, 0x00c4, 0xc007, 0xc008, 0xc009, 0xc00a, 0xc011, 0xc012,
+	0xc013, 0xc014, 0xc023, 0xc024, 0xc027, 0xc028, 0xc02b, 0xc02c,
+	0xc02f, 0xc030, 0xc060, 0xc061, 0xc072, 0xc073, 0xc076, 0xc077,
+	0xc09c, 0xc09d, 0xc09e, 0xc09f, 0xc0a0, 0xc0a1, 0xc0a2, 0xc0a3,
+	0xc0ac, 0xc0ad, 0xc0ae, 0xc0af, 0xcc13, 0xcc14, 0xcca8, 0xcca9,
+	0x1301, 0x1302, 0x1303, 0x1304, 0x1305,
+}
+
+// JARMProbeError is returned by JARMProbe if some probes could not be
+// completed, e.g. because they timed out. The failed probes are hashed as if
+// the server had not answered them.
+type JARMProbeError struct {
+	// Errors holds the error of each probe, in the order of the JARM
+	// specification, or nil for the probes that succeeded.
+	Errors [10]error
+}
+
+func (e *JARMProbeError) Error() string {
+	var failed []string
+	for i, err := range e.Errors {
+		if err != nil {
+			failed = append(failed, jarmProbes[i].name+": "+err.Error())
+		}
+	}
+	return fmt.Sprintf("tls: %d of %d JARM probes failed: %s", len(failed), len(e.Errors), strings.Join(failed, "; "))
+}
+
+// JARMProbe returns the JARM fingerprint (https://github.com/salesforce/jarm)
+// of the TLS server at addr, which is computed from the ServerHellos sent in
+// response to 10 crafted ClientHellos.
+//
+// config may be nil. Its ServerName is sent in SNI, and defaults to the host
+// of addr. Certificates are never verified, as no handshake is completed.
+//
+// Each probe uses a new connection and times out after 20 seconds. If some
+// probes fail, the hash is still returned along with a *JARMProbeError.
+func JARMProbe(addr string, config *Config) (string, error) {
+	if config == nil {
+		config = &Config{}
+	}
+	config = config.Clone()
+	if config.ServerName == "" {
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			return "", err
+		}
+		config.ServerName = host
+	}
+	config.InsecureSkipVerify = true
+
+	var results [len(jarmProbes)]string
+	var probeErr JARMProbeError
+	failed := false
+	for i := range jarmProbes {
+		results[i], probeErr.Errors[i] = jarmProbes[i].run(addr, config)
+		if probeErr.Errors[i] != nil {
+			results[i] = "|||"
+			failed = true
+		}
+	}
+
+	hash := jarmHash(results[:])
+	if failed {
+		return hash, &probeErr
+	}
+	return hash, nil
+}
+
+// run sends the ClientHello of p to addr, and returns the server's choices
+// in the "cipher|version|alpn|extensions" format of the JARM specification.
+func (p *jarmProbe) run(addr string, config *Config) (string, error) {
+	conn, err := net.DialTimeout("tcp", addr, jarmProbeTimeout)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	if err := conn.SetDeadline(time.Now().Add(jarmProbeTimeout)); err != nil {
+		return "", err
+	}
+
+	uconn := UClient(conn, config.Clone(), HelloCustom)
+	if err := uconn.ApplyPreset(p.spec()); err != nil {
+		return "", err
+	}
+	if err := uconn.BuildHandshakeStateWithoutSession(); err != nil {
+		return "", err
+	}
+
+	// The record is written by hand, as JARM sends TLS 1.x probes with the
+	// matching record version rather than TLS 1.0.
+	hello := uconn.HandshakeState.Hello.Raw
+	recordVers := p.version
+	if recordVers == VersionTLS13 {
+		recordVers = VersionTLS10
+	}
+	record := []byte{byte(recordTypeHandshake), byte(recordVers >> 8), byte(recordVers), byte(len(hello) >> 8), byte(len(hello))}
+	if _, err := conn.Write(append(record, hello...)); err != nil {
+		return "", err
+	}
+
+	header := make([]byte, recordHeaderLen)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		if err == io.EOF {
+			// the server closed the connection without answering
+			return "|||", nil
+		}
+		return "", err
+	}
+	if recordType(header[0]) != recordTypeHandshake {
+		return "|||", nil
+	}
+	payload := make([]byte, int(header[3])<<8|int(header[4]))
+	if _, err := io.ReadFull(conn, payload); err != nil {
+		return "", err
+	}
+	return jarmParseServerHello(payload), nil
+}
+
+// spec returns the ClientHelloSpec of the probe.
+func (p *jarmProbe) spec() *ClientHelloSpec {
+	spec := &ClientHelloSpec{
+		TLSVersMin: VersionTLS10,
+		TLSVersMax: p.version,
+	}
+	if p.version == VersionTLS13 {
+		// the legacy version stays at TLS 1.2, see RFC 8446, Section 4.1.2
+		spec.TLSVersMax = VersionTLS12
+		if p.versionSupport == jarmTLS13Support {
+			spec.TLSVersMax = VersionTLS13
+		}
+	}
+
+	for _, suite := range jarmCiphers {
+		if p.noTLS13Ciphers && suite&0xff00 == 0x1300 {
+			continue
+		}
+		spec.CipherSuites = append(spec.CipherSuites, suite)
+	}
+	spec.CipherSuites = jarmReorder(spec.CipherSuites, p.cipherOrder)
+	if p.grease {
+		spec.CipherSuites = append([]uint16{GREASE_PLACEHOLDER}, spec.CipherSuites...)
+	}
+
+	alpn := []string{"http/0.9", "http/1.0", "http/1.1", "spdy/1", "spdy/2", "spdy/3", "h2", "h2c", "hq"}
+	if p.rareALPN {
+		alpn = []string{"http/0.9", "http/1.0", "spdy/1", "spdy/2", "spdy/3", "h2c", "hq"}
+	}
+
+	keyShares := []KeyShare{{Group: X25519}}
+	if p.grease {
+		spec.Extensions = append(spec.Extensions, &UtlsGREASEExtension{})
+		keyShares = append([]KeyShare{{Group: GREASE_PLACEHOLDER, Data: []byte{0}}}, keyShares...)
+	}
+	spec.Extensions = append(spec.Extensions,
+		&SNIExtension{},
+		&ExtendedMasterSecretExtension{},
+		&GenericExtension{Id: 1, Data: []byte{1}}, // max_fragment_length of 512 bytes
+		&RenegotiationInfoExtension{Renegotiation: RenegotiateOnceAsClient},
+		&SupportedCurvesExtension{Curves: []CurveID{X25519, CurveP256, CurveP384, CurveP521}},
+		&SupportedPointsExtension{SupportedPoints: []uint8{pointFormatUncompressed}},
+		&SessionTicketExtension{},
+		&ALPNExtension{AlpnProtocols: jarmReorder(alpn, p.extensionOrder)},
+		&SignatureAlgorithmsExtension{SupportedSignatureAlgorithms: []SignatureScheme{
+			ECDSAWithP256AndSHA256,
+			PSSWithSHA256,
+			PKCS1WithSHA256,
+			ECDSAWithP384AndSHA384,
+			PSSWithSHA384,
+			PKCS1WithSHA384,
+			PSSWithSHA512,
+			PKCS1WithSHA512,
+			PKCS1WithSHA1,
+		}},
+		&KeyShareExtension{KeyShares: keyShares},
+		&PSKKeyExchangeModesExtension{Modes: []uint8{PskModeDHE}},
+	)
+
+	if p.versionSupport != jarmNoSupport {
+		versions := []uint16{VersionTLS10, VersionTLS11, VersionTLS12}
+		if p.versionSupport == jarmTLS13Support {
+			versions = append(versions, VersionTLS13)
+		}
+		versions = jarmReorder(versions, p.extensionOrder)
+		if p.grease {
+			versions = append([]uint16{GREASE_PLACEHOLDER}, versions...)
+		}
+		spec.Extensions = append(spec.Extensions, &SupportedVersionsExtension{Versions: versions})
+	}
+	return spec
+}
+
+// jarmReorder returns a reordered copy of s, as the cipher_mung function of
+// the reference implementation does.
+func jarmReorder[T any](s []T, order jarmOrder) []T {
+	var out []T
+	switch order {
+	case jarmForward:
+		out = append(out, s...)
+	case jarmReverse:
+		for i := len(s) - 1; i >= 0; i-- {
+			out = append(out, s[i])
+		}
+	case jarmBottomHalf:
+		out = append(out, s[len(s)/2+len(s)%2:]...)
+	case jarmTopHalf:
+		// the middle element is part of the top half
+		if len(s)%2 == 1 {
+			out = append(out, s[len(s)/2])
+		}
+		out = append(out, jarmReorder(jarmReorder(s, jarmReverse), jarmBottomHalf)...)
+	case jarmMiddleOut:
+		// starting from the center, each element of the second half comes
+		// before its counterpart of the first half
+		middle := len(s) / 2
+		if len(s)%2 == 1 {
+			out = append(out, s[middle])
+			for i := 1; i <= middle; i++ {
+				out = append(out, s[middle+i], s[middle-i])
+			}
+		} else {
+			for i := 1; i <= middle; i++ {
+				out = append(out, s[middle-1+i], s[middle-i])
+			}
+		}
+	}
+	return out
+}
+
+// jarmParseServerHello returns the "cipher|version|alpn|extensions" result of
+// a probe from the first handshake record sent by the server, or "|||" if it
+// does not start with a ServerHello.
+func jarmParseServerHello(record []byte) string {
+	s := cryptobyte.String(record)
+	var msgType uint8
+	var body, sessionID cryptobyte.String
+	var vers, suite uint16
+	var compression uint8
+	if !s.ReadUint8(&msgType) || msgType != typeServerHello || !s.ReadUint24LengthPrefixed(&body) ||
+		!body.ReadUint16(&vers) || !body.Skip(32) || !body.ReadUint8LengthPrefixed(&sessionID) ||
+		!body.ReadUint16(&suite) || !body.ReadUint8(&compression) {
+		return "|||"
+	}
+
+	var alpn string
+	var types []string
+	var exts cryptobyte.String
+	if !body.Empty() && body.ReadUint16LengthPrefixed(&exts) {
+		for !exts.Empty() {
+			var extType uint16
+			var data cryptobyte.String
+			if !exts.ReadUint16(&extType) || !exts.ReadUint16LengthPrefixed(&data) {
+				return "|||"
+			}
+			types = append(types, fmt.Sprintf("%04x", extType))
+			// the protocol name follows the list and name lengths
+			if extType == extensionALPN && len(data) >= 3 {
+				alpn = string(data[3:])
+			}
+		}
+	}
+	return fmt.Sprintf("%04x|%04x|%s|%s", suite, vers, alpn, strings.Join(types, "-"))
+}
+
+// jarmHash computes the 62 character JARM hash from the results of the
+// probes: 2 hex digits for the selected cipher suite and 1 letter for the
+// version of each probe, and a truncated SHA-256 of all ALPN and extensions.
+func jarmHash(results []string) string {
+	empty := true
+	for _, result := range results {
+		empty = empty && result == "|||"
+	}
+	if empty {
+		return strings.Repeat("0", 62)
+	}
+
+	var b, alpnAndExts strings.Builder
+	for _, result := range results {
+		components := strings.Split(result, "|")
+		if len(components) != 4 {
+			components = []string{"", "", "", ""}
+		}
+
+		if components[0] == "" {
+			b.WriteString("00")
+		} else {
+			// unknown cipher suites are counted past the end of the list
+			index := len(jarmHashCiphers)
+			for i, suite := range jarmHashCiphers {
+				if fmt.Sprintf("%04x", suite) == components[0] {
+					index = i
+					break
+				}
+			}
+			fmt.Fprintf(&b, "%02x", index+1)
+		}
+
+		if components[1] == "" || len(components[1]) != 4 || components[1][3] < '0' || components[1][3] > '5' {
+			b.WriteByte('0')
+		} else {
+			b.WriteByte("abcdef"[components[1][3]-'0'])
+		}
+
+		alpnAndExts.WriteString(components[2])
+		alpnAndExts.WriteString(components[3])
+	}
+
+	sum := sha256.Sum256([]byte(alpnAndExts.String()))
+	b.WriteString(hex.EncodeToString(sum[:])[:32])
+	return b.String()
+}
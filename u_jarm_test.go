@@ -0,0 +1,173 @@
+// Copyright 2024 The uTLS Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tls
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestUTLSJARMReorder(t *testing.T) {
+	odd, even := []int{1, 2, 3, 4, 5}, []int{1, 2, 3, 4}
+	tests := []struct {
+		in       []int
+		order    jarmOrder
+		expected []int
+	}{
+		{odd, jarmForward, []int{1, 2, 3, 4, 5}},
+		{odd, jarmReverse, []int{5, 4, 3, 2, 1}},
+		{odd, jarmBottomHalf, []int{4, 5}},
+		{odd, jarmTopHalf, []int{3, 2, 1}},
+		{odd, jarmMiddleOut, []int{3, 4, 2, 5, 1}},
+		{even, jarmBottomHalf, []int{3, 4}},
+		{even, jarmTopHalf, []int{2, 1}},
+		{even, jarmMiddleOut, []int{3, 2, 4, 1}},
+	}
+	for _, test := range tests {
+		if out := jarmReorder(test.in, test.order); !sliceEq(out, test.expected) {
+			t.Errorf("jarmReorder(%v, %d) = %v, expected %v", test.in, test.order, out, test.expected)
+		}
+	}
+}
+
+func TestUTLSJARMHash(t *testing.T) {
+	results := make([]string, 10)
+	for i := range results {
+		results[i] = "|||"
+	}
+	if hash := jarmHash(results); hash != strings.Repeat("0", 62) {
+		t.Errorf("got JARM %s for no responses, expected zeroes", hash)
+	}
+
+	results[1] = "c02f|0303|h2|ff01-0010"
+	results[2] = "abcd|0302||"
+	expected := "000" + "29d" + "46c" + strings.Repeat("000", 7) + "a53adc13637d20998990e9e6aa9ab04c"
+	if hash := jarmHash(results); hash != expected {
+		t.Errorf("got JARM %s, expected %s", hash, expected)
+	}
+}
+
+func TestUTLSJARMProbeSpec(t *testing.T) {
+	uconn := UClient(&net.TCPConn{}, &Config{ServerName: "example.com"}, HelloCustom)
+	probe := jarmProbes[9] // tls1_3_middle_out
+	if err := uconn.ApplyPreset(probe.spec()); err != nil {
+		t.Fatalf("got error: %v; expected to succeed", err)
+	}
+	if err := uconn.BuildHandshakeState(); err != nil {
+		t.Fatalf("got error: %v; expected to succeed", err)
+	}
+
+	hello := uconn.HandshakeState.Hello
+	if hello.Vers != VersionTLS12 {
+		t.Errorf("got legacy version %x, expected TLS 1.2", hello.Vers)
+	}
+	if len(hello.CipherSuites) != len(jarmCiphers)+1 || !isGREASEUint16(hello.CipherSuites[0]) {
+		t.Errorf("got %d cipher suites starting with %x, expected GREASE and %d more", len(hello.CipherSuites), hello.CipherSuites[0], len(jarmCiphers))
+	}
+
+	expectedOrder := []uint16{
+		0, // GREASE
+		extensionServerName,
+		extensionExtendedMasterSecret,
+		1, // max_fragment_length
+		extensionRenegotiationInfo,
+		extensionSupportedCurves,
+		extensionSupportedPoints,
+		extensionSessionTicket,
+		extensionALPN,
+		extensionSignatureAlgorithms,
+		extensionKeyShare,
+		extensionPSKModes,
+		extensionSupportedVersions,
+	}
+	var order []uint16
+	for _, ext := range uconn.Extensions {
+		id, _ := extensionIDOf(ext)
+		if isGREASEUint16(id) {
+			id = 0
+		}
+		order = append(order, id)
+
+		switch e := ext.(type) {
+		case *ALPNExtension:
+			if expected := []string{"hq", "h2c", "h2", "spdy/3", "spdy/2", "spdy/1", "http/1.1", "http/1.0", "http/0.9"}; !sliceEq(e.AlpnProtocols, expected) {
+				t.Errorf("got ALPN %v, expected %v", e.AlpnProtocols, expected)
+			}
+		case *SupportedVersionsExtension:
+			if len(e.Versions) != 5 || !isGREASEUint16(e.Versions[0]) || !sliceEq(e.Versions[1:], []uint16{VersionTLS13, VersionTLS12, VersionTLS11, VersionTLS10}) {
+				t.Errorf("got supported_versions %x", e.Versions)
+			}
+		}
+	}
+	if !sliceEq(order, expectedOrder) {
+		t.Errorf("got extension order %v, expected %v", order, expectedOrder)
+	}
+}
+
+func TestUTLSJARMProbe(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				server := Server(conn, testConfig.Clone())
+				server.Handshake()
+				server.Close()
+			}()
+		}
+	}()
+
+	hash, err := JARMProbe(ln.Addr().String(), &Config{ServerName: "example.golang"})
+	if err != nil {
+		t.Fatalf("got error: %v; expected to succeed", err)
+	}
+	if len(hash) != 62 || hash == strings.Repeat("0", 62) {
+		t.Fatalf("got JARM %s, expected a 62 character fingerprint", hash)
+	}
+	// the TLS 1.3 forward and middle out probes negotiate a TLS 1.3 cipher
+	// suite with a legacy version of TLS 1.2
+	for _, i := range []int{6, 9} {
+		if part := hash[3*i : 3*i+3]; part != "41d" && part != "42d" && part != "43d" {
+			t.Errorf("got %s for probe %s, expected a TLS 1.3 cipher suite", part, jarmProbes[i].name)
+		}
+	}
+
+	again, err := JARMProbe(ln.Addr().String(), &Config{ServerName: "example.golang"})
+	if err != nil || again != hash {
+		t.Errorf("got JARM %s (error: %v) for the same server, expected %s", again, err, hash)
+	}
+}
+
+func TestUTLSJARMProbeFailure(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	hash, err := JARMProbe(addr, nil)
+	var probeErr *JARMProbeError
+	if !errors.As(err, &probeErr) {
+		t.Fatalf("got error %v, expected *JARMProbeError", err)
+	}
+	for i, err := range probeErr.Errors {
+		if err == nil {
+			t.Errorf("probe %s succeeded, expected it to fail", jarmProbes[i].name)
+		}
+	}
+	if hash != strings.Repeat("0", 62) {
+		t.Errorf("got JARM %s, expected zeroes", hash)
+	}
+}
@@ -15,6 +15,7 @@ import (
 	"math"
 	"math/big"
 	"math/rand"
+	"slices"
 	"sort"
 	"strconv"
 
@@ -23,6 +24,115 @@ import (
 
 var ErrUnknownClientHelloID = errors.New("tls: unknown ClientHelloID")
 
+// The signature_algorithms sent by browsers, in their order, for building
+// custom ClientHelloSpecs. The parrots send copies of these lists; they must
+// not be modified.
+var (
+	// ChromeSignatureAlgorithms is sent by Chrome 83 and later, and by the
+	// Chromium-based Edge and QQ Browser.
+	ChromeSignatureAlgorithms = []SignatureScheme{
+		ECDSAWithP256AndSHA256,
+		PSSWithSHA256,
+		PKCS1WithSHA256,
+		ECDSAWithP384AndSHA384,
+		PSSWithSHA384,
+		PKCS1WithSHA384,
+		PSSWithSHA512,
+		PKCS1WithSHA512,
+	}
+
+	// FirefoxSignatureAlgorithms is sent by Firefox 55 and later.
+	FirefoxSignatureAlgorithms = []SignatureScheme{
+		ECDSAWithP256AndSHA256,
+		ECDSAWithP384AndSHA384,
+		ECDSAWithP521AndSHA512,
+		PSSWithSHA256,
+		PSSWithSHA384,
+		PSSWithSHA512,
+		PKCS1WithSHA256,
+		PKCS1WithSHA384,
+		PKCS1WithSHA512,
+		ECDSAWithSHA1,
+		PKCS1WithSHA1,
+	}
+
+	// SafariSignatureAlgorithms is sent by Safari 16 and later, and by iOS
+	// 12.1 and later. PSSWithSHA384 is listed twice, like Safari does.
+	SafariSignatureAlgorithms = []SignatureScheme{
+		ECDSAWithP256AndSHA256,
+		PSSWithSHA256,
+		PKCS1WithSHA256,
+		ECDSAWithP384AndSHA384,
+		ECDSAWithSHA1,
+		PSSWithSHA384,
+		PSSWithSHA384,
+		PKCS1WithSHA384,
+		PSSWithSHA512,
+		PKCS1WithSHA512,
+		PKCS1WithSHA1,
+	}
+
+	// OpenSSLSignatureAlgorithms is sent by OpenSSL 3.0 clients, such as
+	// openssl s_client and curl, with the default security level.
+	OpenSSLSignatureAlgorithms = []SignatureScheme{
+		ECDSAWithP256AndSHA256,
+		ECDSAWithP384AndSHA384,
+		ECDSAWithP521AndSHA512,
+		Ed25519,
+		0x0808, // ed448
+		0x0809, // rsa_pss_pss_sha256
+		0x080a, // rsa_pss_pss_sha384
+		0x080b, // rsa_pss_pss_sha512
+		PSSWithSHA256,
+		PSSWithSHA384,
+		PSSWithSHA512,
+		PKCS1WithSHA256,
+		PKCS1WithSHA384,
+		PKCS1WithSHA512,
+		FakeECDSAWithSHA224,
+		FakePKCS1WithSHA224,
+		0x0302, // dsa_sha224
+		FakeSHA256WithDSA,
+		0x0502, // dsa_sha384
+		0x0602, // dsa_sha512
+	}
+)
+
+// openSSL3CipherSuites is the default cipher list of OpenSSL 3.0 clients.
+var openSSL3CipherSuites = []uint16{
+	TLS_AES_256_GCM_SHA384,
+	TLS_CHACHA20_POLY1305_SHA256,
+	TLS_AES_128_GCM_SHA256,
+	TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+	TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+	FAKE_TLS_DHE_RSA_WITH_AES_256_GCM_SHA384,
+	TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305,
+	TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305,
+	0xccaa, // TLS_DHE_RSA_WITH_CHACHA20_POLY1305_SHA256
+	TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+	TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+	FAKE_TLS_DHE_RSA_WITH_AES_128_GCM_SHA256,
+	DISABLED_TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA384,
+	DISABLED_TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA384,
+	FAKE_TLS_DHE_RSA_WITH_AES_256_CBC_SHA256,
+	TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA256,
+	TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA256,
+	FAKE_TLS_DHE_RSA_WITH_AES_128_CBC_SHA256,
+	TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA,
+	TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA,
+	FAKE_TLS_DHE_RSA_WITH_AES_256_CBC_SHA,
+	TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA,
+	TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA,
+	FAKE_TLS_DHE_RSA_WITH_AES_128_CBC_SHA,
+	TLS_RSA_WITH_AES_256_GCM_SHA384,
+	TLS_RSA_WITH_AES_128_GCM_SHA256,
+	DISABLED_TLS_RSA_WITH_AES_256_CBC_SHA256,
+	TLS_RSA_WITH_AES_128_CBC_SHA256,
+	TLS_RSA_WITH_AES_256_CBC_SHA,
+	TLS_RSA_WITH_AES_128_CBC_SHA,
+	FAKE_TLS_EMPTY_RENEGOTIATION_INFO_SCSV,
+}
+
 // UTLSIdToSpec converts a ClientHelloID to a corresponding ClientHelloSpec.
 //
 // Exported internal function utlsIdToSpec per request.
@@ -268,16 +378,7 @@ func utlsIdToSpec(id ClientHelloID) (ClientHelloSpec, error) {
 				&SessionTicketExtension{},
 				&ALPNExtension{AlpnProtocols: []string{"h2", "http/1.1"}},
 				&StatusRequestExtension{},
-				&SignatureAlgorithmsExtension{SupportedSignatureAlgorithms: []SignatureScheme{
-					ECDSAWithP256AndSHA256,
-					PSSWithSHA256,
-					PKCS1WithSHA256,
-					ECDSAWithP384AndSHA384,
-					PSSWithSHA384,
-					PKCS1WithSHA384,
-					PSSWithSHA512,
-					PKCS1WithSHA512,
-				}},
+				&SignatureAlgorithmsExtension{SupportedSignatureAlgorithms: slices.Clone(ChromeSignatureAlgorithms)},
 				&SCTExtension{},
 				&KeyShareExtension{[]KeyShare{
 					{Group: CurveID(GREASE_PLACEHOLDER), Data: []byte{0}},
@@ -340,16 +441,7 @@ func utlsIdToSpec(id ClientHelloID) (ClientHelloSpec, error) {
 				&SessionTicketExtension{},
 				&ALPNExtension{AlpnProtocols: []string{"h2", "http/1.1"}},
 				&StatusRequestExtension{},
-				&SignatureAlgorithmsExtension{SupportedSignatureAlgorithms: []SignatureScheme{
-					ECDSAWithP256AndSHA256,
-					PSSWithSHA256,
-					PKCS1WithSHA256,
-					ECDSAWithP384AndSHA384,
-					PSSWithSHA384,
-					PKCS1WithSHA384,
-					PSSWithSHA512,
-					PKCS1WithSHA512,
-				}},
+				&SignatureAlgorithmsExtension{SupportedSignatureAlgorithms: slices.Clone(ChromeSignatureAlgorithms)},
 				&SCTExtension{},
 				&KeyShareExtension{[]KeyShare{
 					{Group: CurveID(GREASE_PLACEHOLDER), Data: []byte{0}},
@@ -412,16 +504,7 @@ func utlsIdToSpec(id ClientHelloID) (ClientHelloSpec, error) {
 				&SessionTicketExtension{},
 				&ALPNExtension{AlpnProtocols: []string{"h2", "http/1.1"}},
 				&StatusRequestExtension{},
-				&SignatureAlgorithmsExtension{SupportedSignatureAlgorithms: []SignatureScheme{
-					ECDSAWithP256AndSHA256,
-					PSSWithSHA256,
-					PKCS1WithSHA256,
-					ECDSAWithP384AndSHA384,
-					PSSWithSHA384,
-					PKCS1WithSHA384,
-					PSSWithSHA512,
-					PKCS1WithSHA512,
-				}},
+				&SignatureAlgorithmsExtension{SupportedSignatureAlgorithms: slices.Clone(ChromeSignatureAlgorithms)},
 				&SCTExtension{},
 				&KeyShareExtension{[]KeyShare{
 					{Group: CurveID(GREASE_PLACEHOLDER), Data: []byte{0}},
@@ -485,16 +568,7 @@ func utlsIdToSpec(id ClientHelloID) (ClientHelloSpec, error) {
 				&SessionTicketExtension{},
 				&ALPNExtension{AlpnProtocols: []string{"h2", "http/1.1"}},
 				&StatusRequestExtension{},
-				&SignatureAlgorithmsExtension{SupportedSignatureAlgorithms: []SignatureScheme{
-					ECDSAWithP256AndSHA256,
-					PSSWithSHA256,
-					PKCS1WithSHA256,
-					ECDSAWithP384AndSHA384,
-					PSSWithSHA384,
-					PKCS1WithSHA384,
-					PSSWithSHA512,
-					PKCS1WithSHA512,
-				}},
+				&SignatureAlgorithmsExtension{SupportedSignatureAlgorithms: slices.Clone(ChromeSignatureAlgorithms)},
 				&SCTExtension{},
 				&KeyShareExtension{[]KeyShare{
 					{Group: CurveID(GREASE_PLACEHOLDER), Data: []byte{0}},
@@ -556,16 +630,7 @@ func utlsIdToSpec(id ClientHelloID) (ClientHelloSpec, error) {
 				&SessionTicketExtension{},
 				&ALPNExtension{AlpnProtocols: []string{"h2", "http/1.1"}},
 				&StatusRequestExtension{},
-				&SignatureAlgorithmsExtension{SupportedSignatureAlgorithms: []SignatureScheme{
-					ECDSAWithP256AndSHA256,
-					PSSWithSHA256,
-					PKCS1WithSHA256,
-					ECDSAWithP384AndSHA384,
-					PSSWithSHA384,
-					PKCS1WithSHA384,
-					PSSWithSHA512,
-					PKCS1WithSHA512,
-				}},
+				&SignatureAlgorithmsExtension{SupportedSignatureAlgorithms: slices.Clone(ChromeSignatureAlgorithms)},
 				&SCTExtension{},
 				&KeyShareExtension{[]KeyShare{
 					{Group: CurveID(GREASE_PLACEHOLDER), Data: []byte{0}},
@@ -629,16 +694,7 @@ func utlsIdToSpec(id ClientHelloID) (ClientHelloSpec, error) {
 				&SessionTicketExtension{},
 				&ALPNExtension{AlpnProtocols: []string{"h2", "http/1.1"}},
 				&StatusRequestExtension{},
-				&SignatureAlgorithmsExtension{SupportedSignatureAlgorithms: []SignatureScheme{
-					ECDSAWithP256AndSHA256,
-					PSSWithSHA256,
-					PKCS1WithSHA256,
-					ECDSAWithP384AndSHA384,
-					PSSWithSHA384,
-					PKCS1WithSHA384,
-					PSSWithSHA512,
-					PKCS1WithSHA512,
-				}},
+				&SignatureAlgorithmsExtension{SupportedSignatureAlgorithms: slices.Clone(ChromeSignatureAlgorithms)},
 				&SCTExtension{},
 				&KeyShareExtension{[]KeyShare{
 					{Group: CurveID(GREASE_PLACEHOLDER), Data: []byte{0}},
@@ -702,16 +758,7 @@ func utlsIdToSpec(id ClientHelloID) (ClientHelloSpec, error) {
 				&SessionTicketExtension{},
 				&ALPNExtension{AlpnProtocols: []string{"h2", "http/1.1"}},
 				&StatusRequestExtension{},
-				&SignatureAlgorithmsExtension{SupportedSignatureAlgorithms: []SignatureScheme{
-					ECDSAWithP256AndSHA256,
-					PSSWithSHA256,
-					PKCS1WithSHA256,
-					ECDSAWithP384AndSHA384,
-					PSSWithSHA384,
-					PKCS1WithSHA384,
-					PSSWithSHA512,
-					PKCS1WithSHA512,
-				}},
+				&SignatureAlgorithmsExtension{SupportedSignatureAlgorithms: slices.Clone(ChromeSignatureAlgorithms)},
 				&SCTExtension{},
 				&KeyShareExtension{KeyShares: []KeyShare{
 					{Group: CurveID(GREASE_PLACEHOLDER), Data: []byte{0}},
@@ -775,6 +822,49 @@ func utlsIdToSpec(id ClientHelloID) (ClientHelloSpec, error) {
 				&SessionTicketExtension{},
 				&ALPNExtension{AlpnProtocols: []string{"h2", "http/1.1"}},
 				&StatusRequestExtension{},
+				&SignatureAlgorithmsExtension{SupportedSignatureAlgorithms: slices.Clone(ChromeSignatureAlgorithms)},
+				&SCTExtension{},
+				&KeyShareExtension{[]KeyShare{
+					{Group: CurveID(GREASE_PLACEHOLDER), Data: []byte{0}},
+					{Group: X25519Kyber768Draft00},
+					{Group: X25519},
+				}},
+				&PSKKeyExchangeModesExtension{[]uint8{
+					PskModeDHE,
+				}},
+				&SupportedVersionsExtension{[]uint16{
+					GREASE_PLACEHOLDER,
+					VersionTLS13,
+					VersionTLS12,
+				}},
+				&UtlsCompressCertExtension{[]CertCompressionAlgo{
+					CertCompressionBrotli,
+				}},
+				&ApplicationSettingsExtension{SupportedProtocols: []string{"h2"}},
+				BoringGREASEECH(),
+				&UtlsGREASEExtension{},
+			}),
+		}, nil
+	case HelloChrome_115_QUIC:
+		return ClientHelloSpec{
+			TLSVersMin: VersionTLS13,
+			TLSVersMax: VersionTLS13,
+			CipherSuites: []uint16{
+				TLS_AES_128_GCM_SHA256,
+				TLS_AES_256_GCM_SHA384,
+				TLS_CHACHA20_POLY1305_SHA256,
+			},
+			CompressionMethods: []byte{
+				0x00, // compressionNone
+			},
+			Extensions: ShuffleChromeTLSExtensions([]TLSExtension{
+				&SNIExtension{},
+				&SupportedCurvesExtension{[]CurveID{
+					X25519,
+					CurveP256,
+					CurveP384,
+				}},
+				&ALPNExtension{AlpnProtocols: []string{"h3"}},
 				&SignatureAlgorithmsExtension{SupportedSignatureAlgorithms: []SignatureScheme{
 					ECDSAWithP256AndSHA256,
 					PSSWithSHA256,
@@ -784,11 +874,88 @@ func utlsIdToSpec(id ClientHelloID) (ClientHelloSpec, error) {
 					PKCS1WithSHA384,
 					PSSWithSHA512,
 					PKCS1WithSHA512,
+					PKCS1WithSHA1,
+				}},
+				&KeyShareExtension{[]KeyShare{
+					{Group: X25519},
+				}},
+				&PSKKeyExchangeModesExtension{[]uint8{
+					PskModeDHE,
+				}},
+				&SupportedVersionsExtension{[]uint16{
+					VersionTLS13,
 				}},
+				&UtlsCompressCertExtension{[]CertCompressionAlgo{
+					CertCompressionBrotli,
+				}},
+				&ApplicationSettingsExtension{SupportedProtocols: []string{"h3"}},
+				&QUICTransportParametersExtension{TransportParameters: TransportParameters{
+					InitialMaxStreamDataBidiRemote(0x600000),
+					InitialMaxStreamsBidi(100),
+					MaxDatagramFrameSize(65536),
+					MaxIdleTimeout(30000),
+					MaxUDPPayloadSize(1472),
+					InitialMaxStreamsUni(103),
+					&GREASEQUICBit{},
+					InitialMaxStreamDataBidiLocal(0x600000),
+					&GREASETransportParameter{},
+					&VersionInformation{
+						ChoosenVersion:    VERSION_1,
+						AvailableVersions: []uint32{VERSION_GREASE, VERSION_1},
+						LegacyID:          true,
+					},
+					InitialMaxData(0xf00000),
+					InitialMaxStreamDataUni(0x600000),
+					InitialSourceConnectionID{},
+				}},
+			}),
+		}, nil
+	case HelloChrome_131:
+		return ClientHelloSpec{
+			CipherSuites: []uint16{
+				GREASE_PLACEHOLDER,
+				TLS_AES_128_GCM_SHA256,
+				TLS_AES_256_GCM_SHA384,
+				TLS_CHACHA20_POLY1305_SHA256,
+				TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+				TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+				TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+				TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+				TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305,
+				TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305,
+				TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA,
+				TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA,
+				TLS_RSA_WITH_AES_128_GCM_SHA256,
+				TLS_RSA_WITH_AES_256_GCM_SHA384,
+				TLS_RSA_WITH_AES_128_CBC_SHA,
+				TLS_RSA_WITH_AES_256_CBC_SHA,
+			},
+			CompressionMethods: []byte{
+				0x00, // compressionNone
+			},
+			Extensions: ShuffleChromeTLSExtensions([]TLSExtension{
+				&UtlsGREASEExtension{},
+				&SNIExtension{},
+				&ExtendedMasterSecretExtension{},
+				&RenegotiationInfoExtension{Renegotiation: RenegotiateOnceAsClient},
+				&SupportedCurvesExtension{[]CurveID{
+					GREASE_PLACEHOLDER,
+					X25519MLKEM768,
+					X25519,
+					CurveP256,
+					CurveP384,
+				}},
+				&SupportedPointsExtension{SupportedPoints: []byte{
+					0x00, // pointFormatUncompressed
+				}},
+				&SessionTicketExtension{},
+				&ALPNExtension{AlpnProtocols: []string{"h2", "http/1.1"}},
+				&StatusRequestExtension{},
+				&SignatureAlgorithmsExtension{SupportedSignatureAlgorithms: slices.Clone(ChromeSignatureAlgorithms)},
 				&SCTExtension{},
 				&KeyShareExtension{[]KeyShare{
 					{Group: CurveID(GREASE_PLACEHOLDER), Data: []byte{0}},
-					{Group: X25519Kyber768Draft00},
+					{Group: X25519MLKEM768},
 					{Group: X25519},
 				}},
 				&PSKKeyExchangeModesExtension{[]uint8{
@@ -802,7 +969,10 @@ func utlsIdToSpec(id ClientHelloID) (ClientHelloSpec, error) {
 				&UtlsCompressCertExtension{[]CertCompressionAlgo{
 					CertCompressionBrotli,
 				}},
-				&ApplicationSettingsExtension{SupportedProtocols: []string{"h2"}},
+				&ApplicationSettingsExtension{
+					CodePoint:          utlsExtensionApplicationSettingsNew,
+					SupportedProtocols: []string{"h2"},
+				},
 				BoringGREASEECH(),
 				&UtlsGREASEExtension{},
 			}),
@@ -838,19 +1008,7 @@ func utlsIdToSpec(id ClientHelloID) (ClientHelloSpec, error) {
 				&SessionTicketExtension{},
 				&ALPNExtension{AlpnProtocols: []string{"h2", "http/1.1"}},
 				&StatusRequestExtension{},
-				&SignatureAlgorithmsExtension{SupportedSignatureAlgorithms: []SignatureScheme{
-					ECDSAWithP256AndSHA256,
-					ECDSAWithP384AndSHA384,
-					ECDSAWithP521AndSHA512,
-					PSSWithSHA256,
-					PSSWithSHA384,
-					PSSWithSHA512,
-					PKCS1WithSHA256,
-					PKCS1WithSHA384,
-					PKCS1WithSHA512,
-					ECDSAWithSHA1,
-					PKCS1WithSHA1},
-				},
+				&SignatureAlgorithmsExtension{SupportedSignatureAlgorithms: slices.Clone(FirefoxSignatureAlgorithms)},
 				&UtlsPaddingExtension{GetPaddingLen: BoringPaddingStyle},
 			},
 			GetSessionID: nil,
@@ -909,19 +1067,7 @@ func utlsIdToSpec(id ClientHelloID) (ClientHelloSpec, error) {
 					VersionTLS12,
 					VersionTLS11,
 					VersionTLS10}},
-				&SignatureAlgorithmsExtension{SupportedSignatureAlgorithms: []SignatureScheme{
-					ECDSAWithP256AndSHA256,
-					ECDSAWithP384AndSHA384,
-					ECDSAWithP521AndSHA512,
-					PSSWithSHA256,
-					PSSWithSHA384,
-					PSSWithSHA512,
-					PKCS1WithSHA256,
-					PKCS1WithSHA384,
-					PKCS1WithSHA512,
-					ECDSAWithSHA1,
-					PKCS1WithSHA1,
-				}},
+				&SignatureAlgorithmsExtension{SupportedSignatureAlgorithms: slices.Clone(FirefoxSignatureAlgorithms)},
 				&PSKKeyExchangeModesExtension{[]uint8{pskModeDHE}},
 				&FakeRecordSizeLimitExtension{0x4001},
 				&UtlsPaddingExtension{GetPaddingLen: BoringPaddingStyle},
@@ -989,19 +1135,7 @@ func utlsIdToSpec(id ClientHelloID) (ClientHelloSpec, error) {
 					VersionTLS11,
 					VersionTLS10,
 				}},
-				&SignatureAlgorithmsExtension{SupportedSignatureAlgorithms: []SignatureScheme{ //signature_algorithms
-					ECDSAWithP256AndSHA256,
-					ECDSAWithP384AndSHA384,
-					ECDSAWithP521AndSHA512,
-					PSSWithSHA256,
-					PSSWithSHA384,
-					PSSWithSHA512,
-					PKCS1WithSHA256,
-					PKCS1WithSHA384,
-					PKCS1WithSHA512,
-					ECDSAWithSHA1,
-					PKCS1WithSHA1,
-				}},
+				&SignatureAlgorithmsExtension{SupportedSignatureAlgorithms: slices.Clone(FirefoxSignatureAlgorithms)},
 				&PSKKeyExchangeModesExtension{[]uint8{ //psk_key_exchange_modes
 					PskModeDHE,
 				}},
@@ -1068,19 +1202,7 @@ func utlsIdToSpec(id ClientHelloID) (ClientHelloSpec, error) {
 					VersionTLS13, //supported_versions
 					VersionTLS12,
 				}},
-				&SignatureAlgorithmsExtension{SupportedSignatureAlgorithms: []SignatureScheme{ //signature_algorithms
-					ECDSAWithP256AndSHA256,
-					ECDSAWithP384AndSHA384,
-					ECDSAWithP521AndSHA512,
-					PSSWithSHA256,
-					PSSWithSHA384,
-					PSSWithSHA512,
-					PKCS1WithSHA256,
-					PKCS1WithSHA384,
-					PKCS1WithSHA512,
-					ECDSAWithSHA1,
-					PKCS1WithSHA1,
-				}},
+				&SignatureAlgorithmsExtension{SupportedSignatureAlgorithms: slices.Clone(FirefoxSignatureAlgorithms)},
 				&PSKKeyExchangeModesExtension{[]uint8{ //psk_key_exchange_modes
 					PskModeDHE,
 				}},
@@ -1166,21 +1288,7 @@ func utlsIdToSpec(id ClientHelloID) (ClientHelloSpec, error) {
 						VersionTLS12,
 					},
 				},
-				&SignatureAlgorithmsExtension{
-					SupportedSignatureAlgorithms: []SignatureScheme{
-						ECDSAWithP256AndSHA256,
-						ECDSAWithP384AndSHA384,
-						ECDSAWithP521AndSHA512,
-						PSSWithSHA256,
-						PSSWithSHA384,
-						PSSWithSHA512,
-						PKCS1WithSHA256,
-						PKCS1WithSHA384,
-						PKCS1WithSHA512,
-						ECDSAWithSHA1,
-						PKCS1WithSHA1,
-					},
-				},
+				&SignatureAlgorithmsExtension{SupportedSignatureAlgorithms: slices.Clone(FirefoxSignatureAlgorithms)},
 				&PSKKeyExchangeModesExtension{
 					Modes: []uint8{
 						PskModeDHE,
@@ -1273,27 +1381,126 @@ func utlsIdToSpec(id ClientHelloID) (ClientHelloSpec, error) {
 						VersionTLS12,
 					},
 				},
-				&SignatureAlgorithmsExtension{
+				&SignatureAlgorithmsExtension{SupportedSignatureAlgorithms: slices.Clone(FirefoxSignatureAlgorithms)},
+				&PSKKeyExchangeModesExtension{[]uint8{
+					PskModeDHE,
+				}},
+				&FakeRecordSizeLimitExtension{
+					Limit: 0x4001,
+				},
+				&GREASEEncryptedClientHelloExtension{
+					CandidateCipherSuites: []HPKESymmetricCipherSuite{
+						{
+							KdfId:  dicttls.HKDF_SHA256,
+							AeadId: dicttls.AEAD_AES_128_GCM,
+						},
+						{
+							KdfId:  dicttls.HKDF_SHA256,
+							AeadId: dicttls.AEAD_CHACHA20_POLY1305,
+						},
+					},
+					CandidatePayloadLens: []uint16{223}, // +16: 239
+				},
+			},
+		}, nil
+	case HelloFirefox_133:
+		return ClientHelloSpec{
+			TLSVersMin: VersionTLS12,
+			TLSVersMax: VersionTLS13,
+			CipherSuites: []uint16{
+				TLS_AES_128_GCM_SHA256,
+				TLS_CHACHA20_POLY1305_SHA256,
+				TLS_AES_256_GCM_SHA384,
+				TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+				TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+				TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305,
+				TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305,
+				TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+				TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+				TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA,
+				TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA,
+				TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA,
+				TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA,
+				TLS_RSA_WITH_AES_128_GCM_SHA256,
+				TLS_RSA_WITH_AES_256_GCM_SHA384,
+				TLS_RSA_WITH_AES_128_CBC_SHA,
+				TLS_RSA_WITH_AES_256_CBC_SHA,
+			},
+			CompressionMethods: []uint8{
+				0x0, // no compression
+			},
+			Extensions: []TLSExtension{
+				&SNIExtension{},
+				&ExtendedMasterSecretExtension{},
+				&RenegotiationInfoExtension{
+					Renegotiation: RenegotiateOnceAsClient,
+				},
+				&SupportedCurvesExtension{
+					Curves: []CurveID{
+						X25519MLKEM768,
+						X25519,
+						CurveP256,
+						CurveP384,
+						CurveP521,
+						256,
+						257,
+					},
+				},
+				&SupportedPointsExtension{
+					SupportedPoints: []uint8{
+						0x0, // uncompressed
+					},
+				},
+				&SessionTicketExtension{},
+				&ALPNExtension{
+					AlpnProtocols: []string{
+						"h2",
+						"http/1.1",
+					},
+				},
+				&StatusRequestExtension{},
+				&FakeDelegatedCredentialsExtension{
 					SupportedSignatureAlgorithms: []SignatureScheme{
 						ECDSAWithP256AndSHA256,
 						ECDSAWithP384AndSHA384,
 						ECDSAWithP521AndSHA512,
-						PSSWithSHA256,
-						PSSWithSHA384,
-						PSSWithSHA512,
-						PKCS1WithSHA256,
-						PKCS1WithSHA384,
-						PKCS1WithSHA512,
 						ECDSAWithSHA1,
-						PKCS1WithSHA1,
 					},
 				},
+				&SCTExtension{},
+				&KeyShareExtension{
+					KeyShares: []KeyShare{
+						{
+							Group: X25519MLKEM768,
+						},
+						{
+							Group: X25519,
+						},
+						{
+							Group: CurveP256,
+						},
+					},
+				},
+				&SupportedVersionsExtension{
+					Versions: []uint16{
+						VersionTLS13,
+						VersionTLS12,
+					},
+				},
+				&SignatureAlgorithmsExtension{SupportedSignatureAlgorithms: slices.Clone(FirefoxSignatureAlgorithms)},
 				&PSKKeyExchangeModesExtension{[]uint8{
 					PskModeDHE,
 				}},
 				&FakeRecordSizeLimitExtension{
 					Limit: 0x4001,
 				},
+				&UtlsCompressCertExtension{
+					Algorithms: []CertCompressionAlgo{
+						CertCompressionZlib,
+						CertCompressionBrotli,
+						CertCompressionZstd,
+					},
+				},
 				&GREASEEncryptedClientHelloExtension{
 					CandidateCipherSuites: []HPKESymmetricCipherSuite{
 						{
@@ -1307,6 +1514,89 @@ func utlsIdToSpec(id ClientHelloID) (ClientHelloSpec, error) {
 					},
 					CandidatePayloadLens: []uint16{223}, // +16: 239
 				},
+				&UtlsPaddingExtension{
+					GetPaddingLen: BoringPaddingStyle,
+				},
+			},
+		}, nil
+	case HelloFirefox_116_QUIC:
+		return ClientHelloSpec{
+			TLSVersMin: VersionTLS13,
+			TLSVersMax: VersionTLS13,
+			CipherSuites: []uint16{
+				TLS_AES_128_GCM_SHA256,
+				TLS_CHACHA20_POLY1305_SHA256,
+				TLS_AES_256_GCM_SHA384,
+			},
+			CompressionMethods: []uint8{
+				0x0, // no compression
+			},
+			Extensions: []TLSExtension{
+				&SNIExtension{},
+				&SupportedCurvesExtension{
+					Curves: []CurveID{
+						X25519,
+						CurveP256,
+						CurveP384,
+						CurveP521,
+						256,
+						257,
+					},
+				},
+				&ALPNExtension{
+					AlpnProtocols: []string{
+						"h3",
+					},
+				},
+				&FakeDelegatedCredentialsExtension{
+					SupportedSignatureAlgorithms: []SignatureScheme{
+						ECDSAWithP256AndSHA256,
+						ECDSAWithP384AndSHA384,
+						ECDSAWithP521AndSHA512,
+						ECDSAWithSHA1,
+					},
+				},
+				&KeyShareExtension{
+					KeyShares: []KeyShare{
+						{
+							Group: X25519,
+						},
+					},
+				},
+				&SupportedVersionsExtension{
+					Versions: []uint16{
+						VersionTLS13,
+					},
+				},
+				&SignatureAlgorithmsExtension{SupportedSignatureAlgorithms: slices.Clone(FirefoxSignatureAlgorithms)},
+				&PSKKeyExchangeModesExtension{[]uint8{
+					PskModeDHE,
+				}},
+				&QUICTransportParametersExtension{
+					TransportParameters: TransportParameters{
+						InitialMaxStreamDataBidiRemote(0x100000),
+						InitialMaxStreamsBidi(16),
+						MaxDatagramFrameSize(1200),
+						MaxIdleTimeout(30000),
+						ActiveConnectionIDLimit(8),
+						&GREASEQUICBit{},
+						&VersionInformation{
+							ChoosenVersion:    VERSION_1,
+							AvailableVersions: []uint32{VERSION_GREASE, VERSION_1},
+							LegacyID:          true,
+						},
+						InitialMaxStreamsUni(16),
+						&GREASETransportParameter{
+							Length: 2,
+						},
+						InitialMaxStreamDataBidiLocal(0xc00000),
+						InitialMaxStreamDataUni(0x100000),
+						InitialSourceConnectionID{},
+						MaxAckDelay(20),
+						InitialMaxData(0x1800000),
+						&DisableActiveMigration{},
+					},
+				},
 			},
 		}, nil
 	case HelloIOS_11_1:
@@ -1402,19 +1692,7 @@ func utlsIdToSpec(id ClientHelloID) (ClientHelloSpec, error) {
 				&RenegotiationInfoExtension{Renegotiation: RenegotiateOnceAsClient},
 				&SNIExtension{},
 				&ExtendedMasterSecretExtension{},
-				&SignatureAlgorithmsExtension{SupportedSignatureAlgorithms: []SignatureScheme{
-					ECDSAWithP256AndSHA256,
-					PSSWithSHA256,
-					PKCS1WithSHA256,
-					ECDSAWithP384AndSHA384,
-					ECDSAWithSHA1,
-					PSSWithSHA384,
-					PSSWithSHA384,
-					PKCS1WithSHA384,
-					PSSWithSHA512,
-					PKCS1WithSHA512,
-					PKCS1WithSHA1,
-				}},
+				&SignatureAlgorithmsExtension{SupportedSignatureAlgorithms: slices.Clone(SafariSignatureAlgorithms)},
 				&StatusRequestExtension{},
 				&NPNExtension{},
 				&SCTExtension{},
@@ -1467,19 +1745,7 @@ func utlsIdToSpec(id ClientHelloID) (ClientHelloSpec, error) {
 				&RenegotiationInfoExtension{Renegotiation: RenegotiateOnceAsClient},
 				&SNIExtension{},
 				&ExtendedMasterSecretExtension{},
-				&SignatureAlgorithmsExtension{SupportedSignatureAlgorithms: []SignatureScheme{
-					ECDSAWithP256AndSHA256,
-					PSSWithSHA256,
-					PKCS1WithSHA256,
-					ECDSAWithP384AndSHA384,
-					ECDSAWithSHA1,
-					PSSWithSHA384,
-					PSSWithSHA384,
-					PKCS1WithSHA384,
-					PSSWithSHA512,
-					PKCS1WithSHA512,
-					PKCS1WithSHA1,
-				}},
+				&SignatureAlgorithmsExtension{SupportedSignatureAlgorithms: slices.Clone(SafariSignatureAlgorithms)},
 				&StatusRequestExtension{},
 				&SCTExtension{},
 				&ALPNExtension{AlpnProtocols: []string{"h2", "http/1.1"}},
@@ -1560,19 +1826,7 @@ func utlsIdToSpec(id ClientHelloID) (ClientHelloSpec, error) {
 				}},
 				&ALPNExtension{AlpnProtocols: []string{"h2", "http/1.1"}},
 				&StatusRequestExtension{},
-				&SignatureAlgorithmsExtension{SupportedSignatureAlgorithms: []SignatureScheme{
-					ECDSAWithP256AndSHA256,
-					PSSWithSHA256,
-					PKCS1WithSHA256,
-					ECDSAWithP384AndSHA384,
-					ECDSAWithSHA1,
-					PSSWithSHA384,
-					PSSWithSHA384,
-					PKCS1WithSHA384,
-					PSSWithSHA512,
-					PKCS1WithSHA512,
-					PKCS1WithSHA1,
-				}},
+				&SignatureAlgorithmsExtension{SupportedSignatureAlgorithms: slices.Clone(SafariSignatureAlgorithms)},
 				&SCTExtension{},
 				&KeyShareExtension{[]KeyShare{
 					{Group: CurveID(GREASE_PLACEHOLDER), Data: []byte{0}},
@@ -1676,31 +1930,20 @@ func utlsIdToSpec(id ClientHelloID) (ClientHelloSpec, error) {
 						CurveP384,
 					},
 				},
-				&SupportedPointsExtension{
-					SupportedPoints: []uint8{
-						0x0, // pointFormatUncompressed
-					},
-				},
-				&SessionTicketExtension{},
-				&ALPNExtension{
-					AlpnProtocols: []string{
-						"h2",
-						"http/1.1",
-					},
-				},
-				&StatusRequestExtension{},
-				&SignatureAlgorithmsExtension{
-					SupportedSignatureAlgorithms: []SignatureScheme{
-						ECDSAWithP256AndSHA256,
-						PSSWithSHA256,
-						PKCS1WithSHA256,
-						ECDSAWithP384AndSHA384,
-						PSSWithSHA384,
-						PKCS1WithSHA384,
-						PSSWithSHA512,
-						PKCS1WithSHA512,
+				&SupportedPointsExtension{
+					SupportedPoints: []uint8{
+						0x0, // pointFormatUncompressed
+					},
+				},
+				&SessionTicketExtension{},
+				&ALPNExtension{
+					AlpnProtocols: []string{
+						"h2",
+						"http/1.1",
 					},
 				},
+				&StatusRequestExtension{},
+				&SignatureAlgorithmsExtension{SupportedSignatureAlgorithms: slices.Clone(ChromeSignatureAlgorithms)},
 				&SCTExtension{},
 				&KeyShareExtension{
 					KeyShares: []KeyShare{
@@ -1794,18 +2037,7 @@ func utlsIdToSpec(id ClientHelloID) (ClientHelloSpec, error) {
 					},
 				},
 				&StatusRequestExtension{},
-				&SignatureAlgorithmsExtension{
-					SupportedSignatureAlgorithms: []SignatureScheme{
-						ECDSAWithP256AndSHA256,
-						PSSWithSHA256,
-						PKCS1WithSHA256,
-						ECDSAWithP384AndSHA384,
-						PSSWithSHA384,
-						PKCS1WithSHA384,
-						PSSWithSHA512,
-						PKCS1WithSHA512,
-					},
-				},
+				&SignatureAlgorithmsExtension{SupportedSignatureAlgorithms: slices.Clone(ChromeSignatureAlgorithms)},
 				&SCTExtension{},
 				&KeyShareExtension{
 					KeyShares: []KeyShare{
@@ -1848,7 +2080,9 @@ func utlsIdToSpec(id ClientHelloID) (ClientHelloSpec, error) {
 				},
 			},
 		}, nil
-	case HelloSafari_16_0:
+	// Safari 17 and 18 on macOS and iOS still send the same ClientHello as
+	// Safari 16: a fixed extension order without session_ticket or GREASE ECH.
+	case HelloSafari_16_0, HelloSafari_18, HelloIOS_18:
 		return ClientHelloSpec{
 			TLSVersMin: VersionTLS10,
 			TLSVersMax: VersionTLS13,
@@ -1906,21 +2140,7 @@ func utlsIdToSpec(id ClientHelloID) (ClientHelloSpec, error) {
 					},
 				},
 				&StatusRequestExtension{},
-				&SignatureAlgorithmsExtension{
-					SupportedSignatureAlgorithms: []SignatureScheme{
-						ECDSAWithP256AndSHA256,
-						PSSWithSHA256,
-						PKCS1WithSHA256,
-						ECDSAWithP384AndSHA384,
-						ECDSAWithSHA1,
-						PSSWithSHA384,
-						PSSWithSHA384,
-						PKCS1WithSHA384,
-						PSSWithSHA512,
-						PKCS1WithSHA512,
-						PKCS1WithSHA1,
-					},
-				},
+				&SignatureAlgorithmsExtension{SupportedSignatureAlgorithms: slices.Clone(SafariSignatureAlgorithms)},
 				&SCTExtension{},
 				&KeyShareExtension{
 					KeyShares: []KeyShare{
@@ -2194,18 +2414,7 @@ func utlsIdToSpec(id ClientHelloID) (ClientHelloSpec, error) {
 					},
 				},
 				&StatusRequestExtension{},
-				&SignatureAlgorithmsExtension{
-					SupportedSignatureAlgorithms: []SignatureScheme{
-						ECDSAWithP256AndSHA256,
-						PSSWithSHA256,
-						PKCS1WithSHA256,
-						ECDSAWithP384AndSHA384,
-						PSSWithSHA384,
-						PKCS1WithSHA384,
-						PSSWithSHA512,
-						PKCS1WithSHA512,
-					},
-				},
+				&SignatureAlgorithmsExtension{SupportedSignatureAlgorithms: slices.Clone(ChromeSignatureAlgorithms)},
 				&SCTExtension{},
 				&KeyShareExtension{
 					KeyShares: []KeyShare{
@@ -2290,16 +2499,7 @@ func utlsIdToSpec(id ClientHelloID) (ClientHelloSpec, error) {
 				&SessionTicketExtension{},
 				&ALPNExtension{AlpnProtocols: []string{"h2", "http/1.1"}},
 				&StatusRequestExtension{},
-				&SignatureAlgorithmsExtension{SupportedSignatureAlgorithms: []SignatureScheme{
-					ECDSAWithP256AndSHA256,
-					PSSWithSHA256,
-					PKCS1WithSHA256,
-					ECDSAWithP384AndSHA384,
-					PSSWithSHA384,
-					PKCS1WithSHA384,
-					PSSWithSHA512,
-					PKCS1WithSHA512,
-				}},
+				&SignatureAlgorithmsExtension{SupportedSignatureAlgorithms: slices.Clone(ChromeSignatureAlgorithms)},
 				&SCTExtension{},
 				&KeyShareExtension{[]KeyShare{
 					{Group: CurveID(GREASE_PLACEHOLDER), Data: []byte{0}},
@@ -2361,16 +2561,7 @@ func utlsIdToSpec(id ClientHelloID) (ClientHelloSpec, error) {
 				&SessionTicketExtension{},
 				&ALPNExtension{AlpnProtocols: []string{"h2", "http/1.1"}},
 				&StatusRequestExtension{},
-				&SignatureAlgorithmsExtension{SupportedSignatureAlgorithms: []SignatureScheme{
-					ECDSAWithP256AndSHA256,
-					PSSWithSHA256,
-					PKCS1WithSHA256,
-					ECDSAWithP384AndSHA384,
-					PSSWithSHA384,
-					PKCS1WithSHA384,
-					PSSWithSHA512,
-					PKCS1WithSHA512,
-				}},
+				&SignatureAlgorithmsExtension{SupportedSignatureAlgorithms: slices.Clone(ChromeSignatureAlgorithms)},
 				&SCTExtension{},
 				&KeyShareExtension{[]KeyShare{
 					{Group: CurveID(GREASE_PLACEHOLDER), Data: []byte{0}},
@@ -2432,16 +2623,7 @@ func utlsIdToSpec(id ClientHelloID) (ClientHelloSpec, error) {
 				&SessionTicketExtension{},
 				&ALPNExtension{AlpnProtocols: []string{"h2", "http/1.1"}},
 				&StatusRequestExtension{},
-				&SignatureAlgorithmsExtension{SupportedSignatureAlgorithms: []SignatureScheme{
-					ECDSAWithP256AndSHA256,
-					PSSWithSHA256,
-					PKCS1WithSHA256,
-					ECDSAWithP384AndSHA384,
-					PSSWithSHA384,
-					PKCS1WithSHA384,
-					PSSWithSHA512,
-					PKCS1WithSHA512,
-				}},
+				&SignatureAlgorithmsExtension{SupportedSignatureAlgorithms: slices.Clone(ChromeSignatureAlgorithms)},
 				&SCTExtension{},
 				&KeyShareExtension{[]KeyShare{
 					{Group: CurveID(GREASE_PLACEHOLDER), Data: []byte{0}},
@@ -2506,16 +2688,7 @@ func utlsIdToSpec(id ClientHelloID) (ClientHelloSpec, error) {
 				&SessionTicketExtension{},
 				&ALPNExtension{AlpnProtocols: []string{"h2", "http/1.1"}},
 				&StatusRequestExtension{},
-				&SignatureAlgorithmsExtension{SupportedSignatureAlgorithms: []SignatureScheme{
-					ECDSAWithP256AndSHA256,
-					PSSWithSHA256,
-					PKCS1WithSHA256,
-					ECDSAWithP384AndSHA384,
-					PSSWithSHA384,
-					PKCS1WithSHA384,
-					PSSWithSHA512,
-					PKCS1WithSHA512,
-				}},
+				&SignatureAlgorithmsExtension{SupportedSignatureAlgorithms: slices.Clone(ChromeSignatureAlgorithms)},
 				&SCTExtension{},
 				&KeyShareExtension{[]KeyShare{
 					{Group: CurveID(GREASE_PLACEHOLDER), Data: []byte{0}},
@@ -2538,8 +2711,68 @@ func utlsIdToSpec(id ClientHelloID) (ClientHelloSpec, error) {
 				&UtlsPreSharedKeyExtension{},
 			}),
 		}, nil
+	case HelloOpenSSL_3:
+		return ClientHelloSpec{
+			CipherSuites:       slices.Clone(openSSL3CipherSuites),
+			CompressionMethods: []byte{compressionNone},
+			Extensions: []TLSExtension{
+				&SNIExtension{},
+				&SupportedPointsExtension{SupportedPoints: []byte{
+					pointFormatUncompressed,
+					0x01, // ansiX962_compressed_prime
+					0x02, // ansiX962_compressed_char2
+				}},
+				&SupportedCurvesExtension{Curves: openSSL3Curves()},
+				&SessionTicketExtension{},
+				&GenericExtension{Id: fakeExtensionEncryptThenMAC},
+				&ExtendedMasterSecretExtension{},
+				&SignatureAlgorithmsExtension{SupportedSignatureAlgorithms: slices.Clone(OpenSSLSignatureAlgorithms)},
+				&SupportedVersionsExtension{Versions: []uint16{
+					VersionTLS13,
+					VersionTLS12,
+					VersionTLS11,
+					VersionTLS10,
+				}},
+				&PSKKeyExchangeModesExtension{Modes: []uint8{pskModeDHE}},
+				&KeyShareExtension{KeyShares: []KeyShare{{Group: X25519}}},
+			},
+		}, nil
+	case HelloCurl:
+		// Unlike s_client, curl doesn't use session tickets, and sets
+		// SSL_OP_ALL, which pads the ClientHello like BoringSSL.
+		return ClientHelloSpec{
+			CipherSuites:       slices.Clone(openSSL3CipherSuites),
+			CompressionMethods: []byte{compressionNone},
+			Extensions: []TLSExtension{
+				&SNIExtension{},
+				&SupportedPointsExtension{SupportedPoints: []byte{
+					pointFormatUncompressed,
+					0x01, // ansiX962_compressed_prime
+					0x02, // ansiX962_compressed_char2
+				}},
+				&SupportedCurvesExtension{Curves: openSSL3Curves()},
+				&ALPNExtension{AlpnProtocols: []string{"h2", "http/1.1"}},
+				&GenericExtension{Id: fakeExtensionEncryptThenMAC},
+				&ExtendedMasterSecretExtension{},
+				&GenericExtension{Id: 49}, // post_handshake_auth
+				&SignatureAlgorithmsExtension{SupportedSignatureAlgorithms: slices.Clone(OpenSSLSignatureAlgorithms)},
+				&SupportedVersionsExtension{Versions: []uint16{
+					VersionTLS13,
+					VersionTLS12,
+					VersionTLS11,
+					VersionTLS10,
+				}},
+				&PSKKeyExchangeModesExtension{Modes: []uint8{pskModeDHE}},
+				&KeyShareExtension{KeyShares: []KeyShare{{Group: X25519}}},
+				&UtlsPaddingExtension{GetPaddingLen: BoringPaddingStyle},
+			},
+		}, nil
+	case helloChrome_131_PQ_HybridOnly:
+		return chromePQSpec(PQModeHybridOnly)
+	case helloChrome_131_PQ_Classical:
+		return chromePQSpec(PQModeClassicalOnly)
 	default:
-		if id.Client == helloRandomized || id.Client == helloRandomizedALPN || id.Client == helloRandomizedNoALPN {
+		if id.Client == helloRandomized || id.Client == helloRandomizedALPN || id.Client == helloRandomizedNoALPN || id.Client == helloRandomizedFixedALPN {
 			// Use empty values as they can be filled later by UConn.ApplyPreset or manually.
 			return generateRandomizedSpec(&id, "", nil)
 		}
@@ -2587,12 +2820,101 @@ func ShuffleChromeTLSExtensions(exts []TLSExtension) []TLSExtension {
 	return exts
 }
 
+// parrotShufflesExtensions reports whether the spec of id shuffles its
+// extensions with ShuffleChromeTLSExtensions each time it is generated.
+func parrotShufflesExtensions(id ClientHelloID) bool {
+	switch id {
+	case HelloChrome_106_Shuffle, HelloChrome_112_PSK_Shuf, HelloChrome_114_Padding_PSK_Shuf,
+		HelloChrome_115_PQ, HelloChrome_115_PQ_PSK, HelloChrome_115_QUIC, HelloChrome_120,
+		HelloChrome_120_PQ, HelloChrome_131, helloChrome_131_PQ_HybridOnly, helloChrome_131_PQ_Classical:
+		return true
+	}
+	return false
+}
+
+// SortCipherSuitesLikeBrowser returns suites in the order of the cipher suites
+// of the ClientHelloSpec of id, with GREASE_PLACEHOLDER where that browser
+// sends GREASE. Cipher suites that the browser doesn't offer are appended in
+// their order in suites, and GREASE values in suites are dropped.
+//
+// If id has no fixed spec, e.g. HelloGolang or a randomized ClientHelloID, the
+// cipher suites are returned in their order in suites.
+func SortCipherSuitesLikeBrowser(suites []uint16, id ClientHelloID) []uint16 {
+	suites = slices.DeleteFunc(slices.Clone(suites), isGREASEUint16)
+	var browserSuites []uint16
+	if id.Client != helloRandomized && id.Client != helloRandomizedALPN && id.Client != helloRandomizedNoALPN && id.Client != helloRandomizedFixedALPN {
+		if spec, err := utlsIdToSpec(id); err == nil {
+			browserSuites = spec.CipherSuites
+		}
+	}
+
+	sorted := make([]uint16, 0, len(suites)+1)
+	for _, suite := range browserSuites {
+		if isGREASEUint16(suite) {
+			sorted = append(sorted, GREASE_PLACEHOLDER)
+		} else if slices.Contains(suites, suite) && !slices.Contains(sorted, suite) {
+			sorted = append(sorted, suite)
+		}
+	}
+	for _, suite := range suites {
+		if !slices.Contains(sorted, suite) {
+			sorted = append(sorted, suite)
+		}
+	}
+	return sorted
+}
+
+// openSSL3Curves returns the default supported_groups of OpenSSL 3.0 clients.
+func openSSL3Curves() []CurveID {
+	return []CurveID{
+		X25519,
+		CurveP256,
+		0x001e, // x448
+		CurveP521,
+		CurveP384,
+		FakeCurveFFDHE2048,
+		FakeCurveFFDHE3072,
+		FakeCurveFFDHE4096,
+		FakeCurveFFDHE6144,
+		FakeCurveFFDHE8192,
+	}
+}
+
+// chromePQSpec returns the spec of HelloChrome_131 with the key_share and
+// supported_groups extensions of mode. ApplyPreset generates a key for every
+// key share.
+func chromePQSpec(mode PQMode) (ClientHelloSpec, error) {
+	spec, err := utlsIdToSpec(HelloChrome_131)
+	if err != nil {
+		return spec, err
+	}
+
+	groups := []CurveID{GREASE_PLACEHOLDER, X25519MLKEM768, X25519, CurveP256, CurveP384}
+	keyShares := []KeyShare{{Group: CurveID(GREASE_PLACEHOLDER), Data: []byte{0}}, {Group: X25519MLKEM768}, {Group: X25519}}
+	switch mode {
+	case PQModeHybridOnly:
+		keyShares = keyShares[:2]
+	case PQModeClassicalOnly:
+		groups = slices.Delete(groups, 1, 2)
+		keyShares = slices.Delete(keyShares, 1, 2)
+	}
+	for _, ext := range spec.Extensions {
+		switch e := ext.(type) {
+		case *SupportedCurvesExtension:
+			e.Curves = groups
+		case *KeyShareExtension:
+			e.KeyShares = keyShares
+		}
+	}
+	return spec, nil
+}
+
 func (uconn *UConn) applyPresetByID(id ClientHelloID) (err error) {
 	var spec ClientHelloSpec
 	uconn.ClientHelloID = id
 	// choose/generate the spec
 	switch id.Client {
-	case helloRandomized, helloRandomizedNoALPN, helloRandomizedALPN:
+	case helloRandomized, helloRandomizedNoALPN, helloRandomizedALPN, helloRandomizedFixedALPN:
 		spec, err = uconn.generateRandomizedSpec()
 		if err != nil {
 			return err
@@ -2620,6 +2942,24 @@ func (uconn *UConn) ApplyPreset(p *ClientHelloSpec) error {
 		return err
 	}
 
+	err = checkTLS13Extensions(uconn.config.MaxVersion, p.Extensions)
+	if err != nil {
+		return err
+	}
+	uconn.forceExtensionOrder = p.ForceExtensionOrder
+
+	// QUIC requires TLS 1.3 (RFC 9001, Section 4.2).
+	if uconn.quic != nil && uconn.config.MinVersion < VersionTLS13 {
+		return errors.New("tls: ClientHelloSpec for QUIC must only offer TLS 1.3")
+	}
+
+	// The GREASE seed is drawn first, so that all GREASE values only depend
+	// on the first bytes read from Config.Rand.
+	err = uconn.initGREASESeed(uconn.config.rand())
+	if err != nil {
+		return err
+	}
+
 	privateHello, clientKeySharePrivate, err := uconn.makeClientHelloForApplyPreset()
 	if err != nil {
 		return err
@@ -2647,23 +2987,16 @@ func (uconn *UConn) ApplyPreset(p *ClientHelloSpec) error {
 			strconv.Itoa(len(hello.Random)) + " bytes")
 	}
 
-	if len(hello.CompressionMethods) == 0 {
+	switch {
+	case uconn.compressionMethods != nil:
+		hello.CompressionMethods = slices.Clone(uconn.compressionMethods)
+	case len(p.CompressionMethods) != 0:
+		hello.CompressionMethods = slices.Clone(p.CompressionMethods)
+	default:
 		hello.CompressionMethods = []uint8{compressionNone}
 	}
 
-	// Currently, GREASE is assumed to come from BoringSSL
-	grease_bytes := make([]byte, 2*ssl_grease_last_index)
 	grease_extensions_seen := 0
-	_, err = io.ReadFull(uconn.config.rand(), grease_bytes)
-	if err != nil {
-		return errors.New("tls: short read from Rand: " + err.Error())
-	}
-	for i := range uconn.greaseSeed {
-		uconn.greaseSeed[i] = binary.LittleEndian.Uint16(grease_bytes[2*i : 2*i+2])
-	}
-	if GetBoringGREASEValue(uconn.greaseSeed, ssl_grease_extension1) == GetBoringGREASEValue(uconn.greaseSeed, ssl_grease_extension2) {
-		uconn.greaseSeed[ssl_grease_extension2] ^= 0x1010
-	}
 
 	hello.CipherSuites = make([]uint16, len(p.CipherSuites))
 	copy(hello.CipherSuites, p.CipherSuites)
@@ -2672,6 +3005,12 @@ func (uconn *UConn) ApplyPreset(p *ClientHelloSpec) error {
 			hello.CipherSuites[i] = GetBoringGREASEValue(uconn.greaseSeed, ssl_grease_cipher)
 		}
 	}
+	if p.GREASECipherCount != 0 {
+		hello.CipherSuites, err = uconn.spreadGREASECiphers(hello.CipherSuites, p.GREASECipherCount)
+		if err != nil {
+			return err
+		}
+	}
 
 	// A random session ID is used to detect when the server accepted a ticket
 	// and is resuming a session (see RFC 5077). In TLS 1.3, it's always set as
@@ -2686,12 +3025,36 @@ func (uconn *UConn) ApplyPreset(p *ClientHelloSpec) error {
 		}
 		uconn.HandshakeState.Hello.SessionId = sessionID[:]
 	}
+	if uconn.sessionID != nil {
+		uconn.HandshakeState.Hello.SessionId = slices.Clone(uconn.sessionID)
+	}
 
 	uconn.Extensions = make([]TLSExtension, len(p.Extensions))
 	copy(uconn.Extensions, p.Extensions)
+	if p.GREASEExtensionCount != 0 {
+		if p.ForceExtensionOrder {
+			return errors.New("tls: GREASEExtensionCount can't be used with ForceExtensionOrder")
+		}
+		uconn.Extensions, err = uconn.spreadGREASEExtensions(uconn.Extensions, p.GREASEExtensionCount)
+		if err != nil {
+			return err
+		}
+	}
+
+	// GREASE ECH values are drawn before the extensions below consume any
+	// randomness, so they don't depend on a (shuffled) extension order.
+	for _, e := range uconn.Extensions {
+		if gech, ok := e.(*GREASEEncryptedClientHelloExtension); ok {
+			if err := gech.initWithRand(uconn.config.rand()); err != nil {
+				return err
+			}
+		}
+	}
 
 	// Check whether NPN extension actually exists
 	var haveNPN bool
+	var alpnProtocols []string
+	var alps *ApplicationSettingsExtension
 
 	// reGrease, and point things to each other
 	for _, e := range uconn.Extensions {
@@ -2701,14 +3064,20 @@ func (uconn *UConn) ApplyPreset(p *ClientHelloSpec) error {
 				ext.ServerName = uconn.config.ServerName
 			}
 		case *UtlsGREASEExtension:
-			switch grease_extensions_seen {
+			if p.GREASEExtensionCount != 0 {
+				break // drawn by spreadGREASEExtensions
+			}
+			if grease_extensions_seen > 1 && !p.ForceExtensionOrder {
+				return errors.New("at most 2 grease extensions are supported")
+			}
+			// With ForceExtensionOrder, any further GREASE extensions
+			// alternate between the two values.
+			switch grease_extensions_seen % 2 {
 			case 0:
 				ext.Value = GetBoringGREASEValue(uconn.greaseSeed, ssl_grease_extension1)
 			case 1:
 				ext.Value = GetBoringGREASEValue(uconn.greaseSeed, ssl_grease_extension2)
 				ext.Body = []byte{0}
-			default:
-				return errors.New("at most 2 grease extensions are supported")
 			}
 			grease_extensions_seen += 1
 		case *SupportedCurvesExtension:
@@ -2725,7 +3094,16 @@ func (uconn *UConn) ApplyPreset(p *ClientHelloSpec) error {
 					ext.KeyShares[i].Group = CurveID(GetBoringGREASEValue(uconn.greaseSeed, ssl_grease_group))
 					continue
 				}
-				if len(ext.KeyShares[i].Data) > 1 {
+				if len(ext.KeyShares[i].Data) > 0 {
+					// provided by the caller and sent as-is, without a private key
+					continue
+				}
+				if uconn.dummyKeyShares {
+					data, err := dummyKeyShareData(curveID)
+					if err != nil {
+						return err
+					}
+					ext.KeyShares[i].Data = data
 					continue
 				}
 
@@ -2768,8 +3146,30 @@ func (uconn *UConn) ApplyPreset(p *ClientHelloSpec) error {
 					ext.Versions[i] = GetBoringGREASEValue(uconn.greaseSeed, ssl_grease_version)
 				}
 			}
+		case *SignatureAlgorithmsExtension:
+			// BoringSSL doesn't GREASE signature algorithms, so there is no
+			// dedicated seed index; the cipher suite one is reused.
+			for i := range ext.SupportedSignatureAlgorithms {
+				if isGREASEUint16(uint16(ext.SupportedSignatureAlgorithms[i])) {
+					ext.SupportedSignatureAlgorithms[i] = SignatureScheme(uconn.sigAlgGREASEValue())
+				}
+			}
 		case *NPNExtension:
 			haveNPN = true
+		case *ALPNExtension:
+			alpnProtocols = ext.AlpnProtocols
+		case *ApplicationSettingsExtension:
+			alps = ext
+		}
+	}
+
+	// ALPS only applies to protocols offered in ALPN, and servers reject a
+	// ClientHello where it doesn't (draft-vvv-tls-alps-01, Section 3).
+	if alps != nil {
+		for _, proto := range alps.SupportedProtocols {
+			if !slices.Contains(alpnProtocols, proto) {
+				return fmt.Errorf("tls: ALPS protocol %q is not offered in the ALPN extension", proto)
+			}
 		}
 	}
 
@@ -2785,6 +3185,136 @@ func (uconn *UConn) ApplyPreset(p *ClientHelloSpec) error {
 	return nil
 }
 
+// spreadGREASECiphers returns suites with n GREASE cipher suites instead of
+// its own, see ClientHelloSpec.GREASECipherCount.
+func (uconn *UConn) spreadGREASECiphers(suites []uint16, n int) ([]uint16, error) {
+	if n < 0 || n > 16 {
+		return nil, errors.New("tls: GREASECipherCount must be between 0 and 16")
+	}
+	suites = slices.DeleteFunc(suites, isGREASEUint16)
+	values := distinctGREASEValues(GetBoringGREASEValue(uconn.greaseSeed, ssl_grease_cipher), n)
+	spread := make([]uint16, 0, len(suites)+n)
+	for i, k := 0, 0; i <= len(suites); i++ {
+		// the k-th GREASE value goes before the (k*len(suites)/n)-th suite
+		for ; k < n && k*len(suites)/n == i; k++ {
+			spread = append(spread, values[k])
+		}
+		if i < len(suites) {
+			spread = append(spread, suites[i])
+		}
+	}
+	return spread, nil
+}
+
+// spreadGREASEExtensions returns exts with n GREASE extensions instead of its
+// own, see ClientHelloSpec.GREASEExtensionCount.
+func (uconn *UConn) spreadGREASEExtensions(exts []TLSExtension, n int) ([]TLSExtension, error) {
+	if n < 0 || n > 16 {
+		return nil, errors.New("tls: GREASEExtensionCount must be between 0 and 16")
+	}
+	exts = slices.DeleteFunc(exts, func(ext TLSExtension) bool {
+		_, ok := ext.(*UtlsGREASEExtension)
+		return ok
+	})
+	// padding and pre_shared_key stay after the last GREASE extension
+	end := len(exts)
+	for end > 0 {
+		switch exts[end-1].(type) {
+		case *UtlsPaddingExtension, PreSharedKeyExtension:
+			end--
+			continue
+		}
+		break
+	}
+
+	// The last extension gets the second value, and the others distinct
+	// ones, as Chrome's two GREASE extensions would.
+	first := GetBoringGREASEValue(uconn.greaseSeed, ssl_grease_extension1)
+	last := GetBoringGREASEValue(uconn.greaseSeed, ssl_grease_extension2)
+	values := []uint16{first}
+	if n > 1 {
+		values = append(distinctGREASEValues(first, n-1, last), last)
+	}
+	position := func(k int) int { // the index in exts the k-th GREASE extension goes before
+		if n == 1 {
+			return 0
+		}
+		return k * end / (n - 1)
+	}
+	spread := make([]TLSExtension, 0, len(exts)+n)
+	for i, k := 0, 0; i <= len(exts); i++ {
+		for ; k < n && position(k) == i; k++ {
+			if k == n-1 && n > 1 {
+				spread = append(spread, &UtlsGREASEExtension{Value: values[k], Body: []byte{0}})
+			} else {
+				spread = append(spread, &UtlsGREASEExtension{Value: values[k]})
+			}
+		}
+		if i < len(exts) {
+			spread = append(spread, exts[i])
+		}
+	}
+	return spread, nil
+}
+
+// distinctGREASEValues returns n distinct GREASE values, starting with first
+// and followed by the next ones in the order of their codepoints, wrapping
+// around, except those in skip.
+func distinctGREASEValues(first uint16, n int, skip ...uint16) []uint16 {
+	values := make([]uint16, 0, n)
+	for v := first; len(values) < n; v = (v+0x1010)&0xf0f0 | 0x0a0a {
+		if !slices.Contains(skip, v) {
+			values = append(values, v)
+		}
+	}
+	return values
+}
+
+// initGREASESeed draws the seed all GREASE values of the ClientHello are
+// derived from, as BoringSSL does.
+func (uconn *UConn) initGREASESeed(rnd io.Reader) error {
+	grease_bytes := make([]byte, 2*ssl_grease_last_index)
+	_, err := io.ReadFull(rnd, grease_bytes)
+	if err != nil {
+		return errors.New("tls: short read from Rand: " + err.Error())
+	}
+	for i := range uconn.greaseSeed {
+		uconn.greaseSeed[i] = binary.LittleEndian.Uint16(grease_bytes[2*i : 2*i+2])
+	}
+	v := uconn.greaseValues
+	if v == nil {
+		v = &GREASEValues{}
+	}
+	for index, value := range map[int]uint16{
+		ssl_grease_cipher:     v.Cipher,
+		ssl_grease_group:      v.Group,
+		ssl_grease_extension1: v.Extension1,
+		ssl_grease_extension2: v.Extension2,
+		ssl_grease_version:    v.Version,
+	} {
+		if value != 0 {
+			uconn.greaseSeed[index] = value
+		}
+	}
+	if GetBoringGREASEValue(uconn.greaseSeed, ssl_grease_extension1) == GetBoringGREASEValue(uconn.greaseSeed, ssl_grease_extension2) {
+		// Only one of them can be pinned, SetGREASEValues rejects equal values.
+		if v.Extension2 == 0 {
+			uconn.greaseSeed[ssl_grease_extension2] ^= 0x1010
+		} else {
+			uconn.greaseSeed[ssl_grease_extension1] ^= 0x1010
+		}
+	}
+	return nil
+}
+
+// sigAlgGREASEValue returns the GREASE signature algorithm of the ClientHello.
+func (uconn *UConn) sigAlgGREASEValue() uint16 {
+	if uconn.greaseValues != nil && uconn.greaseValues.SigAlg != 0 {
+		return uconn.greaseValues.SigAlg
+	}
+	return GetBoringGREASEValue(uconn.greaseSeed, ssl_grease_cipher)
+}
+
 func (uconn *UConn) generateRandomizedSpec() (ClientHelloSpec, error) {
 	return generateRandomizedSpec(&uconn.ClientHelloID, uconn.serverName, uconn.config.NextProtos)
 }
@@ -2817,6 +3347,9 @@ func generateRandomizedSpec(
 	switch id.Client {
 	case helloRandomizedALPN:
 		WithALPN = true
+	case helloRandomizedFixedALPN:
+		WithALPN = true
+		nextProtos = []string{"h2", "http/1.1"}
 	case helloRandomizedNoALPN:
 		WithALPN = false
 	case helloRandomized:
@@ -2856,7 +3389,7 @@ func generateRandomizedSpec(
 
 	p.CipherSuites = removeRandomCiphers(r, shuffledSuites, id.Weights.CipherSuites_Remove_RandomCiphers)
 
-	sni := SNIExtension{serverName}
+	sni := SNIExtension{ServerName: serverName}
 	sessionTicket := SessionTicketExtension{}
 
 	sigAndHashAlgos := []SignatureScheme{
@@ -2925,7 +3458,8 @@ func generateRandomizedSpec(
 		p.Extensions = append(p.Extensions, &alpn)
 	}
 
-	if r.FlipWeightedCoin(id.Weights.Extensions_Append_Padding) || p.TLSVersMax == VersionTLS13 {
+	if r.FlipWeightedCoin(id.Weights.Extensions_Append_Padding) ||
+		(p.TLSVersMax == VersionTLS13 && !id.Weights.tls13PaddingByWeight) {
 		// always include for TLS 1.3, since TLS 1.3 ClientHellos are often over 256 bytes
 		// and that's when padding is required to work around buggy middleboxes
 		p.Extensions = append(p.Extensions, &padding)
@@ -2973,7 +3507,8 @@ func generateRandomizedSpec(
 			if err != nil {
 				return p, err
 			}
-			if r.FlipWeightedCoin(id.Weights.Extensions_Append_ALPS) {
+			// ALPS must only list protocols offered in ALPN.
+			if r.FlipWeightedCoin(id.Weights.Extensions_Append_ALPS) && slices.Contains(nextProtos, "h2") {
 				// As with the ALPN case above, default to something popular
 				// (unlike ALPN, ALPS can't yet be specified in uconn.config).
 				alps := &ApplicationSettingsExtension{SupportedProtocols: []string{"h2"}}
@@ -2981,6 +3516,23 @@ func generateRandomizedSpec(
 			}
 		}
 
+		// Like ALPS, later additions use their own salted seed, and don't
+		// change the draws of r.
+		compressCertRand, err := newPRNGWithSaltedSeed(id.Seed, "CompressCert")
+		if err != nil {
+			return p, err
+		}
+		if compressCertRand.FlipWeightedCoin(id.Weights.Extensions_Append_CompressCert) {
+			p.Extensions = append(p.Extensions, &UtlsCompressCertExtension{[]CertCompressionAlgo{CertCompressionBrotli}})
+		}
+		echRand, err := newPRNGWithSaltedSeed(id.Seed, "GREASE_ECH")
+		if err != nil {
+			return p, err
+		}
+		if echRand.FlipWeightedCoin(id.Weights.Extensions_Append_GREASE_ECH) {
+			p.Extensions = append(p.Extensions, &GREASEEncryptedClientHelloExtension{})
+		}
+
 		// TODO: randomly add DelegatedCredentialsExtension, once it is
 		// sufficiently popular.
 	}
@@ -0,0 +1,836 @@
+// Copyright 2024 The uTLS Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tls
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestUTLSChrome131HybridKeyShare(t *testing.T) {
+	if x25519MLKEM768Scheme() == nil {
+		t.Skip("X25519MLKEM768 is not supported by this Go version")
+	}
+
+	uconn := UClient(&net.TCPConn{}, &Config{ServerName: "foobar"}, HelloChrome_131)
+	if err := uconn.BuildHandshakeState(); err != nil {
+		t.Fatalf("got error: %v; expected to succeed", err)
+	}
+
+	var sawCurves, sawKeyShares, sawALPS bool
+	for _, ext := range uconn.Extensions {
+		switch e := ext.(type) {
+		case *SupportedCurvesExtension:
+			sawCurves = true
+			if len(e.Curves) != 5 || !isGREASEUint16(uint16(e.Curves[0])) ||
+				!sliceEq(e.Curves[1:], []CurveID{X25519MLKEM768, X25519, CurveP256, CurveP384}) {
+				t.Errorf("unexpected supported_groups: %v", e.Curves)
+			}
+		case *KeyShareExtension:
+			sawKeyShares = true
+			if len(e.KeyShares) != 3 || !isGREASEUint16(uint16(e.KeyShares[0].Group)) ||
+				e.KeyShares[1].Group != X25519MLKEM768 || e.KeyShares[2].Group != X25519 {
+				t.Fatalf("unexpected key_share groups: %v", e.KeyShares)
+			}
+			if l := len(e.KeyShares[1].Data); l != 1216 {
+				t.Errorf("X25519MLKEM768 key share is %d bytes, expected 1216", l)
+			}
+			if l := len(e.KeyShares[2].Data); l != 32 {
+				t.Errorf("X25519 key share is %d bytes, expected 32", l)
+			}
+		case *ApplicationSettingsExtension:
+			sawALPS = true
+			if id, _ := extensionIDOf(e); id != utlsExtensionApplicationSettingsNew {
+				t.Errorf("ALPS is sent with codepoint %d, expected %d", id, utlsExtensionApplicationSettingsNew)
+			}
+			if !sliceEq(e.SupportedProtocols, []string{"h2"}) {
+				t.Errorf("ALPS carries %v, expected [h2]", e.SupportedProtocols)
+			}
+		}
+	}
+	if !sawCurves || !sawKeyShares || !sawALPS {
+		t.Errorf("missing extensions: supported_groups %v, key_share %v, ALPS %v", sawCurves, sawKeyShares, sawALPS)
+	}
+}
+
+func TestUTLSHandshakeX25519MLKEM768(t *testing.T) {
+	if x25519MLKEM768Scheme() == nil {
+		t.Skip("X25519MLKEM768 is not supported by this Go version")
+	}
+
+	serverConfig := testConfig.Clone()
+	serverConfig.CurvePreferences = []CurveID{X25519MLKEM768, X25519}
+	clientConfig := &Config{ServerName: "example.golang", InsecureSkipVerify: true}
+
+	c, s := localPipe(t)
+	done := make(chan error, 1)
+	go func() {
+		server := Server(s, serverConfig)
+		done <- server.Handshake()
+		server.Close()
+	}()
+
+	uconn := UClient(c, clientConfig, HelloChrome_131)
+	defer uconn.Close()
+	if err := uconn.Handshake(); err != nil {
+		t.Fatalf("client handshake failed: %v", err)
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("server handshake failed: %v", err)
+	}
+	if group := uconn.HandshakeState.ServerHello.ServerShare.group; group != X25519MLKEM768 {
+		t.Errorf("negotiated group %v, expected X25519MLKEM768", group)
+	}
+}
+
+func TestUTLSProvidedKeyShare(t *testing.T) {
+	if x25519MLKEM768Scheme() == nil {
+		t.Skip("X25519MLKEM768 is not supported by this Go version")
+	}
+
+	provided := bytes.Repeat([]byte{0x42}, 32)
+	handshake := func(t *testing.T, serverCurves []CurveID) (*UConn, error) {
+		spec := mustSpec(t, HelloChrome_131)
+		for _, ext := range spec.Extensions {
+			if ks, ok := ext.(*KeyShareExtension); ok {
+				for i := range ks.KeyShares {
+					if ks.KeyShares[i].Group == X25519 {
+						ks.KeyShares[i].Data = provided
+					}
+				}
+			}
+		}
+
+		serverConfig := testConfig.Clone()
+		serverConfig.CurvePreferences = serverCurves
+		c, s := localPipe(t)
+		go func() {
+			server := Server(s, serverConfig)
+			server.Handshake()
+			server.Close()
+		}()
+
+		uconn := UClient(c, &Config{ServerName: "example.golang", InsecureSkipVerify: true}, HelloCustom)
+		t.Cleanup(func() { uconn.Close() })
+		if err := uconn.ApplyPreset(spec); err != nil {
+			t.Fatalf("got error: %v; expected to succeed", err)
+		}
+		if err := uconn.BuildHandshakeState(); err != nil {
+			t.Fatalf("got error: %v; expected to succeed", err)
+		}
+		if !bytes.Contains(uconn.HandshakeState.Hello.Raw, append([]byte{0x00, 0x1d, 0x00, 0x20}, provided...)) {
+			t.Error("the provided X25519 key share was not sent verbatim")
+		}
+		params := uconn.HandshakeState.State13.KeySharesParams
+		if _, ok := params.GetEcdheKey(X25519); ok {
+			t.Error("got a private key for the provided X25519 key share")
+		}
+		if _, ok := params.GetKemKey(X25519MLKEM768); !ok {
+			t.Error("got no private key for X25519MLKEM768")
+		}
+		return uconn, uconn.Handshake()
+	}
+
+	t.Run("hybrid", func(t *testing.T) {
+		uconn, err := handshake(t, []CurveID{X25519MLKEM768, X25519})
+		if err != nil {
+			t.Fatalf("got error: %v; expected to succeed", err)
+		}
+		if group := uconn.HandshakeState.ServerHello.ServerShare.group; group != X25519MLKEM768 {
+			t.Errorf("negotiated group %v, expected X25519MLKEM768", group)
+		}
+	})
+
+	t.Run("provided", func(t *testing.T) {
+		if _, err := handshake(t, []CurveID{X25519}); err == nil || !strings.Contains(err.Error(), "without a private key") {
+			t.Errorf("got error %v, expected the handshake to fail for the provided key share", err)
+		}
+	})
+}
+
+func TestUTLSChrome131ApplicationSettings(t *testing.T) {
+	if x25519MLKEM768Scheme() == nil {
+		t.Skip("X25519MLKEM768 is not supported by this Go version")
+	}
+
+	uconn := UClient(&net.TCPConn{}, &Config{ServerName: "foobar"}, HelloChrome_131)
+	if err := uconn.BuildHandshakeState(); err != nil {
+		t.Fatalf("got error: %v; expected to succeed", err)
+	}
+	// codepoint 17613, then the extension length and the ALPS protocol list,
+	// which only holds h2
+	want := []byte{0x44, 0xcd, 0x00, 0x05, 0x00, 0x03, 0x02, 'h', '2'}
+	if !bytes.Contains(uconn.HandshakeState.Hello.Raw, want) {
+		t.Errorf("ClientHello doesn't contain the ALPS extension %x", want)
+	}
+	if bytes.Contains(uconn.HandshakeState.Hello.Raw, []byte{0x44, 0x69, 0x00, 0x05}) {
+		t.Error("ClientHello contains ALPS with the old codepoint")
+	}
+	if uconn.utls.applicationSettingsCodepoint != utlsExtensionApplicationSettingsNew {
+		t.Errorf("client application settings use codepoint %d, expected %d", uconn.utls.applicationSettingsCodepoint, utlsExtensionApplicationSettingsNew)
+	}
+
+	t.Run("ALPN mismatch", func(t *testing.T) {
+		spec := mustSpec(t, HelloChrome_131)
+		for _, ext := range spec.Extensions {
+			if alps, ok := ext.(*ApplicationSettingsExtension); ok {
+				alps.SupportedProtocols = []string{"h3"}
+			}
+		}
+		uconn := UClient(&net.TCPConn{}, &Config{ServerName: "foobar"}, HelloCustom)
+		if err := uconn.ApplyPreset(spec); err == nil || !strings.Contains(err.Error(), "ALPN") {
+			t.Errorf("got error %v applying ALPS for a protocol missing from ALPN, expected it to mention ALPN", err)
+		}
+	})
+}
+
+func TestUTLSChromePQModes(t *testing.T) {
+	if x25519MLKEM768Scheme() == nil {
+		t.Skip("X25519MLKEM768 is not supported by this Go version")
+	}
+
+	for _, test := range []struct {
+		name      string
+		mode      PQMode
+		groups    []CurveID
+		keyShares []CurveID
+	}{
+		{"hybrid and classical", PQModeHybridAndClassical, []CurveID{X25519MLKEM768, X25519, CurveP256, CurveP384}, []CurveID{X25519MLKEM768, X25519}},
+		{"hybrid only", PQModeHybridOnly, []CurveID{X25519MLKEM768, X25519, CurveP256, CurveP384}, []CurveID{X25519MLKEM768}},
+		{"classical only", PQModeClassicalOnly, []CurveID{X25519, CurveP256, CurveP384}, []CurveID{X25519}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			c, s := localPipe(t)
+			serverConfig := testConfig.Clone()
+			serverConfig.CurvePreferences = []CurveID{X25519MLKEM768, CurveP256}
+			go func() {
+				server := Server(s, serverConfig)
+				server.Handshake()
+				server.Close()
+			}()
+
+			uconn := UClient(c, &Config{ServerName: "example.golang", InsecureSkipVerify: true}, HelloChrome_PQ(test.mode))
+			defer uconn.Close()
+			if err := uconn.BuildHandshakeState(); err != nil {
+				t.Fatalf("got error: %v; expected to succeed", err)
+			}
+			var groups, keyShares []CurveID
+			for _, ext := range uconn.Extensions {
+				switch e := ext.(type) {
+				case *SupportedCurvesExtension:
+					groups = e.Curves
+				case *KeyShareExtension:
+					for _, ks := range e.KeyShares {
+						keyShares = append(keyShares, ks.Group)
+					}
+				}
+			}
+			if len(groups) == 0 || !isGREASEUint16(uint16(groups[0])) || !sliceEq(groups[1:], test.groups) {
+				t.Errorf("got supported_groups %v, expected GREASE and %v", groups, test.groups)
+			}
+			if len(keyShares) == 0 || !isGREASEUint16(uint16(keyShares[0])) || !sliceEq(keyShares[1:], test.keyShares) {
+				t.Fatalf("got key_share groups %v, expected GREASE and %v", keyShares, test.keyShares)
+			}
+
+			params := uconn.HandshakeState.State13.KeySharesParams
+			for _, group := range test.keyShares {
+				_, kemOk := params.GetKemKey(group)
+				_, ecdheOk := params.GetEcdheKey(group)
+				if !kemOk && !ecdheOk {
+					t.Errorf("no private key for the %v key share", group)
+				}
+			}
+
+			// without a hybrid key share, the server asks for CurveP256
+			if err := uconn.Handshake(); err != nil {
+				t.Fatalf("handshake failed: %v", err)
+			}
+			want := X25519MLKEM768
+			if test.mode == PQModeClassicalOnly {
+				want = CurveP256
+			}
+			if group := uconn.HandshakeState.ServerHello.ServerShare.group; group != want {
+				t.Errorf("negotiated group %v, expected %v", group, want)
+			}
+		})
+	}
+}
+
+func TestUTLSFirefox133(t *testing.T) {
+	if x25519MLKEM768Scheme() == nil {
+		t.Skip("X25519MLKEM768 is not supported by this Go version")
+	}
+
+	uconn := UClient(&net.TCPConn{}, &Config{ServerName: "foobar"}, HelloFirefox_133)
+	if err := uconn.BuildHandshakeState(); err != nil {
+		t.Fatalf("got error: %v; expected to succeed", err)
+	}
+
+	expectedOrder := []uint16{
+		extensionServerName,
+		extensionExtendedMasterSecret,
+		extensionRenegotiationInfo,
+		extensionSupportedCurves,
+		extensionSupportedPoints,
+		extensionSessionTicket,
+		extensionALPN,
+		extensionStatusRequest,
+		fakeExtensionDelegatedCredentials,
+		extensionSCT,
+		extensionKeyShare,
+		extensionSupportedVersions,
+		extensionSignatureAlgorithms,
+		extensionPSKModes,
+		fakeRecordSizeLimit,
+		utlsExtensionCompressCertificate,
+		utlsExtensionECH,
+		utlsExtensionPadding,
+	}
+	var order []uint16
+	for _, ext := range uconn.Extensions {
+		id, _ := extensionIDOf(ext)
+		order = append(order, id)
+
+		switch e := ext.(type) {
+		case *FakeRecordSizeLimitExtension:
+			if e.Limit != 0x4001 {
+				t.Errorf("record_size_limit is %#x, expected 0x4001", e.Limit)
+			}
+			b := make([]byte, e.Len())
+			e.Read(b)
+			if expected := []byte{0x00, 0x1c, 0x00, 0x02, 0x40, 0x01}; !sliceEq(b, expected) {
+				t.Errorf("record_size_limit is sent as %x, expected %x", b, expected)
+			}
+		case *UtlsPaddingExtension:
+			// the X25519MLKEM768 key share pushes the hello far beyond 512 bytes
+			if e.WillPad {
+				t.Errorf("unexpected padding of %d bytes", e.PaddingLen)
+			}
+		}
+	}
+	if !sliceEq(order, expectedOrder) {
+		t.Errorf("got extension order %v, expected %v", order, expectedOrder)
+	}
+
+	// JA4 of the real browser
+	if fp, err := uconn.JA4(); err != nil || fp != "t13d1717h2_5b57614c22b0_3cbfd9057e0d" {
+		t.Errorf("got JA4 %s (error: %v), expected t13d1717h2_5b57614c22b0_3cbfd9057e0d", fp, err)
+	}
+}
+
+// greaseValues returns all GREASE values of the ClientHello built by uconn,
+// by the field they appear in.
+func greaseValues(uconn *UConn) map[string][]uint16 {
+	values := make(map[string][]uint16)
+	add := func(field string, v uint16) {
+		if isGREASEUint16(v) {
+			values[field] = append(values[field], v)
+		}
+	}
+	for _, suite := range uconn.HandshakeState.Hello.CipherSuites {
+		add("cipher_suites", suite)
+	}
+	for _, ext := range uconn.Extensions {
+		switch e := ext.(type) {
+		case *UtlsGREASEExtension:
+			add("extensions", e.Value)
+		case *SupportedCurvesExtension:
+			for _, curve := range e.Curves {
+				add("supported_groups", uint16(curve))
+			}
+		case *KeyShareExtension:
+			for _, ks := range e.KeyShares {
+				add("key_share", uint16(ks.Group))
+			}
+		case *SupportedVersionsExtension:
+			for _, vers := range e.Versions {
+				add("supported_versions", vers)
+			}
+		case *SignatureAlgorithmsExtension:
+			for _, alg := range e.SupportedSignatureAlgorithms {
+				add("signature_algorithms", uint16(alg))
+			}
+		}
+	}
+	return values
+}
+
+func TestUTLSDeterministicGREASE(t *testing.T) {
+	build := func(seed int64) map[string][]uint16 {
+		spec := mustSpec(t, HelloChrome_120)
+		for _, ext := range spec.Extensions {
+			if e, ok := ext.(*SignatureAlgorithmsExtension); ok {
+				e.SupportedSignatureAlgorithms = append([]SignatureScheme{GREASE_PLACEHOLDER}, e.SupportedSignatureAlgorithms...)
+			}
+		}
+		uconn := UClient(&net.TCPConn{}, &Config{ServerName: "foobar", Rand: rand.New(rand.NewSource(seed))}, HelloCustom)
+		if err := uconn.ApplyPreset(spec); err != nil {
+			t.Fatalf("got error: %v; expected to succeed", err)
+		}
+		if err := uconn.BuildHandshakeState(); err != nil {
+			t.Fatalf("got error: %v; expected to succeed", err)
+		}
+		return greaseValues(uconn)
+	}
+
+	first, second := build(1), build(1)
+	if len(first) != 6 || len(first["extensions"]) != 2 {
+		t.Fatalf("got GREASE values %x, expected them in 6 fields", first)
+	}
+	if !reflect.DeepEqual(first, second) {
+		t.Errorf("got GREASE values %x and %x with the same seed", first, second)
+	}
+
+	for seed := int64(2); reflect.DeepEqual(first, build(seed)); seed++ {
+		if seed > 10 {
+			t.Fatalf("GREASE values do not depend on the seed")
+		}
+	}
+}
+
+func TestUTLSGREASECount(t *testing.T) {
+	spec := mustSpec(t, HelloChrome_131)
+	spec.GREASECipherCount = 2
+	spec.GREASEExtensionCount = 3
+	c, s := localPipe(t)
+	done := make(chan error, 1)
+	go func() {
+		server := Server(s, testConfig.Clone())
+		defer server.Close()
+		done <- server.Handshake()
+	}()
+	uconn := UClient(c, &Config{ServerName: "example.golang", InsecureSkipVerify: true}, HelloCustom)
+	defer uconn.Close()
+	if err := uconn.SetGREASEValues(GREASEValues{Cipher: 0x1a1a, Extension1: 0x2a2a, Extension2: 0x3a3a}); err != nil {
+		t.Fatalf("got error: %v; expected to succeed", err)
+	}
+	if err := uconn.ApplyPreset(spec); err != nil {
+		t.Fatalf("got error: %v; expected to succeed", err)
+	}
+	// the server rejects duplicate extensions
+	if err := uconn.Handshake(); err != nil {
+		t.Fatalf("client handshake failed: %v", err)
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("server handshake failed: %v", err)
+	}
+
+	var ch clientHelloMsg
+	if !ch.unmarshal(uconn.HandshakeState.Hello.Raw) {
+		t.Fatal("malformed ClientHello")
+	}
+	// 15 suites, with the second GREASE value before the 8th one
+	suites := ch.cipherSuites
+	if len(suites) != 17 || suites[0] != 0x1a1a || suites[8] != 0x2a2a {
+		t.Errorf("got cipher suites %x, expected GREASE 1a1a first and 2a2a 9th", suites)
+	}
+	for i, suite := range suites {
+		if isGREASEUint16(suite) != (i == 0 || i == 8) {
+			t.Errorf("got cipher suite %#x at position %d", suite, i)
+		}
+	}
+
+	exts := clientHelloRawExtensions(t, uconn.HandshakeState.Hello.Raw)
+	n := len(exts)
+	for i, want := range map[int]rawExtension{
+		0:     {0x2a2a, nil},
+		n / 2: {0x4a4a, nil}, // 3a3a is the last one's
+		n - 1: {0x3a3a, []byte{0}},
+	} {
+		if got := exts[i]; got.extType != want.extType || !bytes.Equal(got.data, want.data) {
+			t.Errorf("got extension %#x with %x at position %d, expected %#x with %x", got.extType, got.data, i, want.extType, want.data)
+		}
+	}
+	greases := 0
+	for _, ext := range exts {
+		if isGREASEUint16(ext.extType) {
+			greases++
+		}
+	}
+	if greases != 3 {
+		t.Errorf("got %d GREASE extensions, expected 3", greases)
+	}
+
+	data, err := json.Marshal(&spec)
+	if err != nil {
+		t.Fatalf("got error: %v; expected to succeed", err)
+	}
+	fromJSON, err := ClientHelloSpecFromJSON(data)
+	if err != nil {
+		t.Fatalf("got error: %v; expected to succeed", err)
+	}
+	if fromJSON.GREASECipherCount != 2 || fromJSON.GREASEExtensionCount != 3 {
+		t.Errorf("got GREASE counts %d and %d from JSON, expected 2 and 3", fromJSON.GREASECipherCount, fromJSON.GREASEExtensionCount)
+	}
+
+	// A single GREASE extension goes first, before padding.
+	spec = mustSpec(t, HelloFirefox_120)
+	spec.GREASEExtensionCount = 1
+	uconn = UClient(&net.TCPConn{}, &Config{ServerName: "example.com"}, HelloCustom)
+	if err := uconn.ApplyPreset(spec); err != nil {
+		t.Fatalf("got error: %v; expected to succeed", err)
+	}
+	if e, ok := uconn.Extensions[0].(*UtlsGREASEExtension); !ok || len(e.Body) != 0 || len(uconn.Extensions) != len(spec.Extensions)+1 {
+		t.Errorf("got extensions %v, expected a single GREASE extension first", uconn.Extensions)
+	}
+
+	for _, bad := range []ClientHelloSpec{
+		{GREASECipherCount: 17},
+		{GREASEExtensionCount: -1},
+		{GREASEExtensionCount: 2, ForceExtensionOrder: true},
+	} {
+		spec := mustSpec(t, HelloChrome_131)
+		spec.GREASECipherCount, spec.GREASEExtensionCount, spec.ForceExtensionOrder = bad.GREASECipherCount, bad.GREASEExtensionCount, bad.ForceExtensionOrder
+		if err := UClient(&net.TCPConn{}, &Config{ServerName: "example.com"}, HelloCustom).ApplyPreset(spec); err == nil {
+			t.Errorf("got no error applying a spec with %d GREASE cipher suites, %d extensions and ForceExtensionOrder %v",
+				bad.GREASECipherCount, bad.GREASEExtensionCount, bad.ForceExtensionOrder)
+		}
+	}
+}
+
+func TestUTLSRandomizedFixedALPN(t *testing.T) {
+	orders := make(map[string]bool)
+	suites := make(map[string]bool)
+	for i := 0; i < 50; i++ {
+		config := &Config{ServerName: "foobar", NextProtos: []string{"http/1.1"}}
+		uconn := UClient(&net.TCPConn{}, config, HelloRandomizedFixedALPN)
+		if err := uconn.BuildHandshakeState(); err != nil {
+			t.Fatalf("got error: %v; expected to succeed", err)
+		}
+
+		var order []uint16
+		sawALPN := false
+		for _, ext := range uconn.Extensions {
+			id, _ := extensionIDOf(ext)
+			order = append(order, id)
+			if e, ok := ext.(*ALPNExtension); ok {
+				sawALPN = true
+				if !sliceEq(e.AlpnProtocols, []string{"h2", "http/1.1"}) {
+					t.Errorf("ALPN is %v, expected [h2 http/1.1]", e.AlpnProtocols)
+				}
+			}
+		}
+		if !sawALPN {
+			t.Fatalf("missing ALPN extension")
+		}
+		if protos := uconn.HandshakeState.Hello.AlpnProtocols; !sliceEq(protos, []string{"h2", "http/1.1"}) {
+			t.Errorf("ClientHello offers ALPN %v, expected [h2 http/1.1]", protos)
+		}
+		orders[fmt.Sprint(order)] = true
+		suites[fmt.Sprint(uconn.HandshakeState.Hello.CipherSuites)] = true
+	}
+	if len(orders) < 2 || len(suites) < 2 {
+		t.Errorf("got %d extension orders and %d cipher suite lists, expected them to vary", len(orders), len(suites))
+	}
+}
+
+func TestUTLSSafari18(t *testing.T) {
+	expectedSuites := []uint16{
+		TLS_AES_128_GCM_SHA256,
+		TLS_AES_256_GCM_SHA384,
+		TLS_CHACHA20_POLY1305_SHA256,
+		TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+		TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+		TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305,
+		TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+		TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+		TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305,
+		TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA,
+		TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA,
+		TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA,
+		TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA,
+		TLS_RSA_WITH_AES_256_GCM_SHA384,
+		TLS_RSA_WITH_AES_128_GCM_SHA256,
+		TLS_RSA_WITH_AES_256_CBC_SHA,
+		TLS_RSA_WITH_AES_128_CBC_SHA,
+		FAKE_TLS_ECDHE_ECDSA_WITH_3DES_EDE_CBC_SHA,
+		TLS_ECDHE_RSA_WITH_3DES_EDE_CBC_SHA,
+		TLS_RSA_WITH_3DES_EDE_CBC_SHA,
+	}
+	expectedSigAlgs := []SignatureScheme{
+		ECDSAWithP256AndSHA256,
+		PSSWithSHA256,
+		PKCS1WithSHA256,
+		ECDSAWithP384AndSHA384,
+		ECDSAWithSHA1,
+		PSSWithSHA384,
+		PSSWithSHA384,
+		PKCS1WithSHA384,
+		PSSWithSHA512,
+		PKCS1WithSHA512,
+		PKCS1WithSHA1,
+	}
+
+	for _, id := range []ClientHelloID{HelloSafari_18, HelloIOS_18, HelloSafari_Auto, HelloIOS_Auto} {
+		uconn := UClient(&net.TCPConn{}, &Config{ServerName: "foobar"}, id)
+		if err := uconn.BuildHandshakeState(); err != nil {
+			t.Fatalf("%s: got error: %v; expected to succeed", id.Str(), err)
+		}
+
+		suites := uconn.HandshakeState.Hello.CipherSuites
+		if len(suites) == 0 || !isGREASEUint16(suites[0]) || !sliceEq(suites[1:], expectedSuites) {
+			t.Errorf("%s: got cipher suites %v, expected GREASE followed by %v", id.Str(), suites, expectedSuites)
+		}
+
+		var sawEMS bool
+		for _, ext := range uconn.Extensions {
+			switch e := ext.(type) {
+			case *ExtendedMasterSecretExtension:
+				sawEMS = true
+			case *SignatureAlgorithmsExtension:
+				if !sliceEq(e.SupportedSignatureAlgorithms, expectedSigAlgs) {
+					t.Errorf("%s: got signature_algorithms %v, expected %v", id.Str(), e.SupportedSignatureAlgorithms, expectedSigAlgs)
+				}
+			case *SessionTicketExtension:
+				t.Errorf("%s: unexpected session_ticket extension", id.Str())
+			case EncryptedClientHelloExtension:
+				t.Errorf("%s: unexpected encrypted_client_hello extension", id.Str())
+			}
+		}
+		if !sawEMS {
+			t.Errorf("%s: missing extended_master_secret extension", id.Str())
+		}
+
+		if fp, err := uconn.JA4(); err != nil || fp != "t13d2014h2_a09f3c656075_14788d8d241b" {
+			t.Errorf("%s: got JA4 %s (error: %v), expected t13d2014h2_a09f3c656075_14788d8d241b", id.Str(), fp, err)
+		}
+	}
+}
+
+func TestUTLSSignatureAlgorithms(t *testing.T) {
+	for want, ids := range map[*[]SignatureScheme][]ClientHelloID{
+		&ChromeSignatureAlgorithms: {
+			HelloChrome_83, HelloChrome_87, HelloChrome_96, HelloChrome_100, HelloChrome_102,
+			HelloChrome_106_Shuffle, HelloChrome_115_PQ, HelloChrome_120, HelloChrome_120_PQ,
+			HelloChrome_131, HelloChrome_100_PSK, HelloChrome_112_PSK_Shuf,
+			HelloChrome_114_Padding_PSK_Shuf, HelloChrome_115_PQ_PSK, HelloEdge_85, HelloEdge_106,
+			HelloQQ_11_1,
+		},
+		&FirefoxSignatureAlgorithms: {
+			HelloFirefox_55, HelloFirefox_56, HelloFirefox_63, HelloFirefox_65, HelloFirefox_99,
+			HelloFirefox_102, HelloFirefox_105, HelloFirefox_120, HelloFirefox_133, HelloFirefox_116_QUIC,
+		},
+		&SafariSignatureAlgorithms: {
+			HelloIOS_12_1, HelloIOS_13, HelloIOS_14, HelloSafari_16_0, HelloSafari_18, HelloIOS_18,
+		},
+	} {
+		for _, id := range ids {
+			spec, err := utlsIdToSpec(id)
+			if err != nil {
+				t.Fatalf("%s: got error: %v; expected to succeed", id.Str(), err)
+			}
+			var sigAlgs []SignatureScheme
+			for _, ext := range spec.Extensions {
+				if e, ok := ext.(*SignatureAlgorithmsExtension); ok {
+					sigAlgs = e.SupportedSignatureAlgorithms
+				}
+			}
+			if !sliceEq(sigAlgs, *want) {
+				t.Errorf("%s: got signature_algorithms %v, expected %v", id.Str(), sigAlgs, *want)
+				continue
+			}
+			// the spec must own its list
+			sigAlgs[0] = PKCS1WithSHA1
+			if (*want)[0] == PKCS1WithSHA1 {
+				t.Fatalf("%s: modifying the spec modified the exported list", id.Str())
+			}
+		}
+	}
+}
+
+func TestUTLSSortCipherSuitesLikeBrowser(t *testing.T) {
+	for _, test := range []struct {
+		id     ClientHelloID
+		suites []uint16
+		want   []uint16
+	}{
+		{
+			HelloChrome_120,
+			[]uint16{TLS_AES_256_GCM_SHA384, 0xfeed, TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, 0x1a1a, TLS_AES_128_GCM_SHA256},
+			[]uint16{GREASE_PLACEHOLDER, TLS_AES_128_GCM_SHA256, TLS_AES_256_GCM_SHA384, TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, 0xfeed},
+		},
+		{
+			// Firefox prefers ChaCha20-Poly1305 over AES-256-GCM, and doesn't GREASE
+			HelloFirefox_120,
+			[]uint16{TLS_AES_256_GCM_SHA384, TLS_CHACHA20_POLY1305_SHA256, TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, TLS_AES_128_GCM_SHA256},
+			[]uint16{TLS_AES_128_GCM_SHA256, TLS_CHACHA20_POLY1305_SHA256, TLS_AES_256_GCM_SHA384, TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256},
+		},
+		{
+			HelloGolang,
+			[]uint16{TLS_AES_256_GCM_SHA384, TLS_AES_128_GCM_SHA256},
+			[]uint16{TLS_AES_256_GCM_SHA384, TLS_AES_128_GCM_SHA256},
+		},
+	} {
+		if got := SortCipherSuitesLikeBrowser(test.suites, test.id); !sliceEq(got, test.want) {
+			t.Errorf("%s: got cipher suites %x, expected %x", test.id.Str(), got, test.want)
+		}
+	}
+}
+
+// ClientHellos to example.com captured from OpenSSL 3.0.17 and curl 7.88.1 on
+// Debian 12.
+const (
+	openSSL3HelloHex = "0100013403032fcd699283ca05161b6a1f5975069fa8afbcbaa33356f90bff1a2c6eed383dc820639fbf74a43d470c36c7da371e2de57ddd397eac047c66d4fa51e1deb1c69ed1003e130213031301c02cc030009fcca9cca8ccaac02bc02f009ec024c028006bc023c0270067c00ac0140039c009c0130033009d009c003d003c0035002f00ff010000ad00000010000e00000b6578616d706c652e636f6d000b000403000102000a00160014001d0017001e0019001801000101010201030104002300000016000000170000000d002a0028040305030603080708080809080a080b080408050806040105010601030303010302040205020602002b0009080304030303020301002d00020101003300260024001d0020f8b301424a30f5c56fb433b4abfbdcbfb1d418ca322ead3dbdd588ac3747a34f"
+	curlHelloHex     = "010001fc0303a5a62708a5de93e9addd3b596b6b230c9b59823ea41de8b8753437b28da4ea6320ebde35216cbc85323527906f95c7ca460bd1644fe2282b409af29c8f4396b3e5003e130213031301c02cc030009fcca9cca8ccaac02bc02f009ec024c028006bc023c0270067c00ac0140039c009c0130033009d009c003d003c0035002f00ff0100017500000010000e00000b6578616d706c652e636f6d000b000403000102000a00160014001d0017001e00190018010001010102010301040010000e000c02683208687474702f312e31001600000017000000310000000d002a0028040305030603080708080809080a080b080408050806040105010601030303010302040205020602002b0009080304030303020301002d00020101003300260024001d0020769e8c3662101accbcb5aa6e8381225e5893100751103392ac5f572020066c51001500b200000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000"
+)
+
+func TestUTLSOpenSSLParrots(t *testing.T) {
+	for _, test := range []struct {
+		id       ClientHelloID
+		helloHex string
+	}{
+		{HelloOpenSSL_3, openSSL3HelloHex},
+		{HelloCurl, curlHelloHex},
+	} {
+		captured, err := hex.DecodeString(test.helloHex)
+		if err != nil {
+			t.Fatal(err)
+		}
+		uconn := UClient(&net.TCPConn{}, &Config{ServerName: "example.com"}, test.id)
+		if err := uconn.BuildHandshakeState(); err != nil {
+			t.Fatalf("%s: got error: %v; expected to succeed", test.id.Str(), err)
+		}
+		hello := uconn.HandshakeState.Hello
+
+		if !sliceEq(hello.CipherSuites, openSSL3CipherSuites) {
+			t.Errorf("%s: got cipher suites %x, expected %x", test.id.Str(), hello.CipherSuites, openSSL3CipherSuites)
+		}
+		spec, err := (&Fingerprinter{AllowBluntMimicry: true}).FingerprintClientHello(prependRecordHeader(captured, VersionTLS10))
+		if err != nil {
+			t.Fatalf("%s: got error: %v; expected to succeed", test.id.Str(), err)
+		}
+		if !sliceEq(spec.CipherSuites, hello.CipherSuites) {
+			t.Errorf("%s: got cipher suites %x, captured %x", test.id.Str(), hello.CipherSuites, spec.CipherSuites)
+		}
+
+		// Everything but the key share is the same as in the capture.
+		got := clientHelloRawExtensions(t, hello.Raw)
+		want := clientHelloRawExtensions(t, captured)
+		if len(got) != len(want) {
+			t.Fatalf("%s: got %d extensions, captured %d", test.id.Str(), len(got), len(want))
+		}
+		for i := range got {
+			if got[i].extType != want[i].extType {
+				t.Errorf("%s: extension %d is %d, captured %d", test.id.Str(), i, got[i].extType, want[i].extType)
+			} else if got[i].extType != extensionKeyShare && !bytes.Equal(got[i].data, want[i].data) {
+				t.Errorf("%s: extension %d has data %x, captured %x", test.id.Str(), got[i].extType, got[i].data, want[i].data)
+			}
+		}
+		if len(hello.Raw) != len(captured) {
+			t.Errorf("%s: got a %d bytes ClientHello, captured %d bytes", test.id.Str(), len(hello.Raw), len(captured))
+		}
+	}
+}
+
+func TestUTLSRandomizedWeights(t *testing.T) {
+	weights := DefaultWeights
+	weights.TLSVersMax_Set_VersionTLS13 = 1
+	weights.Extensions_Append_ALPS = 0.2
+	weights.Extensions_Append_CompressCert = 0.5
+	weights.Extensions_Append_GREASE_ECH = 0.8
+	weights.Extensions_Append_EMS = 0
+
+	const n = 2000
+	counts := make(map[uint16]int)
+	for i := 0; i < n; i++ {
+		var seed PRNGSeed
+		seed[0], seed[1] = byte(i), byte(i>>8)
+		id := ClientHelloID{Client: helloRandomizedFixedALPN, Seed: &seed, Weights: &weights}
+		spec, err := UTLSIdToSpec(id)
+		if err != nil {
+			t.Fatalf("got error: %v; expected to succeed", err)
+		}
+		if again, err := UTLSIdToSpec(id); err != nil || again.JA3() != spec.JA3() {
+			t.Fatalf("seed %d: got JA3 %s and then %s (error: %v), expected the same spec", i, spec.JA3(), again.JA3(), err)
+		}
+		for _, ext := range spec.Extensions {
+			extID, _ := extensionIDOf(ext)
+			counts[extID]++
+		}
+	}
+
+	for extID, weight := range map[uint16]float64{
+		extensionSupportedVersions:       1,
+		utlsExtensionApplicationSettings: 0.2,
+		utlsExtensionCompressCertificate: 0.5,
+		utlsExtensionECH:                 0.8,
+		extensionExtendedMasterSecret:    0,
+	} {
+		if got := float64(counts[extID]) / n; got < weight-0.05 || got > weight+0.05 {
+			t.Errorf("extension %d is in %.3f of the specs, expected %.2f", extID, got, weight)
+		}
+	}
+}
+
+func TestUTLSRandomizedSpecOptions(t *testing.T) {
+	weights := DefaultWeights
+	weights.TLSVersMax_Set_VersionTLS13 = 0
+	weights.Extensions_Append_Padding = 0.3
+	weights.Extensions_Append_CompressCert = 0
+	options := RandomizedSpecOptions{
+		Weights:       &weights,
+		AlwaysInclude: []uint16{extensionSupportedVersions, utlsExtensionCompressCertificate},
+	}
+
+	const n = 2000
+	countExtensions := func(options RandomizedSpecOptions) map[uint16]int {
+		counts := make(map[uint16]int)
+		for i := 0; i < n; i++ {
+			var seed PRNGSeed
+			seed[0], seed[1] = byte(i), byte(i>>8)
+			id, err := options.HelloID(&seed)
+			if err != nil {
+				t.Fatalf("got error: %v; expected to succeed", err)
+			}
+			spec, err := UTLSIdToSpec(id)
+			if err != nil {
+				t.Fatalf("got error: %v; expected to succeed", err)
+			}
+			if again, err := UTLSIdToSpec(id); err != nil || again.JA3() != spec.JA3() {
+				t.Fatalf("seed %d: got JA3 %s and then %s (error: %v), expected the same spec", i, spec.JA3(), again.JA3(), err)
+			}
+			for _, ext := range spec.Extensions {
+				extID, _ := extensionIDOf(ext)
+				counts[extID]++
+			}
+		}
+		return counts
+	}
+
+	// TLS 1.3 ClientHellos are always padded, unless TLS13PaddingByWeight is
+	// set
+	paddingWeight := 1.0
+	for _, byWeight := range []bool{false, true} {
+		options.TLS13PaddingByWeight = byWeight
+		if byWeight {
+			paddingWeight = 0.3
+		}
+		counts := countExtensions(options)
+		for extID, weight := range map[uint16]float64{
+			extensionSupportedVersions:       1,
+			extensionKeyShare:                1,
+			utlsExtensionCompressCertificate: 1,
+			utlsExtensionPadding:             paddingWeight,
+		} {
+			if got := float64(counts[extID]) / n; got < weight-0.05 || got > weight+0.05 {
+				t.Errorf("TLS13PaddingByWeight %v: extension %d is in %.3f of the specs, expected %.2f", byWeight, extID, got, weight)
+			}
+		}
+	}
+
+	if _, err := (RandomizedSpecOptions{AlwaysInclude: []uint16{fakeRecordSizeLimit}}).HelloID(nil); err == nil {
+		t.Error("got no error requiring an extension that is never sent")
+	}
+	if weights.TLSVersMax_Set_VersionTLS13 != 0 {
+		t.Error("HelloID modified the weights")
+	}
+}
@@ -0,0 +1,143 @@
+// Copyright 2024 The uTLS Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tls
+
+import (
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/cryptobyte"
+)
+
+// PeetPrint returns the peetprint fingerprint (https://tls.peet.ws) of the
+// ClientHello that uconn is configured to send. It is made of the following
+// fields, each a list of decimal values separated by "-", in this order and
+// separated by "|":
+//
+//   - the supported_versions
+//   - the ALPN protocols, without their "h" or "http/" prefix, e.g. "2-1.1"
+//   - the supported_groups
+//   - the signature_algorithms
+//   - the psk_key_exchange_modes
+//   - the compress_certificate algorithms
+//   - the cipher suites
+//   - the extension types, sorted as strings
+//
+// GREASE values are written as "GREASE". Missing extensions leave their field
+// empty. The peetprint_hash shown by tls.peet.ws is the MD5 of this string.
+//
+// Like JA4, it may be called once a ClientHelloSpec has been applied, either
+// via ApplyPreset or BuildHandshakeState. It returns an empty string before
+// that, for HelloGolang, or if an extension is malformed.
+func (uconn *UConn) PeetPrint() string {
+	hello := uconn.HandshakeState.Hello
+	if uconn.ClientHelloID.Client == helloGolang || hello == nil || len(hello.CipherSuites) == 0 {
+		return "" // no ClientHelloSpec was applied
+	}
+	exts, err := uconn.wireExtensions()
+	if err != nil {
+		return ""
+	}
+	fp, err := peetPrint(hello.CipherSuites, exts)
+	if err != nil {
+		return ""
+	}
+	return fp
+}
+
+// peetPrint computes the peetprint fingerprint from the ClientHello fields.
+func peetPrint(cipherSuites []uint16, exts []rawExtension) (string, error) {
+	var versions, groups, sigAlgs, pskModes, certCompAlgs []uint16
+	var protocols, extTypes []string
+	for _, ext := range exts {
+		extTypes = append(extTypes, peetPrintValue(ext.extType))
+
+		extData := cryptobyte.String(ext.data)
+		var list cryptobyte.String
+		switch ext.extType {
+		case extensionSupportedVersions:
+			if !extData.ReadUint8LengthPrefixed(&list) || !readUint16List(&list, &versions) {
+				return "", errors.New("tls: malformed supported_versions extension")
+			}
+		case extensionALPN:
+			if !extData.ReadUint16LengthPrefixed(&list) {
+				return "", errors.New("tls: malformed ALPN extension")
+			}
+			for !list.Empty() {
+				var proto cryptobyte.String
+				if !list.ReadUint8LengthPrefixed(&proto) {
+					return "", errors.New("tls: malformed ALPN extension")
+				}
+				if p, ok := strings.CutPrefix(string(proto), "http/"); ok {
+					protocols = append(protocols, p)
+				} else {
+					protocols = append(protocols, strings.TrimPrefix(string(proto), "h"))
+				}
+			}
+		case extensionSupportedCurves:
+			if !extData.ReadUint16LengthPrefixed(&list) || !readUint16List(&list, &groups) {
+				return "", errors.New("tls: malformed supported_groups extension")
+			}
+		case extensionSignatureAlgorithms:
+			if !extData.ReadUint16LengthPrefixed(&list) || !readUint16List(&list, &sigAlgs) {
+				return "", errors.New("tls: malformed signature_algorithms extension")
+			}
+		case extensionPSKModes:
+			if !extData.ReadUint8LengthPrefixed(&list) {
+				return "", errors.New("tls: malformed psk_key_exchange_modes extension")
+			}
+			for _, mode := range list {
+				pskModes = append(pskModes, uint16(mode))
+			}
+		case utlsExtensionCompressCertificate:
+			if !extData.ReadUint8LengthPrefixed(&list) || !readUint16List(&list, &certCompAlgs) {
+				return "", errors.New("tls: malformed compress_certificate extension")
+			}
+		}
+	}
+	sort.Strings(extTypes)
+
+	return strings.Join([]string{
+		joinPeetPrint(versions),
+		strings.Join(protocols, "-"),
+		joinPeetPrint(groups),
+		joinPeetPrint(sigAlgs),
+		joinPeetPrint(pskModes),
+		joinPeetPrint(certCompAlgs),
+		joinPeetPrint(cipherSuites),
+		strings.Join(extTypes, "-"),
+	}, "|"), nil
+}
+
+// readUint16List appends the uint16 values of list to out, which must be
+// all of list.
+func readUint16List(list *cryptobyte.String, out *[]uint16) bool {
+	for !list.Empty() {
+		var v uint16
+		if !list.ReadUint16(&v) {
+			return false
+		}
+		*out = append(*out, v)
+	}
+	return true
+}
+
+func peetPrintValue(v uint16) string {
+	if isGREASEUint16(v) {
+		return "GREASE"
+	}
+	return strconv.Itoa(int(v))
+}
+
+// joinPeetPrint formats values in decimal separated by "-".
+func joinPeetPrint(values []uint16) string {
+	strs := make([]string, len(values))
+	for i, v := range values {
+		strs[i] = peetPrintValue(v)
+	}
+	return strings.Join(strs, "-")
+}
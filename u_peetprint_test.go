@@ -0,0 +1,61 @@
+// Copyright 2024 The uTLS Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tls
+
+import (
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestUTLSPeetPrint(t *testing.T) {
+	uconn := UClient(&net.TCPConn{}, &Config{ServerName: "example.com"}, HelloChrome_120)
+	if fp := uconn.PeetPrint(); fp != "" {
+		t.Errorf("got peetprint %q before the ClientHello was built, expected none", fp)
+	}
+	if err := uconn.BuildHandshakeState(); err != nil {
+		t.Fatalf("got error: %v; expected to succeed", err)
+	}
+	// Chrome 120 shuffles its extensions, which the sorted extension list of
+	// the peetprint doesn't show, and GREASE values are replaced
+	fp := uconn.PeetPrint()
+	for i := 0; i < 10; i++ {
+		uconn := UClient(&net.TCPConn{}, &Config{ServerName: "example.com"}, HelloChrome_120)
+		if err := uconn.BuildHandshakeState(); err != nil {
+			t.Fatalf("got error: %v; expected to succeed", err)
+		}
+		if again := uconn.PeetPrint(); again != fp {
+			t.Fatalf("got peetprint %s, then %s", fp, again)
+		}
+	}
+	if !strings.HasPrefix(fp, "GREASE-772-771|2-1.1|GREASE-29-23-24|") {
+		t.Errorf("got peetprint %s, expected GREASE in the versions and groups", fp)
+	}
+
+	// The OpenSSL 3 parrot sends the ClientHello captured in openSSL3HelloHex
+	// but for the key share, see TestUTLSOpenSSLParrots. No published
+	// peetprint of that capture was available, so this one was written by
+	// reading its fields from the capture, following the scheme.
+	uconn = UClient(&net.TCPConn{}, &Config{ServerName: "example.com"}, HelloOpenSSL_3)
+	if err := uconn.BuildHandshakeState(); err != nil {
+		t.Fatalf("got error: %v; expected to succeed", err)
+	}
+	expected := "772-771-770-769||29-23-30-25-24-256-257-258-259-260|" +
+		"1027-1283-1539-2055-2056-2057-2058-2059-2052-2053-2054-1025-1281-1537-771-769-770-1026-1282-1538|1||" +
+		"4866-4867-4865-49196-49200-159-52393-52392-52394-49195-49199-158-49188-49192-107-49187-49191-103-" +
+		"49162-49172-57-49161-49171-51-157-156-61-60-53-47-255|" +
+		"0-10-11-13-22-23-35-43-45-51"
+	if fp := uconn.PeetPrint(); fp != expected {
+		t.Errorf("got peetprint %s for %s, expected %s", fp, HelloOpenSSL_3.Str(), expected)
+	}
+
+	uconn = UClient(&net.TCPConn{}, &Config{ServerName: "example.com"}, HelloGolang)
+	if err := uconn.BuildHandshakeState(); err != nil {
+		t.Fatalf("got error: %v; expected to succeed", err)
+	}
+	if fp := uconn.PeetPrint(); fp != "" {
+		t.Errorf("got peetprint %q for HelloGolang, expected none", fp)
+	}
+}
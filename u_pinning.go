@@ -0,0 +1,72 @@
+// Copyright 2024 The uTLS Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tls
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/x509"
+	"fmt"
+)
+
+// SetPinnedCerts pins the public keys the server may authenticate with. Each
+// pin is the SHA-256 hash of a DER-encoded SubjectPublicKeyInfo, as in HPKP,
+// e.g. sha256.Sum256(cert.RawSubjectPublicKeyInfo).
+//
+// The pins are checked after the certificate chain of the server is verified,
+// and the handshake fails with a *PinnedCertificateError unless a pin matches
+// the leaf or one of the other certificates of a verified chain. With
+// Config.InsecureSkipVerify only the leaf is checked, since the other
+// certificates are not verified. Resumed sessions were checked when they were
+// established. A nil pins removes the pins.
+func (uconn *UConn) SetPinnedCerts(pins [][]byte) error {
+	pinned := make([][sha256.Size]byte, len(pins))
+	for i, pin := range pins {
+		if len(pin) != sha256.Size {
+			return fmt.Errorf("tls: pinned certificate hash %d is %d bytes long, expected %d", i, len(pin), sha256.Size)
+		}
+		pinned[i] = [sha256.Size]byte(pin)
+	}
+	if len(pinned) == 0 {
+		pinned = nil
+	}
+	uconn.utls.pinnedCerts = pinned
+	return nil
+}
+
+// PinnedCertificateError is returned by the handshake when none of the
+// certificates of the server matches a pin set with UConn.SetPinnedCerts.
+type PinnedCertificateError struct {
+	// Certificates are the certificates that were checked against the pins.
+	Certificates []*x509.Certificate
+}
+
+func (e *PinnedCertificateError) Error() string {
+	return "tls: no certificate of the server matches a pinned public key"
+}
+
+// checkPinnedCerts checks the pins of c against the verified chains, or the
+// leaf of certs if the chains were not verified.
+func (c *Conn) checkPinnedCerts(certs []*x509.Certificate) error {
+	if len(c.utls.pinnedCerts) == 0 {
+		return nil
+	}
+	candidates := certs[:1]
+	if len(c.verifiedChains) > 0 {
+		candidates = nil
+		for _, chain := range c.verifiedChains {
+			candidates = append(candidates, chain...)
+		}
+	}
+	for _, cert := range candidates {
+		hash := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+		for _, pin := range c.utls.pinnedCerts {
+			if subtle.ConstantTimeCompare(hash[:], pin[:]) == 1 {
+				return nil
+			}
+		}
+	}
+	return &PinnedCertificateError{Certificates: candidates}
+}
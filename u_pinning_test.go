@@ -0,0 +1,85 @@
+// Copyright 2024 The uTLS Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tls
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestUTLSPinnedCerts(t *testing.T) {
+	issuer, err := x509.ParseCertificate(testRSACertificateIssuer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(testRSACertificate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	issuerPin := sha256.Sum256(issuer.RawSubjectPublicKeyInfo)
+	leafPin := sha256.Sum256(leaf.RawSubjectPublicKeyInfo)
+	otherPin := sha256.Sum256([]byte("other"))
+
+	for _, test := range []struct {
+		name     string
+		pins     [][]byte
+		insecure bool
+		ok       bool
+	}{
+		{"leaf", [][]byte{otherPin[:], leafPin[:]}, false, true},
+		{"issuer", [][]byte{issuerPin[:]}, false, true},
+		{"mismatch", [][]byte{otherPin[:]}, false, false},
+		{"insecure leaf", [][]byte{leafPin[:]}, true, true},
+		// the issuer isn't verified without a chain
+		{"insecure issuer", [][]byte{issuerPin[:]}, true, false},
+		{"none", nil, false, true},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			roots := x509.NewCertPool()
+			roots.AddCert(issuer)
+			clientConfig := &Config{
+				ServerName:         "example.golang",
+				RootCAs:            roots,
+				InsecureSkipVerify: test.insecure,
+				Time:               func() time.Time { return time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC) },
+			}
+			serverConfig := testConfig.Clone()
+			cert := serverConfig.Certificates[0]
+			cert.Certificate = [][]byte{testRSACertificate, testRSACertificateIssuer}
+			serverConfig.Certificates = []Certificate{cert}
+
+			c, s := localPipe(t)
+			go func() {
+				server := Server(s, serverConfig)
+				server.Handshake()
+				server.Close()
+			}()
+			uconn := UClient(c, clientConfig, HelloChrome_120)
+			defer uconn.Close()
+			if err := uconn.SetPinnedCerts(test.pins); err != nil {
+				t.Fatalf("got error: %v; expected to succeed", err)
+			}
+
+			err := uconn.Handshake()
+			var pinErr *PinnedCertificateError
+			switch {
+			case test.ok && err != nil:
+				t.Fatalf("got error: %v; expected to succeed", err)
+			case !test.ok && !errors.As(err, &pinErr):
+				t.Fatalf("got error %v, expected a *PinnedCertificateError", err)
+			case !test.ok && len(pinErr.Certificates) == 0:
+				t.Error("got a *PinnedCertificateError without certificates")
+			}
+		})
+	}
+
+	uconn := UClient(nil, nil, HelloChrome_120)
+	if err := uconn.SetPinnedCerts([][]byte{leafPin[:8]}); err == nil {
+		t.Error("got no error setting a truncated pin")
+	}
+}
@@ -1,9 +1,11 @@
 package tls
 
 import (
+	"bytes"
 	"encoding/json"
 	"errors"
 	"io"
+	"slices"
 
 	"golang.org/x/crypto/cryptobyte"
 )
@@ -300,6 +302,10 @@ func (e *UtlsPreSharedKeyExtension) UnmarshalJSON(_ []byte) error {
 	return nil // ignore the data
 }
 
+func (e *UtlsPreSharedKeyExtension) MarshalJSON() ([]byte, error) {
+	return marshalExtensionName(extensionPreSharedKey)
+}
+
 // FakePreSharedKeyExtension is an extension used to set the PSK extension in the
 // ClientHello.
 //
@@ -376,89 +382,91 @@ func (*FakePreSharedKeyExtension) PatchBuiltHello(*PubClientHelloMsg) error {
 func (e *FakePreSharedKeyExtension) Write(b []byte) (n int, err error) {
 	fullLen := len(b)
 	s := cryptobyte.String(b)
-
-	var identitiesLength uint16
-	if !s.ReadUint16(&identitiesLength) {
+	// RFC 8446, Section 4.2.11
+	var identities, binders cryptobyte.String
+	if !s.ReadUint16LengthPrefixed(&identities) || identities.Empty() ||
+		!s.ReadUint16LengthPrefixed(&binders) || binders.Empty() || !s.Empty() {
 		return 0, errors.New("tls: invalid PSK extension")
 	}
 
-	// identities
-	for identitiesLength > 0 {
-		var identityLength uint16
-		if !s.ReadUint16(&identityLength) {
-			return 0, errors.New("tls: invalid PSK extension")
-		}
-		identitiesLength -= 2
-
-		if identityLength > identitiesLength {
-			return 0, errors.New("tls: invalid PSK extension")
-		}
-
-		var identity []byte
-		if !s.ReadBytes(&identity, int(identityLength)) {
-			return 0, errors.New("tls: invalid PSK extension")
-		}
-
-		identitiesLength -= identityLength // identity
-
+	var pskIdentities []PskIdentity
+	for !identities.Empty() {
+		var identity cryptobyte.String
 		var obfuscatedTicketAge uint32
-		if !s.ReadUint32(&obfuscatedTicketAge) {
+		if !identities.ReadUint16LengthPrefixed(&identity) || identity.Empty() ||
+			!identities.ReadUint32(&obfuscatedTicketAge) {
 			return 0, errors.New("tls: invalid PSK extension")
 		}
-
-		e.Identities = append(e.Identities, PskIdentity{
-			Label:               identity,
+		pskIdentities = append(pskIdentities, PskIdentity{
+			Label:               bytes.Clone(identity),
 			ObfuscatedTicketAge: obfuscatedTicketAge,
 		})
-
-		identitiesLength -= 4 // obfuscated ticket age
-	}
-
-	var bindersLength uint16
-	if !s.ReadUint16(&bindersLength) {
-		return 0, errors.New("tls: invalid PSK extension")
 	}
 
-	// binders
-	for bindersLength > 0 {
-		var binderLength uint8
-		if !s.ReadUint8(&binderLength) {
+	var pskBinders [][]byte
+	for !binders.Empty() {
+		var binder cryptobyte.String
+		if !binders.ReadUint8LengthPrefixed(&binder) {
 			return 0, errors.New("tls: invalid PSK extension")
 		}
-		bindersLength -= 1
-
-		if uint16(binderLength) > bindersLength {
-			return 0, errors.New("tls: invalid PSK extension")
-		}
-
-		var binder []byte
-		if !s.ReadBytes(&binder, int(binderLength)) {
-			return 0, errors.New("tls: invalid PSK extension")
+		// Read only accepts binders made with the hash of a TLS 1.3 cipher suite
+		if !slices.Contains(validHashLen, len(binder)) {
+			return 0, errors.New("tls: invalid PSK binder size")
 		}
-
-		e.Binders = append(e.Binders, binder)
-
-		bindersLength -= uint16(binderLength)
+		pskBinders = append(pskBinders, bytes.Clone(binder))
 	}
 
+	if len(pskIdentities) != len(pskBinders) {
+		return 0, errors.New("tls: PSK extension has a different number of identities and binders")
+	}
+	e.Identities = append(e.Identities, pskIdentities...)
+	e.Binders = append(e.Binders, pskBinders...)
 	return fullLen, nil
 }
 
 func (e *FakePreSharedKeyExtension) UnmarshalJSON(data []byte) error {
 	var pskAccepter struct {
-		PskIdentities []PskIdentity `json:"identities"`
-		PskBinders    [][]byte      `json:"binders"`
+		PskIdentities []pskIdentityJSON `json:"identities"`
+		PskBinders    []hexBytes        `json:"binders"`
 	}
 
 	if err := json.Unmarshal(data, &pskAccepter); err != nil {
 		return err
 	}
 
-	e.Identities = pskAccepter.PskIdentities
-	e.Binders = pskAccepter.PskBinders
+	e.Identities = nil
+	for _, identity := range pskAccepter.PskIdentities {
+		e.Identities = append(e.Identities, PskIdentity{Label: identity.Label, ObfuscatedTicketAge: identity.ObfuscatedTicketAge})
+	}
+	e.Binders = nil
+	for _, binder := range pskAccepter.PskBinders {
+		e.Binders = append(e.Binders, binder)
+	}
 	return nil
 }
 
+func (e *FakePreSharedKeyExtension) MarshalJSON() ([]byte, error) {
+	identities := make([]pskIdentityJSON, 0, len(e.Identities))
+	for _, identity := range e.Identities {
+		identities = append(identities, pskIdentityJSON{identity.Label, identity.ObfuscatedTicketAge})
+	}
+	binders := make([]hexBytes, 0, len(e.Binders))
+	for _, binder := range e.Binders {
+		binders = append(binders, binder)
+	}
+	return json.Marshal(struct {
+		Name          string            `json:"name"`
+		PskIdentities []pskIdentityJSON `json:"identities"`
+		PskBinders    []hexBytes        `json:"binders"`
+	}{"pre_shared_key", identities, binders})
+}
+
+// pskIdentityJSON is a PskIdentity in JSON, with the label in hex.
+type pskIdentityJSON struct {
+	Label               hexBytes `json:"identity"`
+	ObfuscatedTicketAge uint32   `json:"obfuscated_ticket_age"`
+}
+
 // type guard
 var (
 	_ PreSharedKeyExtension = (*UtlsPreSharedKeyExtension)(nil)
@@ -568,7 +568,12 @@ func (fh *finishedHash) getPublicObj() FinishedHash {
 // TLS 1.3 Key Share. See RFC 8446, Section 4.2.8.
 type KeyShare struct {
 	Group CurveID `json:"group"`
-	Data  []byte  `json:"key_exchange,omitempty"` // optional
+
+	// Data is optional. If empty, a key is generated for Group when the
+	// ClientHelloSpec is applied. Otherwise it is sent as-is, and since there
+	// is no private key for it, the handshake fails if the server selects
+	// Group.
+	Data []byte `json:"key_exchange,omitempty"`
 }
 
 type KeyShares []KeyShare
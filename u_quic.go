@@ -23,7 +23,11 @@ type UQUICConn struct {
 // QUICClient returns a new TLS client side connection using QUICTransport as the
 // underlying transport. The config cannot be nil.
 //
-// The config's MinVersion must be at least TLS 1.3.
+// The config's MinVersion must be at least TLS 1.3, and the ClientHelloSpec of
+// clientHelloID must only offer TLS 1.3, e.g. HelloChrome_115_QUIC. Specs for
+// TCP need their supported_versions restricted, and a
+// QUICTransportParametersExtension added, before they are applied with
+// ApplyPreset.
 func UQUICClient(config *QUICConfig, clientHelloID ClientHelloID) *UQUICConn {
 	return newUQUICConn(UClient(nil, config.TLSConfig, clientHelloID))
 }
@@ -58,10 +62,28 @@ func (q *UQUICConn) Start(ctx context.Context) error {
 	return nil
 }
 
+// ApplyPreset applies a ClientHelloSpec to the connection, see UConn.ApplyPreset.
+// It should only be used with HelloCustom, and the spec must only offer TLS 1.3.
 func (q *UQUICConn) ApplyPreset(p *ClientHelloSpec) error {
 	return q.conn.ApplyPreset(p)
 }
 
+// BuildHandshakeState builds the ClientHello, see UConn.BuildHandshakeState.
+// It is called by Start, but may be called before to inspect or modify the
+// ClientHello through HandshakeState.
+//
+// It fails if the ClientHelloSpec of the connection also offers TLS versions
+// before 1.3, like the Chrome or Firefox presets for TCP do.
+func (q *UQUICConn) BuildHandshakeState() error {
+	return q.conn.BuildHandshakeState()
+}
+
+// HandshakeState returns the state of the ClientHello that will be sent,
+// once it has been built.
+func (q *UQUICConn) HandshakeState() *PubClientHandshakeState {
+	return &q.conn.HandshakeState
+}
+
 // NextEvent returns the next event occurring on the connection.
 // It returns an event with a Kind of QUICNoEvent when no events are available.
 func (q *UQUICConn) NextEvent() QUICEvent {
@@ -0,0 +1,42 @@
+// Copyright 2024 The uTLS Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tls_test
+
+import (
+	"context"
+	"log"
+
+	tls "github.com/refraction-networking/utls"
+)
+
+func ExampleUQUICClient() {
+	conn := tls.UQUICClient(&tls.QUICConfig{
+		TLSConfig: &tls.Config{ServerName: "example.com", MinVersion: tls.VersionTLS13},
+	}, tls.HelloChrome_115_QUIC)
+	defer conn.Close()
+
+	// The ClientHello is built by Start otherwise. Building it first allows
+	// inspecting it, e.g. to find the transport parameters that are sent.
+	if err := conn.BuildHandshakeState(); err != nil {
+		log.Fatal(err)
+	}
+
+	if err := conn.Start(context.Background()); err != nil {
+		log.Fatal(err)
+	}
+	for {
+		e := conn.NextEvent()
+		switch e.Kind {
+		case tls.QUICNoEvent:
+			// Wait for CRYPTO frames from the server, and pass them to
+			// conn.HandleData.
+			return
+		case tls.QUICWriteData:
+			// Send e.Data in CRYPTO frames at encryption level e.Level.
+		case tls.QUICSetReadSecret, tls.QUICSetWriteSecret:
+			// Install the keys derived from e.Data for e.Level.
+		}
+	}
+}
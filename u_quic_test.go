@@ -0,0 +1,152 @@
+// Copyright 2024 The uTLS Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tls
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// quicSpec returns the ClientHelloSpec of id, restricted to TLS 1.3 and h3,
+// with the quic_transport_parameters extension added.
+func quicSpec(t *testing.T, id ClientHelloID) *ClientHelloSpec {
+	spec := mustSpec(t, id)
+	spec.TLSVersMin, spec.TLSVersMax = VersionTLS13, VersionTLS13
+
+	var suites []uint16
+	for _, suite := range spec.CipherSuites {
+		if isGREASEUint16(suite) || cipherSuiteTLS13ByID(suite) != nil {
+			suites = append(suites, suite)
+		}
+	}
+	spec.CipherSuites = suites
+
+	for _, ext := range spec.Extensions {
+		switch e := ext.(type) {
+		case *SupportedVersionsExtension:
+			var versions []uint16
+			for _, v := range e.Versions {
+				if isGREASEUint16(v) || v == VersionTLS13 {
+					versions = append(versions, v)
+				}
+			}
+			e.Versions = versions
+		case *ALPNExtension:
+			e.AlpnProtocols = []string{"h3"}
+		case *ApplicationSettingsExtension:
+			e.SupportedProtocols = []string{"h3"}
+		}
+	}
+	spec.Extensions = append(spec.Extensions, &QUICTransportParametersExtension{
+		TransportParameters: TransportParameters{InitialMaxData(0x100000), MaxIdleTimeout(30000)},
+	})
+	return spec
+}
+
+// runTestUQUICConnection runs the handshake of cli with a QUIC server using
+// serverConfig.
+func runTestUQUICConnection(t *testing.T, cli *UQUICConn, serverConfig *Config) error {
+	srv := QUICServer(&QUICConfig{TLSConfig: serverConfig})
+	defer srv.Close()
+	srv.SetTransportParameters(nil)
+	defer cli.Close()
+
+	ctx := context.Background()
+	if err := cli.Start(ctx); err != nil {
+		return err
+	}
+	if err := srv.Start(ctx); err != nil {
+		return err
+	}
+
+	cliDone, srvDone := false, false
+	for idle := 0; idle < 2; {
+		idle++
+		for e := cli.NextEvent(); e.Kind != QUICNoEvent; e = cli.NextEvent() {
+			idle = 0
+			switch e.Kind {
+			case QUICWriteData:
+				if err := srv.HandleData(e.Level, e.Data); err != nil {
+					return err
+				}
+			case QUICHandshakeDone:
+				cliDone = true
+			}
+		}
+		for e := srv.NextEvent(); e.Kind != QUICNoEvent; e = srv.NextEvent() {
+			idle = 0
+			switch e.Kind {
+			case QUICWriteData:
+				if err := cli.HandleData(e.Level, e.Data); err != nil {
+					return err
+				}
+			case QUICTransportParameters:
+				if len(e.Data) == 0 {
+					t.Error("server got no transport parameters from the client")
+				}
+			case QUICHandshakeDone:
+				srvDone = true
+			}
+		}
+	}
+	if !cliDone || !srvDone {
+		return errors.New("handshake incomplete")
+	}
+	return nil
+}
+
+func TestUTLSQUICConnection(t *testing.T) {
+	serverConfig := testConfig.Clone()
+	serverConfig.MinVersion = VersionTLS13
+	serverConfig.NextProtos = []string{"h3"}
+
+	for _, id := range []ClientHelloID{HelloChrome_120, HelloChrome_131, HelloFirefox_120, HelloSafari_18} {
+		t.Run(id.Str(), func(t *testing.T) {
+			cli := UQUICClient(&QUICConfig{TLSConfig: &Config{ServerName: "example.golang", InsecureSkipVerify: true, MinVersion: VersionTLS13}}, HelloCustom)
+			if err := cli.ApplyPreset(quicSpec(t, id)); err != nil {
+				t.Fatalf("got error: %v; expected to succeed", err)
+			}
+			if err := cli.BuildHandshakeState(); err != nil {
+				t.Fatalf("got error: %v; expected to succeed", err)
+			}
+			hello := cli.HandshakeState().Hello
+			var versions []uint16
+			for _, v := range hello.SupportedVersions {
+				if !isGREASEUint16(v) {
+					versions = append(versions, v)
+				}
+			}
+			if len(hello.SessionId) != 0 || !sliceEq(versions, []uint16{VersionTLS13}) {
+				t.Errorf("got session ID %x and versions %x, expected none and TLS 1.3", hello.SessionId, versions)
+			}
+
+			if err := runTestUQUICConnection(t, cli, serverConfig); err != nil {
+				t.Fatalf("error during connection handshake: %v", err)
+			}
+			if state := cli.ConnectionState(); state.Version != VersionTLS13 || state.NegotiatedProtocol != "h3" {
+				t.Errorf("got version %x and protocol %q, expected TLS 1.3 and h3", state.Version, state.NegotiatedProtocol)
+			}
+		})
+	}
+}
+
+func TestUTLSQUICRejectsTLS12(t *testing.T) {
+	config := &Config{ServerName: "example.golang", MinVersion: VersionTLS13}
+
+	cli := UQUICClient(&QUICConfig{TLSConfig: config.Clone()}, HelloCustom)
+	if err := cli.ApplyPreset(mustSpec(t, HelloChrome_120)); err == nil || !strings.Contains(err.Error(), "TLS 1.3") {
+		t.Errorf("got error %v applying a TLS 1.2 spec, expected it to mention TLS 1.3", err)
+	}
+
+	cli = UQUICClient(&QUICConfig{TLSConfig: config.Clone()}, HelloFirefox_120)
+	if err := cli.BuildHandshakeState(); err == nil {
+		t.Error("building a TLS 1.2 ClientHello for QUIC succeeded, expected an error")
+	}
+	if err := cli.Start(context.Background()); err == nil {
+		t.Error("starting with a TLS 1.2 ClientHello for QUIC succeeded, expected an error")
+	}
+}
@@ -1,10 +1,13 @@
 package tls
 
 import (
+	"bytes"
 	"crypto/rand"
 	"encoding/binary"
+	"errors"
 	"math"
 	"math/big"
+	"slices"
 
 	"github.com/refraction-networking/utls/internal/quicvarint"
 )
@@ -44,6 +47,127 @@ func (tps TransportParameters) Marshal() []byte {
 	return b
 }
 
+// ParseTransportParameters parses the extension_data of a
+// quic_transport_parameters extension, keeping the order of parameters.
+//
+// Known parameters are returned as their own type, as long as marshaling it
+// gives back the same bytes. Others, including those with an unusual encoding
+// of their value, are returned as a FakeQUICTransportParameter, or as a
+// GREASETransportParameter if the ID is reserved for GREASE.
+func ParseTransportParameters(b []byte) (TransportParameters, error) {
+	var tps TransportParameters
+	r := bytes.NewReader(b)
+	for r.Len() > 0 {
+		id, err := quicvarint.Read(r)
+		if err != nil {
+			return nil, errors.New("tls: malformed transport parameter ID")
+		}
+		length, err := quicvarint.Read(r)
+		if err != nil || length > uint64(r.Len()) {
+			return nil, errors.New("tls: malformed transport parameter length")
+		}
+		val := make([]byte, length)
+		r.Read(val)
+		tps = append(tps, parseTransportParameter(id, val))
+	}
+	return tps, nil
+}
+
+func parseTransportParameter(id uint64, val []byte) TransportParameter {
+	var tp TransportParameter
+	switch id {
+	case max_idle_timeout, max_udp_payload_size, initial_max_data,
+		initial_max_stream_data_bidi_local, initial_max_stream_data_bidi_remote,
+		initial_max_stream_data_uni, initial_max_streams_bidi, initial_max_streams_uni,
+		max_ack_delay, active_connection_id_limit, max_datagram_frame_size:
+		v, err := quicvarint.Read(bytes.NewReader(val))
+		if err != nil {
+			break
+		}
+		switch id {
+		case max_idle_timeout:
+			tp = MaxIdleTimeout(v)
+		case max_udp_payload_size:
+			tp = MaxUDPPayloadSize(v)
+		case initial_max_data:
+			tp = InitialMaxData(v)
+		case initial_max_stream_data_bidi_local:
+			tp = InitialMaxStreamDataBidiLocal(v)
+		case initial_max_stream_data_bidi_remote:
+			tp = InitialMaxStreamDataBidiRemote(v)
+		case initial_max_stream_data_uni:
+			tp = InitialMaxStreamDataUni(v)
+		case initial_max_streams_bidi:
+			tp = InitialMaxStreamsBidi(v)
+		case initial_max_streams_uni:
+			tp = InitialMaxStreamsUni(v)
+		case max_ack_delay:
+			tp = MaxAckDelay(v)
+		case active_connection_id_limit:
+			tp = ActiveConnectionIDLimit(v)
+		case max_datagram_frame_size:
+			tp = MaxDatagramFrameSize(v)
+		}
+	case disable_active_migration:
+		tp = &DisableActiveMigration{}
+	case grease_quic_bit:
+		tp = &GREASEQUICBit{}
+	case initial_source_connection_id:
+		tp = InitialSourceConnectionID(val)
+	case padding:
+		tp = PaddingTransportParameter(val)
+	case version_information, version_information_legacy:
+		if len(val) == 0 || len(val)%4 != 0 {
+			break
+		}
+		v := &VersionInformation{
+			ChoosenVersion: binary.BigEndian.Uint32(val),
+			LegacyID:       id == version_information_legacy,
+		}
+		for i := 4; i < len(val); i += 4 {
+			v.AvailableVersions = append(v.AvailableVersions, binary.BigEndian.Uint32(val[i:]))
+		}
+		tp = v
+	default:
+		if (GREASETransportParameter{}).IsGREASEID(id) && len(val) <= math.MaxUint16 {
+			return &GREASETransportParameter{IdOverride: id, Length: uint16(len(val)), ValueOverride: val}
+		}
+	}
+	if tp == nil || !bytes.Equal(tp.Value(), val) {
+		return &FakeQUICTransportParameter{Id: id, Val: val}
+	}
+	return tp
+}
+
+// clone returns a deep copy of tps.
+func (tps TransportParameters) clone() TransportParameters {
+	if tps == nil {
+		return nil
+	}
+	res := make(TransportParameters, len(tps))
+	for i, tp := range tps {
+		switch p := tp.(type) {
+		case *GREASETransportParameter:
+			res[i] = &GREASETransportParameter{IdOverride: p.IdOverride, Length: p.Length, ValueOverride: bytes.Clone(p.ValueOverride)}
+		case InitialSourceConnectionID:
+			res[i] = InitialSourceConnectionID(bytes.Clone(p))
+		case *VersionInformation:
+			res[i] = &VersionInformation{ChoosenVersion: p.ChoosenVersion, AvailableVersions: slices.Clone(p.AvailableVersions), LegacyID: p.LegacyID}
+		case PaddingTransportParameter:
+			res[i] = PaddingTransportParameter(bytes.Clone(p))
+		case *DisableActiveMigration:
+			res[i] = &DisableActiveMigration{}
+		case *GREASEQUICBit:
+			res[i] = &GREASEQUICBit{}
+		case *FakeQUICTransportParameter:
+			res[i] = &FakeQUICTransportParameter{Id: p.Id, Val: bytes.Clone(p.Val)}
+		default:
+			res[i] = tp // integer values
+		}
+	}
+	return res
+}
+
 // TransportParameter represents a QUIC transport parameter.
 //
 // Caller will write the following to the wire:
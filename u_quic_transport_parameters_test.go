@@ -2,6 +2,8 @@ package tls
 
 import (
 	"bytes"
+	"encoding/hex"
+	"strings"
 	"testing"
 )
 
@@ -15,6 +17,165 @@ func testTransportParametersFirefox(t *testing.T) {
 	}
 }
 
+func TestParseTransportParameters(t *testing.T) {
+	tps, err := ParseTransportParameters(_truthTransportParametersFirefox)
+	if err != nil {
+		t.Fatalf("got error: %v; expected to succeed", err)
+	}
+	if !bytes.Equal(tps.Marshal(), _truthTransportParametersFirefox) {
+		t.Errorf("TransportParameters.Marshal() = %x, want %x", tps.Marshal(), _truthTransportParametersFirefox)
+	}
+	if len(tps) != len(_inputTransportParametersFirefox) {
+		t.Fatalf("got %d transport parameters, want %d", len(tps), len(_inputTransportParametersFirefox))
+	}
+	for i, tp := range tps {
+		if _, ok := tp.(*FakeQUICTransportParameter); ok {
+			t.Errorf("transport parameter %d with ID %x was not recognized", i, tp.ID())
+		}
+	}
+	if g, ok := tps[8].(*GREASETransportParameter); !ok || g.IdOverride != 0xff02de1a {
+		t.Errorf("got %T for GREASE transport parameter", tps[8])
+	}
+
+	// max_idle_timeout of 30 encoded in 8 bytes instead of 1
+	unusual := []byte{0x01, 0x08, 0xc0, 0, 0, 0, 0, 0, 0, 0x1e}
+	tps, err = ParseTransportParameters(unusual)
+	if err != nil {
+		t.Fatalf("got error: %v; expected to succeed", err)
+	}
+	if _, ok := tps[0].(*FakeQUICTransportParameter); !ok || !bytes.Equal(tps.Marshal(), unusual) {
+		t.Errorf("got %T marshaled to %x, want a FakeQUICTransportParameter marshaled to %x", tps[0], tps.Marshal(), unusual)
+	}
+
+	for _, malformed := range [][]byte{{0x40}, {0x01}, {0x01, 0x02, 0x00}} {
+		if _, err := ParseTransportParameters(malformed); err == nil {
+			t.Errorf("parsing %x succeeded, want an error", malformed)
+		}
+	}
+}
+
+// _quicInitialCryptoFrame is the CRYPTO frame of the client Initial packet
+// of RFC 9001, Appendix A.2, which carries the ClientHello.
+const _quicInitialCryptoFrame = `
+060040f1010000ed0303ebf8fa56f12939b9584a3896472ec40bb863cfd3e86804fe3a47f06a2b6948
+4c000004130113020100 00c000000010000e00000b6578616d706c652e636f6dff01000100000a0008
+0006001d00170018001000070005 04616c706e0005000501000000000033 00260024001d00209370
+b2c9caa47fbabaf4559fedba753de171fa71f50f1ce15d43e994ec74d748002b0003020304000d0010
+000e0403050306030203080408050806002d00020101001c00024001003900320408ffffffffffffff
+ff05048000ffff07048000ffff0801100104800075300901100f088394c8f03e51570806048000ffff`
+
+func TestUTLSQUICInitialClientHello(t *testing.T) {
+	frame, err := hex.DecodeString(strings.Join(strings.Fields(_quicInitialCryptoFrame), ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientHello := frame[4:] // frame type, offset and length
+	record := append([]byte{byte(recordTypeHandshake), 0x03, 0x01, byte(len(clientHello) >> 8), byte(len(clientHello))}, clientHello...)
+
+	spec, err := (&Fingerprinter{}).FingerprintClientHello(record)
+	if err != nil {
+		t.Fatalf("got error: %v; expected to succeed", err)
+	}
+	var qtp *QUICTransportParametersExtension
+	for _, ext := range spec.Extensions {
+		if e, ok := ext.(*QUICTransportParametersExtension); ok {
+			qtp = e
+		}
+	}
+	if qtp == nil {
+		t.Fatal("quic_transport_parameters extension not found")
+	}
+	expected := TransportParameters{
+		InitialMaxData(0x3fffffffffffffff),
+		InitialMaxStreamDataBidiLocal(0xffff),
+		InitialMaxStreamDataUni(0xffff),
+		InitialMaxStreamsBidi(16),
+		MaxIdleTimeout(30000),
+		InitialMaxStreamsUni(16),
+		InitialSourceConnectionID{0x83, 0x94, 0xc8, 0xf0, 0x3e, 0x51, 0x57, 0x08},
+		InitialMaxStreamDataBidiRemote(0xffff),
+	}
+	if len(qtp.TransportParameters) != len(expected) {
+		t.Fatalf("got %d transport parameters, want %d", len(qtp.TransportParameters), len(expected))
+	}
+	for i, tp := range qtp.TransportParameters {
+		if tp.ID() != expected[i].ID() || !bytes.Equal(tp.Value(), expected[i].Value()) {
+			t.Errorf("got transport parameter %x = %x, want %x = %x", tp.ID(), tp.Value(), expected[i].ID(), expected[i].Value())
+		}
+	}
+
+	// the ClientHello built from the spec carries the exact same parameters
+	q := UQUICClient(&QUICConfig{TLSConfig: &Config{ServerName: "example.com", MinVersion: VersionTLS13}}, HelloCustom)
+	if err := q.ApplyPreset(spec); err != nil {
+		t.Fatalf("got error: %v; expected to succeed", err)
+	}
+	if err := q.conn.BuildHandshakeState(); err != nil {
+		t.Fatalf("got error: %v; expected to succeed", err)
+	}
+	if raw := frame[len(frame)-54:]; !bytes.Contains(q.conn.HandshakeState.Hello.Raw, raw) {
+		t.Errorf("ClientHello does not contain the quic_transport_parameters extension %x", raw)
+	}
+	if len(q.conn.HandshakeState.Hello.SessionId) != 0 {
+		t.Error("got a session ID in a QUIC ClientHello")
+	}
+}
+
+func TestUTLSQUICParrots(t *testing.T) {
+	for _, id := range []ClientHelloID{HelloChrome_115_QUIC, HelloFirefox_116_QUIC} {
+		q := UQUICClient(&QUICConfig{TLSConfig: &Config{ServerName: "example.com", MinVersion: VersionTLS13}}, id)
+		if err := q.conn.BuildHandshakeState(); err != nil {
+			t.Fatalf("%s: got error: %v; expected to succeed", id.Str(), err)
+		}
+		spec, err := (&Fingerprinter{}).RawClientHello(append([]byte{byte(recordTypeHandshake), 0x03, 0x01, 0, 0}, q.conn.HandshakeState.Hello.Raw...))
+		if err != nil {
+			t.Fatalf("%s: got error: %v; expected to succeed", id.Str(), err)
+		}
+		found := false
+		for _, ext := range spec.Extensions {
+			switch e := ext.(type) {
+			case *QUICTransportParametersExtension:
+				found = true
+				for _, tp := range e.TransportParameters {
+					if _, ok := tp.(*FakeQUICTransportParameter); ok {
+						t.Errorf("%s: transport parameter with ID %x was not recognized", id.Str(), tp.ID())
+					}
+				}
+			case *ALPNExtension:
+				if !sliceEq(e.AlpnProtocols, []string{"h3"}) {
+					t.Errorf("%s: got ALPN %v, want h3", id.Str(), e.AlpnProtocols)
+				}
+			}
+		}
+		if !found {
+			t.Errorf("%s: quic_transport_parameters extension not found", id.Str())
+		}
+	}
+}
+
+func TestUTLSQUICSetTransportParameters(t *testing.T) {
+	q := UQUICClient(&QUICConfig{TLSConfig: &Config{ServerName: "example.com", MinVersion: VersionTLS13}}, HelloCustom)
+	if err := q.ApplyPreset(&ClientHelloSpec{
+		TLSVersMin:   VersionTLS13,
+		TLSVersMax:   VersionTLS13,
+		CipherSuites: []uint16{TLS_AES_128_GCM_SHA256},
+		Extensions: []TLSExtension{
+			&SNIExtension{},
+			&SupportedVersionsExtension{Versions: []uint16{VersionTLS13}},
+			&KeyShareExtension{KeyShares: []KeyShare{{Group: X25519}}},
+			&QUICTransportParametersExtension{},
+		},
+	}); err != nil {
+		t.Fatalf("got error: %v; expected to succeed", err)
+	}
+	q.SetTransportParameters(_truthTransportParametersFirefox)
+	if err := q.conn.BuildHandshakeState(); err != nil {
+		t.Fatalf("got error: %v; expected to succeed", err)
+	}
+	if !bytes.HasSuffix(q.conn.HandshakeState.Hello.Raw, _truthTransportParametersFirefox) {
+		t.Error("ClientHello does not end with the transport parameters set with SetTransportParameters")
+	}
+}
+
 var (
 	_inputTransportParametersFirefox = TransportParameters{
 		InitialMaxStreamDataBidiRemote(0x100000),
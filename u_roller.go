@@ -12,7 +12,17 @@ type Roller struct {
 	WorkingHelloID      *ClientHelloID
 	TcpDialTimeout      time.Duration
 	TlsHandshakeTimeout time.Duration
-	r                   *prng
+
+	// DisableShuffle makes Dial try HelloIDs in the given order, instead of
+	// shuffling them on every Dial. A working HelloID is still tried first.
+	DisableShuffle bool
+
+	// Config, if not nil, is cloned for every attempt. ServerName is always
+	// overridden with the serverName passed to Dial.
+	Config *Config
+
+	lastErrors map[ClientHelloID]error // protected by HelloIDMu
+	r          *prng
 }
 
 // NewRoller creates Roller object with default range of HelloIDs to cycle through until a
@@ -47,14 +57,17 @@ func NewRoller() (*Roller, error) {
 // If tcp connection fails or all HelloIDs are tried, returns with last error.
 //
 // Usage examples:
-//    Dial("tcp4", "google.com:443", "google.com")
-//    Dial("tcp", "10.23.144.22:443", "mywebserver.org")
+//
+//	Dial("tcp4", "google.com:443", "google.com")
+//	Dial("tcp", "10.23.144.22:443", "mywebserver.org")
 func (c *Roller) Dial(network, addr, serverName string) (*UConn, error) {
 	helloIDs := make([]ClientHelloID, len(c.HelloIDs))
 	copy(helloIDs, c.HelloIDs)
-	c.r.rand.Shuffle(len(c.HelloIDs), func(i, j int) {
-		helloIDs[i], helloIDs[j] = helloIDs[j], helloIDs[i]
-	})
+	if !c.DisableShuffle {
+		c.r.rand.Shuffle(len(c.HelloIDs), func(i, j int) {
+			helloIDs[i], helloIDs[j] = helloIDs[j], helloIDs[i]
+		})
+	}
 
 	c.HelloIDMu.Lock()
 	workingHelloId := c.WorkingHelloID // keep using same helloID, if it works
@@ -63,7 +76,7 @@ func (c *Roller) Dial(network, addr, serverName string) (*UConn, error) {
 		helloIDFound := false
 		for i, ID := range helloIDs {
 			if ID == *workingHelloId {
-				helloIDs[i] = helloIDs[0]
+				copy(helloIDs[1:i+1], helloIDs[:i])
 				helloIDs[0] = *workingHelloId // push working hello ID first
 				helloIDFound = true
 				break
@@ -79,15 +92,22 @@ func (c *Roller) Dial(network, addr, serverName string) (*UConn, error) {
 	for _, helloID := range helloIDs {
 		tcpConn, err = net.DialTimeout(network, addr, c.TcpDialTimeout)
 		if err != nil {
+			c.setLastError(helloID, err)
 			return nil, err // on tcp Dial failure return with error right away
 		}
 
-		client := UClient(tcpConn, nil, helloID)
+		var config *Config
+		if c.Config != nil {
+			config = c.Config.Clone()
+		}
+		client := UClient(tcpConn, config, helloID)
 		client.SetSNI(serverName)
 		client.SetDeadline(time.Now().Add(c.TlsHandshakeTimeout))
 		err = client.Handshake()
 		client.SetDeadline(time.Time{}) // unset timeout
+		c.setLastError(helloID, err)
 		if err != nil {
+			tcpConn.Close()
 			continue // on tls Dial error keep trying HelloIDs
 		}
 
@@ -98,3 +118,29 @@ func (c *Roller) Dial(network, addr, serverName string) (*UConn, error) {
 	}
 	return nil, err
 }
+
+// LastErrors returns the error of the most recent attempt with each HelloID
+// that Dial has tried so far. HelloIDs whose last attempt succeeded are not
+// included.
+func (c *Roller) LastErrors() map[ClientHelloID]error {
+	c.HelloIDMu.Lock()
+	defer c.HelloIDMu.Unlock()
+	errs := make(map[ClientHelloID]error, len(c.lastErrors))
+	for id, err := range c.lastErrors {
+		errs[id] = err
+	}
+	return errs
+}
+
+func (c *Roller) setLastError(helloID ClientHelloID, err error) {
+	c.HelloIDMu.Lock()
+	defer c.HelloIDMu.Unlock()
+	if err == nil {
+		delete(c.lastErrors, helloID)
+		return
+	}
+	if c.lastErrors == nil {
+		c.lastErrors = make(map[ClientHelloID]error)
+	}
+	c.lastErrors[helloID] = err
+}
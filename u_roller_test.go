@@ -0,0 +1,90 @@
+// Copyright 2024 The uTLS Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tls
+
+import (
+	"crypto/x509"
+	"errors"
+	"net"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestUTLSRoller(t *testing.T) {
+	// the server rejects any client that offers SHA-1 signatures, like Firefox
+	serverConfig := testConfig.Clone()
+	serverConfig.GetConfigForClient = func(chi *ClientHelloInfo) (*Config, error) {
+		if slices.Contains(chi.SignatureSchemes, PKCS1WithSHA1) {
+			return nil, errors.New("rejected")
+		}
+		return nil, nil
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				server := Server(conn, serverConfig)
+				server.Handshake()
+				server.Close()
+			}()
+		}
+	}()
+
+	issuer, err := x509.ParseCertificate(testRSACertificateIssuer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(issuer)
+
+	roller, err := NewRoller()
+	if err != nil {
+		t.Fatal(err)
+	}
+	roller.HelloIDs = []ClientHelloID{HelloFirefox_120, HelloChrome_120}
+	roller.DisableShuffle = true
+	roller.Config = &Config{
+		RootCAs: roots,
+		Time:    func() time.Time { return time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC) },
+	}
+
+	uconn, err := roller.Dial("tcp", ln.Addr().String(), "example.golang")
+	if err != nil {
+		t.Fatalf("got error: %v; expected to succeed", err)
+	}
+	uconn.Close()
+	if uconn.ClientHelloID != HelloChrome_120 {
+		t.Errorf("got %s, expected %s", uconn.ClientHelloID.Str(), HelloChrome_120.Str())
+	}
+	errs := roller.LastErrors()
+	if len(errs) != 1 || errs[HelloFirefox_120] == nil {
+		t.Errorf("got last errors %v, expected an error for %s only", errs, HelloFirefox_120.Str())
+	}
+
+	// the working HelloID is tried first from now on
+	uconn, err = roller.Dial("tcp", ln.Addr().String(), "example.golang")
+	if err != nil {
+		t.Fatalf("got error: %v; expected to succeed", err)
+	}
+	uconn.Close()
+	if *roller.WorkingHelloID != HelloChrome_120 {
+		t.Errorf("got working %s, expected %s", roller.WorkingHelloID.Str(), HelloChrome_120.Str())
+	}
+
+	roller.HelloIDs = []ClientHelloID{HelloFirefox_120}
+	roller.WorkingHelloID = nil
+	if _, err := roller.Dial("tcp", ln.Addr().String(), "example.golang"); err == nil {
+		t.Error("got no error, expected all HelloIDs to fail")
+	}
+}
@@ -0,0 +1,117 @@
+// Copyright 2024 The uTLS Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tls
+
+import (
+	"fmt"
+	"net"
+	"slices"
+
+	"golang.org/x/crypto/cryptobyte"
+)
+
+// ServerHelloSpec controls the ServerHello sent by a UServerConn, to mimic
+// the ServerHello of another server implementation. The extensions are the
+// ones the server would send anyway; only their order changes.
+type ServerHelloSpec struct {
+	// SelectCipherSuite, if not nil, returns the cipher suite to select for a
+	// ClientHello, or 0 to use the default selection. The handshake fails if
+	// the client didn't offer it, or if it can't be used with the negotiated
+	// version or the server certificate.
+	SelectCipherSuite func(*ClientHelloInfo) uint16
+
+	// ExtensionOrder is the order of the ServerHello extensions, by
+	// codepoint. Extensions that are not in the list are sent after the
+	// others, in their default order. It also applies to HelloRetryRequests.
+	ExtensionOrder []uint16
+}
+
+// UServerConn is a server-side connection whose ServerHello follows a
+// ServerHelloSpec.
+type UServerConn struct {
+	*Conn
+}
+
+// UServer returns a new TLS server side connection like Server, that sends a
+// ServerHello following spec. A nil spec is the same as an empty one.
+func UServer(conn net.Conn, config *Config, spec *ServerHelloSpec) *UServerConn {
+	c := Server(conn, config)
+	c.utls.serverHelloSpec = spec
+	return &UServerConn{Conn: c}
+}
+
+// utlsSelectCipherSuite returns the cipher suite selected by the
+// ServerHelloSpec, or 0 to use the default selection. clientHelloInfo is only
+// called if there is a SelectCipherSuite function.
+func (c *Conn) utlsSelectCipherSuite(clientHelloInfo func() *ClientHelloInfo) uint16 {
+	spec := c.utls.serverHelloSpec
+	if spec == nil || spec.SelectCipherSuite == nil {
+		return 0
+	}
+	return spec.SelectCipherSuite(clientHelloInfo())
+}
+
+// utlsMarshalServerHello sets m.raw to the ServerHello with its extensions in
+// the order of the ServerHelloSpec, if any.
+func (c *Conn) utlsMarshalServerHello(m *serverHelloMsg) error {
+	spec := c.utls.serverHelloSpec
+	if spec == nil || len(spec.ExtensionOrder) == 0 {
+		return nil
+	}
+	raw, err := m.marshal()
+	if err != nil {
+		return err
+	}
+	m.raw, err = reorderServerHelloExtensions(raw, spec.ExtensionOrder)
+	return err
+}
+
+// reorderServerHelloExtensions returns a copy of the ServerHello message raw
+// with the extensions in order first, followed by the others.
+func reorderServerHelloExtensions(raw []byte, order []uint16) ([]byte, error) {
+	s := cryptobyte.String(raw)
+	var sessionID, extensions cryptobyte.String
+	if !s.Skip(4+2+32) || // message type, uint24 length, version and random
+		!s.ReadUint8LengthPrefixed(&sessionID) ||
+		!s.Skip(2+1) || // cipher_suite and compression_method
+		!s.ReadUint16LengthPrefixed(&extensions) {
+		return nil, fmt.Errorf("tls: invalid ServerHello")
+	}
+	if extensions.Empty() {
+		return raw, nil
+	}
+	start := len(raw) - len(extensions)
+
+	type extension struct {
+		id   uint16
+		data []byte // with its type and length
+	}
+	var exts []extension
+	for !extensions.Empty() {
+		var id uint16
+		var data cryptobyte.String
+		before := extensions
+		if !extensions.ReadUint16(&id) || !extensions.ReadUint16LengthPrefixed(&data) {
+			return nil, fmt.Errorf("tls: invalid ServerHello extensions")
+		}
+		exts = append(exts, extension{id, before[:len(before)-len(extensions)]})
+	}
+	slices.SortStableFunc(exts, func(a, b extension) int {
+		i, j := slices.Index(order, a.id), slices.Index(order, b.id)
+		if i == -1 {
+			i = len(order)
+		}
+		if j == -1 {
+			j = len(order)
+		}
+		return i - j
+	})
+
+	reordered := slices.Clone(raw[:start])
+	for _, ext := range exts {
+		reordered = append(reordered, ext.data...)
+	}
+	return reordered, nil
+}
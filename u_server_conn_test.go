@@ -0,0 +1,120 @@
+// Copyright 2024 The uTLS Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tls
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestUTLSServerHelloSpec(t *testing.T) {
+	handshake := func(t *testing.T, spec *ServerHelloSpec, serverConfig *Config) (*UConn, error, error) {
+		t.Helper()
+		c, s := localPipe(t)
+		done := make(chan error, 1)
+		go func() {
+			server := UServer(s, serverConfig, spec)
+			defer server.Close()
+			done <- server.Handshake()
+		}()
+		uconn := UClient(c, &Config{ServerName: "example.golang", InsecureSkipVerify: true}, HelloChrome_120)
+		t.Cleanup(func() { uconn.Close() })
+		err := uconn.Handshake()
+		if err != nil {
+			uconn.Close()
+		}
+		return uconn, err, <-done
+	}
+
+	for _, test := range []struct {
+		name   string
+		server func(*Config)
+		order  []uint16
+		suite  uint16
+		hrr    []uint16
+	}{
+		{
+			name:  "TLS 1.3",
+			order: []uint16{extensionKeyShare, extensionSupportedVersions},
+			suite: TLS_CHACHA20_POLY1305_SHA256,
+		},
+		{
+			name:   "HelloRetryRequest",
+			server: func(c *Config) { c.CurvePreferences = []CurveID{CurveP384} },
+			order:  []uint16{extensionKeyShare, extensionSupportedVersions},
+			suite:  TLS_AES_256_GCM_SHA384,
+			hrr:    []uint16{extensionKeyShare, extensionSupportedVersions},
+		},
+		{
+			name:   "TLS 1.2",
+			server: func(c *Config) { c.MaxVersion = VersionTLS12 },
+			order:  []uint16{extensionSupportedPoints, extensionALPN, extensionExtendedMasterSecret, extensionRenegotiationInfo},
+			suite:  TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			serverConfig := testConfig.Clone()
+			serverConfig.NextProtos = []string{"h2"}
+			if test.server != nil {
+				test.server(serverConfig)
+			}
+			var info *ClientHelloInfo
+			spec := &ServerHelloSpec{
+				SelectCipherSuite: func(chi *ClientHelloInfo) uint16 {
+					info = chi
+					return test.suite
+				},
+				ExtensionOrder: slices.Clone(test.order),
+			}
+			uconn, err, serverErr := handshake(t, spec, serverConfig)
+			if err != nil || serverErr != nil {
+				t.Fatalf("got errors %v on the client and %v on the server", err, serverErr)
+			}
+			if info == nil || info.ServerName != "example.golang" {
+				t.Errorf("got ClientHelloInfo %+v, expected the client's", info)
+			}
+			if suite := uconn.ConnectionState().CipherSuite; suite != test.suite {
+				t.Errorf("got cipher suite %s, expected %s", CipherSuiteName(suite), CipherSuiteName(test.suite))
+			}
+
+			serverHello := uconn.ServerHelloInfo()
+			var sent []uint16
+			for _, ext := range serverHello.Extensions {
+				if slices.Contains(test.order, ext) {
+					sent = append(sent, ext)
+				}
+			}
+			if !slices.Equal(sent, test.order) || !slices.Equal(serverHello.Extensions[:len(sent)], test.order) {
+				t.Errorf("got ServerHello extensions %v, expected to start with %v", serverHello.Extensions, test.order)
+			}
+			if test.hrr != nil {
+				if hrr := serverHello.HelloRetryRequest; hrr == nil || !slices.Equal(hrr.Extensions, test.hrr) {
+					t.Errorf("got HelloRetryRequest %+v, expected extensions %v", hrr, test.hrr)
+				}
+			}
+		})
+	}
+
+	// without a spec, the ServerHello is the default one
+	uconn, err, serverErr := handshake(t, nil, testConfig.Clone())
+	if err != nil || serverErr != nil {
+		t.Fatalf("got errors %v on the client and %v on the server", err, serverErr)
+	}
+	if exts := uconn.ServerHelloInfo().Extensions; !slices.Equal(exts, []uint16{extensionSupportedVersions, extensionKeyShare}) {
+		t.Errorf("got ServerHello extensions %v, expected the default order", exts)
+	}
+
+	// the selected cipher suite must be usable with TLS 1.3
+	spec := &ServerHelloSpec{SelectCipherSuite: func(*ClientHelloInfo) uint16 { return TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256 }}
+	if _, _, serverErr := handshake(t, spec, testConfig.Clone()); serverErr == nil || !strings.Contains(serverErr.Error(), "can't be negotiated") {
+		t.Errorf("got error %v, expected the cipher suite to be rejected", serverErr)
+	}
+	// a zero cipher suite keeps the default selection
+	spec = &ServerHelloSpec{SelectCipherSuite: func(*ClientHelloInfo) uint16 { return 0 }}
+	if _, err, serverErr := handshake(t, spec, testConfig.Clone()); err != nil || serverErr != nil {
+		t.Errorf("got errors %v on the client and %v on the server", err, serverErr)
+	}
+}
@@ -0,0 +1,141 @@
+// Copyright 2024 The uTLS Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tls
+
+import "golang.org/x/crypto/cryptobyte"
+
+// ServerHelloInfo describes the ServerHello received by a UConn, for
+// fingerprinting servers. See (*UConn).ServerHelloInfo.
+type ServerHelloInfo struct {
+	// Version is the negotiated TLS version.
+	Version     uint16
+	CipherSuite uint16
+	// KeyShareGroup is the group of the server's key_share, or 0 before TLS
+	// 1.3.
+	KeyShareGroup CurveID
+	// ALPN is the negotiated application protocol, which is sent in the
+	// EncryptedExtensions in TLS 1.3. It is only set once the handshake is
+	// complete.
+	ALPN string
+	// CertCompression is the algorithm the server compressed its Certificate
+	// message with, or 0 if it didn't. It is only set once the handshake is
+	// complete.
+	CertCompression CertCompressionAlgo
+	// Extensions are the codepoints of the ServerHello extensions, in the
+	// order they were sent.
+	Extensions []uint16
+
+	// HelloRetryRequest is set if the server sent one.
+	HelloRetryRequest *HelloRetryRequestInfo
+}
+
+// HelloRetryRequestInfo describes a HelloRetryRequest received by a UConn.
+type HelloRetryRequestInfo struct {
+	CipherSuite uint16
+	// SelectedGroup is the group the server asked a key share for, or 0.
+	SelectedGroup CurveID
+	// Cookie is the cookie the client had to send back, if any.
+	Cookie []byte
+	// Extensions are the codepoints of the HelloRetryRequest extensions, in
+	// the order they were sent.
+	Extensions []uint16
+}
+
+// ServerHelloInfo returns what the server selected in its ServerHello, and
+// the HelloRetryRequest that preceded it, if any. It can be called once the
+// ServerHello was received, even if the handshake failed later, and returns
+// nil before that.
+func (uconn *UConn) ServerHelloInfo() *ServerHelloInfo {
+	serverHello := uconn.HandshakeState.ServerHello
+	if serverHello == nil || serverHello.Raw == nil {
+		return nil
+	}
+
+	info := &ServerHelloInfo{
+		Version:       uconn.vers,
+		CipherSuite:   serverHello.CipherSuite,
+		KeyShareGroup: serverHello.ServerShare.group,
+		Extensions:    serverHelloExtensions(serverHello.Raw),
+	}
+	if uconn.isHandshakeComplete.Load() {
+		info.ALPN = uconn.clientProtocol
+		info.CertCompression = uconn.utls.certCompressionUsed
+	}
+	if hrr := uconn.utls.helloRetryRequest; hrr != nil {
+		info.HelloRetryRequest = &HelloRetryRequestInfo{
+			CipherSuite:   hrr.cipherSuite,
+			SelectedGroup: hrr.selectedGroup,
+			Cookie:        hrr.cookie,
+			Extensions:    serverHelloExtensions(hrr.raw),
+		}
+	}
+	return info
+}
+
+// CertCompressionUsed returns the algorithm the server compressed its
+// Certificate message with, or 0 if it was sent uncompressed, was not sent
+// because the session was resumed, or was not received yet. Servers only
+// compress the certificate with one of the algorithms of the
+// compress_certificate extension, so this reports whether the extension had an
+// effect.
+func (uconn *UConn) CertCompressionUsed() CertCompressionAlgo {
+	return uconn.utls.certCompressionUsed
+}
+
+// NegotiatedGroup returns the group of the key exchange selected by the
+// server: the group of its key_share in TLS 1.3, or of its ServerKeyExchange
+// in TLS 1.2 and earlier. It returns 0 before the key exchange, and if there
+// was none, e.g. with RSA key exchange or a PSK-only resumption.
+func (uconn *UConn) NegotiatedGroup() CurveID {
+	if uconn.vers >= VersionTLS13 {
+		if serverHello := uconn.HandshakeState.ServerHello; serverHello != nil {
+			return serverHello.ServerShare.group
+		}
+		return 0
+	}
+	return uconn.utls.serverKeyExchangeGroup
+}
+
+// NegotiatedGroupMatchesPreference reports whether the server selected the
+// first group of the supported_groups extension, not counting GREASE, e.g.
+// X25519MLKEM768 for Chrome, rather than a group it prefers over the one of the
+// parrot. It returns false if NegotiatedGroup returns 0.
+func (uconn *UConn) NegotiatedGroupMatchesPreference() bool {
+	group := uconn.NegotiatedGroup()
+	if group == 0 {
+		return false
+	}
+	for _, preferred := range uconn.HandshakeState.Hello.SupportedCurves {
+		if !isGREASEUint16(uint16(preferred)) {
+			return preferred == group
+		}
+	}
+	return false
+}
+
+// serverHelloExtensions returns the extension codepoints of a ServerHello
+// message that was successfully unmarshaled.
+func serverHelloExtensions(raw []byte) []uint16 {
+	s := cryptobyte.String(raw)
+	var sessionID, extensions cryptobyte.String
+	if !s.Skip(4+2+32) || // message type, uint24 length, version and random
+		!s.ReadUint8LengthPrefixed(&sessionID) ||
+		!s.Skip(2+1) || // cipher_suite and compression_method
+		!s.ReadUint16LengthPrefixed(&extensions) {
+		return nil
+	}
+
+	var exts []uint16
+	for !extensions.Empty() {
+		var extension uint16
+		var extData cryptobyte.String
+		if !extensions.ReadUint16(&extension) ||
+			!extensions.ReadUint16LengthPrefixed(&extData) {
+			return nil
+		}
+		exts = append(exts, extension)
+	}
+	return exts
+}
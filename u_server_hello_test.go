@@ -0,0 +1,125 @@
+// Copyright 2024 The uTLS Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tls
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestUTLSServerHelloInfo(t *testing.T) {
+	for _, test := range []struct {
+		name       string
+		maxVersion uint16
+		extensions []uint16
+		hrr        bool
+	}{
+		// Chrome only sends an X25519 key share, so the server asks for CurveP256
+		{"TLS 1.3", VersionTLS13, []uint16{extensionSupportedVersions, extensionKeyShare}, true},
+		{"TLS 1.2", VersionTLS12, []uint16{extensionRenegotiationInfo, extensionExtendedMasterSecret, extensionSessionTicket, extensionALPN, extensionSupportedPoints}, false},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			c, s := localPipe(t)
+			serverConfig := testConfig.Clone()
+			serverConfig.MaxVersion = test.maxVersion
+			serverConfig.CurvePreferences = []CurveID{CurveP256}
+			serverConfig.NextProtos = []string{"h2"}
+			// other tests may leave an OCSP staple or SCTs on the shared certificate
+			cert := serverConfig.Certificates[0]
+			cert.OCSPStaple, cert.SignedCertificateTimestamps = nil, nil
+			serverConfig.Certificates = []Certificate{cert}
+			go func() {
+				server := Server(s, serverConfig)
+				server.Handshake()
+				server.Close()
+			}()
+
+			uconn := UClient(c, &Config{ServerName: "example.golang", InsecureSkipVerify: true}, HelloChrome_120)
+			defer uconn.Close()
+			if info := uconn.ServerHelloInfo(); info != nil {
+				t.Errorf("got %+v before the handshake, expected nil", info)
+			}
+			if err := uconn.Handshake(); err != nil {
+				t.Fatalf("handshake failed: %v", err)
+			}
+
+			info := uconn.ServerHelloInfo()
+			if info == nil {
+				t.Fatal("got no ServerHelloInfo after the handshake")
+			}
+			if info.Version != test.maxVersion || info.CipherSuite != uconn.ConnectionState().CipherSuite || info.ALPN != "h2" {
+				t.Errorf("got version %x, cipher suite %x and ALPN %q", info.Version, info.CipherSuite, info.ALPN)
+			}
+			wantGroup := CurveP256
+			if test.maxVersion != VersionTLS13 {
+				wantGroup = 0
+			}
+			if info.KeyShareGroup != wantGroup {
+				t.Errorf("got key_share group %v, expected %v", info.KeyShareGroup, wantGroup)
+			}
+			got := slices.Clone(info.Extensions)
+			slices.Sort(got)
+			want := slices.Clone(test.extensions)
+			slices.Sort(want)
+			if !slices.Equal(got, want) {
+				t.Errorf("got extensions %v, expected %v", info.Extensions, test.extensions)
+			}
+
+			hrr := info.HelloRetryRequest
+			if (hrr != nil) != test.hrr {
+				t.Fatalf("got HelloRetryRequest %+v, expected one: %v", hrr, test.hrr)
+			}
+			if hrr != nil {
+				if hrr.SelectedGroup != CurveP256 || hrr.CipherSuite != info.CipherSuite || !slices.Contains(hrr.Extensions, extensionKeyShare) {
+					t.Errorf("got HelloRetryRequest %+v, expected one asking for CurveP256", hrr)
+				}
+			}
+		})
+	}
+}
+
+func TestUTLSNegotiatedGroup(t *testing.T) {
+	for _, test := range []struct {
+		name       string
+		maxVersion uint16
+		curves     []CurveID
+		group      CurveID
+		preferred  bool
+	}{
+		// HelloChrome_120 prefers X25519
+		{"TLS 1.3", VersionTLS13, []CurveID{X25519, CurveP256}, X25519, true},
+		{"TLS 1.3 HelloRetryRequest", VersionTLS13, []CurveID{CurveP256}, CurveP256, false},
+		{"TLS 1.2", VersionTLS12, []CurveID{X25519}, X25519, true},
+		{"TLS 1.2 other group", VersionTLS12, []CurveID{CurveP384}, CurveP384, false},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			c, s := localPipe(t)
+			serverConfig := testConfig.Clone()
+			serverConfig.MaxVersion = test.maxVersion
+			serverConfig.CurvePreferences = test.curves
+			serverConfig.CipherSuites = []uint16{TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256}
+			go func() {
+				server := Server(s, serverConfig)
+				server.Handshake()
+				server.Close()
+			}()
+
+			uconn := UClient(c, &Config{ServerName: "example.golang", InsecureSkipVerify: true}, HelloChrome_120)
+			defer uconn.Close()
+			if group := uconn.NegotiatedGroup(); group != 0 {
+				t.Errorf("got group %v before the handshake, expected 0", group)
+			}
+			if err := uconn.Handshake(); err != nil {
+				t.Fatalf("handshake failed: %v", err)
+			}
+			if group := uconn.NegotiatedGroup(); group != test.group {
+				t.Errorf("got group %v, expected %v", group, test.group)
+			}
+			if preferred := uconn.NegotiatedGroupMatchesPreference(); preferred != test.preferred {
+				t.Errorf("got NegotiatedGroupMatchesPreference %v, expected %v", preferred, test.preferred)
+			}
+		})
+	}
+}
@@ -81,7 +81,7 @@ const shouldLoad shouldLoadSessionResult = 3
 //   - If both the `sessionTicketExt` and `pskExtension` are nil, which might occur if the client hello spec does not include them, we should skip the loadSession().
 //   - In all other cases, the function proceeds to load the session.
 func (s *sessionController) shouldLoadSession() shouldLoadSessionResult {
-	if s.sessionTicketExt == nil && s.pskExtension == nil || s.uconnRef.clientHelloBuildStatus != NotBuilt {
+	if s.sessionTicketExt == nil && s.pskExtension == nil && !s.canAddPskExt() || s.uconnRef.clientHelloBuildStatus != NotBuilt {
 		// No need to load session since we don't have the related extensions.
 		return shouldReturn
 	}
@@ -166,6 +166,9 @@ func (s *sessionController) initPskExt(session *SessionState, earlySecret []byte
 	s.assertHelloNotBuilt("initPskExt")
 	s.assertControllerState("initPskExt", NoSession)
 	panicOnNil("initPskExt", session, earlySecret, pskIdentities)
+	if s.pskExtension == nil && s.canAddPskExt() {
+		s.addPskExt()
+	}
 	if s.pskExtension == nil {
 		s.assertCanSkip("initPskExt", "pre-shared key extension")
 		return
@@ -183,6 +186,31 @@ func (s *sessionController) initPskExt(session *SessionState, earlySecret []byte
 	s.state = PskExtInitialized
 }
 
+// canAddPskExt reports whether a PSK extension may be added to a spec that
+// lacks one. Browsers send pre_shared_key whenever they have a TLS 1.3 ticket,
+// so the parrots only leave it out of their specs because there usually is
+// none. Custom specs are left as they are.
+func (s *sessionController) canAddPskExt() bool {
+	if s.pskExtension != nil || s.uconnRef.ClientHelloID.Client == helloCustom {
+		return false
+	}
+	return anyTrue(s.uconnRef.Extensions, func(_ int, e *TLSExtension) bool {
+		_, ok := (*e).(*PSKKeyExchangeModesExtension)
+		return ok
+	})
+}
+
+// addPskExt appends an empty PSK extension to the extension list, as the last
+// extension, and takes ownership of it.
+func (s *sessionController) addPskExt() {
+	uAssert(s.canAddPskExt(), "tls: addPskExt failed: can't add a psk extension")
+	pskExt := &UtlsPreSharedKeyExtension{}
+	pskExt.SetOmitEmptyPsk(s.uconnRef.config.OmitEmptyPsk)
+	pskExt.writeToUConn(s.uconnRef)
+	s.uconnRef.Extensions = append(s.uconnRef.Extensions, pskExt)
+	s.pskExtension = pskExt
+}
+
 // setSessionTicketToUConn write the ticket states from the session ticket extension to the client hello and handshake state.
 func (s *sessionController) setSessionTicketToUConn() {
 	uAssert(s.sessionTicketExt != nil && s.state == SessionTicketExtInitialized, "tls: setSessionTicketExt failed: invalid state")
@@ -259,7 +287,7 @@ func (s *sessionController) overrideSessionTicketExt(sessionTicketExt ISessionTi
 //
 // This function ensures that there is only one session ticket extension or PSK
 // extension, and that the PSK extension is the last extension in the extension
-// list.
+// list, unless the ClientHelloSpec forces the extension order.
 func (s *sessionController) syncSessionExts() error {
 	uAssert(s.uconnRef.clientHelloBuildStatus == NotBuilt, "tls: checkSessionExts failed: we can't modify the session after the clientHello is built")
 	s.assertNotLocked("checkSessionExts")
@@ -280,7 +308,7 @@ func (s *sessionController) syncSessionExts() error {
 			}
 			numSessionExt += 1
 		case PreSharedKeyExtension:
-			uAssert(i == len(s.uconnRef.Extensions)-1, "tls: checkSessionExts failed: PreSharedKeyExtension must be the last extension")
+			uAssert(i == len(s.uconnRef.Extensions)-1 || s.uconnRef.forceExtensionOrder, "tls: checkSessionExts failed: PreSharedKeyExtension must be the last extension")
 			if s.pskExtension == nil {
 				// If there isn't a user-provided psk extension, use the one from the spec
 				s.pskExtension = ext
@@ -68,6 +68,10 @@ func (e *SessionTicketExtension) UnmarshalJSON(_ []byte) error {
 	return nil // no-op
 }
 
+func (e *SessionTicketExtension) MarshalJSON() ([]byte, error) {
+	return marshalExtensionName(extensionSessionTicket)
+}
+
 func (e *SessionTicketExtension) Write(_ []byte) (int, error) {
 	// RFC 5077, Section 3.2
 	return 0, nil
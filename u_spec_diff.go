@@ -0,0 +1,318 @@
+// Copyright 2024 The uTLS Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tls
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/refraction-networking/utls/dicttls"
+)
+
+// SpecDiffKind is the kind of a difference between two ClientHelloSpecs.
+type SpecDiffKind uint8
+
+const (
+	// SpecDiffTLSVersions: TLSVersMin or TLSVersMax differ. A and B hold
+	// {TLSVersMin, TLSVersMax} of each spec.
+	SpecDiffTLSVersions SpecDiffKind = iota
+	// SpecDiffCipherSuites: the cipher suites or their order differ.
+	SpecDiffCipherSuites
+	// SpecDiffCompressionMethods: the compression methods differ.
+	SpecDiffCompressionMethods
+	// SpecDiffExtensionAdded: the extension is only in the second spec.
+	SpecDiffExtensionAdded
+	// SpecDiffExtensionRemoved: the extension is only in the first spec.
+	SpecDiffExtensionRemoved
+	// SpecDiffExtensionReordered: the extension is in both specs, but moved
+	// relative to the other extensions.
+	SpecDiffExtensionReordered
+	// SpecDiffExtensionContents: the extension is serialized differently.
+	// DataA and DataB hold both serializations.
+	SpecDiffExtensionContents
+	// SpecDiffSupportedGroups: the groups of the supported_groups extension
+	// differ.
+	SpecDiffSupportedGroups
+)
+
+// SpecDiff is a difference between two ClientHelloSpecs, as returned by
+// DiffSpecs.
+type SpecDiff struct {
+	Kind SpecDiffKind
+
+	// Extension is the codepoint of the extension for the extension kinds,
+	// with GREASE values replaced by GREASE_PLACEHOLDER.
+	Extension uint16
+	// IndexA and IndexB are the positions of the extension in each spec, or
+	// -1 if it is missing from that spec.
+	IndexA, IndexB int
+
+	// A and B are the values of each spec for SpecDiffTLSVersions,
+	// SpecDiffCipherSuites, SpecDiffCompressionMethods and
+	// SpecDiffSupportedGroups. GREASE values are replaced by
+	// GREASE_PLACEHOLDER.
+	A, B []uint16
+	// DataA and DataB are the serialized extensions for
+	// SpecDiffExtensionContents.
+	DataA, DataB []byte
+}
+
+// String returns a human-readable description of the difference.
+func (d SpecDiff) String() string {
+	switch d.Kind {
+	case SpecDiffTLSVersions:
+		return fmt.Sprintf("TLS versions differ: %s != %s", specDiffList(d.A, specDiffVersionName), specDiffList(d.B, specDiffVersionName))
+	case SpecDiffCipherSuites:
+		return fmt.Sprintf("cipher suites differ: %s != %s", specDiffList(d.A, specDiffCipherSuiteName), specDiffList(d.B, specDiffCipherSuiteName))
+	case SpecDiffCompressionMethods:
+		return fmt.Sprintf("compression methods differ: %s != %s", specDiffList(d.A, nil), specDiffList(d.B, nil))
+	case SpecDiffExtensionAdded:
+		return fmt.Sprintf("extension %s added at position %d", specDiffExtensionName(d.Extension), d.IndexB)
+	case SpecDiffExtensionRemoved:
+		return fmt.Sprintf("extension %s removed from position %d", specDiffExtensionName(d.Extension), d.IndexA)
+	case SpecDiffExtensionReordered:
+		return fmt.Sprintf("extension %s moved from position %d to %d", specDiffExtensionName(d.Extension), d.IndexA, d.IndexB)
+	case SpecDiffExtensionContents:
+		return fmt.Sprintf("extension %s contents differ: %x != %x", specDiffExtensionName(d.Extension), d.DataA, d.DataB)
+	case SpecDiffSupportedGroups:
+		return fmt.Sprintf("supported groups differ: %s != %s", specDiffList(d.A, specDiffGroupName), specDiffList(d.B, specDiffGroupName))
+	default:
+		return "unknown difference " + strconv.Itoa(int(d.Kind))
+	}
+}
+
+// DiffSpecs returns the differences between a and b, e.g. between a parrot
+// and a spec built from a browser's ClientHello with a Fingerprinter. It
+// returns nil if both specs result in the same ClientHello fingerprint.
+//
+// GREASE values are compared as GREASE_PLACEHOLDER, and values that only
+// depend on the connection, like key shares, session tickets, the SNI or the
+// padding length, are ignored. Extensions are matched by codepoint, and
+// repeated codepoints in the order they appear.
+//
+// The differences of the whole ClientHello come first, then those of the
+// extensions of a in their order, then the extensions added in b.
+func DiffSpecs(a, b ClientHelloSpec) []SpecDiff {
+	var diffs []SpecDiff
+
+	if a.TLSVersMin != b.TLSVersMin || a.TLSVersMax != b.TLSVersMax {
+		diffs = append(diffs, SpecDiff{
+			Kind: SpecDiffTLSVersions, IndexA: -1, IndexB: -1,
+			A: []uint16{a.TLSVersMin, a.TLSVersMax}, B: []uint16{b.TLSVersMin, b.TLSVersMax},
+		})
+	}
+	if suitesA, suitesB := unGREASEList(a.CipherSuites), unGREASEList(b.CipherSuites); !sliceEq(suitesA, suitesB) {
+		diffs = append(diffs, SpecDiff{Kind: SpecDiffCipherSuites, IndexA: -1, IndexB: -1, A: suitesA, B: suitesB})
+	}
+	if methodsA, methodsB := specDiffBytes(a.CompressionMethods), specDiffBytes(b.CompressionMethods); !sliceEq(methodsA, methodsB) {
+		diffs = append(diffs, SpecDiff{Kind: SpecDiffCompressionMethods, IndexA: -1, IndexB: -1, A: methodsA, B: methodsB})
+	}
+
+	keysA, keysB := specDiffKeys(a.Extensions), specDiffKeys(b.Extensions)
+	indexB := make(map[specDiffKey]int, len(keysB))
+	for i, key := range keysB {
+		indexB[key] = i
+	}
+	indexA := make(map[specDiffKey]int, len(keysA))
+	for i, key := range keysA {
+		indexA[key] = i
+	}
+	moved := specDiffMoved(keysA, keysB, indexB)
+
+	for i, key := range keysA {
+		j, ok := indexB[key]
+		if !ok {
+			diffs = append(diffs, SpecDiff{Kind: SpecDiffExtensionRemoved, Extension: key.id, IndexA: i, IndexB: -1})
+			continue
+		}
+		if moved[key] {
+			diffs = append(diffs, SpecDiff{Kind: SpecDiffExtensionReordered, Extension: key.id, IndexA: i, IndexB: j})
+		}
+
+		if curvesA, ok := a.Extensions[i].(*SupportedCurvesExtension); ok {
+			if curvesB, ok := b.Extensions[j].(*SupportedCurvesExtension); ok {
+				groupsA, groupsB := specDiffGroups(curvesA.Curves), specDiffGroups(curvesB.Curves)
+				if !sliceEq(groupsA, groupsB) {
+					diffs = append(diffs, SpecDiff{Kind: SpecDiffSupportedGroups, Extension: key.id, IndexA: i, IndexB: j, A: groupsA, B: groupsB})
+				}
+				continue
+			}
+		}
+		dataA, okA := specDiffData(a.Extensions[i])
+		dataB, okB := specDiffData(b.Extensions[j])
+		if okA != okB || okA && string(dataA) != string(dataB) {
+			diffs = append(diffs, SpecDiff{Kind: SpecDiffExtensionContents, Extension: key.id, IndexA: i, IndexB: j, DataA: dataA, DataB: dataB})
+		}
+	}
+	for j, key := range keysB {
+		if _, ok := indexA[key]; !ok {
+			diffs = append(diffs, SpecDiff{Kind: SpecDiffExtensionAdded, Extension: key.id, IndexA: -1, IndexB: j})
+		}
+	}
+
+	return diffs
+}
+
+// FingerprintEqual reports whether chs and other result in the same ClientHello
+// fingerprint, such as JA3 or JA4, that is whether DiffSpecs finds no
+// difference between them. Unlike reflect.DeepEqual, it ignores GREASE values
+// and the values that depend on the connection, but not the order of the
+// cipher suites, extensions or groups.
+func (chs *ClientHelloSpec) FingerprintEqual(other ClientHelloSpec) bool {
+	return len(DiffSpecs(*chs, other)) == 0
+}
+
+// specDiffKey identifies an extension of a spec: the n-th one with the
+// (unGREASEd) codepoint id.
+type specDiffKey struct {
+	id uint16
+	n  int
+}
+
+func specDiffKeys(exts []TLSExtension) []specDiffKey {
+	keys := make([]specDiffKey, len(exts))
+	seen := make(map[uint16]int)
+	for i, ext := range exts {
+		id, ok := extensionIDOf(ext)
+		if !ok {
+			// an unknown type can't be told apart from other unknown
+			// types, so it is never matched
+			keys[i] = specDiffKey{id: 0xffff, n: -1 - i}
+			continue
+		}
+		id = unGREASEUint16(id)
+		keys[i] = specDiffKey{id: id, n: seen[id]}
+		seen[id]++
+	}
+	return keys
+}
+
+// specDiffMoved returns the extensions found in both specs that are not part
+// of the longest sequence of extensions keeping their relative order.
+func specDiffMoved(keysA, keysB []specDiffKey, indexB map[specDiffKey]int) map[specDiffKey]bool {
+	var common []specDiffKey
+	for _, key := range keysA {
+		if _, ok := indexB[key]; ok {
+			common = append(common, key)
+		}
+	}
+
+	// longest increasing subsequence of the positions in b, in O(n²) as
+	// there are only a few dozens extensions
+	length := make([]int, len(common))
+	prev := make([]int, len(common))
+	best := -1
+	for i := range common {
+		length[i], prev[i] = 1, -1
+		for j := 0; j < i; j++ {
+			if indexB[common[j]] < indexB[common[i]] && length[j]+1 > length[i] {
+				length[i], prev[i] = length[j]+1, j
+			}
+		}
+		if best == -1 || length[i] > length[best] {
+			best = i
+		}
+	}
+
+	moved := make(map[specDiffKey]bool)
+	for _, key := range common {
+		moved[key] = true
+	}
+	for i := best; i != -1; i = prev[i] {
+		delete(moved, common[i])
+	}
+	return moved
+}
+
+// specDiffData returns the serialization of ext that is compared by
+// DiffSpecs, without the values specific to a connection, or false if ext
+// is only compared by codepoint.
+func specDiffData(ext TLSExtension) ([]byte, bool) {
+	switch ext.(type) {
+	case *UtlsGREASEExtension, *UtlsPaddingExtension, *GREASEEncryptedClientHelloExtension,
+		EncryptedClientHelloExtension, PreSharedKeyExtension:
+		// random, or set by ApplyPreset and the rest of the ClientHello
+		return nil, false
+	}
+
+	clone, err := cloneExtension(ext)
+	if err != nil {
+		clone = ext
+	}
+	b := make([]byte, clone.Len())
+	if _, err := clone.Read(b); err != nil && err != io.EOF {
+		return nil, false
+	}
+	return b, true
+}
+
+func unGREASEList(values []uint16) []uint16 {
+	res := make([]uint16, len(values))
+	for i, v := range values {
+		res[i] = unGREASEUint16(v)
+	}
+	return res
+}
+
+func specDiffGroups(curves []CurveID) []uint16 {
+	res := make([]uint16, len(curves))
+	for i, curve := range curves {
+		res[i] = unGREASEUint16(uint16(curve))
+	}
+	return res
+}
+
+func specDiffBytes(values []uint8) []uint16 {
+	res := make([]uint16, len(values))
+	for i, v := range values {
+		res[i] = uint16(v)
+	}
+	return res
+}
+
+func specDiffList(values []uint16, name func(uint16) string) string {
+	names := make([]string, len(values))
+	for i, v := range values {
+		if name == nil {
+			names[i] = strconv.Itoa(int(v))
+		} else {
+			names[i] = name(v)
+		}
+	}
+	return "[" + strings.Join(names, " ") + "]"
+}
+
+func specDiffExtensionName(id uint16) string {
+	if isGREASEUint16(id) {
+		return "GREASE"
+	}
+	if name, ok := dicttls.DictExtTypeValueIndexed[id]; ok {
+		return fmt.Sprintf("%s (%d)", name, id)
+	}
+	return strconv.Itoa(int(id))
+}
+
+func specDiffCipherSuiteName(id uint16) string {
+	if isGREASEUint16(id) {
+		return "GREASE"
+	}
+	return CipherSuiteName(id)
+}
+
+func specDiffGroupName(id uint16) string {
+	if isGREASEUint16(id) {
+		return "GREASE"
+	}
+	return CurveID(id).String()
+}
+
+func specDiffVersionName(v uint16) string {
+	if v == 0 {
+		return "0"
+	}
+	return VersionName(v)
+}
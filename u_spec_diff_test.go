@@ -0,0 +1,158 @@
+// Copyright 2024 The uTLS Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tls
+
+import (
+	"fmt"
+	"net"
+	"slices"
+	"testing"
+)
+
+func TestUTLSDiffSpecs(t *testing.T) {
+	a, b := mustSpec(t, HelloFirefox_120), mustSpec(t, HelloFirefox_120)
+	if diffs := DiffSpecs(*a, *b); diffs != nil {
+		t.Fatalf("got differences %v between identical specs", diffs)
+	}
+
+	b.CipherSuites[0], b.CipherSuites[1] = b.CipherSuites[1], b.CipherSuites[0]
+	var sni TLSExtension
+	var exts []TLSExtension
+	for _, ext := range b.Extensions {
+		switch e := ext.(type) {
+		case *SNIExtension:
+			sni = e
+			continue
+		case *StatusRequestExtension:
+			continue
+		case *ALPNExtension:
+			e.AlpnProtocols = []string{"http/1.1"}
+		case *SupportedCurvesExtension:
+			e.Curves = []CurveID{X25519}
+		}
+		exts = append(exts, ext)
+	}
+	b.Extensions = append(exts, sni, &GenericExtension{Id: 0xfeed})
+
+	var kinds []SpecDiffKind
+	var strs []string
+	for _, d := range DiffSpecs(*a, *b) {
+		kinds = append(kinds, d.Kind)
+		strs = append(strs, d.String())
+	}
+	wantKinds := []SpecDiffKind{
+		SpecDiffCipherSuites,
+		SpecDiffExtensionReordered,
+		SpecDiffSupportedGroups,
+		SpecDiffExtensionContents,
+		SpecDiffExtensionRemoved,
+		SpecDiffExtensionAdded,
+	}
+	if !slices.Equal(kinds, wantKinds) {
+		t.Fatalf("got differences %q, expected kinds %v", strs, wantKinds)
+	}
+	n := len(b.Extensions)
+	for i, want := range map[int]string{
+		1: fmt.Sprintf("extension server_name (0) moved from position 0 to %d", n-2),
+		2: "supported groups differ: [X25519 CurveP256 CurveP384 CurveP521 CurveID(256) CurveID(257)] != [X25519]",
+		4: "extension status_request (5) removed from position 7",
+		5: fmt.Sprintf("extension 65261 added at position %d", n-1),
+	} {
+		if strs[i] != want {
+			t.Errorf("got difference %q, expected %q", strs[i], want)
+		}
+	}
+}
+
+func TestUTLSDiffSpecsIgnoresConnectionValues(t *testing.T) {
+	uconn := UClient(&net.TCPConn{}, &Config{ServerName: "example.com"}, HelloChrome_120)
+	if err := uconn.BuildHandshakeState(); err != nil {
+		t.Fatalf("got error: %v; expected to succeed", err)
+	}
+	a, err := uconn.CurrentSpec()
+	if err != nil {
+		t.Fatalf("got error: %v; expected to succeed", err)
+	}
+	b, err := uconn.CurrentSpec()
+	if err != nil {
+		t.Fatalf("got error: %v; expected to succeed", err)
+	}
+
+	// b gets its own GREASE values, key shares and GREASE ECH payload
+	other := UClient(&net.TCPConn{}, &Config{ServerName: "example.org"}, HelloCustom)
+	if err := other.ApplyPreset(&b); err != nil {
+		t.Fatalf("got error: %v; expected to succeed", err)
+	}
+	if err := other.BuildHandshakeState(); err != nil {
+		t.Fatalf("got error: %v; expected to succeed", err)
+	}
+	if diffs := DiffSpecs(a, b); diffs != nil {
+		t.Errorf("got differences %v, expected none", diffs)
+	}
+}
+
+func TestUTLSFingerprintEqual(t *testing.T) {
+	a, b := mustSpec(t, HelloChrome_102), mustSpec(t, HelloChrome_102)
+	if !a.FingerprintEqual(*b) {
+		t.Errorf("got different fingerprints for identical specs: %v", DiffSpecs(*a, *b))
+	}
+
+	// different GREASE values and key shares
+	b.CipherSuites[0] = 0x2a2a
+	for _, ext := range b.Extensions {
+		switch e := ext.(type) {
+		case *UtlsGREASEExtension:
+			e.Value, e.Body = 0x3a3a, []byte{0}
+		case *SupportedCurvesExtension:
+			e.Curves[0] = 0x4a4a
+		case *KeyShareExtension:
+			e.KeyShares[0].Group = 0x4a4a
+			e.KeyShares[1].Data = make([]byte, 32)
+		case *SupportedVersionsExtension:
+			e.Versions[0] = 0x5a5a
+		}
+	}
+	if !a.FingerprintEqual(*b) {
+		t.Errorf("got different fingerprints for specs with different GREASE values: %v", DiffSpecs(*a, *b))
+	}
+
+	// a spec parsed from the ClientHello of a parrot
+	uconn := UClient(&net.TCPConn{}, &Config{ServerName: "example.com"}, HelloChrome_102)
+	if err := uconn.BuildHandshakeState(); err != nil {
+		t.Fatalf("got error: %v; expected to succeed", err)
+	}
+	parsed, err := (&Fingerprinter{}).FingerprintClientHello(prependRecordHeader(uconn.HandshakeState.Hello.Raw, VersionTLS10))
+	if err != nil {
+		t.Fatalf("got error: %v; expected to succeed", err)
+	}
+	if !a.FingerprintEqual(*parsed) {
+		t.Errorf("got a different fingerprint for the parsed ClientHello: %v", DiffSpecs(*a, *parsed))
+	}
+
+	for name, change := range map[string]func(*ClientHelloSpec){
+		"cipher suite order": func(spec *ClientHelloSpec) {
+			spec.CipherSuites[1], spec.CipherSuites[2] = spec.CipherSuites[2], spec.CipherSuites[1]
+		},
+		"extension order": func(spec *ClientHelloSpec) {
+			spec.Extensions[1], spec.Extensions[2] = spec.Extensions[2], spec.Extensions[1]
+		},
+		"group order": func(spec *ClientHelloSpec) {
+			for _, ext := range spec.Extensions {
+				if e, ok := ext.(*SupportedCurvesExtension); ok {
+					e.Curves[1], e.Curves[2] = e.Curves[2], e.Curves[1]
+				}
+			}
+		},
+		"missing extension": func(spec *ClientHelloSpec) {
+			*spec = spec.WithoutExtension(extensionALPN)
+		},
+	} {
+		changed := mustSpec(t, HelloChrome_102)
+		change(changed)
+		if a.FingerprintEqual(*changed) {
+			t.Errorf("%s: got the same fingerprint, expected a different one", name)
+		}
+	}
+}
@@ -0,0 +1,226 @@
+// Copyright 2024 The uTLS Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tls
+
+import (
+	"errors"
+	"reflect"
+	"sync"
+)
+
+// Clone returns a deep copy of chs that can be applied to another connection.
+// Values that are specific to a connection are not copied, see CurrentSpec.
+func (chs *ClientHelloSpec) Clone() (ClientHelloSpec, error) {
+	spec := *chs
+	spec.CipherSuites = make([]uint16, len(chs.CipherSuites))
+	for i, suite := range chs.CipherSuites {
+		spec.CipherSuites[i] = unGREASEUint16(suite)
+	}
+	spec.CompressionMethods = append([]uint8(nil), chs.CompressionMethods...)
+	spec.Extensions = make([]TLSExtension, len(chs.Extensions))
+	for i, ext := range chs.Extensions {
+		clone, err := cloneExtension(ext)
+		if err != nil {
+			return ClientHelloSpec{}, err
+		}
+		spec.Extensions[i] = clone
+	}
+	return spec, nil
+}
+
+// ClientHelloSpecPool reuses the ClientHelloSpecs of a parrot across
+// connections, to avoid generating the spec and allocating its extensions for
+// every UConn. It is safe for concurrent use.
+//
+//	spec, err := pool.Get()
+//	uconn := UClient(conn, config, HelloCustom)
+//	err = uconn.ApplyPreset(spec)
+//	err = uconn.Handshake()
+//	pool.Put(spec)
+//
+// Specs of parrots that shuffle their extensions, like HelloChrome_120, are
+// shuffled again by every Get.
+type ClientHelloSpecPool struct {
+	template ClientHelloSpec
+	// templates holds the extensions of template by codepoint, except GREASE.
+	templates map[uint16]TLSExtension
+	shuffle   bool
+	pool      sync.Pool
+}
+
+// NewClientHelloSpecPool returns a pool of the ClientHelloSpec of id, which
+// must be a parrot with a fixed ClientHello structure, i.e. not HelloGolang,
+// HelloCustom or a randomized ClientHelloID.
+func NewClientHelloSpecPool(id ClientHelloID) (*ClientHelloSpecPool, error) {
+	switch id.Client {
+	case helloGolang, helloCustom, helloRandomized, helloRandomizedALPN, helloRandomizedNoALPN, helloRandomizedFixedALPN:
+		return nil, errors.New("tls: ClientHelloSpecPool requires a parrot with a fixed ClientHello")
+	}
+	spec, err := UTLSIdToSpec(id)
+	if err != nil {
+		return nil, err
+	}
+	template, err := spec.Clone()
+	if err != nil {
+		return nil, err
+	}
+
+	p := &ClientHelloSpecPool{
+		template:  template,
+		templates: make(map[uint16]TLSExtension, len(template.Extensions)),
+		shuffle:   parrotShufflesExtensions(id),
+	}
+	for _, ext := range template.Extensions {
+		extID, ok := extensionIDOf(ext)
+		if !ok {
+			return nil, errors.New("tls: ClientHelloSpecPool cannot reuse the spec of " + id.Str())
+		}
+		if !isGREASEUint16(extID) {
+			p.templates[extID] = ext
+		}
+	}
+	return p, nil
+}
+
+// Get returns a ClientHelloSpec of the parrot, either a new one or one that
+// was returned to the pool with Put. Like any ClientHelloSpec, it must only be
+// applied to one UConn.
+func (p *ClientHelloSpecPool) Get() (*ClientHelloSpec, error) {
+	spec, ok := p.pool.Get().(*ClientHelloSpec)
+	if !ok {
+		clone, err := p.template.Clone()
+		if err != nil {
+			return nil, err
+		}
+		spec = &clone
+	}
+	if p.shuffle {
+		ShuffleChromeTLSExtensions(spec.Extensions)
+	}
+	return spec, nil
+}
+
+// Put returns a spec from Get to the pool, once the handshake of the UConn it
+// was applied to completed or failed, and no more ClientHellos are built with
+// it. The key shares, server name, sessions and any other value set for that
+// connection are cleared. The spec must not be used after Put.
+//
+// Specs whose extensions were added, removed or replaced are not reused.
+func (p *ClientHelloSpecPool) Put(spec *ClientHelloSpec) {
+	if spec == nil || !p.reset(spec) {
+		return
+	}
+	p.pool.Put(spec)
+}
+
+// reset restores spec to p.template in place, reusing the extensions and
+// their slices. It reports false if spec doesn't have the extensions of the
+// template anymore, in which case it may be partially reset.
+func (p *ClientHelloSpecPool) reset(spec *ClientHelloSpec) bool {
+	if len(spec.Extensions) != len(p.template.Extensions) {
+		return false
+	}
+	spec.CipherSuites = append(spec.CipherSuites[:0], p.template.CipherSuites...)
+	spec.CompressionMethods = append(spec.CompressionMethods[:0], p.template.CompressionMethods...)
+	spec.TLSVersMin = p.template.TLSVersMin
+	spec.TLSVersMax = p.template.TLSVersMax
+	spec.GetSessionID = p.template.GetSessionID
+	spec.ForceExtensionOrder = p.template.ForceExtensionOrder
+	spec.GREASECipherCount = p.template.GREASECipherCount
+	spec.GREASEExtensionCount = p.template.GREASEExtensionCount
+
+	seen := make(map[uint16]bool, len(spec.Extensions))
+	for _, ext := range spec.Extensions {
+		extID, ok := extensionIDOf(ext)
+		if !ok {
+			return false
+		}
+		if isGREASEUint16(extID) {
+			// The GREASE value is drawn again by ApplyPreset, which only sets
+			// a body for the second GREASE extension, which isn't shuffled.
+			if _, ok := ext.(*UtlsGREASEExtension); !ok {
+				return false
+			}
+			continue
+		}
+		if seen[extID] {
+			return false
+		}
+		seen[extID] = true
+		template, ok := p.templates[extID]
+		if !ok || reflect.TypeOf(ext) != reflect.TypeOf(template) || !resetExtension(ext, template) {
+			return false
+		}
+	}
+	return true
+}
+
+// resetExtension sets ext to the values of template, which has the same type
+// and was never applied to a connection. It reports false for extensions that
+// it cannot reset.
+func resetExtension(ext, template TLSExtension) bool {
+	switch e := ext.(type) {
+	case *SNIExtension:
+		*e = *template.(*SNIExtension)
+	case *StatusRequestExtension, *SCTExtension, *ExtendedMasterSecretExtension:
+	case *SupportedCurvesExtension:
+		e.Curves = append(e.Curves[:0], template.(*SupportedCurvesExtension).Curves...)
+	case *SupportedPointsExtension:
+		e.SupportedPoints = append(e.SupportedPoints[:0], template.(*SupportedPointsExtension).SupportedPoints...)
+	case *SignatureAlgorithmsExtension:
+		e.SupportedSignatureAlgorithms = append(e.SupportedSignatureAlgorithms[:0], template.(*SignatureAlgorithmsExtension).SupportedSignatureAlgorithms...)
+	case *SignatureAlgorithmsCertExtension:
+		e.SupportedSignatureAlgorithms = append(e.SupportedSignatureAlgorithms[:0], template.(*SignatureAlgorithmsCertExtension).SupportedSignatureAlgorithms...)
+	case *FakeDelegatedCredentialsExtension:
+		e.SupportedSignatureAlgorithms = append(e.SupportedSignatureAlgorithms[:0], template.(*FakeDelegatedCredentialsExtension).SupportedSignatureAlgorithms...)
+	case *ALPNExtension:
+		e.AlpnProtocols = append(e.AlpnProtocols[:0], template.(*ALPNExtension).AlpnProtocols...)
+	case *ApplicationSettingsExtension:
+		t := template.(*ApplicationSettingsExtension)
+		e.CodePoint = t.CodePoint
+		e.SupportedProtocols = append(e.SupportedProtocols[:0], t.SupportedProtocols...)
+	case *StatusRequestV2Extension:
+		e.StatusTypes = append(e.StatusTypes[:0], template.(*StatusRequestV2Extension).StatusTypes...)
+	case *UtlsPaddingExtension:
+		*e = *template.(*UtlsPaddingExtension)
+	case *UtlsCompressCertExtension:
+		e.Algorithms = append(e.Algorithms[:0], template.(*UtlsCompressCertExtension).Algorithms...)
+	case *FakeRecordSizeLimitExtension:
+		*e = *template.(*FakeRecordSizeLimitExtension)
+	case *FakeMaxFragmentLengthExtension:
+		*e = *template.(*FakeMaxFragmentLengthExtension)
+	case *FakeHeartbeatExtension:
+		*e = *template.(*FakeHeartbeatExtension)
+	case *PSKKeyExchangeModesExtension:
+		e.Modes = append(e.Modes[:0], template.(*PSKKeyExchangeModesExtension).Modes...)
+	case *SupportedVersionsExtension:
+		e.Versions = append(e.Versions[:0], template.(*SupportedVersionsExtension).Versions...)
+	case *KeyShareExtension:
+		// Only the public keys are kept in the extension, the private keys
+		// are in the HandshakeState of the UConn.
+		e.KeyShares = append(e.KeyShares[:0], template.(*KeyShareExtension).KeyShares...)
+	case *SessionTicketExtension:
+		*e = *template.(*SessionTicketExtension)
+	case *UtlsPreSharedKeyExtension:
+		*e = *template.(*UtlsPreSharedKeyExtension)
+	case *RenegotiationInfoExtension:
+		*e = *template.(*RenegotiationInfoExtension)
+	case *FakeChannelIDExtension:
+		*e = *template.(*FakeChannelIDExtension)
+	case *NPNExtension:
+		e.NextProtos = append(e.NextProtos[:0], template.(*NPNExtension).NextProtos...)
+	case *GREASEEncryptedClientHelloExtension:
+		// The config ID, payload and encapsulated key are drawn again.
+		t := template.(*GREASEEncryptedClientHelloExtension)
+		*e = GREASEEncryptedClientHelloExtension{
+			CandidateCipherSuites: append(e.CandidateCipherSuites[:0], t.CandidateCipherSuites...),
+			CandidateConfigIds:    append(e.CandidateConfigIds[:0], t.CandidateConfigIds...),
+			CandidatePayloadLens:  append(e.CandidatePayloadLens[:0], t.CandidatePayloadLens...),
+		}
+	default:
+		return false
+	}
+	return true
+}
@@ -0,0 +1,188 @@
+// Copyright 2024 The uTLS Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tls
+
+import (
+	"bytes"
+	"net"
+	"slices"
+	"testing"
+)
+
+func TestUTLSClientHelloSpecPool(t *testing.T) {
+	pool, err := NewClientHelloSpecPool(HelloChrome_120)
+	if err != nil {
+		t.Fatalf("got error: %v; expected to succeed", err)
+	}
+	parrot, err := UTLSIdToSpec(HelloChrome_120)
+	if err != nil {
+		t.Fatalf("got error: %v; expected to succeed", err)
+	}
+	extensionIDs := func(exts []TLSExtension) []uint16 {
+		var ids []uint16
+		for _, ext := range exts {
+			id, _ := extensionIDOf(ext)
+			ids = append(ids, unGREASEUint16(id))
+		}
+		slices.Sort(ids)
+		return ids
+	}
+
+	spec, err := pool.Get()
+	if err != nil {
+		t.Fatalf("got error: %v; expected to succeed", err)
+	}
+	var publicKey []byte
+	for i := 0; i < 2; i++ {
+		uconn := UClient(&net.TCPConn{}, &Config{ServerName: "example.com"}, HelloCustom)
+		if err := uconn.ApplyPreset(spec); err != nil {
+			t.Fatalf("got error: %v; expected to succeed", err)
+		}
+		if err := uconn.SetALPN([]string{"h2"}); err != nil {
+			t.Fatalf("got error: %v; expected to succeed", err)
+		}
+		if err := uconn.BuildHandshakeState(); err != nil {
+			t.Fatalf("got error: %v; expected to succeed", err)
+		}
+		if got, want := extensionIDs(uconn.Extensions), extensionIDs(parrot.Extensions); !slices.Equal(got, want) {
+			t.Errorf("got extensions %v, expected %v", got, want)
+		}
+		key := uconn.HandshakeState.State13.EcdheKey.PublicKey().Bytes()
+		if slices.Equal(key, publicKey) {
+			t.Error("got the same key share for two connections")
+		}
+		publicKey = key
+
+		if !pool.reset(spec) {
+			t.Fatal("the spec was not reset")
+		}
+		for _, ext := range spec.Extensions {
+			switch e := ext.(type) {
+			case *SNIExtension:
+				if e.ServerName != "" {
+					t.Errorf("got server name %q after reset", e.ServerName)
+				}
+			case *ALPNExtension:
+				if !slices.Equal(e.AlpnProtocols, []string{"h2", "http/1.1"}) {
+					t.Errorf("got ALPN %q after reset, expected the parrot's", e.AlpnProtocols)
+				}
+			case *KeyShareExtension:
+				for _, ks := range e.KeyShares {
+					if !isGREASEUint16(uint16(ks.Group)) && ks.Data != nil {
+						t.Errorf("key share %v still has a public key after reset", ks.Group)
+					}
+				}
+			case *SupportedCurvesExtension:
+				if e.Curves[0] != GREASE_PLACEHOLDER {
+					t.Errorf("got GREASE group %#x after reset, expected GREASE_PLACEHOLDER", e.Curves[0])
+				}
+			}
+		}
+	}
+	pool.Put(spec)
+
+	spec, err = pool.Get()
+	if err != nil {
+		t.Fatalf("got error: %v; expected to succeed", err)
+	}
+	spec.Extensions = spec.Extensions[1:]
+	if pool.reset(spec) {
+		t.Error("a spec without one of the parrot's extensions was reset")
+	}
+
+	if _, err := NewClientHelloSpecPool(HelloRandomized); err == nil {
+		t.Error("got no error creating a pool for HelloRandomized")
+	}
+}
+
+func TestUTLSParrotShufflesExtensions(t *testing.T) {
+	order := func(id ClientHelloID) []uint16 {
+		spec, err := UTLSIdToSpec(id)
+		if err != nil {
+			t.Fatalf("%s: got error: %v; expected to succeed", id.Str(), err)
+		}
+		var ids []uint16
+		for _, ext := range spec.Extensions {
+			extID, _ := extensionIDOf(ext)
+			ids = append(ids, unGREASEUint16(extID))
+		}
+		return ids
+	}
+	for _, id := range append(parrotHelloIDs, helloChrome_131_PQ_HybridOnly, helloChrome_131_PQ_Classical) {
+		first := order(id)
+		shuffled := false
+		// The chance of four identical shuffles of a dozen extensions is
+		// negligible.
+		for i := 0; i < 3 && !shuffled; i++ {
+			shuffled = !slices.Equal(first, order(id))
+		}
+		if shuffled != parrotShufflesExtensions(id) {
+			t.Errorf("%s: parrotShufflesExtensions is %v, but the spec shuffles: %v", id.Str(), parrotShufflesExtensions(id), shuffled)
+		}
+	}
+}
+
+func BenchmarkUTLSApplyPreset(b *testing.B) {
+	config := &Config{ServerName: "example.com"}
+	for _, id := range []ClientHelloID{HelloChrome_131, HelloFirefox_120, HelloSafari_Auto, HelloIOS_Auto, HelloEdge_Auto, HelloRandomized} {
+		b.Run(id.Str(), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if err := UClient(&net.TCPConn{}, config, id).BuildHandshakeState(); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+	b.Run("UTLSIdToSpec", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			spec, err := UTLSIdToSpec(HelloChrome_120)
+			if err != nil {
+				b.Fatal(err)
+			}
+			if err := UClient(&net.TCPConn{}, config, HelloCustom).ApplyPreset(&spec); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("ClientHelloSpecPool", func(b *testing.B) {
+		pool, err := NewClientHelloSpecPool(HelloChrome_120)
+		if err != nil {
+			b.Fatal(err)
+		}
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			spec, err := pool.Get()
+			if err != nil {
+				b.Fatal(err)
+			}
+			if err := UClient(&net.TCPConn{}, config, HelloCustom).ApplyPreset(spec); err != nil {
+				b.Fatal(err)
+			}
+			pool.Put(spec)
+		}
+	})
+}
+
+func TestUTLSMarshalClientHelloAllocs(t *testing.T) {
+	uconn := UClient(&net.TCPConn{}, &Config{ServerName: "example.com"}, HelloChrome_131)
+	if err := uconn.BuildHandshakeState(); err != nil {
+		t.Fatalf("got error: %v; expected to succeed", err)
+	}
+	raw := uconn.HandshakeState.Hello.Raw
+	// The two buffers of the hello, and the errors of hostnameInSNI parsing the
+	// server name as an IP address. Integers are not boxed.
+	if n := testing.AllocsPerRun(100, func() {
+		if err := uconn.MarshalClientHelloNoECH(); err != nil {
+			t.Fatal(err)
+		}
+	}); n > 5 {
+		t.Errorf("got %v allocations marshaling the ClientHello, expected at most 5", n)
+	}
+	if !bytes.Equal(uconn.HandshakeState.Hello.Raw, raw) {
+		t.Error("marshaling the ClientHello again changed it")
+	}
+}
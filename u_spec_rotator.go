@@ -0,0 +1,173 @@
+// Copyright 2024 The uTLS Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tls
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+// RotationMode is how a SpecRotator picks the next ClientHello.
+type RotationMode int
+
+const (
+	// RotateRoundRobin uses the ClientHellos one after the other, in order.
+	RotateRoundRobin RotationMode = iota
+	// RotateWeighted picks a random ClientHello every time, each with a
+	// probability proportional to its Weight.
+	RotateWeighted
+)
+
+// RotatorEntry is a ClientHello used by a SpecRotator, either a ClientHelloID
+// or a ClientHelloSpec.
+type RotatorEntry struct {
+	HelloID ClientHelloID
+	// Spec, if not nil, is applied with HelloCustom instead of HelloID. A clone
+	// of it is used every time, so it can be shared by connections.
+	Spec *ClientHelloSpec
+	// Weight is the relative probability of the entry with RotateWeighted,
+	// which must be positive. It is ignored by RotateRoundRobin.
+	Weight int
+}
+
+// SpecRotator rotates between several ClientHellos, so that consecutive
+// connections don't all send the same fingerprint. Unlike Roller, it doesn't
+// retry with another ClientHello when a handshake fails. It is safe for
+// concurrent use.
+type SpecRotator struct {
+	// Config, if not nil, is cloned for every connection.
+	Config              *Config
+	TcpDialTimeout      time.Duration // zero means no timeout
+	TlsHandshakeTimeout time.Duration // zero means no timeout
+
+	mode    RotationMode
+	entries []RotatorEntry
+
+	mu          sync.Mutex
+	next        int // protected by mu
+	totalWeight int
+	r           *prng
+}
+
+// NewSpecRotator returns a SpecRotator between entries, in the given mode.
+func NewSpecRotator(mode RotationMode, entries ...RotatorEntry) (*SpecRotator, error) {
+	if len(entries) == 0 {
+		return nil, errors.New("tls: SpecRotator needs at least one ClientHello")
+	}
+	if mode != RotateRoundRobin && mode != RotateWeighted {
+		return nil, errors.New("tls: unknown RotationMode")
+	}
+	r, err := newPRNG()
+	if err != nil {
+		return nil, err
+	}
+	rotator := &SpecRotator{
+		mode:    mode,
+		entries: make([]RotatorEntry, len(entries)),
+		r:       r,
+	}
+	for i, entry := range entries {
+		if mode == RotateWeighted && entry.Weight <= 0 {
+			return nil, errors.New("tls: SpecRotator weights must be positive")
+		}
+		if entry.Spec != nil {
+			spec, err := entry.Spec.Clone()
+			if err != nil {
+				return nil, err
+			}
+			entry.HelloID, entry.Spec = HelloCustom, &spec
+		}
+		rotator.entries[i] = entry
+		rotator.totalWeight += entry.Weight
+	}
+	return rotator, nil
+}
+
+// NewBrowserSpecRotator returns a weighted SpecRotator between the latest
+// parrots of popular browsers, each chosen about as often as the browser is
+// used.
+func NewBrowserSpecRotator() (*SpecRotator, error) {
+	return NewSpecRotator(RotateWeighted,
+		RotatorEntry{HelloID: HelloChrome_Auto, Weight: 60},
+		RotatorEntry{HelloID: HelloIOS_Auto, Weight: 12},
+		RotatorEntry{HelloID: HelloSafari_Auto, Weight: 8},
+		RotatorEntry{HelloID: HelloEdge_Auto, Weight: 5},
+		RotatorEntry{HelloID: HelloFirefox_Auto, Weight: 3},
+	)
+}
+
+// Next returns the next ClientHello. Its Spec, if any, is a new clone.
+func (r *SpecRotator) Next() (RotatorEntry, error) {
+	r.mu.Lock()
+	var entry RotatorEntry
+	switch r.mode {
+	case RotateRoundRobin:
+		entry = r.entries[r.next]
+		r.next = (r.next + 1) % len(r.entries)
+	case RotateWeighted:
+		n := r.r.Intn(r.totalWeight)
+		for _, entry = range r.entries {
+			if n < entry.Weight {
+				break
+			}
+			n -= entry.Weight
+		}
+	}
+	r.mu.Unlock()
+
+	if entry.Spec != nil {
+		spec, err := entry.Spec.Clone()
+		if err != nil {
+			return RotatorEntry{}, err
+		}
+		entry.Spec = &spec
+	}
+	return entry, nil
+}
+
+// UClient returns a UConn to serverName with the next ClientHello, see
+// UClient.
+func (r *SpecRotator) UClient(conn net.Conn, serverName string) (*UConn, error) {
+	entry, err := r.Next()
+	if err != nil {
+		return nil, err
+	}
+	config := &Config{}
+	if r.Config != nil {
+		config = r.Config.Clone()
+	}
+	config.ServerName = serverName
+	uconn := UClient(conn, config, entry.HelloID)
+	if entry.Spec != nil {
+		if err := uconn.ApplyPreset(entry.Spec); err != nil {
+			return nil, err
+		}
+	}
+	return uconn, nil
+}
+
+// Dial connects to addr and completes a handshake with the next ClientHello.
+func (r *SpecRotator) Dial(network, addr, serverName string) (*UConn, error) {
+	conn, err := net.DialTimeout(network, addr, r.TcpDialTimeout)
+	if err != nil {
+		return nil, err
+	}
+	uconn, err := r.UClient(conn, serverName)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if r.TlsHandshakeTimeout != 0 {
+		uconn.SetDeadline(time.Now().Add(r.TlsHandshakeTimeout))
+	}
+	if err := uconn.Handshake(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	uconn.SetDeadline(time.Time{})
+	return uconn, nil
+}
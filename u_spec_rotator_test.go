@@ -0,0 +1,133 @@
+// Copyright 2024 The uTLS Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tls
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestUTLSSpecRotatorRoundRobin(t *testing.T) {
+	ids := []ClientHelloID{HelloChrome_120, HelloFirefox_120, HelloIOS_14}
+	var entries []RotatorEntry
+	for _, id := range ids {
+		entries = append(entries, RotatorEntry{HelloID: id})
+	}
+	r, err := NewSpecRotator(RotateRoundRobin, entries...)
+	if err != nil {
+		t.Fatalf("got error: %v; expected to succeed", err)
+	}
+	for i := 0; i < 2*len(ids); i++ {
+		entry, err := r.Next()
+		if err != nil {
+			t.Fatalf("got error: %v; expected to succeed", err)
+		}
+		if entry.HelloID != ids[i%len(ids)] {
+			t.Errorf("dial %d: got %s, expected %s", i, entry.HelloID.Str(), ids[i%len(ids)].Str())
+		}
+	}
+
+	// concurrent Dials still use every ClientHello in turn
+	counts := make(map[ClientHelloID]int)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 30; j++ {
+				entry, err := r.Next()
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				mu.Lock()
+				counts[entry.HelloID]++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	for _, id := range ids {
+		if counts[id] != 100 {
+			t.Errorf("%s was used %d times, expected 100", id.Str(), counts[id])
+		}
+	}
+}
+
+func TestUTLSSpecRotatorWeighted(t *testing.T) {
+	r, err := NewSpecRotator(RotateWeighted,
+		RotatorEntry{HelloID: HelloChrome_120, Weight: 3},
+		RotatorEntry{HelloID: HelloFirefox_120, Weight: 1},
+	)
+	if err != nil {
+		t.Fatalf("got error: %v; expected to succeed", err)
+	}
+	const n = 4000
+	chrome := 0
+	for i := 0; i < n; i++ {
+		entry, err := r.Next()
+		if err != nil {
+			t.Fatalf("got error: %v; expected to succeed", err)
+		}
+		if entry.HelloID == HelloChrome_120 {
+			chrome++
+		}
+	}
+	// 3000 expected, with a standard deviation of about 27
+	if chrome < 2800 || chrome > 3200 {
+		t.Errorf("HelloChrome_120 was picked %d times out of %d, expected about %d", chrome, n, n*3/4)
+	}
+
+	if _, err := NewSpecRotator(RotateWeighted, RotatorEntry{HelloID: HelloChrome_120}); err == nil {
+		t.Error("a weighted SpecRotator without weights was created, expected an error")
+	}
+	if _, err := NewBrowserSpecRotator(); err != nil {
+		t.Errorf("got error: %v; expected to succeed", err)
+	}
+}
+
+func TestUTLSSpecRotatorSpec(t *testing.T) {
+	r, err := NewSpecRotator(RotateRoundRobin,
+		RotatorEntry{Spec: mustSpec(t, HelloFirefox_120)},
+		RotatorEntry{HelloID: HelloChrome_120},
+	)
+	if err != nil {
+		t.Fatalf("got error: %v; expected to succeed", err)
+	}
+	first, err := r.Next()
+	if err != nil {
+		t.Fatalf("got error: %v; expected to succeed", err)
+	}
+	r.Next()
+	second, err := r.Next()
+	if err != nil {
+		t.Fatalf("got error: %v; expected to succeed", err)
+	}
+	if first.HelloID != HelloCustom || first.Spec == nil || first.Spec == second.Spec {
+		t.Fatal("expected a new clone of the spec applied with HelloCustom every time")
+	}
+
+	for i := 0; i < 2; i++ {
+		c, s := localPipe(t)
+		go func() {
+			server := Server(s, testConfig)
+			server.Handshake()
+			server.Close()
+		}()
+		r.Config = &Config{InsecureSkipVerify: true}
+		uconn, err := r.UClient(c, "example.golang")
+		if err != nil {
+			t.Fatalf("got error: %v; expected to succeed", err)
+		}
+		if err := uconn.Handshake(); err != nil {
+			t.Fatalf("handshake %d failed: %v", i, err)
+		}
+		if uconn.HandshakeState.Hello.ServerName != "example.golang" {
+			t.Errorf("got server name %q, expected example.golang", uconn.HandshakeState.Hello.ServerName)
+		}
+		uconn.Close()
+	}
+}
@@ -0,0 +1,121 @@
+// Copyright 2024 The uTLS Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tls
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+)
+
+// SpecErrorKind is the kind of inconsistency reported by
+// (*ClientHelloSpec).Validate.
+type SpecErrorKind uint8
+
+const (
+	// SpecErrorKeyShareGroup: a key_share group is not in supported_groups.
+	SpecErrorKeyShareGroup SpecErrorKind = iota
+	// SpecErrorALPSProtocol: an ALPS protocol is not offered in ALPN.
+	SpecErrorALPSProtocol
+	// SpecErrorTLS13CipherSuite: TLS 1.3 cipher suites are offered, but
+	// supported_versions doesn't advertise TLS 1.3.
+	SpecErrorTLS13CipherSuite
+	// SpecErrorPSKNotLast: pre_shared_key is not the last extension.
+	SpecErrorPSKNotLast
+	// SpecErrorPSKModeKeyShare: psk_key_exchange_modes offers psk_dhe_ke,
+	// but there is no key_share extension.
+	SpecErrorPSKModeKeyShare
+)
+
+// SpecError is an inconsistency between the fields of a ClientHelloSpec, which
+// no browser would send, as returned by (*ClientHelloSpec).Validate.
+type SpecError struct {
+	Kind SpecErrorKind
+
+	// Value is the key_share group for SpecErrorKeyShareGroup, the first TLS
+	// 1.3 cipher suite for SpecErrorTLS13CipherSuite, and the position of the
+	// extension for SpecErrorPSKNotLast.
+	Value uint16
+	// Protocol is the ALPS protocol for SpecErrorALPSProtocol.
+	Protocol string
+}
+
+func (e *SpecError) Error() string {
+	switch e.Kind {
+	case SpecErrorKeyShareGroup:
+		return fmt.Sprintf("tls: key_share group %s is not in supported_groups", specDiffGroupName(e.Value))
+	case SpecErrorALPSProtocol:
+		return fmt.Sprintf("tls: ALPS protocol %q is not offered in ALPN", e.Protocol)
+	case SpecErrorTLS13CipherSuite:
+		return fmt.Sprintf("tls: TLS 1.3 cipher suite %s is offered without TLS 1.3 in supported_versions", CipherSuiteName(e.Value))
+	case SpecErrorPSKNotLast:
+		return fmt.Sprintf("tls: pre_shared_key extension at position %d is not the last extension", e.Value)
+	case SpecErrorPSKModeKeyShare:
+		return "tls: psk_dhe_ke is offered without the key_share extension"
+	default:
+		return "tls: unknown ClientHelloSpec error " + strconv.Itoa(int(e.Kind))
+	}
+}
+
+// Validate returns the inconsistencies between the fields of chs that make the
+// ClientHello stand out, or nil if there are none. The errors are *SpecError,
+// so that callers can decide which ones to treat as fatal.
+//
+// GREASE values are compared as GREASE_PLACEHOLDER. Validate doesn't check
+// that the spec can be applied; ApplyPreset reports those errors.
+func (chs *ClientHelloSpec) Validate() []error {
+	var errs []error
+
+	var groups, keyShares []uint16
+	var alpn, alps []string
+	offersTLS13, haveKeyShare, offersPSKModeDHE := false, false, false
+	for i, ext := range chs.Extensions {
+		switch e := ext.(type) {
+		case *SupportedCurvesExtension:
+			groups = append(groups, specDiffGroups(e.Curves)...)
+		case *KeyShareExtension:
+			haveKeyShare = true
+			for _, ks := range e.KeyShares {
+				keyShares = append(keyShares, unGREASEUint16(uint16(ks.Group)))
+			}
+		case *SupportedVersionsExtension:
+			offersTLS13 = offersTLS13 || slices.Contains(e.Versions, VersionTLS13)
+		case *ALPNExtension:
+			alpn = append(alpn, e.AlpnProtocols...)
+		case *ApplicationSettingsExtension:
+			alps = append(alps, e.SupportedProtocols...)
+		case *PSKKeyExchangeModesExtension:
+			offersPSKModeDHE = offersPSKModeDHE || slices.Contains(e.Modes, PskModeDHE)
+		case PreSharedKeyExtension:
+			if i != len(chs.Extensions)-1 {
+				errs = append(errs, &SpecError{Kind: SpecErrorPSKNotLast, Value: uint16(i)})
+			}
+		}
+	}
+
+	for _, group := range keyShares {
+		if !slices.Contains(groups, group) {
+			errs = append(errs, &SpecError{Kind: SpecErrorKeyShareGroup, Value: group})
+		}
+	}
+	for _, proto := range alps {
+		if !slices.Contains(alpn, proto) {
+			errs = append(errs, &SpecError{Kind: SpecErrorALPSProtocol, Protocol: proto})
+		}
+	}
+	if offersPSKModeDHE && !haveKeyShare {
+		errs = append(errs, &SpecError{Kind: SpecErrorPSKModeKeyShare})
+	}
+	if !offersTLS13 {
+		for _, suite := range chs.CipherSuites {
+			if cipherSuiteTLS13ByID(suite) != nil {
+				errs = append(errs, &SpecError{Kind: SpecErrorTLS13CipherSuite, Value: suite})
+				break
+			}
+		}
+	}
+
+	return errs
+}
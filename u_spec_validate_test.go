@@ -0,0 +1,60 @@
+// Copyright 2024 The uTLS Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tls
+
+import (
+	"errors"
+	"slices"
+	"testing"
+)
+
+func TestUTLSValidateParrots(t *testing.T) {
+	for _, id := range parrotHelloIDs {
+		spec, err := UTLSIdToSpec(id)
+		if err != nil {
+			t.Fatalf("%s: got error: %v; expected to succeed", id.Str(), err)
+		}
+		if errs := spec.Validate(); errs != nil {
+			t.Errorf("%s: got errors %v, expected a consistent spec", id.Str(), errs)
+		}
+	}
+}
+
+func TestUTLSValidate(t *testing.T) {
+	spec := ClientHelloSpec{
+		CipherSuites: []uint16{TLS_AES_128_GCM_SHA256, TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256},
+		Extensions: []TLSExtension{
+			&SupportedCurvesExtension{Curves: []CurveID{GREASE_PLACEHOLDER, X25519}},
+			&KeyShareExtension{KeyShares: []KeyShare{{Group: 0x2a2a, Data: []byte{0}}, {Group: X25519}, {Group: CurveP256}}},
+			&UtlsPreSharedKeyExtension{},
+			&SupportedVersionsExtension{Versions: []uint16{VersionTLS12}},
+			&ALPNExtension{AlpnProtocols: []string{"http/1.1"}},
+			&ApplicationSettingsExtension{SupportedProtocols: []string{"h2"}},
+		},
+	}
+
+	var kinds []SpecErrorKind
+	for _, err := range spec.Validate() {
+		var specErr *SpecError
+		if !errors.As(err, &specErr) {
+			t.Fatalf("got error %v of type %T, expected *SpecError", err, err)
+		}
+		kinds = append(kinds, specErr.Kind)
+		switch specErr.Kind {
+		case SpecErrorKeyShareGroup:
+			if CurveID(specErr.Value) != CurveP256 {
+				t.Errorf("got key_share group %v, expected only CurveP256 to be reported", CurveID(specErr.Value))
+			}
+		case SpecErrorPSKNotLast:
+			if specErr.Value != 2 {
+				t.Errorf("got pre_shared_key at position %d, expected 2", specErr.Value)
+			}
+		}
+	}
+	want := []SpecErrorKind{SpecErrorPSKNotLast, SpecErrorKeyShareGroup, SpecErrorALPSProtocol, SpecErrorTLS13CipherSuite}
+	if !slices.Equal(kinds, want) {
+		t.Errorf("got error kinds %v, expected %v", kinds, want)
+	}
+}
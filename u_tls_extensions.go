@@ -5,18 +5,31 @@
 package tls
 
 import (
+	"bytes"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"slices"
 	"strings"
 
 	"github.com/refraction-networking/utls/dicttls"
 	"golang.org/x/crypto/cryptobyte"
 )
 
-// ExtensionFromID returns a TLSExtension for the given extension ID.
+// ExtensionFromID returns a TLSExtension for the given extension ID, or nil
+// if it is unknown. Extensions registered with RegisterExtension are returned
+// as well.
 func ExtensionFromID(id uint16) TLSExtension {
+	if factory := registeredExtension(id); factory != nil {
+		return factory()
+	}
+	return builtinExtensionFromID(id)
+}
+
+// builtinExtensionFromID returns the implementation of this package of the
+// extension with the given ID, or nil.
+func builtinExtensionFromID(id uint16) TLSExtension {
 	// deep copy
 	switch id {
 	case extensionServerName:
@@ -45,6 +58,10 @@ func ExtensionFromID(id uint16) TLSExtension {
 		return &UtlsCompressCertExtension{}
 	case fakeRecordSizeLimit:
 		return &FakeRecordSizeLimitExtension{}
+	case fakeExtensionMaxFragmentLength:
+		return &FakeMaxFragmentLengthExtension{}
+	case fakeExtensionHeartbeat:
+		return &FakeHeartbeatExtension{}
 	case fakeExtensionDelegatedCredentials:
 		return &FakeDelegatedCredentialsExtension{}
 	case extensionSessionTicket:
@@ -55,8 +72,8 @@ func ExtensionFromID(id uint16) TLSExtension {
 	// 	return &EarlyDataExtension{}
 	case extensionSupportedVersions:
 		return &SupportedVersionsExtension{}
-	// case extensionCookie:
-	// 	return &CookieExtension{}
+	case extensionCookie:
+		return &CookieExtension{}
 	case extensionPSKModes:
 		return &PSKKeyExchangeModesExtension{}
 	// case extensionCertificateAuthorities:
@@ -71,6 +88,8 @@ func ExtensionFromID(id uint16) TLSExtension {
 		return &NPNExtension{}
 	case utlsExtensionApplicationSettings:
 		return &ApplicationSettingsExtension{}
+	case utlsExtensionApplicationSettingsNew:
+		return &ApplicationSettingsExtension{CodePoint: utlsExtensionApplicationSettingsNew}
 	case fakeOldExtensionChannelID:
 		return &FakeChannelIDExtension{true}
 	case fakeExtensionChannelID:
@@ -87,6 +106,234 @@ func ExtensionFromID(id uint16) TLSExtension {
 	}
 }
 
+// ExtensionFromBytes returns the extension sent with codepoint and data, the
+// extension body without its type and length, e.g. from a captured
+// ClientHello. Known extensions are parsed into their typed implementation,
+// with a FakePreSharedKeyExtension for pre_shared_key, and a GenericExtension
+// holding a copy of data is returned for unknown codepoints or data that
+// doesn't parse.
+//
+// As with ClientHelloSpecs built by a Fingerprinter, values that only depend
+// on the connection are not kept: the padding length follows
+// BoringPaddingStyle, and key shares and GREASE values are generated again by
+// ApplyPreset.
+func ExtensionFromBytes(codepoint uint16, data []byte) TLSExtension {
+	if ext, ok := ExtensionFromID(codepoint).(TLSExtensionWriter); ok {
+		// parsing may keep slices of data
+		if _, err := ext.Write(bytes.Clone(data)); err == nil {
+			if grease, ok := ext.(*UtlsGREASEExtension); ok {
+				grease.Value = codepoint
+			}
+			return ext
+		}
+	}
+	return &GenericExtension{Id: codepoint, Data: bytes.Clone(data)}
+}
+
+// extensionIDOf returns the extension type that ext is sent with.
+// It is the inverse of ExtensionFromID, and ok is false for unknown implementations.
+func extensionIDOf(ext TLSExtension) (id uint16, ok bool) {
+	switch e := ext.(type) {
+	case *SNIExtension:
+		return extensionServerName, true
+	case *StatusRequestExtension:
+		return extensionStatusRequest, true
+	case *SupportedCurvesExtension:
+		return extensionSupportedCurves, true
+	case *SupportedPointsExtension:
+		return extensionSupportedPoints, true
+	case *SignatureAlgorithmsExtension:
+		return extensionSignatureAlgorithms, true
+	case *ALPNExtension:
+		return extensionALPN, true
+	case *StatusRequestV2Extension:
+		return extensionStatusRequestV2, true
+	case *SCTExtension:
+		return extensionSCT, true
+	case *UtlsPaddingExtension:
+		return utlsExtensionPadding, true
+	case *ExtendedMasterSecretExtension:
+		return extensionExtendedMasterSecret, true
+	case *FakeTokenBindingExtension:
+		return fakeExtensionTokenBinding, true
+	case *UtlsCompressCertExtension:
+		return utlsExtensionCompressCertificate, true
+	case *FakeRecordSizeLimitExtension:
+		return fakeRecordSizeLimit, true
+	case *FakeMaxFragmentLengthExtension:
+		return fakeExtensionMaxFragmentLength, true
+	case *FakeHeartbeatExtension:
+		return fakeExtensionHeartbeat, true
+	case *FakeDelegatedCredentialsExtension:
+		return fakeExtensionDelegatedCredentials, true
+	case *SessionTicketExtension:
+		return extensionSessionTicket, true
+	case PreSharedKeyExtension:
+		return extensionPreSharedKey, true
+	case *EarlyDataExtension:
+		return extensionEarlyData, true
+	case *SupportedVersionsExtension:
+		return extensionSupportedVersions, true
+	case *CookieExtension:
+		return extensionCookie, true
+	case *PSKKeyExchangeModesExtension:
+		return extensionPSKModes, true
+	case *SignatureAlgorithmsCertExtension:
+		return extensionSignatureAlgorithmsCert, true
+	case *KeyShareExtension:
+		return extensionKeyShare, true
+	case *QUICTransportParametersExtension:
+		return extensionQUICTransportParameters, true
+	case *NPNExtension:
+		return extensionNextProtoNeg, true
+	case *ApplicationSettingsExtension:
+		return e.codePoint(), true
+	case *FakeChannelIDExtension:
+		if e.OldExtensionID {
+			return fakeOldExtensionChannelID, true
+		}
+		return fakeExtensionChannelID, true
+	case EncryptedClientHelloExtension:
+		return utlsExtensionECH, true
+	case *RenegotiationInfoExtension:
+		return extensionRenegotiationInfo, true
+	case *UtlsGREASEExtension:
+		if !isGREASEUint16(e.Value) {
+			return GREASE_PLACEHOLDER, true // value is assigned by ApplyPreset
+		}
+		return e.Value, true
+	case *GenericExtension:
+		return e.Id, true
+	default:
+		return 0, false
+	}
+}
+
+// extensionHasCodepoint reports whether ext is sent with codepoint. Any GREASE
+// codepoint matches all GREASE extensions, whose values are only assigned by
+// ApplyPreset.
+func extensionHasCodepoint(ext TLSExtension, codepoint uint16) bool {
+	id, ok := extensionIDOf(ext)
+	return ok && (id == codepoint || isGREASEUint16(id) && isGREASEUint16(codepoint))
+}
+
+// cloneExtension returns a deep copy of ext that can be applied to another
+// connection. Values that are specific to a connection are not copied: key
+// shares are emptied so that new keys are generated, GREASE values become
+// placeholders, and session tickets, PSKs and the SNI are left unset (but
+// SNIExtension.Names is copied).
+func cloneExtension(ext TLSExtension) (TLSExtension, error) {
+	unGREASE := func(values []uint16) []uint16 {
+		if values == nil {
+			return nil
+		}
+		res := make([]uint16, len(values))
+		for i, v := range values {
+			res[i] = unGREASEUint16(v)
+		}
+		return res
+	}
+
+	switch e := ext.(type) {
+	case *SNIExtension:
+		var names []SNIServerName
+		if e.Names != nil {
+			names = make([]SNIServerName, len(e.Names))
+			for i, name := range e.Names {
+				names[i] = SNIServerName{name.NameType, bytes.Clone(name.Name)}
+			}
+		}
+		return &SNIExtension{Names: names}, nil
+	case *StatusRequestExtension:
+		return &StatusRequestExtension{}, nil
+	case *SupportedCurvesExtension:
+		var curves []CurveID
+		for _, curve := range e.Curves {
+			curves = append(curves, CurveID(unGREASEUint16(uint16(curve))))
+		}
+		return &SupportedCurvesExtension{Curves: curves}, nil
+	case *SupportedPointsExtension:
+		return &SupportedPointsExtension{SupportedPoints: slices.Clone(e.SupportedPoints)}, nil
+	case *SignatureAlgorithmsExtension:
+		return &SignatureAlgorithmsExtension{SupportedSignatureAlgorithms: slices.Clone(e.SupportedSignatureAlgorithms)}, nil
+	case *ALPNExtension:
+		return &ALPNExtension{AlpnProtocols: slices.Clone(e.AlpnProtocols)}, nil
+	case *StatusRequestV2Extension:
+		return &StatusRequestV2Extension{StatusTypes: slices.Clone(e.StatusTypes)}, nil
+	case *SCTExtension:
+		return &SCTExtension{}, nil
+	case *UtlsPaddingExtension:
+		return &UtlsPaddingExtension{PaddingLen: e.PaddingLen, WillPad: e.WillPad, GetPaddingLen: e.GetPaddingLen}, nil
+	case *ExtendedMasterSecretExtension:
+		return &ExtendedMasterSecretExtension{}, nil
+	case *FakeTokenBindingExtension:
+		return &FakeTokenBindingExtension{MajorVersion: e.MajorVersion, MinorVersion: e.MinorVersion, KeyParameters: slices.Clone(e.KeyParameters)}, nil
+	case *UtlsCompressCertExtension:
+		return &UtlsCompressCertExtension{Algorithms: slices.Clone(e.Algorithms)}, nil
+	case *FakeRecordSizeLimitExtension:
+		return &FakeRecordSizeLimitExtension{Limit: e.Limit}, nil
+	case *FakeMaxFragmentLengthExtension:
+		return &FakeMaxFragmentLengthExtension{Code: e.Code}, nil
+	case *FakeHeartbeatExtension:
+		return &FakeHeartbeatExtension{Mode: e.Mode}, nil
+	case *FakeDelegatedCredentialsExtension:
+		return &FakeDelegatedCredentialsExtension{SupportedSignatureAlgorithms: slices.Clone(e.SupportedSignatureAlgorithms)}, nil
+	case *SessionTicketExtension:
+		return &SessionTicketExtension{}, nil
+	case *UtlsPreSharedKeyExtension:
+		return &UtlsPreSharedKeyExtension{OmitEmptyPsk: e.OmitEmptyPsk}, nil
+	case *FakePreSharedKeyExtension:
+		psk := &FakePreSharedKeyExtension{OmitEmptyPsk: e.OmitEmptyPsk}
+		for _, identity := range e.Identities {
+			psk.Identities = append(psk.Identities, PskIdentity{Label: bytes.Clone(identity.Label), ObfuscatedTicketAge: identity.ObfuscatedTicketAge})
+		}
+		for _, binder := range e.Binders {
+			psk.Binders = append(psk.Binders, bytes.Clone(binder))
+		}
+		return psk, nil
+	case *SupportedVersionsExtension:
+		return &SupportedVersionsExtension{Versions: unGREASE(e.Versions)}, nil
+	case *CookieExtension:
+		return &CookieExtension{Cookie: bytes.Clone(e.Cookie)}, nil
+	case *PSKKeyExchangeModesExtension:
+		return &PSKKeyExchangeModesExtension{Modes: slices.Clone(e.Modes)}, nil
+	case *SignatureAlgorithmsCertExtension:
+		return &SignatureAlgorithmsCertExtension{SupportedSignatureAlgorithms: slices.Clone(e.SupportedSignatureAlgorithms)}, nil
+	case *KeyShareExtension:
+		var keyShares []KeyShare
+		for _, ks := range e.KeyShares {
+			if isGREASEUint16(uint16(ks.Group)) {
+				keyShares = append(keyShares, KeyShare{Group: CurveID(GREASE_PLACEHOLDER), Data: bytes.Clone(ks.Data)})
+			} else {
+				keyShares = append(keyShares, KeyShare{Group: ks.Group})
+			}
+		}
+		return &KeyShareExtension{KeyShares: keyShares}, nil
+	case *QUICTransportParametersExtension:
+		return &QUICTransportParametersExtension{TransportParameters: e.TransportParameters.clone(), marshalResult: bytes.Clone(e.marshalResult)}, nil
+	case *NPNExtension:
+		return &NPNExtension{NextProtos: slices.Clone(e.NextProtos)}, nil
+	case *ApplicationSettingsExtension:
+		return &ApplicationSettingsExtension{CodePoint: e.CodePoint, SupportedProtocols: slices.Clone(e.SupportedProtocols)}, nil
+	case *FakeChannelIDExtension:
+		return &FakeChannelIDExtension{OldExtensionID: e.OldExtensionID}, nil
+	case *GREASEEncryptedClientHelloExtension:
+		return &GREASEEncryptedClientHelloExtension{
+			CandidateCipherSuites: slices.Clone(e.CandidateCipherSuites),
+			CandidateConfigIds:    slices.Clone(e.CandidateConfigIds),
+			CandidatePayloadLens:  slices.Clone(e.CandidatePayloadLens),
+		}, nil
+	case *RenegotiationInfoExtension:
+		return &RenegotiationInfoExtension{Renegotiation: e.Renegotiation}, nil
+	case *UtlsGREASEExtension:
+		return &UtlsGREASEExtension{Body: bytes.Clone(e.Body)}, nil
+	case *GenericExtension:
+		return &GenericExtension{Id: e.Id, Data: bytes.Clone(e.Data)}, nil
+	default:
+		return nil, fmt.Errorf("tls: cannot copy extension of type %T", ext)
+	}
+}
+
 type TLSExtension interface {
 	writeToUConn(*UConn) error
 
@@ -120,9 +367,27 @@ type TLSExtensionJSON interface {
 // SNIExtension implements server_name (0)
 type SNIExtension struct {
 	ServerName string // not an array because go crypto/tls doesn't support multiple SNIs
+
+	// Names, if not nil, is sent as the server_name list instead of ServerName,
+	// exactly as given, e.g. to send several names or names of other types.
+	// ServerName is still used to verify the server certificate.
+	Names []SNIServerName
+}
+
+// SNIServerName is an entry of the server_name list of an SNIExtension.
+type SNIServerName struct {
+	NameType uint8 // 0 is host_name
+	Name     []byte
 }
 
 func (e *SNIExtension) Len() int {
+	if e.Names != nil {
+		n := 4 + 2
+		for _, name := range e.Names {
+			n += 1 + 2 + len(name.Name)
+		}
+		return n
+	}
 	// Literal IP addresses, absolute FQDNs, and empty strings are not permitted as SNI values.
 	// See RFC 6066, Section 3.
 	hostName := hostnameInSNI(e.ServerName)
@@ -133,15 +398,22 @@ func (e *SNIExtension) Len() int {
 }
 
 func (e *SNIExtension) Read(b []byte) (int, error) {
+	if e.Names != nil {
+		return e.readNames(b)
+	}
 	// Literal IP addresses, absolute FQDNs, and empty strings are not permitted as SNI values.
 	// See RFC 6066, Section 3.
 	hostName := hostnameInSNI(e.ServerName)
 	if len(hostName) == 0 {
 		return 0, io.EOF
 	}
-	if len(b) < e.Len() {
+	extLen := 4 + 2 + 1 + 2 + len(hostName) // e.Len(), without parsing the name again
+	if len(b) < extLen {
 		return 0, io.ErrShortBuffer
 	}
+	if extLen-4 > 0xffff {
+		return 0, errors.New("tls: server name is too long")
+	}
 	// RFC 3546, section 3.1
 	b[0] = byte(extensionServerName >> 8)
 	b[1] = byte(extensionServerName)
@@ -152,7 +424,35 @@ func (e *SNIExtension) Read(b []byte) (int, error) {
 	// b[6] Server Name Type: host_name (0)
 	b[7] = byte(len(hostName) >> 8)
 	b[8] = byte(len(hostName))
-	copy(b[9:], []byte(hostName))
+	copy(b[9:], hostName)
+	return extLen, io.EOF
+}
+
+func (e *SNIExtension) readNames(b []byte) (int, error) {
+	if len(b) < e.Len() {
+		return 0, io.ErrShortBuffer
+	}
+	if e.Len()-4 > 0xffff {
+		return 0, errors.New("tls: server_name list is too long")
+	}
+	for _, name := range e.Names {
+		if len(name.Name) > 0xffff {
+			return 0, errors.New("tls: server name is too long")
+		}
+	}
+	b[0] = byte(extensionServerName >> 8)
+	b[1] = byte(extensionServerName)
+	b[2] = byte((e.Len() - 4) >> 8)
+	b[3] = byte(e.Len() - 4)
+	b[4] = byte((e.Len() - 6) >> 8)
+	b[5] = byte(e.Len() - 6)
+	i := 6
+	for _, name := range e.Names {
+		b[i] = name.NameType
+		b[i+1] = byte(len(name.Name) >> 8)
+		b[i+2] = byte(len(name.Name))
+		i += 3 + copy(b[i+3:], name.Name)
+	}
 	return e.Len(), io.EOF
 }
 
@@ -160,6 +460,10 @@ func (e *SNIExtension) UnmarshalJSON(_ []byte) error {
 	return nil // no-op
 }
 
+func (e *SNIExtension) MarshalJSON() ([]byte, error) {
+	return marshalExtensionName(extensionServerName)
+}
+
 // Write is a no-op for StatusRequestExtension.
 // SNI should not be fingerprinted and is user controlled.
 func (e *SNIExtension) Write(b []byte) (int, error) {
@@ -230,6 +534,10 @@ func (e *StatusRequestExtension) UnmarshalJSON(_ []byte) error {
 	return nil // no-op
 }
 
+func (e *StatusRequestExtension) MarshalJSON() ([]byte, error) {
+	return marshalExtensionName(extensionStatusRequest)
+}
+
 // Write is a no-op for StatusRequestExtension. No data for this extension.
 func (e *StatusRequestExtension) Write(b []byte) (int, error) {
 	fullLen := len(b)
@@ -305,6 +613,17 @@ func (e *SupportedCurvesExtension) UnmarshalJSON(data []byte) error {
 	return nil
 }
 
+func (e *SupportedCurvesExtension) MarshalJSON() ([]byte, error) {
+	namedGroups, err := jsonNames(e.Curves, dicttls.DictSupportedGroupsValueIndexed, "named group")
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(struct {
+		Name           string   `json:"name"`
+		NamedGroupList []string `json:"named_group_list"`
+	}{"supported_groups", namedGroups})
+}
+
 func (e *SupportedCurvesExtension) Write(b []byte) (int, error) {
 	fullLen := len(b)
 	extData := cryptobyte.String(b)
@@ -333,6 +652,8 @@ func (e *SupportedCurvesExtension) writeToUConn(uc *UConn) error {
 
 // SupportedPointsExtension implements ec_point_formats (11)
 type SupportedPointsExtension struct {
+	// SupportedPoints is sent as is, in this order, and is part of the JA3
+	// fingerprint. Browsers typically only send pointFormatUncompressed.
 	SupportedPoints []uint8
 }
 
@@ -344,6 +665,9 @@ func (e *SupportedPointsExtension) Read(b []byte) (int, error) {
 	if len(b) < e.Len() {
 		return 0, io.ErrShortBuffer
 	}
+	if len(e.SupportedPoints) > 255 {
+		return 0, errors.New("too many point formats")
+	}
 	// http://tools.ietf.org/html/rfc4492#section-5.5.2
 	b[0] = byte(extensionSupportedPoints >> 8)
 	b[1] = byte(extensionSupportedPoints)
@@ -374,6 +698,17 @@ func (e *SupportedPointsExtension) UnmarshalJSON(data []byte) error {
 	return nil
 }
 
+func (e *SupportedPointsExtension) MarshalJSON() ([]byte, error) {
+	pointFormats, err := jsonNames(e.SupportedPoints, dicttls.DictECPointFormatValueIndexed, "point format")
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(struct {
+		Name              string   `json:"name"`
+		ECPointFormatList []string `json:"ec_point_format_list"`
+	}{"ec_point_formats", pointFormats})
+}
+
 func (e *SupportedPointsExtension) Write(b []byte) (int, error) {
 	fullLen := len(b)
 	extData := cryptobyte.String(b)
@@ -442,6 +777,17 @@ func (e *SignatureAlgorithmsExtension) UnmarshalJSON(data []byte) error {
 	return nil
 }
 
+func (e *SignatureAlgorithmsExtension) MarshalJSON() ([]byte, error) {
+	sigSchemes, err := jsonNames(e.SupportedSignatureAlgorithms, dicttls.DictSignatureSchemeValueIndexed, "signature scheme")
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(struct {
+		Name       string   `json:"name"`
+		Algorithms []string `json:"supported_signature_algorithms"`
+	}{"signature_algorithms", sigSchemes})
+}
+
 func (e *SignatureAlgorithmsExtension) Write(b []byte) (int, error) {
 	fullLen := len(b)
 	extData := cryptobyte.String(b)
@@ -470,49 +816,83 @@ func (e *SignatureAlgorithmsExtension) writeToUConn(uc *UConn) error {
 
 // StatusRequestV2Extension implements status_request_v2 (17)
 type StatusRequestV2Extension struct {
+	// StatusTypes are the status_type of each CertificateStatusRequestItemV2,
+	// in order, each sent with empty responder_id_list and
+	// request_extensions. If empty, a single ocsp_multi (2) item is sent.
+	StatusTypes []uint8
 }
 
+// FakeStatusRequestV2Extension is an alias of StatusRequestV2Extension: the
+// client never processes the status_request_v2 response.
+type FakeStatusRequestV2Extension = StatusRequestV2Extension
+
 func (e *StatusRequestV2Extension) writeToUConn(uc *UConn) error {
 	uc.HandshakeState.Hello.OcspStapling = true
 	return nil
 }
 
+func (e *StatusRequestV2Extension) statusTypes() []uint8 {
+	if len(e.StatusTypes) == 0 {
+		return []uint8{statusV2TypeOCSP}
+	}
+	return e.StatusTypes
+}
+
 func (e *StatusRequestV2Extension) Len() int {
-	return 13
+	return 4 + 2 + 7*len(e.statusTypes())
 }
 
 func (e *StatusRequestV2Extension) Read(b []byte) (int, error) {
 	if len(b) < e.Len() {
 		return 0, io.ErrShortBuffer
 	}
-	// RFC 4366, section 3.6
+	// RFC 6961, section 2.2
+	statusTypes := e.statusTypes()
 	b[0] = byte(extensionStatusRequestV2 >> 8)
 	b[1] = byte(extensionStatusRequestV2)
-	b[2] = 0
-	b[3] = 9
-	b[4] = 0
-	b[5] = 7
-	b[6] = 2 // OCSP type
-	b[7] = 0
-	b[8] = 4
-	// Two zero valued uint16s for the two lengths.
+	b[2] = byte((e.Len() - 4) >> 8)
+	b[3] = byte(e.Len() - 4)
+	b[4] = byte((e.Len() - 6) >> 8)
+	b[5] = byte(e.Len() - 6)
+	for i, statusType := range statusTypes {
+		item := b[6+7*i:]
+		item[0] = statusType
+		item[1] = 0
+		item[2] = 4
+		// Two zero valued uint16s for the two lengths.
+		clear(item[3:7])
+	}
 	return e.Len(), io.EOF
 }
 
-// Write is a no-op for StatusRequestV2Extension. No data for this extension.
+// Write reads the status types of the CertificateStatusRequestItemV2 list.
+// Responder IDs and request extensions are not kept.
 func (e *StatusRequestV2Extension) Write(b []byte) (int, error) {
 	fullLen := len(b)
 	extData := cryptobyte.String(b)
-	// RFC 4366, Section 3.6
-	var statusType uint8
-	var ignored cryptobyte.String
-	if !extData.ReadUint16LengthPrefixed(&ignored) || !ignored.ReadUint8(&statusType) {
+	// RFC 6961, section 2.2
+	var items cryptobyte.String
+	if !extData.ReadUint16LengthPrefixed(&items) || items.Empty() || !extData.Empty() {
 		return fullLen, errors.New("unable to read status request v2 extension data")
 	}
 
-	if statusType != statusV2TypeOCSP {
-		return fullLen, errors.New("status request v2 extension statusType is not statusV2TypeOCSP(2)")
+	var statusTypes []uint8
+	for !items.Empty() {
+		var statusType uint8
+		var request, ignored cryptobyte.String
+		if !items.ReadUint8(&statusType) ||
+			!items.ReadUint16LengthPrefixed(&request) ||
+			!request.ReadUint16LengthPrefixed(&ignored) ||
+			!request.ReadUint16LengthPrefixed(&ignored) ||
+			!request.Empty() {
+			return fullLen, errors.New("unable to read status request v2 extension data")
+		}
+		if statusType != statusTypeOCSP && statusType != statusV2TypeOCSP {
+			return fullLen, errors.New("status request v2 extension statusType is not statusTypeOCSP(1) or statusV2TypeOCSP(2)")
+		}
+		statusTypes = append(statusTypes, statusType)
 	}
+	e.StatusTypes = statusTypes
 
 	return fullLen, nil
 }
@@ -521,6 +901,10 @@ func (e *StatusRequestV2Extension) UnmarshalJSON(_ []byte) error {
 	return nil // no-op
 }
 
+func (e *StatusRequestV2Extension) MarshalJSON() ([]byte, error) {
+	return marshalExtensionName(extensionStatusRequestV2)
+}
+
 // SignatureAlgorithmsCertExtension implements signature_algorithms_cert (50)
 type SignatureAlgorithmsCertExtension struct {
 	SupportedSignatureAlgorithms []SignatureScheme
@@ -572,6 +956,17 @@ func (e *SignatureAlgorithmsCertExtension) UnmarshalJSON(data []byte) error {
 	return nil
 }
 
+func (e *SignatureAlgorithmsCertExtension) MarshalJSON() ([]byte, error) {
+	sigSchemes, err := jsonNames(e.SupportedSignatureAlgorithms, dicttls.DictSignatureSchemeValueIndexed, "signature scheme")
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(struct {
+		Name       string   `json:"name"`
+		Algorithms []string `json:"supported_signature_algorithms"`
+	}{"signature_algorithms_cert", sigSchemes})
+}
+
 // Write implementation copied from SignatureAlgorithmsExtension.Write
 //
 // Warning: not tested.
@@ -661,6 +1056,13 @@ func (e *ALPNExtension) UnmarshalJSON(b []byte) error {
 	return nil
 }
 
+func (e *ALPNExtension) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Name             string   `json:"name"`
+		ProtocolNameList []string `json:"protocol_name_list"`
+	}{"application_layer_protocol_negotiation", e.AlpnProtocols})
+}
+
 func (e *ALPNExtension) Write(b []byte) (int, error) {
 	fullLen := len(b)
 	extData := cryptobyte.String(b)
@@ -686,10 +1088,22 @@ func (e *ALPNExtension) Write(b []byte) (int, error) {
 // At the time of this writing, this extension is currently a draft:
 // https://datatracker.ietf.org/doc/html/draft-vvv-tls-alps-01
 type ApplicationSettingsExtension struct {
+	// CodePoint is the extension type to send. Chrome moved from the original 17513
+	// to 17613 in version 131. If zero, 17513 is used.
+	CodePoint          uint16
 	SupportedProtocols []string
 }
 
+func (e *ApplicationSettingsExtension) codePoint() uint16 {
+	if e.CodePoint == 0 {
+		return utlsExtensionApplicationSettings
+	}
+	return e.CodePoint
+}
+
 func (e *ApplicationSettingsExtension) writeToUConn(uc *UConn) error {
+	// the client's application settings must be sent with the same codepoint
+	uc.utls.applicationSettingsCodepoint = e.codePoint()
 	return nil
 }
 
@@ -707,8 +1121,8 @@ func (e *ApplicationSettingsExtension) Read(b []byte) (int, error) {
 	}
 
 	// Read Type.
-	b[0] = byte(utlsExtensionApplicationSettings >> 8)   // hex: 44 dec: 68
-	b[1] = byte(utlsExtensionApplicationSettings & 0xff) // hex: 69 dec: 105
+	b[0] = byte(e.codePoint() >> 8)   // hex: 44 dec: 68
+	b[1] = byte(e.codePoint() & 0xff) // hex: 69 (or cd) dec: 105 (or 205)
 
 	lengths := b[2:] // get the remaining buffer without Type
 	b = b[6:]        // set the buffer to the buffer without Type, Length and ALPS Extension Length (so only the Supported ALPN list remains)
@@ -744,6 +1158,17 @@ func (e *ApplicationSettingsExtension) UnmarshalJSON(b []byte) error {
 	return nil
 }
 
+func (e *ApplicationSettingsExtension) MarshalJSON() ([]byte, error) {
+	name, err := extensionJSONName(e.codePoint())
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(struct {
+		Name               string   `json:"name"`
+		SupportedProtocols []string `json:"supported_protocols"`
+	}{name, e.SupportedProtocols})
+}
+
 // Write implementation copied from ALPNExtension.Write
 func (e *ApplicationSettingsExtension) Write(b []byte) (int, error) {
 	fullLen := len(b)
@@ -794,6 +1219,10 @@ func (e *SCTExtension) UnmarshalJSON(_ []byte) error {
 	return nil // no-op
 }
 
+func (e *SCTExtension) MarshalJSON() ([]byte, error) {
+	return marshalExtensionName(extensionSCT)
+}
+
 func (e *SCTExtension) Write(_ []byte) (int, error) {
 	return 0, nil
 }
@@ -831,15 +1260,18 @@ func (e *GenericExtension) Read(b []byte) (int, error) {
 
 func (e *GenericExtension) UnmarshalJSON(b []byte) error {
 	var genericExtension struct {
-		Name string `json:"name"`
-		Data []byte `json:"data"`
+		Name string   `json:"name"`
+		Id   *uint16  `json:"id"`
+		Data hexBytes `json:"data"`
 	}
 	if err := json.Unmarshal(b, &genericExtension); err != nil {
 		return err
 	}
 
-	// lookup extension ID by name
-	if id, ok := dicttls.DictExtTypeNameIndexed[genericExtension.Name]; ok {
+	// lookup extension ID by name, unless it is given
+	if genericExtension.Id != nil {
+		e.Id = *genericExtension.Id
+	} else if id, ok := dicttls.DictExtTypeNameIndexed[genericExtension.Name]; ok {
 		e.Id = id
 	} else {
 		return fmt.Errorf("unknown extension name %s", genericExtension.Name)
@@ -848,6 +1280,14 @@ func (e *GenericExtension) UnmarshalJSON(b []byte) error {
 	return nil
 }
 
+func (e *GenericExtension) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Name string   `json:"name,omitempty"`
+		Id   uint16   `json:"id"`
+		Data hexBytes `json:"data"`
+	}{dicttls.DictExtTypeValueIndexed[e.Id], e.Id, e.Data})
+}
+
 // ExtendedMasterSecretExtension implements extended_master_secret (23)
 //
 // Was named as ExtendedMasterSecretExtension, renamed due to crypto/tls
@@ -881,11 +1321,55 @@ func (e *ExtendedMasterSecretExtension) UnmarshalJSON(_ []byte) error {
 	return nil // no-op
 }
 
+func (e *ExtendedMasterSecretExtension) MarshalJSON() ([]byte, error) {
+	return marshalExtensionName(extensionExtendedMasterSecret)
+}
+
 func (e *ExtendedMasterSecretExtension) Write(_ []byte) (int, error) {
 	// https://tools.ietf.org/html/rfc7627
 	return 0, nil
 }
 
+// EarlyDataExtension is the early_data extension of a ClientHello, which
+// announces that 0-RTT data follows it. It is added by uTLS when resuming a
+// session that allows it (see UConn.WriteEarlyData), and is only meaningful
+// right before the pre_shared_key extension.
+type EarlyDataExtension struct{}
+
+func (e *EarlyDataExtension) writeToUConn(uc *UConn) error {
+	return nil
+}
+
+func (e *EarlyDataExtension) Len() int {
+	return 4
+}
+
+func (e *EarlyDataExtension) Read(b []byte) (int, error) {
+	if len(b) < e.Len() {
+		return 0, io.ErrShortBuffer
+	}
+	// https://datatracker.ietf.org/doc/html/rfc8446#section-4.2.10
+	b[0] = byte(extensionEarlyData >> 8)
+	b[1] = byte(extensionEarlyData)
+	// The length is 0
+	return e.Len(), io.EOF
+}
+
+func (e *EarlyDataExtension) UnmarshalJSON(_ []byte) error {
+	return nil // no-op
+}
+
+func (e *EarlyDataExtension) MarshalJSON() ([]byte, error) {
+	return marshalExtensionName(extensionEarlyData)
+}
+
+func (e *EarlyDataExtension) Write(b []byte) (int, error) {
+	if len(b) != 0 {
+		return 0, errors.New("tls: early_data extension of a ClientHello must be empty")
+	}
+	return 0, nil
+}
+
 // var extendedMasterSecretLabel = []byte("extended master secret")
 
 // extendedMasterFromPreMasterSecret generates the master secret from the pre-master
@@ -957,10 +1441,10 @@ func (e *UtlsGREASEExtension) Write(b []byte) (int, error) {
 
 func (e *UtlsGREASEExtension) UnmarshalJSON(b []byte) error {
 	var jsonObj struct {
-		Id       uint16 `json:"id"`
-		Data     []byte `json:"data"`
-		KeepID   bool   `json:"keep_id"`
-		KeepData bool   `json:"keep_data"`
+		Id       uint16   `json:"id"`
+		Data     hexBytes `json:"data"`
+		KeepID   bool     `json:"keep_id"`
+		KeepData bool     `json:"keep_data"`
 	}
 
 	if err := json.Unmarshal(b, &jsonObj); err != nil {
@@ -984,6 +1468,26 @@ func (e *UtlsGREASEExtension) UnmarshalJSON(b []byte) error {
 	}
 }
 
+func (e *UtlsGREASEExtension) MarshalJSON() ([]byte, error) {
+	grease := struct {
+		Name     string   `json:"name"`
+		Id       uint16   `json:"id,omitempty"`
+		Data     hexBytes `json:"data,omitempty"`
+		KeepID   bool     `json:"keep_id,omitempty"`
+		KeepData bool     `json:"keep_data,omitempty"`
+	}{Name: "GREASE"}
+	if isGREASEUint16(e.Value) && e.Value != GREASE_PLACEHOLDER {
+		grease.Id, grease.KeepID = e.Value, true
+	}
+	if e.Body != nil {
+		if grease.Id == 0 {
+			grease.Id = GREASE_PLACEHOLDER
+		}
+		grease.Data, grease.KeepData = e.Body, true
+	}
+	return json.Marshal(grease)
+}
+
 // UtlsPaddingExtension implements padding (21)
 type UtlsPaddingExtension struct {
 	PaddingLen int
@@ -1045,6 +1549,19 @@ func (e *UtlsPaddingExtension) UnmarshalJSON(b []byte) error {
 	return nil
 }
 
+// MarshalJSON marshals the extension with its fixed length. Padding with
+// GetPaddingLen is always marshaled as BoringPaddingStyle.
+func (e *UtlsPaddingExtension) MarshalJSON() ([]byte, error) {
+	var length int
+	if e.GetPaddingLen == nil && e.WillPad {
+		length = e.PaddingLen
+	}
+	return json.Marshal(struct {
+		Name   string `json:"name"`
+		Length int    `json:"len"`
+	}{"padding", length})
+}
+
 func (e *UtlsPaddingExtension) Write(_ []byte) (int, error) {
 	e.GetPaddingLen = BoringPaddingStyle
 	return 0, nil
@@ -1165,6 +1682,17 @@ func (e *UtlsCompressCertExtension) UnmarshalJSON(b []byte) error {
 	return nil
 }
 
+func (e *UtlsCompressCertExtension) MarshalJSON() ([]byte, error) {
+	algorithms, err := jsonNames(e.Algorithms, dicttls.DictCertificateCompressionAlgorithmValueIndexed, "certificate compression algorithm")
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(struct {
+		Name       string   `json:"name"`
+		Algorithms []string `json:"algorithms"`
+	}{"compress_certificate", algorithms})
+}
+
 // KeyShareExtension implements key_share (51) and is for TLS 1.3 only.
 type KeyShareExtension struct {
 	KeyShares []KeyShare
@@ -1245,8 +1773,8 @@ func (e *KeyShareExtension) writeToUConn(uc *UConn) error {
 func (e *KeyShareExtension) UnmarshalJSON(b []byte) error {
 	var keyShareClientHello struct {
 		ClientShares []struct {
-			Group       string  `json:"group"`
-			KeyExchange []uint8 `json:"key_exchange"`
+			Group       string   `json:"group"`
+			KeyExchange hexBytes `json:"key_exchange"`
 		} `json:"client_shares"`
 	}
 	if err := json.Unmarshal(b, &keyShareClientHello); err != nil {
@@ -1257,7 +1785,7 @@ func (e *KeyShareExtension) UnmarshalJSON(b []byte) error {
 		if clientShare.Group == "GREASE" {
 			e.KeyShares = append(e.KeyShares, KeyShare{
 				Group: GREASE_PLACEHOLDER,
-				Data:  clientShare.KeyExchange,
+				Data:  []byte(clientShare.KeyExchange),
 			})
 			continue
 		}
@@ -1265,7 +1793,7 @@ func (e *KeyShareExtension) UnmarshalJSON(b []byte) error {
 		if groupID, ok := dicttls.DictSupportedGroupsNameIndexed[clientShare.Group]; ok {
 			ks := KeyShare{
 				Group: CurveID(groupID),
-				Data:  clientShare.KeyExchange,
+				Data:  []byte(clientShare.KeyExchange),
 			}
 			e.KeyShares = append(e.KeyShares, ks)
 		} else {
@@ -1275,11 +1803,32 @@ func (e *KeyShareExtension) UnmarshalJSON(b []byte) error {
 	return nil
 }
 
+func (e *KeyShareExtension) MarshalJSON() ([]byte, error) {
+	type clientShare struct {
+		Group       string   `json:"group"`
+		KeyExchange hexBytes `json:"key_exchange,omitempty"`
+	}
+	clientShares := make([]clientShare, 0, len(e.KeyShares))
+	for _, ks := range e.KeyShares {
+		group, err := jsonNames([]CurveID{ks.Group}, dicttls.DictSupportedGroupsValueIndexed, "group")
+		if err != nil {
+			return nil, err
+		}
+		clientShares = append(clientShares, clientShare{group[0], ks.Data})
+	}
+	return json.Marshal(struct {
+		Name         string        `json:"name"`
+		ClientShares []clientShare `json:"client_shares"`
+	}{"key_share", clientShares})
+}
+
 // QUICTransportParametersExtension implements quic_transport_parameters (57).
 //
-// Currently, it works as a fake extension and does not support parsing, since
-// the QUICConn provided by this package does not really understand these
-// parameters.
+// The QUICConn provided by this package does not really understand these
+// parameters, so they are sent as-is. A parsed extension keeps the exact
+// bytes it was parsed from, including the order and encoding of parameters.
+// If TransportParameters is empty, the parameters set with
+// UQUICConn.SetTransportParameters are sent instead.
 type QUICTransportParametersExtension struct {
 	TransportParameters TransportParameters
 
@@ -1308,12 +1857,27 @@ func (e *QUICTransportParametersExtension) Read(b []byte) (int, error) {
 	return e.Len(), io.EOF
 }
 
-func (e *QUICTransportParametersExtension) writeToUConn(*UConn) error {
+func (e *QUICTransportParametersExtension) Write(b []byte) (int, error) {
+	tps, err := ParseTransportParameters(b)
+	if err != nil {
+		return 0, err
+	}
+	e.TransportParameters = tps
+	e.marshalResult = bytes.Clone(b)
+	return len(b), nil
+}
+
+func (e *QUICTransportParametersExtension) writeToUConn(uc *UConn) error {
 	// no need to set *UConn.quic.transportParams, since it is unused
+	if uc.quic != nil && len(e.TransportParameters) == 0 && len(e.marshalResult) == 0 {
+		e.marshalResult = bytes.Clone(uc.quic.transportParams)
+	}
 	return nil
 }
 
-// PSKKeyExchangeModesExtension implements psk_key_exchange_modes (45).
+// PSKKeyExchangeModesExtension implements psk_key_exchange_modes (45). Modes
+// are sent exactly in the given order, e.g. PskModeDHE alone like browsers.
+// PskModeDHE requires a KeyShareExtension, see (*ClientHelloSpec).Validate.
 type PSKKeyExchangeModesExtension struct {
 	Modes []uint8
 }
@@ -1385,6 +1949,17 @@ func (e *PSKKeyExchangeModesExtension) UnmarshalJSON(b []byte) error {
 	return nil
 }
 
+func (e *PSKKeyExchangeModesExtension) MarshalJSON() ([]byte, error) {
+	modes, err := jsonNames(e.Modes, dicttls.DictPSKKeyExchangeModeValueIndexed, "PSK key exchange mode")
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(struct {
+		Name  string   `json:"name"`
+		Modes []string `json:"ke_modes"`
+	}{"psk_key_exchange_modes", modes})
+}
+
 // SupportedVersionsExtension implements supported_versions (43).
 type SupportedVersionsExtension struct {
 	Versions []uint16
@@ -1473,38 +2048,87 @@ func (e *SupportedVersionsExtension) UnmarshalJSON(b []byte) error {
 	return nil
 }
 
-// CookieExtension implements cookie (44).
-// MUST NOT be part of initial ClientHello
+func (e *SupportedVersionsExtension) MarshalJSON() ([]byte, error) {
+	versions := make([]string, 0, len(e.Versions))
+	for _, version := range e.Versions {
+		switch {
+		case isGREASEUint16(version):
+			versions = append(versions, "GREASE")
+		case version >= VersionTLS10 && version <= VersionTLS13:
+			versions = append(versions, fmt.Sprintf("TLS 1.%d", version-VersionTLS10))
+		default:
+			return nil, fmt.Errorf("tls: version %#04x has no JSON name", version)
+		}
+	}
+	return json.Marshal(struct {
+		Name     string   `json:"name"`
+		Versions []string `json:"versions"`
+	}{"supported_versions", versions})
+}
+
+// CookieExtension implements cookie (44). The cookie of a HelloRetryRequest
+// is echoed in it, replacing Cookie, and the extension is added after
+// supported_versions if the ClientHello has none.
+//
+// RFC 8446 only allows it after a HelloRetryRequest, but a non-empty Cookie
+// is sent in the initial ClientHello as well, e.g. to study how middleboxes
+// react to it. An empty Cookie is not sent, and only sets the position of the
+// echoed cookie.
 type CookieExtension struct {
 	Cookie []byte
 }
 
 func (e *CookieExtension) writeToUConn(uc *UConn) error {
+	if len(e.Cookie) > 0xffff-2 {
+		return errors.New("tls: cookie is too long")
+	}
+	uc.HandshakeState.Hello.Cookie = e.Cookie
 	return nil
 }
 
 func (e *CookieExtension) Len() int {
-	return 4 + len(e.Cookie)
+	if len(e.Cookie) == 0 {
+		return 0
+	}
+	return 4 + 2 + len(e.Cookie)
 }
 
 func (e *CookieExtension) Read(b []byte) (int, error) {
+	if len(e.Cookie) == 0 {
+		return 0, io.EOF
+	}
+	if len(e.Cookie) > 0xffff-2 {
+		return 0, errors.New("tls: cookie is too long")
+	}
 	if len(b) < e.Len() {
 		return 0, io.ErrShortBuffer
 	}
 
+	// RFC 8446, Section 4.2.2: the cookie is a uint16 length-prefixed vector
 	b[0] = byte(extensionCookie >> 8)
 	b[1] = byte(extensionCookie)
-	b[2] = byte(len(e.Cookie) >> 8)
-	b[3] = byte(len(e.Cookie))
-	if len(e.Cookie) > 0 {
-		copy(b[4:], e.Cookie)
-	}
+	b[2] = byte((len(e.Cookie) + 2) >> 8)
+	b[3] = byte(len(e.Cookie) + 2)
+	b[4] = byte(len(e.Cookie) >> 8)
+	b[5] = byte(len(e.Cookie))
+	copy(b[6:], e.Cookie)
 	return e.Len(), io.EOF
 }
 
+func (e *CookieExtension) Write(b []byte) (int, error) {
+	fullLen := len(b)
+	extData := cryptobyte.String(b)
+	var cookie []byte
+	if !readUint16LengthPrefixed(&extData, &cookie) || len(cookie) == 0 || !extData.Empty() {
+		return 0, errors.New("unable to read cookie extension data")
+	}
+	e.Cookie = cookie
+	return fullLen, nil
+}
+
 func (e *CookieExtension) UnmarshalJSON(data []byte) error {
 	var cookie struct {
-		Cookie []uint8 `json:"cookie"`
+		Cookie hexBytes `json:"cookie"`
 	}
 	if err := json.Unmarshal(data, &cookie); err != nil {
 		return err
@@ -1513,6 +2137,13 @@ func (e *CookieExtension) UnmarshalJSON(data []byte) error {
 	return nil
 }
 
+func (e *CookieExtension) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Name   string   `json:"name"`
+		Cookie hexBytes `json:"cookie"`
+	}{"cookie", e.Cookie})
+}
+
 // NPNExtension implements next_protocol_negotiation (Not IANA assigned)
 type NPNExtension struct {
 	NextProtos []string
@@ -1551,18 +2182,22 @@ func (e *NPNExtension) UnmarshalJSON(_ []byte) error {
 	return nil
 }
 
+func (e *NPNExtension) MarshalJSON() ([]byte, error) {
+	return marshalExtensionName(extensionNextProtoNeg)
+}
+
 // RenegotiationInfoExtension implements renegotiation_info (65281)
 type RenegotiationInfoExtension struct {
 	// Renegotiation field limits how many times client will perform renegotiation: no limit, once, or never.
 	// The extension still will be sent, even if Renegotiation is set to RenegotiateNever.
 	Renegotiation RenegotiationSupport // [UTLS] added for internal use only
 
-	// RenegotiatedConnection is not yet properly handled, now we
-	// are just copying it to the client hello.
+	// RenegotiatedConnection is the "renegotiated_connection" field of RFC 5746.
+	// It is set by ApplyConfig from the state of the connection: it is empty for
+	// the initial handshake, and the client's verify_data of the previous
+	// handshake when renegotiating.
 	//
-	// If this is the initial handshake for a connection, then the
-	// "renegotiated_connection" field is of zero length in both the
-	// ClientHello and the ServerHello.
+	// It is kept by Write, so that it shows the value of a parsed ClientHello.
 	RenegotiatedConnection []byte
 }
 
@@ -1593,18 +2228,21 @@ func (e *RenegotiationInfoExtension) UnmarshalJSON(_ []byte) error {
 	return nil
 }
 
+func (e *RenegotiationInfoExtension) MarshalJSON() ([]byte, error) {
+	return marshalExtensionName(extensionRenegotiationInfo)
+}
+
 func (e *RenegotiationInfoExtension) Write(b []byte) (int, error) {
 	e.Renegotiation = RenegotiateOnceAsClient // none empty or other modes are unsupported
-	// extData := cryptobyte.String(b)
-	// var renegotiatedConnection cryptobyte.String
-	// if !extData.ReadUint8LengthPrefixed(&renegotiatedConnection) || !extData.Empty() {
-	// 	return 0, errors.New("unable to read renegotiation info extension data")
-	// }
-	// e.RenegotiatedConnection = make([]byte, len(renegotiatedConnection))
-	// copy(e.RenegotiatedConnection, renegotiatedConnection)
-
-	// we don't really want to parse it at all.
-
+	extData := cryptobyte.String(b)
+	var renegotiatedConnection cryptobyte.String
+	if !extData.ReadUint8LengthPrefixed(&renegotiatedConnection) || !extData.Empty() {
+		return 0, errors.New("unable to read renegotiation info extension data")
+	}
+	e.RenegotiatedConnection = bytes.Clone(renegotiatedConnection)
+	if len(e.RenegotiatedConnection) == 0 {
+		e.RenegotiatedConnection = nil
+	}
 	return len(b), nil
 }
 
@@ -1616,9 +2254,11 @@ func (e *RenegotiationInfoExtension) writeToUConn(uc *UConn) error {
 	case RenegotiateFreelyAsClient:
 		uc.HandshakeState.Hello.SecureRenegotiationSupported = true
 		// TODO: don't do backward propagation here
+		e.RenegotiatedConnection = nil
 		if uc.handshakes > 0 {
-			e.RenegotiatedConnection = uc.clientFinished[:]
+			e.RenegotiatedConnection = bytes.Clone(uc.clientFinished[:])
 		}
+		uc.HandshakeState.Hello.SecureRenegotiation = e.RenegotiatedConnection
 	case RenegotiateNever:
 	default:
 	}
@@ -1665,13 +2305,23 @@ func (e *FakeChannelIDExtension) UnmarshalJSON(_ []byte) error {
 	return nil
 }
 
-// FakeRecordSizeLimitExtension implements record_size_limit (28)
-// but with no support.
+func (e *FakeChannelIDExtension) MarshalJSON() ([]byte, error) {
+	if e.OldExtensionID {
+		return marshalExtensionName(fakeOldExtensionChannelID)
+	}
+	return marshalExtensionName(fakeExtensionChannelID)
+}
+
+// FakeRecordSizeLimitExtension implements record_size_limit (28). Records
+// written by the client are limited to the value the server sends back, see
+// UConn.SetRecordSizeLimit; the client's own limit isn't enforced on the
+// records it reads.
 type FakeRecordSizeLimitExtension struct {
 	Limit uint16
 }
 
 func (e *FakeRecordSizeLimitExtension) writeToUConn(uc *UConn) error {
+	uc.utls.recordSizeLimit = e.Limit
 	return nil
 }
 
@@ -1716,6 +2366,155 @@ func (e *FakeRecordSizeLimitExtension) UnmarshalJSON(data []byte) error {
 	return nil
 }
 
+func (e *FakeRecordSizeLimitExtension) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Name  string `json:"name"`
+		Limit uint16 `json:"record_size_limit"`
+	}{"record_size_limit", e.Limit})
+}
+
+// FakeMaxFragmentLengthExtension implements max_fragment_length (1) of RFC
+// 6066, with the Code of the maximum fragment length: 1 for 512 bytes, 2 for
+// 1024, 3 for 2048 and 4 for 4096. If the server echoes it, the records
+// written by the client are limited to that length.
+type FakeMaxFragmentLengthExtension struct {
+	Code uint8
+}
+
+func checkMaxFragmentLengthCode(code uint8) error {
+	if code < 1 || code > 4 {
+		return fmt.Errorf("tls: max_fragment_length code %d is not between 1 and 4", code)
+	}
+	return nil
+}
+
+func (e *FakeMaxFragmentLengthExtension) writeToUConn(uc *UConn) error {
+	if err := checkMaxFragmentLengthCode(e.Code); err != nil {
+		return err
+	}
+	uc.utls.maxFragmentLength = e.Code
+	return nil
+}
+
+func (e *FakeMaxFragmentLengthExtension) Len() int {
+	return 5
+}
+
+func (e *FakeMaxFragmentLengthExtension) Read(b []byte) (int, error) {
+	if err := checkMaxFragmentLengthCode(e.Code); err != nil {
+		return 0, err
+	}
+	if len(b) < e.Len() {
+		return 0, io.ErrShortBuffer
+	}
+	b[0] = byte(fakeExtensionMaxFragmentLength >> 8)
+	b[1] = byte(fakeExtensionMaxFragmentLength & 0xff)
+	b[2] = 0
+	b[3] = 1
+	b[4] = e.Code
+	return e.Len(), io.EOF
+}
+
+func (e *FakeMaxFragmentLengthExtension) Write(b []byte) (int, error) {
+	fullLen := len(b)
+	extData := cryptobyte.String(b)
+	if !extData.ReadUint8(&e.Code) || !extData.Empty() {
+		return 0, errors.New("unable to read max fragment length extension data")
+	}
+	if err := checkMaxFragmentLengthCode(e.Code); err != nil {
+		return 0, err
+	}
+	return fullLen, nil
+}
+
+func (e *FakeMaxFragmentLengthExtension) UnmarshalJSON(data []byte) error {
+	var codeAccepter struct {
+		Code uint8 `json:"max_fragment_length"`
+	}
+	if err := json.Unmarshal(data, &codeAccepter); err != nil {
+		return err
+	}
+	if err := checkMaxFragmentLengthCode(codeAccepter.Code); err != nil {
+		return err
+	}
+
+	e.Code = codeAccepter.Code
+	return nil
+}
+
+func (e *FakeMaxFragmentLengthExtension) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Name string `json:"name"`
+		Code uint8  `json:"max_fragment_length"`
+	}{"max_fragment_length", e.Code})
+}
+
+// FakeHeartbeatExtension implements heartbeat (15) of RFC 6520, which some
+// legacy clients send, with its Mode: 1 (peer_allowed_to_send) or 2
+// (peer_not_allowed_to_send). Heartbeat messages are never sent nor answered.
+type FakeHeartbeatExtension struct {
+	Mode uint8
+}
+
+func (e *FakeHeartbeatExtension) writeToUConn(uc *UConn) error {
+	return nil
+}
+
+func (e *FakeHeartbeatExtension) Len() int {
+	return 5
+}
+
+func (e *FakeHeartbeatExtension) Read(b []byte) (int, error) {
+	if len(b) < e.Len() {
+		return 0, io.ErrShortBuffer
+	}
+	b[0] = byte(fakeExtensionHeartbeat >> 8)
+	b[1] = byte(fakeExtensionHeartbeat & 0xff)
+	b[2] = 0
+	b[3] = 1
+	b[4] = e.Mode
+	return e.Len(), io.EOF
+}
+
+func (e *FakeHeartbeatExtension) Write(b []byte) (int, error) {
+	fullLen := len(b)
+	extData := cryptobyte.String(b)
+	if !extData.ReadUint8(&e.Mode) || !extData.Empty() {
+		return 0, errors.New("unable to read heartbeat extension data")
+	}
+	return fullLen, nil
+}
+
+func (e *FakeHeartbeatExtension) UnmarshalJSON(data []byte) error {
+	var modeAccepter struct {
+		Mode string `json:"mode"`
+	}
+	if err := json.Unmarshal(data, &modeAccepter); err != nil {
+		return err
+	}
+	mode, ok := dicttls.DictHeartbeatModeNameIndexed[modeAccepter.Mode]
+	if !ok {
+		return fmt.Errorf("unknown heartbeat mode: %s", modeAccepter.Mode)
+	}
+	e.Mode = mode
+	return nil
+}
+
+func (e *FakeHeartbeatExtension) MarshalJSON() ([]byte, error) {
+	mode, ok := dicttls.DictHeartbeatModeValueIndexed[e.Mode]
+	if !ok {
+		return nil, fmt.Errorf("tls: heartbeat mode %d has no JSON name", e.Mode)
+	}
+	return json.Marshal(struct {
+		Name string `json:"name"`
+		Mode string `json:"mode"`
+	}{"heartbeat", mode})
+}
+
+// DelegatedCredentialsExtension offers to accept delegated credentials
+// (RFC 9345), signed with one of SupportedSignatureAlgorithms, which are sent
+// in their order. A delegated credential sent by the server is verified, and
+// the server's CertificateVerify is checked with its key.
 type DelegatedCredentialsExtension = FakeDelegatedCredentialsExtension
 
 // https://tools.ietf.org/html/rfc8472#section-2
@@ -1757,10 +2556,11 @@ func (e *FakeTokenBindingExtension) Write(b []byte) (int, error) {
 	var keyParameters cryptobyte.String
 	if !extData.ReadUint8(&e.MajorVersion) ||
 		!extData.ReadUint8(&e.MinorVersion) ||
-		!extData.ReadUint8LengthPrefixed(&keyParameters) {
+		!extData.ReadUint8LengthPrefixed(&keyParameters) ||
+		keyParameters.Empty() || !extData.Empty() {
 		return 0, errors.New("unable to read token binding extension data")
 	}
-	e.KeyParameters = keyParameters
+	e.KeyParameters = bytes.Clone(keyParameters)
 	return fullLen, nil
 }
 
@@ -1793,6 +2593,35 @@ func (e *FakeTokenBindingExtension) UnmarshalJSON(data []byte) error {
 	return nil
 }
 
+func (e *FakeTokenBindingExtension) MarshalJSON() ([]byte, error) {
+	var keyParameters []string
+	for _, param := range e.KeyParameters {
+		switch param {
+		case 0:
+			keyParameters = append(keyParameters, "rsa2048_pkcs1.5")
+		case 1:
+			keyParameters = append(keyParameters, "rsa2048_pss")
+		case 2:
+			keyParameters = append(keyParameters, "ecdsap256")
+		default:
+			return nil, fmt.Errorf("tls: token binding key parameter %d has no JSON name", param)
+		}
+	}
+	var tokenBinding struct {
+		Name               string `json:"name"`
+		TB_ProtocolVersion struct {
+			Major uint8 `json:"major"`
+			Minor uint8 `json:"minor"`
+		} `json:"token_binding_version"`
+		TokenBindingKeyParameters []string `json:"key_parameters_list"`
+	}
+	tokenBinding.Name = "token_binding"
+	tokenBinding.TB_ProtocolVersion.Major = e.MajorVersion
+	tokenBinding.TB_ProtocolVersion.Minor = e.MinorVersion
+	tokenBinding.TokenBindingKeyParameters = keyParameters
+	return json.Marshal(tokenBinding)
+}
+
 // https://datatracker.ietf.org/doc/html/draft-ietf-tls-subcerts-15#section-4.1.1
 
 type FakeDelegatedCredentialsExtension struct {
@@ -1800,6 +2629,7 @@ type FakeDelegatedCredentialsExtension struct {
 }
 
 func (e *FakeDelegatedCredentialsExtension) writeToUConn(uc *UConn) error {
+	uc.delegatedCredentialAlgs = e.SupportedSignatureAlgorithms
 	return nil
 }
 
@@ -1869,3 +2699,14 @@ func (e *FakeDelegatedCredentialsExtension) UnmarshalJSON(data []byte) error {
 	}
 	return nil
 }
+
+func (e *FakeDelegatedCredentialsExtension) MarshalJSON() ([]byte, error) {
+	sigSchemes, err := jsonNames(e.SupportedSignatureAlgorithms, dicttls.DictSignatureSchemeValueIndexed, "signature scheme")
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(struct {
+		Name       string   `json:"name"`
+		Algorithms []string `json:"supported_signature_algorithms"`
+	}{"delegated_credentials", sigSchemes})
+}
@@ -0,0 +1,879 @@
+// Copyright 2024 The uTLS Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tls
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/cryptobyte"
+)
+
+func TestUTLSExtensionFromBytes(t *testing.T) {
+	for _, test := range []struct {
+		name      string
+		codepoint uint16
+		data      []byte
+		check     func(t *testing.T, ext TLSExtension)
+	}{
+		{
+			name:      "supported_versions",
+			codepoint: extensionSupportedVersions,
+			data:      []byte{0x04, 0x03, 0x04, 0x03, 0x03},
+			check: func(t *testing.T, ext TLSExtension) {
+				e, ok := ext.(*SupportedVersionsExtension)
+				if !ok {
+					t.Fatalf("got %T, expected *SupportedVersionsExtension", ext)
+				}
+				if !sliceEq(e.Versions, []uint16{VersionTLS13, VersionTLS12}) {
+					t.Errorf("got versions %x, expected TLS 1.3 and 1.2", e.Versions)
+				}
+			},
+		},
+		{
+			name:      "GREASE",
+			codepoint: 0x3a3a,
+			data:      []byte{0},
+			check: func(t *testing.T, ext TLSExtension) {
+				if _, ok := ext.(*UtlsGREASEExtension); !ok {
+					t.Fatalf("got %T, expected *UtlsGREASEExtension", ext)
+				}
+			},
+		},
+		{
+			name:      "renegotiation_info",
+			codepoint: extensionRenegotiationInfo,
+			data:      []byte{12, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12},
+			check: func(t *testing.T, ext TLSExtension) {
+				e, ok := ext.(*RenegotiationInfoExtension)
+				if !ok {
+					t.Fatalf("got %T, expected *RenegotiationInfoExtension", ext)
+				}
+				if !bytes.Equal(e.RenegotiatedConnection, []byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12}) {
+					t.Errorf("got renegotiated_connection %x, expected 0102030405060708090a0b0c", e.RenegotiatedConnection)
+				}
+			},
+		},
+		{
+			name:      "unknown",
+			codepoint: 0xfeed,
+			data:      []byte{1, 2, 3},
+			check: func(t *testing.T, ext TLSExtension) {
+				if e, ok := ext.(*GenericExtension); !ok || e.Id != 0xfeed {
+					t.Fatalf("got %#v, expected a GenericExtension with codepoint 0xfeed", ext)
+				}
+			},
+		},
+		{
+			name:      "malformed",
+			codepoint: extensionSupportedVersions,
+			data:      []byte{0x04, 0x03, 0x04},
+			check: func(t *testing.T, ext TLSExtension) {
+				if _, ok := ext.(*GenericExtension); !ok {
+					t.Fatalf("got %T, expected a GenericExtension for data that doesn't parse", ext)
+				}
+			},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			data := bytes.Clone(test.data)
+			ext := ExtensionFromBytes(test.codepoint, data)
+			test.check(t, ext)
+			data[0] ^= 0xff // the extension doesn't alias data
+
+			b := make([]byte, ext.Len())
+			if _, err := ext.Read(b); err != nil && err != io.EOF {
+				t.Fatalf("got error: %v; expected to succeed", err)
+			}
+			want := append([]byte{byte(test.codepoint >> 8), byte(test.codepoint), 0, byte(len(test.data))}, test.data...)
+			if !bytes.Equal(b, want) {
+				t.Errorf("extension is serialized as %x, expected %x", b, want)
+			}
+		})
+	}
+}
+
+func TestUTLSRenegotiationInfoExtension(t *testing.T) {
+	renegotiationInfo := func(t *testing.T, raw []byte) []byte {
+		t.Helper()
+		var m clientHelloMsg
+		if !m.unmarshal(raw) {
+			t.Fatal("failed to unmarshal the ClientHello")
+		}
+		if !m.secureRenegotiationSupported {
+			t.Fatal("no renegotiation_info extension")
+		}
+		return m.secureRenegotiation
+	}
+
+	c, s := localPipe(t)
+	serverConfig := testConfig.Clone()
+	serverConfig.MaxVersion = VersionTLS12
+	server := Server(s, serverConfig)
+	defer server.Close()
+	done := make(chan error, 1)
+	go func() {
+		done <- server.Handshake()
+	}()
+
+	uconn := UClient(c, &Config{ServerName: "example.golang", InsecureSkipVerify: true}, HelloChrome_120)
+	defer uconn.Close()
+	if err := uconn.Handshake(); err != nil {
+		t.Fatalf("client handshake failed: %v", err)
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("server handshake failed: %v", err)
+	}
+	random := bytes.Clone(uconn.HandshakeState.Hello.Random)
+	if info := renegotiationInfo(t, uconn.HandshakeState.Hello.Raw); len(info) != 0 {
+		t.Errorf("got renegotiated_connection %x in the initial ClientHello, expected it to be empty", info)
+	}
+
+	// The Go server doesn't renegotiate, so it only sends a HelloRequest and
+	// reads the new ClientHello.
+	hellos := make(chan *clientHelloMsg, 1)
+	go func() {
+		defer close(hellos)
+		if _, err := server.writeHandshakeRecord(new(helloRequestMsg), nil); err != nil {
+			t.Errorf("failed to send HelloRequest: %v", err)
+			return
+		}
+		msg, err := server.readHandshake(nil)
+		if err != nil {
+			t.Errorf("failed to read the renegotiation ClientHello: %v", err)
+			return
+		}
+		hello, ok := msg.(*clientHelloMsg)
+		if !ok {
+			t.Errorf("got %T, expected a ClientHello", msg)
+			return
+		}
+		hellos <- hello
+		server.Close()
+	}()
+	uconn.Read(make([]byte, 1)) // fails once the server closes the connection
+
+	renegotiation, ok := <-hellos
+	if !ok {
+		return
+	}
+	if !bytes.Equal(renegotiation.secureRenegotiation, server.clientFinished[:]) {
+		t.Errorf("got renegotiated_connection %x, expected the client's verify_data %x", renegotiation.secureRenegotiation, server.clientFinished[:])
+	}
+	if bytes.Equal(renegotiation.random, random) {
+		t.Error("the renegotiation ClientHello has the random of the initial one")
+	}
+}
+
+func TestUTLSSupportedVersionsOrder(t *testing.T) {
+	supportedVersions := func(t *testing.T, uconn *UConn) []uint16 {
+		t.Helper()
+		for _, ext := range clientHelloRawExtensions(t, uconn.HandshakeState.Hello.Raw) {
+			if ext.extType != extensionSupportedVersions {
+				continue
+			}
+			s := cryptobyte.String(ext.data)
+			var list cryptobyte.String
+			var versions []uint16
+			if !s.ReadUint8LengthPrefixed(&list) || !readUint16List(&list, &versions) || !s.Empty() {
+				t.Fatal("malformed supported_versions extension")
+			}
+			return versions
+		}
+		t.Fatal("no supported_versions extension")
+		return nil
+	}
+
+	uconn := UClient(&net.TCPConn{}, &Config{ServerName: "example.com"}, HelloChrome_120)
+	if err := uconn.BuildHandshakeState(); err != nil {
+		t.Fatalf("got error: %v; expected to succeed", err)
+	}
+	versions := supportedVersions(t, uconn)
+	if len(versions) != 3 || !isGREASEUint16(versions[0]) || versions[1] != VersionTLS13 || versions[2] != VersionTLS12 {
+		t.Errorf("got supported_versions %x, expected GREASE, 0304 and 0303", versions)
+	}
+
+	// an unusual order is kept, and still negotiates TLS 1.3
+	spec := mustSpec(t, HelloChrome_120)
+	for _, ext := range spec.Extensions {
+		if e, ok := ext.(*SupportedVersionsExtension); ok {
+			e.Versions = []uint16{VersionTLS13, GREASE_PLACEHOLDER, VersionTLS12}
+		}
+	}
+	c, s := localPipe(t)
+	done := make(chan error, 1)
+	go func() {
+		server := Server(s, testConfig.Clone())
+		defer server.Close()
+		done <- server.Handshake()
+	}()
+	uconn = UClient(c, &Config{ServerName: "example.golang", InsecureSkipVerify: true}, HelloCustom)
+	if err := uconn.ApplyPreset(spec); err != nil {
+		t.Fatalf("got error: %v; expected to succeed", err)
+	}
+	if err := uconn.Handshake(); err != nil {
+		t.Fatalf("client handshake failed: %v", err)
+	}
+	defer uconn.Close()
+	if err := <-done; err != nil {
+		t.Fatalf("server handshake failed: %v", err)
+	}
+	versions = supportedVersions(t, uconn)
+	if len(versions) != 3 || versions[0] != VersionTLS13 || !isGREASEUint16(versions[1]) || versions[2] != VersionTLS12 {
+		t.Errorf("got supported_versions %x, expected 0304, GREASE and 0303", versions)
+	}
+	if v := uconn.ConnectionState().Version; v != VersionTLS13 {
+		t.Errorf("negotiated version %x, expected TLS 1.3", v)
+	}
+}
+
+func TestUTLSWithoutExtendedMasterSecret(t *testing.T) {
+	handshake := func(t *testing.T, spec *ClientHelloSpec) (client *UConn, server *Conn, clientKeyLog, serverKeyLog *bytes.Buffer, err error) {
+		t.Helper()
+		c, s := localPipe(t)
+		serverKeyLog = new(bytes.Buffer)
+		serverConfig := testConfig.Clone()
+		serverConfig.MaxVersion = VersionTLS12
+		serverConfig.KeyLogWriter = serverKeyLog
+		server = Server(s, serverConfig)
+		done := make(chan error, 1)
+		go func() {
+			done <- server.Handshake()
+		}()
+		clientKeyLog = new(bytes.Buffer)
+		uconn := UClient(c, &Config{ServerName: "example.golang", InsecureSkipVerify: true, KeyLogWriter: clientKeyLog}, HelloCustom)
+		if err := uconn.ApplyPreset(spec); err != nil {
+			t.Fatalf("got error: %v; expected to succeed", err)
+		}
+		err = uconn.Handshake()
+		if err != nil {
+			uconn.Close()
+		}
+		<-done
+		return uconn, server, clientKeyLog, serverKeyLog, err
+	}
+
+	// the server doesn't use EMS when the client doesn't offer it
+	spec := mustSpec(t, HelloChrome_120).WithoutExtension(extensionExtendedMasterSecret)
+	client, server, clientKeyLog, serverKeyLog, err := handshake(t, &spec)
+	if err != nil {
+		t.Fatalf("client handshake failed: %v", err)
+	}
+	for _, ext := range clientHelloRawExtensions(t, client.HandshakeState.Hello.Raw) {
+		if ext.extType == extensionExtendedMasterSecret {
+			t.Error("got an extended_master_secret extension in the ClientHello")
+		}
+	}
+	if client.extMasterSecret || server.extMasterSecret {
+		t.Errorf("got extended master secret on the client (%v) or the server (%v), expected neither", client.extMasterSecret, server.extMasterSecret)
+	}
+	if clientKeyLog.Len() == 0 || !bytes.Equal(clientKeyLog.Bytes(), serverKeyLog.Bytes()) {
+		t.Errorf("got client key log %q, expected the server's %q", clientKeyLog, serverKeyLog)
+	}
+	go server.Write([]byte("hello"))
+	buf := make([]byte, 5)
+	if _, err := io.ReadFull(client, buf); err != nil || string(buf) != "hello" {
+		t.Errorf("got %q (error: %v), expected to read the server's data", buf, err)
+	}
+	client.Close()
+	server.Close()
+
+	// extended_master_secret sent without ExtendedMasterSecretExtension isn't
+	// expected in the ServerHello
+	for i, ext := range spec.Extensions {
+		if _, ok := ext.(*SNIExtension); ok {
+			spec.Extensions = append(spec.Extensions[:i:i], append([]TLSExtension{&GenericExtension{Id: extensionExtendedMasterSecret}}, spec.Extensions[i:]...)...)
+			break
+		}
+	}
+	if _, _, _, _, err := handshake(t, &spec); err == nil || !strings.Contains(err.Error(), "unsolicited extended_master_secret") {
+		t.Errorf("got error %v, expected an unsolicited extended_master_secret", err)
+	}
+}
+
+func TestUTLSSNIExtensionNames(t *testing.T) {
+	names := []SNIServerName{
+		{NameType: 0, Name: []byte("example.golang")},
+		{NameType: 0xff, Name: []byte{1, 2, 3}},
+	}
+	spec := mustSpec(t, HelloChrome_120)
+	for i, ext := range spec.Extensions {
+		if _, ok := ext.(*SNIExtension); ok {
+			spec.Extensions[i] = &SNIExtension{Names: names}
+		}
+	}
+	clone, err := spec.Clone()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, s := localPipe(t)
+	done := make(chan error, 1)
+	server := Server(s, testConfig.Clone())
+	go func() {
+		defer server.Close()
+		done <- server.Handshake()
+	}()
+	uconn := UClient(c, &Config{ServerName: "example.golang", InsecureSkipVerify: true}, HelloCustom)
+	defer uconn.Close()
+	if err := uconn.ApplyPreset(&clone); err != nil {
+		t.Fatalf("got error: %v; expected to succeed", err)
+	}
+	if err := uconn.Handshake(); err != nil {
+		t.Fatalf("client handshake failed: %v", err)
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("server handshake failed: %v", err)
+	}
+	if name := server.ConnectionState().ServerName; name != "example.golang" {
+		t.Errorf("got SNI %q on the server, expected example.golang", name)
+	}
+
+	expected := []byte{
+		0, 23, // server_name_list
+		0, 0, 14, 'e', 'x', 'a', 'm', 'p', 'l', 'e', '.', 'g', 'o', 'l', 'a', 'n', 'g',
+		0xff, 0, 3, 1, 2, 3,
+	}
+	var found bool
+	for _, ext := range clientHelloRawExtensions(t, uconn.HandshakeState.Hello.Raw) {
+		if ext.extType == extensionServerName {
+			found = true
+			if !bytes.Equal(ext.data, expected) {
+				t.Errorf("got server_name extension %x, expected %x", ext.data, expected)
+			}
+		}
+	}
+	if !found {
+		t.Error("no server_name extension in the ClientHello")
+	}
+
+	// the lengths must fit in their uint16 prefixes
+	long := bytes.Repeat([]byte{'a'}, 0x10000)
+	for _, ext := range []*SNIExtension{
+		{Names: []SNIServerName{{Name: long}}},
+		{Names: []SNIServerName{{Name: long[:0x8000]}, {Name: long[:0x8000]}}},
+		{ServerName: string(long)},
+	} {
+		if _, err := ext.Read(make([]byte, ext.Len())); err == nil || err == io.EOF {
+			t.Errorf("got error %v for a %d byte server_name extension, expected it to be too long", err, ext.Len())
+		}
+	}
+}
+
+func TestUTLSBoringPaddingStyle(t *testing.T) {
+	for _, test := range []struct {
+		unpaddedLen, paddingLen int
+		willPad                 bool
+	}{
+		{255, 0, false},
+		{256, 252, true},
+		{300, 208, true},
+		{507, 1, true},
+		{508, 1, true}, // the extension always has at least one byte
+		{511, 1, true},
+		{512, 0, false},
+	} {
+		if paddingLen, willPad := BoringPaddingStyle(test.unpaddedLen); paddingLen != test.paddingLen || willPad != test.willPad {
+			t.Errorf("BoringPaddingStyle(%d) = %d, %v, expected %d, %v", test.unpaddedLen, paddingLen, willPad, test.paddingLen, test.willPad)
+		}
+	}
+
+	// helloLen returns the length of the ClientHello message of a spec with a
+	// filler extension of fillerLen bytes, padded or not.
+	helloLen := func(t *testing.T, fillerLen int, padded bool) int {
+		t.Helper()
+		spec := &ClientHelloSpec{
+			CipherSuites: []uint16{TLS_AES_128_GCM_SHA256, TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256},
+			Extensions: []TLSExtension{
+				&SNIExtension{},
+				&SupportedCurvesExtension{Curves: []CurveID{X25519}},
+				&KeyShareExtension{KeyShares: []KeyShare{{Group: X25519}}},
+				&SupportedVersionsExtension{Versions: []uint16{VersionTLS13, VersionTLS12}},
+				&GenericExtension{Id: 0x1234, Data: make([]byte, fillerLen)},
+			},
+		}
+		if padded {
+			spec.Extensions = append(spec.Extensions, &UtlsPaddingExtension{GetPaddingLen: BoringPaddingStyle})
+		}
+		uconn := UClient(&net.TCPConn{}, &Config{ServerName: "example.com"}, HelloCustom)
+		if err := uconn.ApplyPreset(spec); err != nil {
+			t.Fatalf("got error: %v; expected to succeed", err)
+		}
+		if err := uconn.BuildHandshakeState(); err != nil {
+			t.Fatalf("got error: %v; expected to succeed", err)
+		}
+		return len(uconn.HandshakeState.Hello.Raw)
+	}
+
+	// A ClientHello that would be 300 bytes long, including the handshake
+	// header, is padded to 512 bytes, past the range that crashes F5 servers.
+	filler := 300 - helloLen(t, 0, false)
+	if l := helloLen(t, filler, false); l != 300 {
+		t.Fatalf("got an unpadded ClientHello of %d bytes, expected 300", l)
+	}
+	if l := helloLen(t, filler, true); l != 512 {
+		t.Errorf("got a padded ClientHello of %d bytes, expected 512", l)
+	}
+	// right below 512 bytes, there is no room for an empty padding extension
+	if l := helloLen(t, filler+210, true); l != 510+4+1 {
+		t.Errorf("got a padded ClientHello of %d bytes, expected %d", l, 510+4+1)
+	}
+	// shorter and longer ClientHellos are not padded
+	for _, fillerLen := range []int{filler - 50, filler + 212} {
+		if l, unpadded := helloLen(t, fillerLen, true), helloLen(t, fillerLen, false); l != unpadded {
+			t.Errorf("got a padded ClientHello of %d bytes, expected it not to be padded from %d bytes", l, unpadded)
+		}
+	}
+
+	// so are the ClientHellos of the parrots that pad like BoringSSL
+	for _, id := range []ClientHelloID{HelloChrome_70, HelloChrome_102, HelloChrome_106_Shuffle, HelloEdge_106} {
+		for _, serverName := range []string{"a.io", "a-much-longer-server-name.example.com"} {
+			uconn := UClient(&net.TCPConn{}, &Config{ServerName: serverName}, id)
+			if err := uconn.BuildHandshakeState(); err != nil {
+				t.Fatalf("%s: got error: %v; expected to succeed", id.Str(), err)
+			}
+			if l := len(uconn.HandshakeState.Hello.Raw); l > 0xff && l < 0x200 {
+				t.Errorf("%s to %s: got a ClientHello of %d bytes, expected it to be padded to 512", id.Str(), serverName, l)
+			}
+		}
+	}
+}
+
+func TestUTLSFakeTokenBindingExtension(t *testing.T) {
+	// token_binding version 0.16 (draft 16), offering ecdsap256, rsa2048_pss and rsa2048_pkcs1.5
+	raw := []byte{0x00, 0x18, 0x00, 0x06, 0x00, 0x10, 0x03, 0x02, 0x01, 0x00}
+
+	uconn := UClient(&net.TCPConn{}, &Config{ServerName: "example.com"}, HelloCustom)
+	if err := uconn.ApplyPreset(&ClientHelloSpec{
+		CipherSuites: []uint16{TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256},
+		Extensions: []TLSExtension{
+			&SNIExtension{},
+			&FakeTokenBindingExtension{MajorVersion: 0, MinorVersion: 16, KeyParameters: []uint8{2, 1, 0}},
+		},
+	}); err != nil {
+		t.Fatalf("got error: %v; expected to succeed", err)
+	}
+	if err := uconn.BuildHandshakeState(); err != nil {
+		t.Fatalf("got error: %v; expected to succeed", err)
+	}
+	hello := uconn.HandshakeState.Hello.Raw
+	if !bytes.HasSuffix(hello, raw) {
+		t.Fatalf("got ClientHello %x, expected it to end with %x", hello, raw)
+	}
+
+	spec, err := (&Fingerprinter{}).FingerprintClientHello(append([]byte{byte(recordTypeHandshake), 3, 1, byte(len(hello) >> 8), byte(len(hello))}, hello...))
+	if err != nil {
+		t.Fatalf("got error: %v; expected to succeed", err)
+	}
+	ext, ok := spec.Extensions[len(spec.Extensions)-1].(*FakeTokenBindingExtension)
+	if !ok {
+		t.Fatalf("got %T, expected *FakeTokenBindingExtension", spec.Extensions[len(spec.Extensions)-1])
+	}
+	if ext.MajorVersion != 0 || ext.MinorVersion != 16 || !bytes.Equal(ext.KeyParameters, []uint8{2, 1, 0}) {
+		t.Errorf("got token_binding %d.%d with key parameters %v", ext.MajorVersion, ext.MinorVersion, ext.KeyParameters)
+	}
+	b := make([]byte, ext.Len())
+	if _, err := ext.Read(b); err != nil && err != io.EOF {
+		t.Fatalf("got error: %v; expected to succeed", err)
+	}
+	if !bytes.Equal(b, raw) {
+		t.Errorf("extension is serialized as %x, expected %x", b, raw)
+	}
+
+	for _, data := range [][]byte{
+		{0x00, 0x10, 0x00},             // no key parameters
+		{0x00, 0x10, 0x01, 0x02, 0x00}, // trailing data
+	} {
+		if _, err := (&FakeTokenBindingExtension{}).Write(data); err == nil {
+			t.Errorf("got no error parsing %x", data)
+		}
+	}
+}
+
+func TestUTLSFakeStatusRequestV2Extension(t *testing.T) {
+	// status_request_v2 offering ocsp_multi and ocsp, after status_request
+	raw := []byte{0x00, 0x11, 0x00, 0x10, 0x00, 0x0e,
+		0x02, 0x00, 0x04, 0x00, 0x00, 0x00, 0x00,
+		0x01, 0x00, 0x04, 0x00, 0x00, 0x00, 0x00}
+	statusRequest := []byte{0x00, 0x05, 0x00, 0x05, 0x01, 0x00, 0x00, 0x00, 0x00}
+
+	uconn := UClient(&net.TCPConn{}, &Config{ServerName: "example.com"}, HelloCustom)
+	if err := uconn.ApplyPreset(&ClientHelloSpec{
+		CipherSuites: []uint16{TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256},
+		Extensions: []TLSExtension{
+			&SNIExtension{},
+			&StatusRequestExtension{},
+			&FakeStatusRequestV2Extension{StatusTypes: []uint8{statusV2TypeOCSP, statusTypeOCSP}},
+		},
+	}); err != nil {
+		t.Fatalf("got error: %v; expected to succeed", err)
+	}
+	if err := uconn.BuildHandshakeState(); err != nil {
+		t.Fatalf("got error: %v; expected to succeed", err)
+	}
+	hello := uconn.HandshakeState.Hello.Raw
+	if !bytes.HasSuffix(hello, append(statusRequest, raw...)) {
+		t.Fatalf("got ClientHello %x, expected it to end with %x%x", hello, statusRequest, raw)
+	}
+
+	spec, err := (&Fingerprinter{}).FingerprintClientHello(prependRecordHeader(hello, VersionTLS10))
+	if err != nil {
+		t.Fatalf("got error: %v; expected to succeed", err)
+	}
+	if _, ok := spec.Extensions[len(spec.Extensions)-2].(*StatusRequestExtension); !ok {
+		t.Fatalf("got %T, expected *StatusRequestExtension", spec.Extensions[len(spec.Extensions)-2])
+	}
+	ext, ok := spec.Extensions[len(spec.Extensions)-1].(*FakeStatusRequestV2Extension)
+	if !ok {
+		t.Fatalf("got %T, expected *FakeStatusRequestV2Extension", spec.Extensions[len(spec.Extensions)-1])
+	}
+	if !bytes.Equal(ext.StatusTypes, []uint8{statusV2TypeOCSP, statusTypeOCSP}) {
+		t.Errorf("got status types %v, expected [2 1]", ext.StatusTypes)
+	}
+	b := make([]byte, ext.Len())
+	if _, err := ext.Read(b); err != nil && err != io.EOF {
+		t.Fatalf("got error: %v; expected to succeed", err)
+	}
+	if !bytes.Equal(b, raw) {
+		t.Errorf("extension is serialized as %x, expected %x", b, raw)
+	}
+
+	// The zero value sends a single ocsp_multi item.
+	b = make([]byte, (&StatusRequestV2Extension{}).Len())
+	if _, err := (&StatusRequestV2Extension{}).Read(b); err != nil && err != io.EOF {
+		t.Fatalf("got error: %v; expected to succeed", err)
+	}
+	if expected := []byte{0x00, 0x11, 0x00, 0x09, 0x00, 0x07, 0x02, 0x00, 0x04, 0x00, 0x00, 0x00, 0x00}; !bytes.Equal(b, expected) {
+		t.Errorf("empty extension is serialized as %x, expected %x", b, expected)
+	}
+
+	for _, data := range [][]byte{
+		{0x00, 0x00}, // empty list
+		{0x00, 0x07, 0x03, 0x00, 0x04, 0x00, 0x00, 0x00, 0x00},       // unknown status type
+		{0x00, 0x07, 0x02, 0x00, 0x05, 0x00, 0x00, 0x00, 0x00},       // truncated request
+		{0x00, 0x07, 0x02, 0x00, 0x04, 0x00, 0x00, 0x00, 0x00, 0x00}, // trailing data
+	} {
+		if _, err := (&StatusRequestV2Extension{}).Write(data); err == nil {
+			t.Errorf("got no error parsing %x", data)
+		}
+	}
+}
+
+func TestUTLSFakeMaxFragmentLengthExtension(t *testing.T) {
+	raw := []byte{0x00, 0x01, 0x00, 0x01, 0x02} // 1024 bytes
+
+	uconn := UClient(&net.TCPConn{}, &Config{ServerName: "example.com"}, HelloCustom)
+	if err := uconn.ApplyPreset(&ClientHelloSpec{
+		CipherSuites: []uint16{TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256},
+		Extensions: []TLSExtension{
+			&SNIExtension{},
+			&FakeMaxFragmentLengthExtension{Code: 2},
+		},
+	}); err != nil {
+		t.Fatalf("got error: %v; expected to succeed", err)
+	}
+	if err := uconn.BuildHandshakeState(); err != nil {
+		t.Fatalf("got error: %v; expected to succeed", err)
+	}
+	hello := uconn.HandshakeState.Hello.Raw
+	if !bytes.HasSuffix(hello, raw) {
+		t.Fatalf("got ClientHello %x, expected it to end with %x", hello, raw)
+	}
+
+	spec, err := (&Fingerprinter{}).FingerprintClientHello(prependRecordHeader(hello, VersionTLS10))
+	if err != nil {
+		t.Fatalf("got error: %v; expected to succeed", err)
+	}
+	ext, ok := spec.Extensions[len(spec.Extensions)-1].(*FakeMaxFragmentLengthExtension)
+	if !ok {
+		t.Fatalf("got %T, expected *FakeMaxFragmentLengthExtension", spec.Extensions[len(spec.Extensions)-1])
+	}
+	if ext.Code != 2 {
+		t.Errorf("got max_fragment_length code %d, expected 2", ext.Code)
+	}
+	jsonExt, err := ext.MarshalJSON()
+	if err != nil {
+		t.Fatalf("got error: %v; expected to succeed", err)
+	}
+	var fromJSON FakeMaxFragmentLengthExtension
+	if err := fromJSON.UnmarshalJSON(jsonExt); err != nil || fromJSON != *ext {
+		t.Errorf("got %+v (error: %v) from JSON %s, expected %+v", fromJSON, err, jsonExt, *ext)
+	}
+
+	for _, code := range []uint8{0, 5} {
+		if _, err := (&FakeMaxFragmentLengthExtension{Code: code}).Read(make([]byte, 5)); err == nil || err == io.EOF {
+			t.Errorf("code %d: got no error serializing the extension", code)
+		}
+		if _, err := (&FakeMaxFragmentLengthExtension{}).Write([]byte{code}); err == nil {
+			t.Errorf("code %d: got no error parsing the extension", code)
+		}
+		uconn := UClient(&net.TCPConn{}, &Config{ServerName: "example.com"}, HelloCustom)
+		err := uconn.ApplyPreset(&ClientHelloSpec{Extensions: []TLSExtension{&FakeMaxFragmentLengthExtension{Code: code}}})
+		if err == nil {
+			err = uconn.BuildHandshakeState()
+		}
+		if err == nil || !strings.Contains(err.Error(), "max_fragment_length") {
+			t.Errorf("code %d: got error %v, expected an invalid code", code, err)
+		}
+	}
+	if _, err := (&FakeMaxFragmentLengthExtension{}).Write([]byte{2, 0}); err == nil {
+		t.Error("got no error parsing trailing data")
+	}
+}
+
+func TestUTLSFakeHeartbeatExtension(t *testing.T) {
+	raw := []byte{0x00, 0x0f, 0x00, 0x01, 0x01} // peer_allowed_to_send
+
+	uconn := UClient(&net.TCPConn{}, &Config{ServerName: "example.com"}, HelloCustom)
+	if err := uconn.ApplyPreset(&ClientHelloSpec{
+		CipherSuites: []uint16{TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256},
+		Extensions: []TLSExtension{
+			&SNIExtension{},
+			&FakeHeartbeatExtension{Mode: 1},
+		},
+	}); err != nil {
+		t.Fatalf("got error: %v; expected to succeed", err)
+	}
+	if err := uconn.BuildHandshakeState(); err != nil {
+		t.Fatalf("got error: %v; expected to succeed", err)
+	}
+	hello := uconn.HandshakeState.Hello.Raw
+	if !bytes.HasSuffix(hello, raw) {
+		t.Fatalf("got ClientHello %x, expected it to end with %x", hello, raw)
+	}
+
+	spec, err := (&Fingerprinter{}).FingerprintClientHello(prependRecordHeader(hello, VersionTLS10))
+	if err != nil {
+		t.Fatalf("got error: %v; expected to succeed", err)
+	}
+	ext, ok := spec.Extensions[len(spec.Extensions)-1].(*FakeHeartbeatExtension)
+	if !ok {
+		t.Fatalf("got %T, expected *FakeHeartbeatExtension", spec.Extensions[len(spec.Extensions)-1])
+	}
+	if ext.Mode != 1 {
+		t.Errorf("got heartbeat mode %d, expected 1", ext.Mode)
+	}
+	jsonExt, err := ext.MarshalJSON()
+	if err != nil {
+		t.Fatalf("got error: %v; expected to succeed", err)
+	}
+	if !strings.Contains(string(jsonExt), "peer_allowed_to_send") {
+		t.Errorf("got JSON %s, expected the name of the mode", jsonExt)
+	}
+	var fromJSON FakeHeartbeatExtension
+	if err := fromJSON.UnmarshalJSON(jsonExt); err != nil || fromJSON != *ext {
+		t.Errorf("got %+v (error: %v) from JSON %s, expected %+v", fromJSON, err, jsonExt, *ext)
+	}
+
+	// the same ClientHello is sent again from the fingerprinted spec
+	uconn = UClient(&net.TCPConn{}, &Config{ServerName: "example.com"}, HelloCustom)
+	if err := uconn.ApplyPreset(spec); err != nil {
+		t.Fatalf("got error: %v; expected to succeed", err)
+	}
+	if err := uconn.BuildHandshakeState(); err != nil {
+		t.Fatalf("got error: %v; expected to succeed", err)
+	}
+	if !bytes.HasSuffix(uconn.HandshakeState.Hello.Raw, raw) {
+		t.Errorf("got ClientHello %x, expected it to end with %x", uconn.HandshakeState.Hello.Raw, raw)
+	}
+
+	if _, err := (&FakeHeartbeatExtension{}).Write([]byte{1, 0}); err == nil {
+		t.Error("got no error parsing trailing data")
+	}
+	if _, err := (&FakeHeartbeatExtension{Mode: 3}).MarshalJSON(); err == nil {
+		t.Error("got no error marshaling an unknown mode")
+	}
+}
+
+func TestUTLSMaxFragmentLengthNegotiated(t *testing.T) {
+	defer func() { testingOnlyMaxFragmentLength = 0 }()
+
+	handshake := func(t *testing.T, spec *ClientHelloSpec, version uint16) (*UConn, *recordSizeConn, chan error, error) {
+		t.Helper()
+		c, s := localPipe(t)
+		serverConfig := testConfig.Clone()
+		serverConfig.MaxVersion = version
+		done := make(chan error, 1)
+		go func() {
+			server := Server(s, serverConfig)
+			defer server.Close()
+			_, err := io.Copy(io.Discard, server)
+			done <- err
+		}()
+		conn := &recordSizeConn{Conn: c}
+		uconn := UClient(conn, &Config{ServerName: "example.golang", InsecureSkipVerify: true}, HelloCustom)
+		clone, err := spec.Clone()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := uconn.ApplyPreset(&clone); err != nil {
+			t.Fatalf("got error: %v; expected to succeed", err)
+		}
+		err = uconn.Handshake()
+		if err != nil {
+			uconn.Close()
+			<-done
+		}
+		return uconn, conn, done, err
+	}
+
+	spec := mustSpec(t, HelloChrome_120)
+	spec.Extensions = append([]TLSExtension{&FakeMaxFragmentLengthExtension{Code: 2}}, spec.Extensions...)
+	for _, version := range []uint16{VersionTLS12, VersionTLS13} {
+		t.Run(VersionName(version), func(t *testing.T) {
+			testingOnlyMaxFragmentLength = 2
+			uconn, conn, done, err := handshake(t, spec, version)
+			if err != nil {
+				t.Fatalf("client handshake failed: %v", err)
+			}
+			conn.sizes = nil
+			if _, err := uconn.Write(make([]byte, 10000)); err != nil {
+				t.Fatal(err)
+			}
+			uconn.Close()
+			if err := <-done; err != nil {
+				t.Fatalf("server failed: %v", err)
+			}
+			// 1024 bytes of data with at most 32 bytes of overhead
+			if n := len(conn.sizes) - 1; n < 10 { // without close_notify
+				t.Errorf("got %d records for 10000 bytes, expected at least 10", n)
+			}
+			for _, size := range conn.sizes {
+				if size > 1024+32 {
+					t.Errorf("got record of %d bytes, expected at most %d", size, 1024+32)
+				}
+			}
+
+			// the server must echo the offered code
+			testingOnlyMaxFragmentLength = 3
+			if _, _, _, err := handshake(t, spec, version); err == nil || !strings.Contains(err.Error(), "max_fragment_length") {
+				t.Errorf("got error %v, expected the code to be rejected", err)
+			}
+			without := spec.WithoutExtension(fakeExtensionMaxFragmentLength)
+			if _, _, _, err := handshake(t, &without, version); err == nil || !strings.Contains(err.Error(), "unsolicited max_fragment_length") {
+				t.Errorf("got error %v, expected the extension to be unsolicited", err)
+			}
+		})
+	}
+}
+
+func TestUTLSPSKKeyExchangeModesExtension(t *testing.T) {
+	chromeModes := []byte{0x00, 0x2d, 0x00, 0x02, 0x01, 0x01} // psk_dhe_ke
+	helloBytes, err := hex.DecodeString(chromeResumptionHelloHex)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(helloBytes, chromeModes) {
+		t.Fatalf("the Chrome capture doesn't contain %x", chromeModes)
+	}
+	uconn := UClient(&net.TCPConn{}, &Config{ServerName: "example.com"}, HelloChrome_120)
+	if err := uconn.BuildHandshakeState(); err != nil {
+		t.Fatalf("got error: %v; expected to succeed", err)
+	}
+	if !bytes.Contains(uconn.HandshakeState.Hello.Raw, chromeModes) {
+		t.Errorf("the Chrome 120 parrot doesn't send psk_key_exchange_modes %x", chromeModes)
+	}
+	if modes := uconn.HandshakeState.Hello.PskModes; !bytes.Equal(modes, []uint8{PskModeDHE}) {
+		t.Errorf("got PskModes %v, expected [psk_dhe_ke]", modes)
+	}
+
+	// the modes are sent in order
+	for _, modes := range [][]uint8{{PskModeDHE, PskModePlain}, {PskModePlain, PskModeDHE}, {PskModePlain}} {
+		ext := &PSKKeyExchangeModesExtension{Modes: modes}
+		b := make([]byte, ext.Len())
+		if _, err := ext.Read(b); err != nil && err != io.EOF {
+			t.Fatalf("got error: %v; expected to succeed", err)
+		}
+		if expected := append([]byte{0x00, 0x2d, 0x00, byte(len(modes) + 1), byte(len(modes))}, modes...); !bytes.Equal(b, expected) {
+			t.Errorf("modes %v are serialized as %x, expected %x", modes, b, expected)
+		}
+		var parsed PSKKeyExchangeModesExtension
+		if _, err := parsed.Write(b[4:]); err != nil || !bytes.Equal(parsed.Modes, modes) {
+			t.Errorf("got modes %v (error: %v) from %x, expected %v", parsed.Modes, err, b, modes)
+		}
+	}
+
+	// psk_dhe_ke needs a key_share, psk_ke doesn't
+	for _, test := range []struct {
+		modes    []uint8
+		keyShare bool
+		valid    bool
+	}{
+		{[]uint8{PskModeDHE}, true, true},
+		{[]uint8{PskModeDHE}, false, false},
+		{[]uint8{PskModePlain, PskModeDHE}, false, false},
+		{[]uint8{PskModePlain}, false, true},
+	} {
+		spec := ClientHelloSpec{Extensions: []TLSExtension{&PSKKeyExchangeModesExtension{Modes: test.modes}}}
+		if test.keyShare {
+			spec.Extensions = append(spec.Extensions,
+				&SupportedCurvesExtension{Curves: []CurveID{X25519}},
+				&KeyShareExtension{KeyShares: []KeyShare{{Group: X25519}}})
+		}
+		errs := spec.Validate()
+		var specErr *SpecError
+		if valid := len(errs) == 0; valid != test.valid || !valid && (!errors.As(errs[0], &specErr) || specErr.Kind != SpecErrorPSKModeKeyShare) {
+			t.Errorf("modes %v with key_share %v: got errors %v, expected valid %v", test.modes, test.keyShare, errs, test.valid)
+		}
+	}
+}
+
+func TestUTLSSupportedPointsOrder(t *testing.T) {
+	for _, points := range [][]uint8{
+		{pointFormatUncompressed},
+		{2, pointFormatUncompressed, 1},
+		{pointFormatUncompressed, pointFormatUncompressed},
+	} {
+		spec := mustSpec(t, HelloChrome_120)
+		for _, ext := range spec.Extensions {
+			if e, ok := ext.(*SupportedPointsExtension); ok {
+				e.SupportedPoints = points
+			}
+		}
+		uconn := UClient(&net.TCPConn{}, &Config{ServerName: "example.golang"}, HelloCustom)
+		if err := uconn.ApplyPreset(spec); err != nil {
+			t.Fatalf("%v: got error: %v; expected to succeed", points, err)
+		}
+		if err := uconn.BuildHandshakeState(); err != nil {
+			t.Fatalf("%v: got error: %v; expected to succeed", points, err)
+		}
+
+		expected := append([]byte{byte(len(points))}, points...)
+		found := false
+		for _, ext := range clientHelloRawExtensions(t, uconn.HandshakeState.Hello.Raw) {
+			if ext.extType == extensionSupportedPoints {
+				found = true
+				if !bytes.Equal(ext.data, expected) {
+					t.Errorf("%v: got ec_point_formats %x, expected %x", points, ext.data, expected)
+				}
+			}
+		}
+		if !found {
+			t.Errorf("%v: got no ec_point_formats extension", points)
+		}
+
+		fingerprinted, err := (&Fingerprinter{}).FingerprintClientHello(prependRecordHeader(uconn.HandshakeState.Hello.Raw, VersionTLS10))
+		if err != nil {
+			t.Fatalf("%v: got error: %v; expected to succeed", points, err)
+		}
+		var formats []string
+		for _, point := range points {
+			formats = append(formats, strconv.Itoa(int(point)))
+		}
+		if ja3, suffix := fingerprinted.JA3(), ","+strings.Join(formats, "-"); !strings.HasSuffix(ja3, suffix) {
+			t.Errorf("%v: got JA3 %s, expected it to end with %s", points, ja3, suffix)
+		}
+	}
+
+	if _, err := (&SupportedPointsExtension{SupportedPoints: make([]uint8, 256)}).Read(make([]byte, 300)); err == nil {
+		t.Error("got no error for 256 point formats")
+	}
+}